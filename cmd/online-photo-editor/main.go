@@ -2,24 +2,42 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"log/slog"
-	"net/http"
 	"online-photo-editor/internal/config"
 	"online-photo-editor/internal/http-server/handlers/image/blur"
 	"online-photo-editor/internal/http-server/handlers/image/brightness"
+	"online-photo-editor/internal/http-server/handlers/image/commit"
+	"online-photo-editor/internal/http-server/handlers/image/contactsheet"
 	"online-photo-editor/internal/http-server/handlers/image/contrast"
 	"online-photo-editor/internal/http-server/handlers/image/convert"
 	"online-photo-editor/internal/http-server/handlers/image/crop"
+	"online-photo-editor/internal/http-server/handlers/image/crops"
+	"online-photo-editor/internal/http-server/handlers/image/favicons"
+	"online-photo-editor/internal/http-server/handlers/image/framerate"
 	"online-photo-editor/internal/http-server/handlers/image/gamma"
+	"online-photo-editor/internal/http-server/handlers/image/info"
+	"online-photo-editor/internal/http-server/handlers/image/phash"
+	"online-photo-editor/internal/http-server/handlers/image/placeholder"
 	"online-photo-editor/internal/http-server/handlers/image/processor"
+	"online-photo-editor/internal/http-server/handlers/image/remove"
 	"online-photo-editor/internal/http-server/handlers/image/resize"
 	"online-photo-editor/internal/http-server/handlers/image/saturation"
+	"online-photo-editor/internal/http-server/handlers/image/serve"
 	"online-photo-editor/internal/http-server/handlers/image/sharpen"
+	"online-photo-editor/internal/http-server/handlers/image/tiles"
 	"online-photo-editor/internal/http-server/handlers/image/upload"
+	mwAuth "online-photo-editor/internal/http-server/middleware/auth"
+	mwCompress "online-photo-editor/internal/http-server/middleware/compress"
 	mwLogger "online-photo-editor/internal/http-server/middleware/logger"
+	"online-photo-editor/internal/http-server/server"
+	"online-photo-editor/internal/lib/audit"
 	"online-photo-editor/internal/lib/logger/handlers/slogpretty"
 	"online-photo-editor/internal/lib/logger/sl"
+	"online-photo-editor/internal/lib/remote"
+	"online-photo-editor/internal/lib/signedurl"
 	imgStorage "online-photo-editor/internal/storage/filesystem"
+	"online-photo-editor/internal/storage/memory"
 	"os"
 	"os/signal"
 	"syscall"
@@ -43,25 +61,84 @@ func main() {
 	log.Info("starting online-photo-editor", slog.String("env", cfg.Env))
 	log.Debug("debug messages are enabled")
 
-	imageStorage, err := imgStorage.New(cfg.StorageImagePath)
+	auditSink, closeAudit, err := audit.New(cfg.Audit.Sink, cfg.Audit.Path)
 	if err != nil {
-		log.Error("failed to init image storage", sl.Err(err))
+		log.Error("failed to init audit log", sl.Err(err))
 		os.Exit(1)
 	}
+	defer closeAudit()
 
-	router := setupRouter(log, imageStorage, cfg.StorageImagePath)
+	encryptionKey, err := base64.StdEncoding.DecodeString(cfg.EncryptionKey)
+	if err != nil {
+		log.Error("invalid storage encryption key", sl.Err(err))
+		os.Exit(1)
+	}
+
+	var urlSigner *signedurl.Signer
+	if cfg.ImageServer.SigningKey != "" {
+		urlSigner, err = signedurl.New([]byte(cfg.ImageServer.SigningKey), cfg.ImageServer.SignedURLTTL)
+		if err != nil {
+			log.Error("invalid url signing config", sl.Err(err))
+			os.Exit(1)
+		}
+	}
+
+	janitorCtx, stopJanitor := context.WithCancel(context.Background())
+	defer stopJanitor()
+
+	var imageStorage processor.ImageProcessor
+	switch cfg.Storage {
+	case config.StorageMemory:
+		if len(encryptionKey) > 0 || urlSigner != nil {
+			log.Error("the memory storage supports neither encryption_key nor signing_key")
+			os.Exit(1)
+		}
+
+		memStorage := memory.New()
+		memStorage.DefaultExt = cfg.DefaultExt
+		memStorage.MaxImages = cfg.MemoryMaxImages
+		imageStorage = memStorage
+	default:
+		fsStorage, err := imgStorage.New(cfg.StorageImagePath, imgStorage.Options{
+			TempPath:           cfg.TempStorage.Path,
+			PublicBaseURL:      cfg.ImageServer.PublicBaseURL,
+			OnCollision:        cfg.OnNameCollision,
+			DefaultExt:         cfg.DefaultExt,
+			Audit:              auditSink,
+			MaxPixels:          cfg.MaxImagePixels,
+			MaxFrames:          cfg.MaxAnimationFrames,
+			MaxAnimationPixels: cfg.MaxAnimationPixels,
+			MaxImages:          cfg.Quota.MaxImages,
+			MaxBytes:           cfg.Quota.MaxBytes,
+			EvictOldest:        cfg.Quota.EvictOldest,
+			NamespaceMaxImages: cfg.Quota.NamespaceMaxImages,
+			NamespaceMaxBytes:  cfg.Quota.NamespaceMaxBytes,
+			MaxNamespaces:      cfg.Quota.MaxNamespaces,
+			EncryptionKey:      encryptionKey,
+			URLSigner:          urlSigner,
+		})
+		if err != nil {
+			log.Error("failed to init image storage", sl.Err(err))
+			os.Exit(1)
+		}
+
+		if cfg.TempStorage.Path != "" {
+			fsStorage.StartJanitor(janitorCtx, log, cfg.TempStorage.CleanupInterval, cfg.TempStorage.TTL)
+		}
+		imageStorage = fsStorage
+	}
+
+	router := setupRouter(log, imageStorage, auditSink, urlSigner, cfg)
 
 	log.Info("starting server", slog.String("address", cfg.Address))
 
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
-	srv := &http.Server{
-		Addr:         cfg.Address,
-		Handler:      router,
-		ReadTimeout:  cfg.HTTPServer.Timeout,
-		WriteTimeout: cfg.HTTPServer.Timeout,
-		IdleTimeout:  cfg.HTTPServer.IdleTimeout,
+	srv, err := server.New(cfg.HTTPServer, router)
+	if err != nil {
+		log.Error("failed to init server", sl.Err(err))
+		os.Exit(1)
 	}
 
 	go func() {
@@ -115,34 +192,132 @@ func setupPrettySlog() *slog.Logger {
 	return slog.New(handler)
 }
 
-func setupRouter(log *slog.Logger, imageStorage *imgStorage.ImageStorage, storagePath string) *chi.Mux {
+func setupRouter(log *slog.Logger, imageStorage processor.ImageProcessor, auditSink audit.Sink, urlSigner *signedurl.Signer, cfg *config.Config) *chi.Mux {
 	router := chi.NewRouter()
 	router.Use(middleware.RequestID, middleware.RealIP, mwLogger.New(log), middleware.Recoverer, middleware.URLFormat)
+	router.Use(mwCompress.New(log, cfg.HTTPServer.CompressMinSize))
+
+	processorOpts := processor.Options{
+		DefaultFormats:  cfg.Processing.DefaultFormats,
+		ActionTimeouts:  cfg.Processing.ActionTimeouts,
+		Profile:         cfg.Processing.Profile,
+		MaxCost:         cfg.Processing.MaxCost,
+		EXIFThumbnail:   cfg.Processing.EXIFThumbnail,
+		OptimizeJPEG:    cfg.Processing.OptimizeJPEG,
+		MemoryBudget:    cfg.Processing.MemoryBudget,
+		PreviewDebounce: cfg.Processing.PreviewDebounce,
+		MaxDuration:     cfg.Processing.MaxDuration,
+		MaxDataURISize:  cfg.Processing.MaxDataURISize,
+		DenyUpscale:     !cfg.Processing.AllowUpscale,
+		OnUpscale:       cfg.Processing.OnUpscale,
+		Presets:         presets(cfg.Processing.Presets),
+	}
+	if cfg.Remote.Enabled {
+		processorOpts.Remote = remote.New(imageStorage, remote.Options{
+			AllowedHosts: cfg.Remote.AllowedHosts,
+			MaxEntries:   cfg.Remote.CacheSize,
+			TTL:          cfg.Remote.CacheTTL,
+			Timeout:      cfg.Remote.Timeout,
+		})
+	}
+
+	batches := processor.NewBatches()
+
+	apiKeys := mwAuth.NewKeys(cfg.Auth.APIKeys)
+	variantCache := serve.NewVariantCache(cfg.ImageServer.VariantCacheSize)
+
+	// Downloads check their signature themselves, and the other reads of
+	// an image take a signature or a key. Everything else needs a key.
+	router.Get("/images/{name}", serve.New(log, imageStorage, serve.Options{
+		CacheMaxAge:     cfg.ImageServer.CacheMaxAge,
+		Signer:          urlSigner,
+		NegotiateFormat: cfg.ImageServer.NegotiateFormat,
+		// A size that can be warmed can be downloaded.
+		Sizes: variantSizes(append(cfg.ImageServer.Variants, cfg.ImageServer.WarmSizes...)),
+		Cache: variantCache,
+	}))
+
+	router.Group(func(router chi.Router) {
+		router.Use(mwAuth.NewSigned(log, apiKeys, urlSigner))
+
+		router.Get("/images/{name}/phash", phash.New(log, imageStorage))
 
-	router.Post("/image", upload.New(log, imageStorage))
+		router.Get("/images/{name}/info", info.New(log, imageStorage))
 
-	router.Post("/image/crop", crop.New(log, imageStorage))
+		router.Get("/images/{name}/placeholder", placeholder.New(log, imageStorage))
+	})
 
-	router.Post("/image/resize", resize.New(log, imageStorage))
+	router.Group(func(router chi.Router) {
+		router.Use(mwAuth.New(log, apiKeys))
 
-	router.Post("/image/convert", convert.New(log, imageStorage))
+		router.Post("/image", upload.New(log, imageStorage))
 
-	router.Post("/image/blur", blur.New(log, imageStorage))
+		router.Post("/image/commit", commit.New(log, imageStorage))
 
-	router.Post("/image/brightness", brightness.New(log, imageStorage))
+		router.Post("/image/crop", crop.New(log, imageStorage))
 
-	router.Post("/image/contrast", contrast.New(log, imageStorage))
+		router.Post("/image/crop/preview", crop.NewPreview(log, imageStorage))
 
-	router.Post("/image/gamma", gamma.New(log, imageStorage))
+		router.Post("/crops", crops.New(log, imageStorage))
 
-	router.Post("/image/saturation", saturation.New(log, imageStorage))
+		router.Post("/favicons", favicons.New(log, imageStorage))
 
-	router.Post("/image/sharpen", sharpen.New(log, imageStorage))
+		router.Post("/contact-sheet", contactsheet.New(log, imageStorage))
 
-	router.Post("/image/process", processor.New(log, imageStorage))
+		router.Post("/image/resize", resize.New(log, imageStorage))
 
-	fileServer := http.FileServer(http.Dir(storagePath))
-	router.Handle("/images/*", http.StripPrefix("/images", fileServer))
+		router.Post("/image/convert", convert.New(log, imageStorage))
+
+		router.Post("/image/blur", blur.New(log, imageStorage))
+
+		router.Post("/image/brightness", brightness.New(log, imageStorage))
+
+		router.Post("/image/contrast", contrast.New(log, imageStorage))
+
+		router.Post("/image/gamma", gamma.New(log, imageStorage))
+
+		router.Post("/image/saturation", saturation.New(log, imageStorage))
+
+		router.Post("/image/sharpen", sharpen.New(log, imageStorage))
+
+		router.Post("/image/framerate", framerate.New(log, imageStorage))
+
+		router.Post("/image/process", processor.New(log, imageStorage, processorOpts))
+
+		router.Post("/batches", processor.NewBatch(log, imageStorage, processorOpts, batches))
+		router.Get("/batches/{id}", processor.NewBatchStatus(log, batches))
+		router.Post("/batch", processor.NewSyncBatch(log, imageStorage, processorOpts))
+
+		router.Post("/validate", processor.NewValidate(log, imageStorage, processorOpts))
+
+		router.Post("/tiles", tiles.New(log, imageStorage))
+
+		router.Post("/images/{name}/warm", serve.NewWarm(log, imageStorage, variantCache, variantSizes(cfg.ImageServer.WarmSizes)))
+
+		router.Delete("/images/{name}", remove.New(log, imageStorage, auditSink))
+	})
 
 	return router
 }
+
+func presets(cfgPresets map[string][]config.PresetAction) map[string][]processor.ImageAction {
+	presets := make(map[string][]processor.ImageAction, len(cfgPresets))
+
+	for name, actions := range cfgPresets {
+		for _, action := range actions {
+			presets[name] = append(presets[name], processor.ImageAction{Action: action.Action, Params: action.Params})
+		}
+	}
+
+	return presets
+}
+
+func variantSizes(cfgSizes []config.VariantSize) []serve.Size {
+	sizes := make([]serve.Size, 0, len(cfgSizes))
+
+	for _, size := range cfgSizes {
+		sizes = append(sizes, serve.Size{Width: size.Width, Height: size.Height, Format: size.Format, Quality: size.Quality})
+	}
+
+	return sizes
+}
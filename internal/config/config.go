@@ -8,16 +8,162 @@ import (
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
+// The storage backends, see Config.Storage.
+const (
+	StorageFilesystem = "filesystem"
+	StorageMemory     = "memory"
+)
+
 type Config struct {
-	Env              string `yaml:"env" env-default:"local"`
-	StorageImagePath string `yaml:"storage_image_path" env:"STORAGE_IMAGE_PATH" env-required:"true"`
-	HTTPServer       `yaml:"http_server"`
+	Env                string `yaml:"env" env-default:"local"`
+	Storage            string `yaml:"storage" env:"STORAGE" env-default:"filesystem"`
+	StorageImagePath   string `yaml:"storage_image_path" env:"STORAGE_IMAGE_PATH"`
+	MemoryMaxImages    int    `yaml:"memory_max_images" env:"STORAGE_MEMORY_MAX_IMAGES"`
+	OnNameCollision    string `yaml:"on_name_collision" env:"STORAGE_ON_NAME_COLLISION" env-default:"suffix"`
+	DefaultExt         string `yaml:"default_ext" env:"STORAGE_DEFAULT_EXT" env-default:"png"`
+	EncryptionKey      string `yaml:"encryption_key" env:"STORAGE_ENCRYPTION_KEY"`
+	MaxImagePixels     int64  `yaml:"max_image_pixels" env:"STORAGE_MAX_IMAGE_PIXELS" env-default:"100000000"`
+	MaxAnimationFrames int    `yaml:"max_animation_frames" env:"STORAGE_MAX_ANIMATION_FRAMES" env-default:"1000"`
+	MaxAnimationPixels int64  `yaml:"max_animation_pixels" env:"STORAGE_MAX_ANIMATION_PIXELS" env-default:"1000000000"`
+	Quota              `yaml:"quota"`
+	TempStorage        `yaml:"temp_storage"`
+	HTTPServer         `yaml:"http_server"`
+	ImageServer        `yaml:"image_server"`
+	Processing         `yaml:"processing"`
+	Remote             `yaml:"remote"`
+	Audit              `yaml:"audit"`
+	Auth               `yaml:"auth"`
+}
+
+// Auth is who may call the API.
+type Auth struct {
+	// APIKeys, when set, make every request but downloads carry one of
+	// them in X-API-Key. They are required with a SigningKey: otherwise
+	// anyone could have a guessed image name signed.
+	APIKeys []string `yaml:"api_keys" env:"AUTH_API_KEYS" env-separator:","`
+}
+
+// Remote is the fetching of source images by URL, for the source_url of
+// processing requests.
+type Remote struct {
+	Enabled bool `yaml:"enabled" env:"REMOTE_ENABLED"`
+	// AllowedHosts are the hosts images may be fetched from; empty allows
+	// any.
+	AllowedHosts []string      `yaml:"allowed_hosts" env:"REMOTE_ALLOWED_HOSTS" env-separator:","`
+	CacheSize    int           `yaml:"cache_size" env-default:"100"`
+	CacheTTL     time.Duration `yaml:"cache_ttl" env-default:"1h"`
+	Timeout      time.Duration `yaml:"timeout" env-default:"10s"`
+}
+
+type Audit struct {
+	// Sink is stdout, file or empty to disable the audit log.
+	Sink string `yaml:"sink" env:"AUDIT_SINK"`
+	Path string `yaml:"path" env:"AUDIT_PATH"`
+}
+
+// Quota limits the stored images; zero means no limit.
+type Quota struct {
+	MaxImages   int   `yaml:"max_images" env:"STORAGE_QUOTA_MAX_IMAGES"`
+	MaxBytes    int64 `yaml:"max_bytes" env:"STORAGE_QUOTA_MAX_BYTES"`
+	EvictOldest bool  `yaml:"evict_oldest" env:"STORAGE_QUOTA_EVICT_OLDEST"`
+	// NamespaceMaxImages and NamespaceMaxBytes limit every namespace of
+	// images on its own, the default one included.
+	NamespaceMaxImages int   `yaml:"namespace_max_images" env:"STORAGE_QUOTA_NAMESPACE_MAX_IMAGES"`
+	NamespaceMaxBytes  int64 `yaml:"namespace_max_bytes" env:"STORAGE_QUOTA_NAMESPACE_MAX_BYTES"`
+	// MaxNamespaces caps the namespaces holding images, the default one
+	// aside.
+	MaxNamespaces int `yaml:"max_namespaces" env:"STORAGE_QUOTA_MAX_NAMESPACES"`
+}
+
+type TempStorage struct {
+	Path            string        `yaml:"path" env:"STORAGE_TEMP_PATH"`
+	TTL             time.Duration `yaml:"ttl" env-default:"24h"`
+	CleanupInterval time.Duration `yaml:"cleanup_interval" env-default:"1h"`
 }
 
 type HTTPServer struct {
-	Address     string        `yaml:"address" env-default:"localhost:8080"`
-	Timeout     time.Duration `yaml:"timeout" env-default:"4s"`
-	IdleTimeout time.Duration `yaml:"idle_timeout" env-default:"60s"`
+	Address string `yaml:"address" env-default:"localhost:8080"`
+	// Timeout is the read and write timeout unless ReadTimeout or
+	// WriteTimeout say otherwise.
+	Timeout           time.Duration `yaml:"timeout" env:"HTTP_SERVER_TIMEOUT" env-default:"4s"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" env:"HTTP_SERVER_READ_TIMEOUT"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"HTTP_SERVER_READ_HEADER_TIMEOUT"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" env:"HTTP_SERVER_WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" env:"HTTP_SERVER_IDLE_TIMEOUT" env-default:"60s"`
+	// HTTP2 enables HTTP/2, including cleartext h2c.
+	HTTP2                bool   `yaml:"http2" env:"HTTP_SERVER_HTTP2" env-default:"true"`
+	MaxConcurrentStreams uint32 `yaml:"max_concurrent_streams" env:"HTTP_SERVER_MAX_CONCURRENT_STREAMS" env-default:"250"`
+	// CompressMinSize is the smallest JSON or text response, in bytes,
+	// that is compressed.
+	CompressMinSize int `yaml:"compress_min_size" env:"HTTP_SERVER_COMPRESS_MIN_SIZE" env-default:"1024"`
+}
+
+// EffectiveWriteTimeout returns WriteTimeout, or Timeout when it is unset.
+func (s HTTPServer) EffectiveWriteTimeout() time.Duration {
+	if s.WriteTimeout == 0 {
+		return s.Timeout
+	}
+	return s.WriteTimeout
+}
+
+type ImageServer struct {
+	CacheMaxAge   time.Duration `yaml:"cache_max_age" env-default:"1h"`
+	PublicBaseURL string        `yaml:"public_base_url" env:"PUBLIC_BASE_URL"`
+	// SigningKey, at least 16 bytes, makes downloads require a signed URL
+	// valid for SignedURLTTL; empty serves images to anyone.
+	SigningKey   string        `yaml:"signing_key" env:"IMAGE_SERVER_SIGNING_KEY"`
+	SignedURLTTL time.Duration `yaml:"signed_url_ttl" env-default:"24h"`
+	// NegotiateFormat serves JPEG and PNG images as WebP to clients that
+	// accept it, with Vary: Accept.
+	NegotiateFormat bool `yaml:"negotiate_format"`
+	// Variants are the variants downloads may ask for besides WarmSizes.
+	Variants []VariantSize `yaml:"variants"`
+	// WarmSizes are the variants POST /images/{name}/warm precomputes.
+	WarmSizes []VariantSize `yaml:"warm_sizes"`
+	// VariantCacheSize is how many bytes of rendered variants are kept in
+	// memory; variants are never stored.
+	VariantCacheSize int64 `yaml:"variant_cache_size" env-default:"67108864"`
+}
+
+// VariantSize is a variant, as the w, h, format and q query params of a
+// download ask for it.
+type VariantSize struct {
+	Width   int    `yaml:"w"`
+	Height  int    `yaml:"h"`
+	Format  string `yaml:"format"`
+	Quality int    `yaml:"q"`
+}
+
+type Processing struct {
+	DefaultFormats map[string]string         `yaml:"default_formats"`
+	ActionTimeouts map[string]time.Duration  `yaml:"action_timeouts"`
+	Profile        string                    `yaml:"profile" env-default:"balanced"`
+	MaxCost        float64                   `yaml:"max_cost"`
+	EXIFThumbnail  bool                      `yaml:"exif_thumbnail"`
+	OptimizeJPEG   bool                      `yaml:"optimize_jpeg"`
+	MemoryBudget   int64                     `yaml:"memory_budget"`
+	Presets        map[string][]PresetAction `yaml:"presets"`
+	// PreviewDebounce is how long a session preview waits for a newer
+	// one before it is rendered.
+	PreviewDebounce time.Duration `yaml:"preview_debounce" env-default:"100ms"`
+	// MaxDuration bounds loading, processing and saving one image. It
+	// must be below the write timeout, so the 504 of a run cut short is
+	// written before the connection is closed; zero makes it nine tenths
+	// of the write timeout.
+	MaxDuration time.Duration `yaml:"max_duration"`
+	// MaxDataURISize is the largest result, in bytes, returned as a data
+	// URI.
+	MaxDataURISize int64 `yaml:"max_data_uri_size" env-default:"1048576"`
+	// AllowUpscale is the allow_upscale of resizes that leave it out, and
+	// OnUpscale their on_upscale: clamp or reject.
+	AllowUpscale bool   `yaml:"allow_upscale" env-default:"true"`
+	OnUpscale    string `yaml:"on_upscale" env-default:"clamp"`
+}
+
+// PresetAction is one action of a preset, as in a /image/process request.
+type PresetAction struct {
+	Action string                 `yaml:"action"`
+	Params map[string]interface{} `yaml:"params"`
 }
 
 func MustLoad() *Config {
@@ -37,5 +183,29 @@ func MustLoad() *Config {
 		log.Fatalf("cannot read config file: %s", err)
 	}
 
+	switch cfg.Storage {
+	case StorageFilesystem:
+		if cfg.StorageImagePath == "" {
+			log.Fatal("storage_image_path is required with the filesystem storage")
+		}
+	case StorageMemory:
+	default:
+		log.Fatalf("storage must be %s or %s, not %q", StorageFilesystem, StorageMemory, cfg.Storage)
+	}
+
+	if cfg.ImageServer.SigningKey != "" && len(cfg.Auth.APIKeys) == 0 {
+		log.Fatal("auth.api_keys are required with image_server.signing_key")
+	}
+
+	// A run must end before the server gives up writing its response, or
+	// the client gets a closed connection instead of the 504.
+	writeTimeout := cfg.HTTPServer.EffectiveWriteTimeout()
+	switch {
+	case cfg.Processing.MaxDuration == 0:
+		cfg.Processing.MaxDuration = writeTimeout * 9 / 10
+	case writeTimeout > 0 && cfg.Processing.MaxDuration >= writeTimeout:
+		log.Fatalf("processing.max_duration (%s) must be below the http_server write timeout (%s)", cfg.Processing.MaxDuration, writeTimeout)
+	}
+
 	return &cfg
 }
@@ -8,7 +8,10 @@ import (
 	"online-photo-editor/internal/http-server/handlers/image/processor"
 	"online-photo-editor/internal/lib/api/blur"
 	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/encoding"
 	"online-photo-editor/internal/lib/logger/sl"
+	"online-photo-editor/internal/storage"
 
 	"path/filepath"
 
@@ -18,7 +21,7 @@ import (
 
 type Request struct {
 	blur.BlurParams
-	ImageName string `json:"image_name" validate:"required,max=100"`
+	ImageName string `json:"image_name" validate:"required,max=100,image_name"`
 }
 
 type Response struct {
@@ -63,8 +66,7 @@ func New(log *slog.Logger, imgBlur processor.ImageProcessor) http.HandlerFunc {
 		inputImg, err := imgBlur.LoadImage(req.ImageName)
 		if err != nil {
 			log.Error("failed to load image", sl.Err(err))
-			render.Status(r, http.StatusNotFound)
-			render.JSON(w, r, response.Error("failed to load image"))
+			response.LoadError(w, r, err)
 			return
 		}
 
@@ -80,7 +82,7 @@ func New(log *slog.Logger, imgBlur processor.ImageProcessor) http.HandlerFunc {
 			return
 		}
 
-		imgName, err := imgBlur.GenerateName("proc", filepath.Ext(req.ImageName))
+		imgName, err := imgBlur.GenerateName(storage.InNamespaceOf(req.ImageName, "proc"), filepath.Ext(req.ImageName))
 		if err != nil {
 			log.Error("failed to generate name", sl.Err(err))
 			render.Status(r, http.StatusInternalServerError)
@@ -88,11 +90,10 @@ func New(log *slog.Logger, imgBlur processor.ImageProcessor) http.HandlerFunc {
 			return
 		}
 
-		imgUrl, err := imgBlur.SaveImage(inputImg, imgName)
+		imgUrl, err := imgBlur.SaveImage(inputImg, imgName, encoding.Options{Origin: audit.OriginOf(r)})
 		if err != nil {
 			log.Error("failed to save image", sl.Err(err))
-			render.Status(r, http.StatusUnsupportedMediaType)
-			render.JSON(w, r, response.Error("failed to save image"))
+			response.SaveError(w, r, err, "failed to save image")
 			return
 		}
 
@@ -0,0 +1,79 @@
+package commit
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"online-photo-editor/internal/http-server/handlers/image/processor"
+	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/logger/sl"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+type Request struct {
+	ImageName string `json:"image_name" validate:"required,max=100,image_name"`
+}
+
+type Response struct {
+	response.Response
+	ImageUrl string `json:"image_url"`
+}
+
+func New(log *slog.Logger, imgCommitter processor.ImageProcessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.img.commit.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error("empty request"))
+
+			return
+		}
+
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error("failed to decode request"))
+
+			return
+		}
+
+		if !response.Validation(log, w, r, req, http.StatusBadRequest) {
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		imgUrl, err := imgCommitter.CommitImage(req.ImageName)
+		if err != nil {
+			log.Error("failed to commit image", sl.Err(err))
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, response.Error("failed to commit image"))
+			return
+		}
+
+		log.Info("image committed", slog.String("image url", imgUrl))
+
+		responseOK(w, r, imgUrl)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, imgUrl string) {
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, Response{
+		Response: response.OK(),
+		ImageUrl: imgUrl,
+	})
+}
@@ -0,0 +1,168 @@
+package contactsheet
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"online-photo-editor/internal/http-server/handlers/image/processor"
+	"online-photo-editor/internal/lib/api/contactsheet"
+	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/logger/sl"
+	"online-photo-editor/internal/storage"
+	"os"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+const defaultFormat = "jpg"
+
+// Request lists the images of the sheet in order. The sheet is saved as
+// Format, jpg by default, in the namespace of the first image.
+type Request struct {
+	contactsheet.ContactSheetParams
+	ImageNames []string `json:"image_names" validate:"required,min=1,max=100,dive,required,max=100,image_name"`
+	Format     string   `json:"format,omitempty" validate:"omitempty,oneof=jpg jpeg png webp"`
+}
+
+// Response maps every image that couldn't be loaded, and is a placeholder
+// on the sheet, to why.
+type Response struct {
+	response.Response
+	ImageUrl string            `json:"image_url,omitempty"`
+	Width    int               `json:"width,omitempty"`
+	Height   int               `json:"height,omitempty"`
+	Errors   map[string]string `json:"errors,omitempty"`
+}
+
+// New saves a contact sheet: a grid of thumbnails of the images with the
+// name of each under it. An image that can't be loaded gets a placeholder
+// cell saying so instead of failing the sheet; only a sheet without any
+// image that loads fails, with 422.
+func New(log *slog.Logger, imgProcessor processor.ImageProcessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.img.contactsheet.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error("empty request"))
+
+			return
+		}
+
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error("failed to decode request"))
+
+			return
+		}
+
+		if !response.Validation(log, w, r, req, http.StatusBadRequest) {
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		cells := make([]contactsheet.Cell, len(req.ImageNames))
+		loadErrs := make(map[string]string)
+
+		for i, name := range req.ImageNames {
+			cells[i].Label = name
+
+			img, err := imgProcessor.LoadImage(name)
+			if err != nil {
+				log.Warn("failed to load image", slog.String("image", name), sl.Err(err))
+				cells[i].Error = loadError(err)
+				loadErrs[name] = cells[i].Error
+				continue
+			}
+
+			// Only the thumbnail is kept, so a sheet of many large images
+			// holds one of them at full size at a time.
+			thumb, err := req.Thumbnail(img)
+			if err != nil {
+				log.Error("failed to fit image", slog.String("image", name), sl.Err(err))
+				render.Status(r, http.StatusBadRequest)
+				render.JSON(w, r, response.Error("failed to draw contact sheet"))
+				return
+			}
+			cells[i].Image = thumb
+		}
+
+		if len(loadErrs) == len(req.ImageNames) {
+			log.Error("no image could be loaded")
+			render.Status(r, http.StatusUnprocessableEntity)
+			render.JSON(w, r, Response{
+				Response: response.Error("no image could be loaded"),
+				Errors:   loadErrs,
+			})
+			return
+		}
+
+		sheet, err := req.ContactSheetImage(cells)
+		if err != nil {
+			log.Error("failed to draw contact sheet", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error("failed to draw contact sheet"))
+			return
+		}
+
+		format := req.Format
+		if format == "" {
+			format = defaultFormat
+		}
+
+		imgName, err := imgProcessor.GenerateName(storage.InNamespaceOf(req.ImageNames[0], "contact_sheet"), "."+format)
+		if err != nil {
+			log.Error("failed to generate name", sl.Err(err))
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, response.Error("failed to generate name"))
+			return
+		}
+
+		imgUrl, err := imgProcessor.SaveImage(sheet, imgName, encoding.Options{Origin: audit.OriginOf(r)})
+		if err != nil {
+			log.Error("failed to save contact sheet", sl.Err(err))
+			imgProcessor.DeleteImage(imgName)
+			response.SaveError(w, r, err, "failed to save contact sheet")
+			return
+		}
+
+		log.Info("contact sheet saved", slog.String("image url", imgUrl), slog.Int("images", len(cells)), slog.Int("missing", len(loadErrs)))
+
+		b := sheet.Bounds()
+		render.Status(r, http.StatusOK)
+		render.JSON(w, r, Response{
+			Response: response.OK(),
+			ImageUrl: imgUrl,
+			Width:    b.Dx(),
+			Height:   b.Dy(),
+			Errors:   loadErrs,
+		})
+	}
+}
+
+// loadError is the label of the placeholder of an image that failed to
+// load with err.
+func loadError(err error) string {
+	if target := storage.ContentError(err); target != nil {
+		return target.Error()
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return "image not found"
+	}
+	return "failed to load image"
+}
@@ -0,0 +1,57 @@
+package contactsheet_test
+
+import (
+	"encoding/json"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"online-photo-editor/internal/http-server/handlers/image/contactsheet"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/logger/handlers/slogdiscard"
+	"online-photo-editor/internal/storage/memory"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_ContactSheet(t *testing.T) {
+	mem := memory.New()
+	for _, name := range []string{"acme--a.png", "acme--b.jpg"} {
+		_, err := mem.SaveImage(imaging.New(120, 80, color.NRGBA{G: 200, A: 255}), name, encoding.Options{})
+		require.NoError(t, err)
+	}
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/contact-sheet", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		contactsheet.New(slogdiscard.NewDiscardLogger(), mem).ServeHTTP(w, req)
+		return w
+	}
+
+	w := send(`{"image_names": ["acme--a.png", "acme--missing.png", "acme--b.jpg"], "columns": 3, "cell_size": 64, "format": "png"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp contactsheet.Response
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, map[string]string{"acme--missing.png": "image not found"}, resp.Errors)
+
+	imgName := strings.TrimPrefix(resp.ImageUrl, "/images/")
+	assert.True(t, strings.HasPrefix(imgName, "acme--"), imgName)
+	assert.True(t, strings.HasSuffix(imgName, ".png"), imgName)
+
+	sheet, err := mem.LoadImage(imgName)
+	require.NoError(t, err)
+	assert.Equal(t, resp.Width, sheet.Bounds().Dx())
+	assert.Equal(t, resp.Height, sheet.Bounds().Dy())
+	assert.Equal(t, 10+3*(64+10), resp.Width)
+
+	w = send(`{"image_names": ["gone.png"]}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "image not found")
+
+	w = send(`{"image_names": []}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
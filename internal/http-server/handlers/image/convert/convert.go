@@ -8,7 +8,10 @@ import (
 	"online-photo-editor/internal/http-server/handlers/image/processor"
 	"online-photo-editor/internal/lib/api/convert"
 	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/encoding"
 	"online-photo-editor/internal/lib/logger/sl"
+	"online-photo-editor/internal/storage"
 
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/render"
@@ -16,11 +19,13 @@ import (
 
 type Request struct {
 	convert.ConvertParams
-	ImageName string `json:"image_name" validate:"required,max=100"`
+	ImageName string `json:"image_name" validate:"required,max=100,image_name"`
 }
 type Response struct {
 	response.Response
 	ImageUrl string `json:"image_url"`
+	// Quality is the quality picked for quality auto.
+	Quality int `json:"quality,omitempty"`
 }
 
 func New(log *slog.Logger, imgConverter processor.ImageProcessor) http.HandlerFunc {
@@ -57,27 +62,29 @@ func New(log *slog.Logger, imgConverter processor.ImageProcessor) http.HandlerFu
 
 		log.Info("request body decoded", slog.Any("request", req))
 
-		inputImg, err := imgConverter.LoadImage(req.ImageName)
-		if err != nil {
-			log.Error("failed to load image", sl.Err(err))
-			render.Status(r, http.StatusNotFound)
-			render.JSON(w, r, response.Error("failed to load image"))
+		// The format is checked before the image is loaded, so an
+		// unsupported one always fails the same way.
+		fileExt, err := req.ConvertParams.ConvertImage()
+		if errors.Is(err, convert.ErrAutoQuality) {
+			log.Error("invalid quality", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error(convert.ErrAutoQuality.Error()))
 			return
 		}
-
-		if !response.Validation(log, w, r, req.ConvertParams, http.StatusBadRequest) {
+		if err != nil {
+			log.Error("unsupported format", sl.Err(err))
+			response.UnsupportedFormat(w, r, convert.Message(req.Format, err), convert.Formats)
 			return
 		}
 
-		fileExt, err := req.ConvertParams.ConvertImage()
+		inputImg, err := imgConverter.LoadImage(req.ImageName)
 		if err != nil {
-			log.Error("failed to crop image", sl.Err(err))
-			render.Status(r, http.StatusNotFound)
-			render.JSON(w, r, response.Error("failed to crop image"))
+			log.Error("failed to load image", sl.Err(err))
+			response.LoadError(w, r, err)
 			return
 		}
 
-		imgName, err := imgConverter.GenerateName("proc", fileExt)
+		imgName, err := imgConverter.GenerateName(storage.InNamespaceOf(req.ImageName, "proc"), fileExt)
 		if err != nil {
 			log.Error("failed to generate name", sl.Err(err))
 			render.Status(r, http.StatusInternalServerError)
@@ -85,24 +92,31 @@ func New(log *slog.Logger, imgConverter processor.ImageProcessor) http.HandlerFu
 			return
 		}
 
-		imgUrl, err := imgConverter.SaveImage(inputImg, imgName)
+		opts := encoding.Options{Quality: int(req.Quality), BitDepth: req.BitDepth, Dither: req.Dither, Origin: audit.OriginOf(r)}
+		picked := 0
+		if req.Quality == convert.QualityAuto {
+			picked = convert.AutoQuality(inputImg)
+			opts.Quality = picked
+		}
+
+		imgUrl, err := imgConverter.SaveImage(inputImg, imgName, opts)
 		if err != nil {
 			log.Error("failed to save image", sl.Err(err))
-			render.Status(r, http.StatusUnsupportedMediaType)
-			render.JSON(w, r, response.Error("failed to save image"))
+			response.SaveError(w, r, err, "failed to save image")
 			return
 		}
 
 		log.Info("image saved", slog.String("image url", imgUrl))
 
-		responseOK(w, r, imgUrl)
+		responseOK(w, r, imgUrl, picked)
 	}
 }
 
-func responseOK(w http.ResponseWriter, r *http.Request, imgUrl string) {
+func responseOK(w http.ResponseWriter, r *http.Request, imgUrl string, quality int) {
 	render.Status(r, http.StatusOK)
 	render.JSON(w, r, Response{
 		Response: response.OK(),
 		ImageUrl: imgUrl,
+		Quality:  quality,
 	})
 }
@@ -0,0 +1,71 @@
+package convert_test
+
+import (
+	"encoding/json"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"online-photo-editor/internal/http-server/handlers/image/convert"
+	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/logger/handlers/slogdiscard"
+	"online-photo-editor/internal/storage/memory"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_Convert_UnsupportedFormat(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(imaging.New(10, 10, color.White), "img.png", encoding.Options{})
+	require.NoError(t, err)
+
+	// The format is checked first, so a missing image fails the same way.
+	for _, imgName := range []string{"img.png", "missing.png"} {
+		body := `{"image_name": "` + imgName + `", "format": "avif"}`
+		req := httptest.NewRequest(http.MethodPost, "/image/convert", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		convert.New(slogdiscard.NewDiscardLogger(), mem).ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusUnsupportedMediaType, w.Code, imgName)
+
+		var resp response.UnsupportedFormatResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, `unsupported format "avif"`, resp.Error, imgName)
+		assert.Equal(t, []string{"jpg", "jpeg", "png", "gif", "bmp", "webp", "tif", "tiff"}, resp.SupportedFormats, imgName)
+	}
+}
+
+func TestHandler_Convert_VectorFormat(t *testing.T) {
+	for _, format := range []string{"svg", "pdf", ".eps"} {
+		body := `{"image_name": "img.png", "format": "` + format + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/image/convert", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		convert.New(slogdiscard.NewDiscardLogger(), memory.New()).ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusUnsupportedMediaType, w.Code, format)
+
+		var resp response.UnsupportedFormatResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "cannot convert raster image to vector format", resp.Error, format)
+	}
+}
+
+func TestHandler_Convert(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(imaging.New(10, 10, color.White), "img.png", encoding.Options{})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/image/convert", strings.NewReader(`{"image_name": "img.png", "format": "webp"}`))
+	w := httptest.NewRecorder()
+	convert.New(slogdiscard.NewDiscardLogger(), mem).ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp convert.Response
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, strings.HasSuffix(resp.ImageUrl, ".webp"), resp.ImageUrl)
+}
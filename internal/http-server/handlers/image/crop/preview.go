@@ -0,0 +1,101 @@
+package crop
+
+import (
+	"bytes"
+	"errors"
+	"image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"online-photo-editor/internal/http-server/handlers/image/processor"
+	"online-photo-editor/internal/lib/api/guides"
+	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/imgformat"
+	"online-photo-editor/internal/lib/logger/sl"
+	"strconv"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/render"
+)
+
+// NewPreview answers with the PNG bytes of the source image with the crop
+// area of the request outlined, the rest shaded and a rule-of-thirds grid
+// inside it, for editors to show a crop before it is made. Nothing is
+// saved.
+func NewPreview(log *slog.Logger, imgLoader processor.ImageProcessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.img.crop.NewPreview"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error("empty request"))
+
+			return
+		}
+
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error("failed to decode request"))
+
+			return
+		}
+
+		if !response.Validation(log, w, r, req, http.StatusBadRequest) {
+			return
+		}
+
+		inputImg, err := imgLoader.LoadImage(req.ImageName)
+		if err != nil {
+			log.Error("failed to load image", sl.Err(err))
+			response.LoadError(w, r, err)
+			return
+		}
+
+		rect, err := req.CropParams.Rect(inputImg.Bounds().Dx(), inputImg.Bounds().Dy())
+		if err != nil {
+			log.Error("invalid crop area", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error("crop area exceeds image boundaries"))
+			return
+		}
+
+		overlay := guides.GuidesParams{
+			Guide:  guides.GuideCrop,
+			X:      rect.Min.X,
+			Y:      rect.Min.Y,
+			Width:  rect.Dx(),
+			Height: rect.Dy(),
+		}
+		previewImg, err := overlay.GuidesImage(inputImg)
+		if err != nil {
+			log.Error("failed to draw crop preview", sl.Err(err))
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, response.Error("failed to draw crop preview"))
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, previewImg); err != nil {
+			log.Error("failed to encode crop preview", sl.Err(err))
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, response.Error("failed to encode crop preview"))
+			return
+		}
+
+		w.Header().Set("Content-Type", imgformat.ContentType("png"))
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf.Bytes())
+	}
+}
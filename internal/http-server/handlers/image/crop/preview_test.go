@@ -0,0 +1,72 @@
+package crop_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"online-photo-editor/internal/http-server/handlers/image/crop"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/logger/handlers/slogdiscard"
+	"online-photo-editor/internal/storage/memory"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func servePreview(t *testing.T, mem *memory.MemStorage, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/image/crop/preview", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	crop.NewPreview(slogdiscard.NewDiscardLogger(), mem).ServeHTTP(w, req)
+	return w
+}
+
+func TestHandler_Preview(t *testing.T) {
+	mem := memory.New()
+	gray := color.NRGBA{R: 100, G: 100, B: 100, A: 255}
+	_, err := mem.SaveImage(imaging.New(300, 300, gray), "img.png", encoding.Options{})
+	require.NoError(t, err)
+
+	w := servePreview(t, mem, `{"image_name": "img.png", "x": 60, "y": 30, "width": 120, "height": 90}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+
+	img, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 300, 300), img.Bounds())
+
+	brightness := func(x, y int) uint8 {
+		return color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+	}
+
+	// The outline runs along the edges of the crop area.
+	for _, p := range []image.Point{{60, 30}, {179, 30}, {60, 119}, {179, 119}, {120, 30}, {60, 75}} {
+		assert.Greater(t, brightness(p.X, p.Y), uint8(200), "outline at %v", p)
+	}
+	// The thirds grid splits it at 100 and 140, 60 and 90.
+	assert.Greater(t, brightness(100, 45), uint8(200))
+	assert.Greater(t, brightness(70, 60), uint8(200))
+
+	assert.Equal(t, uint8(100), brightness(70, 40), "inside is untouched")
+	assert.Less(t, brightness(10, 10), uint8(100), "outside is shaded")
+	assert.Less(t, brightness(181, 75), uint8(100), "outside is shaded")
+
+	assert.Equal(t, []string{"img.png"}, mem.List(), "nothing is saved")
+}
+
+func TestHandler_Preview_OutOfBounds(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(imaging.New(100, 100, color.White), "img.png", encoding.Options{})
+	require.NoError(t, err)
+
+	w := servePreview(t, mem, `{"image_name": "img.png", "x": 50, "y": 50, "width": 80, "height": 20}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "crop area exceeds image boundaries")
+}
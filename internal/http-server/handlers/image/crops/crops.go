@@ -0,0 +1,183 @@
+package crops
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"net/http"
+	"online-photo-editor/internal/http-server/handlers/image/processor"
+	"online-photo-editor/internal/lib/api/crop"
+	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/logger/sl"
+	"online-photo-editor/internal/storage"
+	"path/filepath"
+	"slices"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+)
+
+// Request names the crops to take from ImageName. The crops themselves
+// are validated one by one, so a bad one doesn't fail the request.
+type Request struct {
+	ImageName string                     `json:"image_name" validate:"required,max=100,image_name"`
+	Crops     map[string]crop.CropParams `json:"crops" validate:"required,min=1,max=20,dive,keys,required,max=50,endkeys"`
+}
+
+// Response maps the name of every crop saved to its URL, and the name of
+// every crop that was rejected to why.
+type Response struct {
+	response.Response
+	Images map[string]string `json:"images"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// New saves several crops of one image, decoding it only once. If any
+// crop fails to save, the ones already saved are deleted.
+func New(log *slog.Logger, imgCropper processor.ImageProcessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.img.crops.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error("empty request"))
+
+			return
+		}
+
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error("failed to decode request"))
+
+			return
+		}
+
+		if !response.Validation(log, w, r, req, http.StatusBadRequest) {
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		inputImg, err := imgCropper.LoadImage(req.ImageName)
+		if err != nil {
+			log.Error("failed to load image", sl.Err(err))
+			response.LoadError(w, r, err)
+			return
+		}
+
+		images := make(map[string]string, len(req.Crops))
+		cropErrs := make(map[string]string)
+		var saved []string
+
+		names := make([]string, 0, len(req.Crops))
+		for name := range req.Crops {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+
+		for _, name := range names {
+			params := req.Crops[name]
+
+			if msg := checkCrop(&params, inputImg.Bounds().Max.X, inputImg.Bounds().Max.Y); msg != "" {
+				log.Warn("invalid crop", slog.String("crop", name), slog.String("error", msg))
+				cropErrs[name] = msg
+				continue
+			}
+
+			croppedImg, err := params.CropImage(inputImg)
+			if err != nil {
+				log.Warn("failed to crop image", slog.String("crop", name), sl.Err(err))
+				cropErrs[name] = "failed to crop image"
+				continue
+			}
+
+			imgUrl, imgName, err := saveCrop(imgCropper, croppedImg, req.ImageName, audit.OriginOf(r))
+			if imgName != "" {
+				saved = append(saved, imgName)
+			}
+			if err != nil {
+				log.Error("failed to save crop", slog.String("crop", name), sl.Err(err))
+				for _, name := range saved {
+					if err := imgCropper.DeleteImage(name); err != nil {
+						log.Warn("failed to delete saved crop", sl.Err(err))
+					}
+				}
+				response.SaveError(w, r, err, "failed to save crops")
+				return
+			}
+			images[name] = imgUrl
+		}
+
+		if len(images) == 0 {
+			log.Error("no valid crops")
+			render.Status(r, http.StatusUnprocessableEntity)
+			render.JSON(w, r, Response{
+				Response: response.Error("no valid crops"),
+				Images:   images,
+				Errors:   cropErrs,
+			})
+			return
+		}
+
+		log.Info("crops saved", slog.Int("saved", len(images)), slog.Int("rejected", len(cropErrs)))
+
+		render.Status(r, http.StatusOK)
+		render.JSON(w, r, Response{
+			Response: response.OK(),
+			Images:   images,
+			Errors:   cropErrs,
+		})
+	}
+}
+
+// checkCrop returns why params can't crop a width×height image, or ""
+// if it can.
+func checkCrop(params *crop.CropParams, width, height int) string {
+	if err := response.ValidateStruct(params); err != nil {
+		var validateErr validator.ValidationErrors
+		if errors.As(err, &validateErr) {
+			return response.ValidationError(validateErr).Error
+		}
+		return "invalid crop"
+	}
+
+	if err := params.CheckBounds(width, height); err != nil {
+		return "crop area exceeds image boundaries"
+	}
+
+	return ""
+}
+
+// saveCrop saves img, cut from source, under a new name in the namespace
+// of source. The name is returned even on error once it is handed out, so
+// the caller can clean up.
+func saveCrop(imgSaver processor.ImageProcessor, img image.Image, source string, origin audit.Origin) (string, string, error) {
+	const op = "handlers.img.crops.saveCrop"
+
+	name, err := imgSaver.GenerateName(storage.InNamespaceOf(source, "crop"), filepath.Ext(source))
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	imgUrl, err := imgSaver.SaveImage(img, name, encoding.Options{Origin: origin})
+	if err != nil {
+		return "", name, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return imgUrl, name, nil
+}
@@ -0,0 +1,88 @@
+package crops_test
+
+import (
+	"encoding/json"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"online-photo-editor/internal/http-server/handlers/image/crops"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/logger/handlers/slogdiscard"
+	"online-photo-editor/internal/storage/memory"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveCrops(t *testing.T, mem *memory.MemStorage, body string) (*httptest.ResponseRecorder, crops.Response) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/crops", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	crops.New(slogdiscard.NewDiscardLogger(), mem).ServeHTTP(w, req)
+
+	var resp crops.Response
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp), w.Body.String())
+	return w, resp
+}
+
+func TestHandler_Crops(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(imaging.New(800, 600, color.White), "img.png", encoding.Options{})
+	require.NoError(t, err)
+
+	body := `{"image_name": "img.png", "crops": {
+		"hero": {"aspect_ratio": "16:9", "gravity": "top"},
+		"thumb": {"x": 100, "y": 50, "width": 200, "height": 200},
+		"outside": {"x": 700, "y": 10, "width": 200, "height": 200},
+		"invalid": {"x": 1, "y": 1, "width": 10, "height": 10, "gravity": "top"}
+	}}`
+	w, resp := serveCrops(t, mem, body)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	sizes := map[string][2]int{"hero": {800, 450}, "thumb": {200, 200}}
+	require.Len(t, resp.Images, len(sizes))
+	for name, size := range sizes {
+		imgName := strings.TrimPrefix(resp.Images[name], "/images/")
+		assert.Regexp(t, `^crop_\d+.*\.png$`, imgName, name)
+
+		width, height, err := mem.ImageSize(imgName)
+		require.NoError(t, err, name)
+		assert.Equal(t, size, [2]int{width, height}, name)
+	}
+
+	assert.Equal(t, map[string]string{
+		"outside": "crop area exceeds image boundaries",
+		"invalid": "field Gravity is not valid",
+	}, resp.Errors)
+}
+
+func TestHandler_Crops_NoneValid(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(imaging.New(100, 100, color.White), "img.png", encoding.Options{})
+	require.NoError(t, err)
+
+	body := `{"image_name": "img.png", "crops": {"big": {"x": 1, "y": 1, "width": 200, "height": 200}}}`
+	w, resp := serveCrops(t, mem, body)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Empty(t, resp.Images)
+	assert.Equal(t, map[string]string{"big": "crop area exceeds image boundaries"}, resp.Errors)
+}
+
+func TestHandler_Crops_InvalidRequest(t *testing.T) {
+	mem := memory.New()
+
+	for _, body := range []string{
+		`{"image_name": "img.png"}`,
+		`{"image_name": "img.png", "crops": {}}`,
+		`{"image_name": "img.png", "crops": {"": {"aspect_ratio": "1:1"}}}`,
+		`{"image_name": "../img.png", "crops": {"a": {"aspect_ratio": "1:1"}}}`,
+	} {
+		w, _ := serveCrops(t, mem, body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+}
@@ -0,0 +1,184 @@
+package favicons
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"net/http"
+	"online-photo-editor/internal/http-server/handlers/image/processor"
+	"online-photo-editor/internal/lib/api/crop"
+	"online-photo-editor/internal/lib/api/resize"
+	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/imgformat"
+	"online-photo-editor/internal/lib/logger/sl"
+	"online-photo-editor/internal/storage"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+// icon is a file of the set. Size is 0 for the ICO, which holds several.
+type icon struct {
+	Name     string
+	Size     int
+	Manifest bool
+}
+
+// icons are the files of a favicon set, by their conventional names.
+var icons = []icon{
+	{Name: "favicon.ico"},
+	{Name: "favicon-16x16.png", Size: 16},
+	{Name: "favicon-32x32.png", Size: 32},
+	{Name: "apple-touch-icon.png", Size: 180},
+	{Name: "android-chrome-192x192.png", Size: 192, Manifest: true},
+	{Name: "android-chrome-512x512.png", Size: 512, Manifest: true},
+}
+
+type Request struct {
+	ImageName string `json:"image_name" validate:"required,max=100,image_name"`
+}
+
+// Response maps the conventional name of every file of the set to its
+// URL. Manifest is the icons member of a web app manifest for them.
+type Response struct {
+	response.Response
+	Icons    map[string]string `json:"icons"`
+	Manifest Manifest          `json:"manifest"`
+}
+
+type Manifest struct {
+	Icons []ManifestIcon `json:"icons"`
+}
+
+type ManifestIcon struct {
+	Src   string `json:"src"`
+	Sizes string `json:"sizes"`
+	Type  string `json:"type"`
+}
+
+// New saves a favicon set made from one image, center-cropped to a square
+// first. If any file fails to save, the ones already saved are deleted.
+func New(log *slog.Logger, imgProcessor processor.ImageProcessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.img.favicons.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error("empty request"))
+
+			return
+		}
+
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error("failed to decode request"))
+
+			return
+		}
+
+		if !response.Validation(log, w, r, req, http.StatusBadRequest) {
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		inputImg, err := imgProcessor.LoadImage(req.ImageName)
+		if err != nil {
+			log.Error("failed to load image", sl.Err(err))
+			response.LoadError(w, r, err)
+			return
+		}
+
+		square := crop.CropParams{AspectRatio: "1:1"}
+		squareImg, err := square.CropImage(inputImg)
+		if err != nil {
+			log.Error("failed to crop image", sl.Err(err))
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, response.Error("failed to crop image"))
+			return
+		}
+
+		resp := Response{
+			Response: response.OK(),
+			Icons:    make(map[string]string, len(icons)),
+			Manifest: Manifest{Icons: []ManifestIcon{}},
+		}
+		var saved []string
+
+		for _, icon := range icons {
+			imgUrl, imgName, err := saveIcon(imgProcessor, squareImg, icon, storage.Namespace(req.ImageName), audit.OriginOf(r))
+			if imgName != "" {
+				saved = append(saved, imgName)
+			}
+			if err != nil {
+				log.Error("failed to save icon", slog.String("icon", icon.Name), sl.Err(err))
+				for _, name := range saved {
+					if err := imgProcessor.DeleteImage(name); err != nil {
+						log.Warn("failed to delete saved icon", sl.Err(err))
+					}
+				}
+				response.SaveError(w, r, err, "failed to save favicons")
+				return
+			}
+
+			resp.Icons[icon.Name] = imgUrl
+			if icon.Manifest {
+				resp.Manifest.Icons = append(resp.Manifest.Icons, ManifestIcon{
+					Src:   imgUrl,
+					Sizes: fmt.Sprintf("%dx%d", icon.Size, icon.Size),
+					Type:  imgformat.ContentType("png"),
+				})
+			}
+		}
+
+		log.Info("favicons saved", slog.Int("icons", len(resp.Icons)))
+
+		render.Status(r, http.StatusOK)
+		render.JSON(w, r, resp)
+	}
+}
+
+// saveIcon saves one file of the set from the square img under a new
+// name in namespace. The name is returned even on error once it is handed out, so the
+// caller can clean up.
+func saveIcon(imgSaver processor.ImageProcessor, img image.Image, icon icon, namespace string, origin audit.Origin) (string, string, error) {
+	const op = "handlers.img.favicons.saveIcon"
+
+	ext := ".ico"
+	if icon.Size > 0 {
+		ext = ".png"
+
+		params := resize.ResizeParams{Width: icon.Size, Height: icon.Size}
+		resized, err := params.ResizeImage(img)
+		if err != nil {
+			return "", "", fmt.Errorf("%s: %w", op, err)
+		}
+		img = resized
+	}
+
+	name, err := imgSaver.GenerateName(storage.InNamespace(namespace, "favicon"), ext)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	imgUrl, err := imgSaver.SaveImage(img, name, encoding.Options{Origin: origin})
+	if err != nil {
+		return "", name, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return imgUrl, name, nil
+}
@@ -0,0 +1,85 @@
+package favicons_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/color"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"online-photo-editor/internal/http-server/handlers/image/favicons"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/logger/handlers/slogdiscard"
+	"online-photo-editor/internal/storage/memory"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_Favicons(t *testing.T) {
+	// A landscape source: red in the middle square, blue on the sides.
+	src := imaging.New(300, 200, color.NRGBA{B: 255, A: 255})
+	for y := 0; y < 200; y++ {
+		for x := 50; x < 250; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+
+	mem := memory.New()
+	_, err := mem.SaveImage(src, "logo.png", encoding.Options{})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/favicons", strings.NewReader(`{"image_name": "logo.png"}`))
+	w := httptest.NewRecorder()
+	favicons.New(slogdiscard.NewDiscardLogger(), mem).ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp favicons.Response
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+
+	sizes := map[string]int{
+		"favicon-16x16.png":          16,
+		"favicon-32x32.png":          32,
+		"apple-touch-icon.png":       180,
+		"android-chrome-192x192.png": 192,
+		"android-chrome-512x512.png": 512,
+	}
+	require.Len(t, resp.Icons, len(sizes)+1)
+
+	for name, size := range sizes {
+		imgName := strings.TrimPrefix(resp.Icons[name], "/images/")
+		require.True(t, strings.HasSuffix(imgName, ".png"), name)
+
+		img, err := mem.LoadImage(imgName)
+		require.NoError(t, err, name)
+		assert.Equal(t, image.Rect(0, 0, size, size), img.Bounds(), name)
+		// The center crop dropped the blue sides.
+		assert.Equal(t, color.NRGBA{R: 255, A: 255}, color.NRGBAModel.Convert(img.At(0, size/2)), name)
+	}
+
+	file, err := mem.OpenImage(strings.TrimPrefix(resp.Icons["favicon.ico"], "/images/"))
+	require.NoError(t, err)
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(data, []byte{0, 0, 1, 0}))
+	assert.Equal(t, uint16(3), binary.LittleEndian.Uint16(data[4:]), "16, 32 and 48 pixel entries")
+
+	assert.Equal(t, []favicons.ManifestIcon{
+		{Src: resp.Icons["android-chrome-192x192.png"], Sizes: "192x192", Type: "image/png"},
+		{Src: resp.Icons["android-chrome-512x512.png"], Sizes: "512x512", Type: "image/png"},
+	}, resp.Manifest.Icons)
+}
+
+func TestHandler_Favicons_NotFound(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/favicons", strings.NewReader(`{"image_name": "missing.png"}`))
+	w := httptest.NewRecorder()
+	favicons.New(slogdiscard.NewDiscardLogger(), memory.New()).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,116 @@
+package framerate
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"online-photo-editor/internal/http-server/handlers/image/processor"
+	"online-photo-editor/internal/lib/api/framerate"
+	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/logger/sl"
+	"online-photo-editor/internal/storage"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+type Request struct {
+	framerate.FrameRateParams
+	ImageName string `json:"image_name" validate:"required,max=100,image_name"`
+}
+
+type Response struct {
+	response.Response
+	ImageUrl string `json:"image_url"`
+	Frames   int    `json:"frames"`
+	// Duration is the length of one loop in milliseconds.
+	Duration int64 `json:"duration_ms"`
+}
+
+// New changes the frame delays of an animated GIF and saves the result,
+// every frame kept, as a new GIF.
+func New(log *slog.Logger, imgRetimer processor.ImageProcessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.img.framerate.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error("empty request"))
+
+			return
+		}
+
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error("failed to decode request"))
+
+			return
+		}
+
+		if !response.Validation(log, w, r, req, http.StatusBadRequest) {
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if strings.ToLower(filepath.Ext(req.ImageName)) != ".gif" {
+			log.Error("image is not a GIF", slog.String("image", req.ImageName))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error("image must be a GIF"))
+			return
+		}
+
+		anim, err := imgRetimer.LoadGIF(req.ImageName)
+		if err != nil {
+			log.Error("failed to load image", sl.Err(err))
+			response.LoadError(w, r, err)
+			return
+		}
+
+		if err := req.FrameRateParams.Retime(anim); err != nil {
+			log.Error("failed to retime image", sl.Err(err))
+			render.Status(r, http.StatusUnprocessableEntity)
+			render.JSON(w, r, response.Error(err.Error()))
+			return
+		}
+
+		imgName, err := imgRetimer.GenerateName(storage.InNamespaceOf(req.ImageName, "proc"), ".gif")
+		if err != nil {
+			log.Error("failed to generate name", sl.Err(err))
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, response.Error("failed to generate name"))
+			return
+		}
+
+		imgUrl, err := imgRetimer.SaveGIF(anim, imgName, audit.OriginOf(r))
+		if err != nil {
+			log.Error("failed to save image", sl.Err(err))
+			response.SaveError(w, r, err, "failed to save image")
+			return
+		}
+
+		log.Info("image saved", slog.String("image url", imgUrl))
+
+		render.Status(r, http.StatusOK)
+		render.JSON(w, r, Response{
+			Response: response.OK(),
+			ImageUrl: imgUrl,
+			Frames:   len(anim.Image),
+			Duration: framerate.Duration(anim).Milliseconds(),
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package framerate_test
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"net/http"
+	"net/http/httptest"
+	"online-photo-editor/internal/http-server/handlers/image/framerate"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/logger/handlers/slogdiscard"
+	"online-photo-editor/internal/storage/memory"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func animation(delays ...int) *gif.GIF {
+	anim := &gif.GIF{}
+	for i, delay := range delays {
+		frame := image.NewPaletted(image.Rect(0, 0, 8, 8), palette.Plan9)
+		frame.Set(i, i, color.White)
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, delay)
+	}
+	return anim
+}
+
+func TestHandler_FrameRate(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		wantStatus   int
+		wantDelays   []int
+		wantDuration int64
+	}{
+		{
+			name:       "slow down doubles the duration",
+			body:       `{"image_name": "anim.gif", "speed": 0.5}`,
+			wantStatus: http.StatusOK, wantDelays: []int{20, 10, 60}, wantDuration: 900,
+		},
+		{
+			name:       "uniform fps",
+			body:       `{"image_name": "anim.gif", "fps": 25}`,
+			wantStatus: http.StatusOK, wantDelays: []int{4, 4, 4}, wantDuration: 120,
+		},
+		{
+			name:       "uniform delay",
+			body:       `{"image_name": "anim.gif", "delay": 200}`,
+			wantStatus: http.StatusOK, wantDelays: []int{20, 20, 20}, wantDuration: 600,
+		},
+		{
+			name:       "speed and fps together",
+			body:       `{"image_name": "anim.gif", "speed": 2, "fps": 10}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "negative delay",
+			body:       `{"image_name": "anim.gif", "delay": -10}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "too fast",
+			body:       `{"image_name": "anim.gif", "speed": 50}`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "not a gif",
+			body:       `{"image_name": "anim.png", "speed": 2}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := memory.New()
+			_, err := mem.SaveGIF(animation(10, 5, 30), "anim.gif", audit.Origin{})
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/image/framerate", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			framerate.New(slogdiscard.NewDiscardLogger(), mem).ServeHTTP(w, req)
+
+			require.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp framerate.Response
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, 3, resp.Frames)
+			assert.Equal(t, tt.wantDuration, resp.Duration)
+
+			saved, err := mem.LoadGIF(strings.TrimPrefix(resp.ImageUrl, "/images/"))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantDelays, saved.Delay)
+			assert.Len(t, saved.Image, 3)
+		})
+	}
+}
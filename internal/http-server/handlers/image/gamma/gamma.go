@@ -8,7 +8,10 @@ import (
 	"online-photo-editor/internal/http-server/handlers/image/processor"
 	"online-photo-editor/internal/lib/api/gamma"
 	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/encoding"
 	"online-photo-editor/internal/lib/logger/sl"
+	"online-photo-editor/internal/storage"
 	"path/filepath"
 
 	"github.com/go-chi/chi/middleware"
@@ -17,7 +20,7 @@ import (
 
 type Request struct {
 	gamma.GammaParams
-	ImageName string `json:"image_name" validate:"required,max=100"`
+	ImageName string `json:"image_name" validate:"required,max=100,image_name"`
 }
 
 type Response struct {
@@ -62,8 +65,7 @@ func New(log *slog.Logger, imgGamma processor.ImageProcessor) http.HandlerFunc {
 		inputImg, err := imgGamma.LoadImage(req.ImageName)
 		if err != nil {
 			log.Error("failed to load image", sl.Err(err))
-			render.Status(r, http.StatusNotFound)
-			render.JSON(w, r, response.Error("failed to load image"))
+			response.LoadError(w, r, err)
 			return
 		}
 
@@ -79,7 +81,7 @@ func New(log *slog.Logger, imgGamma processor.ImageProcessor) http.HandlerFunc {
 			return
 		}
 
-		imgName, err := imgGamma.GenerateName("proc", filepath.Ext(req.ImageName))
+		imgName, err := imgGamma.GenerateName(storage.InNamespaceOf(req.ImageName, "proc"), filepath.Ext(req.ImageName))
 		if err != nil {
 			log.Error("failed to generate name", sl.Err(err))
 			render.Status(r, http.StatusInternalServerError)
@@ -87,11 +89,10 @@ func New(log *slog.Logger, imgGamma processor.ImageProcessor) http.HandlerFunc {
 			return
 		}
 
-		imgUrl, err := imgGamma.SaveImage(inputImg, imgName)
+		imgUrl, err := imgGamma.SaveImage(inputImg, imgName, encoding.Options{Origin: audit.OriginOf(r)})
 		if err != nil {
 			log.Error("failed to save image", sl.Err(err))
-			render.Status(r, http.StatusUnsupportedMediaType)
-			render.JSON(w, r, response.Error("failed to save image"))
+			response.SaveError(w, r, err, "failed to save image")
 			return
 		}
 
@@ -0,0 +1,79 @@
+package info
+
+import (
+	"log/slog"
+	"net/http"
+	"online-photo-editor/internal/http-server/handlers/image/processor"
+	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/imgformat"
+	"online-photo-editor/internal/lib/logger/sl"
+	"online-photo-editor/internal/storage"
+	"path/filepath"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+type Response struct {
+	response.Response
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Format string `json:"format"`
+	// Frames is 1 for still images.
+	Frames int   `json:"frames"`
+	Size   int64 `json:"size"`
+}
+
+// New reports the dimensions, format, frame count and file size of a
+// stored image from its headers, without decoding any pixels, so it stays
+// cheap for large animations.
+func New(log *slog.Logger, imgLoader processor.ImageProcessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.img.info.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		imgName := chi.URLParam(r, "name")
+		if err := storage.ValidateName(imgName); err != nil {
+			log.Error("invalid image name", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error(err.Error()))
+			return
+		}
+
+		width, height, err := imgLoader.ImageSize(imgName)
+		if err != nil {
+			log.Error("failed to read image size", sl.Err(err))
+			response.LoadError(w, r, err)
+			return
+		}
+
+		frames, err := imgLoader.ImageFrames(imgName)
+		if err != nil {
+			log.Error("failed to count frames", sl.Err(err))
+			response.LoadError(w, r, err)
+			return
+		}
+
+		size, err := imgLoader.FileSize(imgName)
+		if err != nil {
+			log.Error("failed to read file size", sl.Err(err))
+			response.LoadError(w, r, err)
+			return
+		}
+
+		render.Status(r, http.StatusOK)
+		render.JSON(w, r, Response{
+			Response: response.OK(),
+			Width:    width,
+			Height:   height,
+			Format:   imgformat.Normalize(filepath.Ext(imgName)),
+			Frames:   frames,
+			Size:     size,
+		})
+	}
+}
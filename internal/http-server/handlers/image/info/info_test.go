@@ -0,0 +1,65 @@
+package info_test
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/gif"
+	"net/http"
+	"net/http/httptest"
+	"online-photo-editor/internal/http-server/handlers/image/info"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/logger/handlers/slogdiscard"
+	"online-photo-editor/internal/storage/memory"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_Info(t *testing.T) {
+	mem := memory.New()
+
+	anim := &gif.GIF{}
+	for i := 0; i < 4; i++ {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 64, 48), color.Palette{color.Black, color.White}))
+		anim.Delay = append(anim.Delay, 10)
+	}
+	_, err := mem.SaveGIF(anim, "anim.gif", audit.Origin{})
+	require.NoError(t, err)
+	_, err = mem.SaveImage(image.NewRGBA(image.Rect(0, 0, 20, 10)), "still.png", encoding.Options{})
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Get("/images/{name}/info", info.New(slogdiscard.NewDiscardLogger(), mem))
+
+	get := func(name string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/images/"+name+"/info", nil))
+		return w
+	}
+
+	tests := []struct {
+		name string
+		want info.Response
+	}{
+		{"anim.gif", info.Response{Width: 64, Height: 48, Format: "gif", Frames: 4}},
+		{"still.png", info.Response{Width: 20, Height: 10, Format: "png", Frames: 1}},
+	}
+	for _, tt := range tests {
+		w := get(tt.name)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var resp info.Response
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, tt.want.Width, resp.Width, tt.name)
+		assert.Equal(t, tt.want.Height, resp.Height, tt.name)
+		assert.Equal(t, tt.want.Format, resp.Format, tt.name)
+		assert.Equal(t, tt.want.Frames, resp.Frames, tt.name)
+		assert.Positive(t, resp.Size, tt.name)
+	}
+
+	assert.Equal(t, http.StatusNotFound, get("missing.png").Code)
+}
@@ -0,0 +1,71 @@
+package phash
+
+import (
+	"log/slog"
+	"net/http"
+	"online-photo-editor/internal/http-server/handlers/image/processor"
+	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/logger/sl"
+	"online-photo-editor/internal/lib/phash"
+	"online-photo-editor/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+type Response struct {
+	response.Response
+	Algorithm string `json:"algorithm"`
+	Hash      string `json:"hash"`
+}
+
+func New(log *slog.Logger, imgLoader processor.ImageProcessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.img.phash.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		imgName := chi.URLParam(r, "name")
+		if err := storage.ValidateName(imgName); err != nil {
+			log.Error("invalid image name", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error(err.Error()))
+			return
+		}
+
+		algorithm := r.URL.Query().Get("algorithm")
+		if algorithm == "" {
+			algorithm = phash.AlgorithmPerceptual
+		}
+
+		inputImg, err := imgLoader.LoadImage(imgName)
+		if err != nil {
+			log.Error("failed to load image", sl.Err(err))
+			response.LoadError(w, r, err)
+			return
+		}
+
+		hash, err := phash.Hash(inputImg, algorithm)
+		if err != nil {
+			log.Error("failed to hash image", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error("algorithm must be one of ahash, dhash, phash"))
+			return
+		}
+
+		responseOK(w, r, algorithm, phash.Hex(hash))
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, algorithm string, hash string) {
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, Response{
+		Response:  response.OK(),
+		Algorithm: algorithm,
+		Hash:      hash,
+	})
+}
@@ -0,0 +1,127 @@
+package placeholder
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"online-photo-editor/internal/http-server/handlers/image/processor"
+	"online-photo-editor/internal/lib/api/placeholder"
+	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/imgformat"
+	"online-photo-editor/internal/lib/logger/sl"
+	"online-photo-editor/internal/storage"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+type Response struct {
+	response.Response
+	DataURI string `json:"data_uri"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Size    int    `json:"size"`
+}
+
+// New returns a low-quality image placeholder of a stored image as a data
+// URI, to inline as the src of an <img> while the image loads. The
+// width, format, quality and blur query params are those of
+// placeholder.PlaceholderParams. Nothing is kept in storage.
+func New(log *slog.Logger, imgProcessor processor.ImageProcessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.img.placeholder.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		imgName := chi.URLParam(r, "name")
+		if err := storage.ValidateName(imgName); err != nil {
+			log.Error("invalid image name", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error(err.Error()))
+			return
+		}
+
+		params, err := parseParams(r.URL.Query())
+		if err != nil {
+			log.Error("invalid placeholder params", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error(err.Error()))
+			return
+		}
+
+		if !response.Validation(log, w, r, params, http.StatusBadRequest) {
+			return
+		}
+
+		inputImg, err := imgProcessor.LoadImage(imgName)
+		if err != nil {
+			log.Error("failed to load image", sl.Err(err))
+			response.LoadError(w, r, err)
+			return
+		}
+
+		outputImg, err := params.PlaceholderImage(inputImg)
+		if err != nil {
+			log.Error("failed to make placeholder", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error("failed to make placeholder"))
+			return
+		}
+
+		fileExt := params.FileExt()
+
+		// The placeholder goes straight into the response, so it takes no
+		// room in storage and can't evict anything.
+		var buf bytes.Buffer
+		if err := encoding.Encode(&buf, outputImg, fileExt, encoding.Options{Quality: params.EncodeQuality()}); err != nil {
+			log.Error("failed to encode placeholder", sl.Err(err))
+			response.SaveError(w, r, fmt.Errorf("%w: %w", storage.ErrEncode, err), "failed to encode placeholder")
+			return
+		}
+		data := buf.Bytes()
+
+		b := outputImg.Bounds()
+		render.Status(r, http.StatusOK)
+		render.JSON(w, r, Response{
+			Response: response.OK(),
+			DataURI:  "data:" + imgformat.ContentType(fileExt) + ";base64," + base64.StdEncoding.EncodeToString(data),
+			Width:    b.Dx(),
+			Height:   b.Dy(),
+			Size:     len(data),
+		})
+	}
+}
+
+// parseParams reads the width, format, quality and blur query params.
+func parseParams(query url.Values) (placeholder.PlaceholderParams, error) {
+	params := placeholder.PlaceholderParams{Format: query.Get("format")}
+
+	for key, dst := range map[string]*int{"width": &params.Width, "quality": &params.Quality} {
+		if raw := query.Get(key); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				return params, fmt.Errorf("%s must be a whole number", key)
+			}
+			*dst = n
+		}
+	}
+
+	if raw := query.Get("blur"); raw != "" {
+		blur, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return params, fmt.Errorf("blur must be a number")
+		}
+		params.Blur = blur
+	}
+
+	return params, nil
+}
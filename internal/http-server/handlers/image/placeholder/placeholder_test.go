@@ -0,0 +1,76 @@
+package placeholder_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"online-photo-editor/internal/http-server/handlers/image/placeholder"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/logger/handlers/slogdiscard"
+	"online-photo-editor/internal/storage/memory"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_Placeholder(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(imaging.New(400, 200, color.NRGBA{R: 200, G: 40, B: 40, A: 255}), "photo.png", encoding.Options{})
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Get("/images/{name}/placeholder", placeholder.New(slogdiscard.NewDiscardLogger(), mem))
+
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	w := get("/images/photo.png/placeholder?width=16&format=jpg&quality=20&blur=1")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp placeholder.Response
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, [2]int{16, 8}, [2]int{resp.Width, resp.Height})
+
+	const prefix = "data:image/jpeg;base64,"
+	require.True(t, strings.HasPrefix(resp.DataURI, prefix), resp.DataURI)
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(resp.DataURI, prefix))
+	require.NoError(t, err)
+	assert.Equal(t, resp.Size, len(data))
+
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 16, 8), img.Bounds())
+	assert.Equal(t, []string{"photo.png"}, mem.List(), "the placeholder isn't kept")
+
+	w = get("/images/photo.png/placeholder")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, strings.HasPrefix(resp.DataURI, "data:image/webp;base64,"), resp.DataURI)
+	assert.Equal(t, [2]int{20, 10}, [2]int{resp.Width, resp.Height})
+
+	for _, query := range []string{"width=1000", "width=wide", "format=png", "blur=-1"} {
+		w = get("/images/photo.png/placeholder?" + query)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+
+	w = get("/images/missing.png/placeholder")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// A full storage neither fails a placeholder nor evicts for it.
+	mem.MaxImages = 1
+	w = get("/images/photo.png/placeholder")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, []string{"photo.png"}, mem.List())
+}
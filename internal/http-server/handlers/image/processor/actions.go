@@ -0,0 +1,615 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"online-photo-editor/internal/lib/api/autocrop"
+	"online-photo-editor/internal/lib/api/blur"
+	"online-photo-editor/internal/lib/api/brightness"
+	"online-photo-editor/internal/lib/api/channelmixer"
+	"online-photo-editor/internal/lib/api/contrast"
+	"online-photo-editor/internal/lib/api/convert"
+	"online-photo-editor/internal/lib/api/crop"
+	"online-photo-editor/internal/lib/api/datestamp"
+	"online-photo-editor/internal/lib/api/dewarp"
+	"online-photo-editor/internal/lib/api/distort"
+	"online-photo-editor/internal/lib/api/exposure"
+	"online-photo-editor/internal/lib/api/frame"
+	"online-photo-editor/internal/lib/api/gamma"
+	"online-photo-editor/internal/lib/api/gradientmap"
+	"online-photo-editor/internal/lib/api/guides"
+	"online-photo-editor/internal/lib/api/lut"
+	"online-photo-editor/internal/lib/api/motionblur"
+	"online-photo-editor/internal/lib/api/perspective"
+	"online-photo-editor/internal/lib/api/portrait"
+	"online-photo-editor/internal/lib/api/reducecolors"
+	"online-photo-editor/internal/lib/api/reflection"
+	"online-photo-editor/internal/lib/api/replacebg"
+	"online-photo-editor/internal/lib/api/replacecolor"
+	"online-photo-editor/internal/lib/api/resize"
+	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/api/saturation"
+	"online-photo-editor/internal/lib/api/shadowshighlights"
+	"online-photo-editor/internal/lib/api/sharpen"
+	"online-photo-editor/internal/lib/api/socialcard"
+	"online-photo-editor/internal/lib/api/straighten"
+	"online-photo-editor/internal/lib/api/swirl"
+	"online-photo-editor/internal/lib/api/watermark"
+	"online-photo-editor/internal/lib/profile"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// step is an action whose params are decoded and validated.
+type step struct {
+	action string
+	params interface{}
+	// apply is nil for actions that only change how the result is saved.
+	apply func(image.Image) (image.Image, error)
+	// format, quality, bitDepth and dither are the output settings picked
+	// by a convert action. quality is convert.QualityAuto to pick it from
+	// the result.
+	format   string
+	quality  int
+	bitDepth int
+	dither   bool
+}
+
+// boundsChecker is implemented by params that must fit inside the image
+// they are applied to.
+type boundsChecker interface {
+	CheckBounds(width, height int) error
+}
+
+// sizer is implemented by params that change the image dimensions. ok is
+// false when the new size can only be known by running the action.
+type sizer interface {
+	OutputSize(width, height int) (w int, h int, ok bool)
+}
+
+// dpiSetter is implemented by params that ask for a print resolution to be
+// recorded in the saved image. Zero leaves it unset.
+type dpiSetter interface {
+	OutputDPI() int
+}
+
+// alphaNeeder is implemented by params whose result is meant to be
+// transparent, so it must be saved in a format with an alpha channel.
+type alphaNeeder interface {
+	NeedsAlpha() bool
+}
+
+// alphaStep returns the action of the first step whose result must keep
+// its transparency, false if there is none.
+func alphaStep(steps []step) (string, bool) {
+	for _, s := range steps {
+		if needer, ok := s.params.(alphaNeeder); ok && needer.NeedsAlpha() {
+			return s.action, true
+		}
+	}
+	return "", false
+}
+
+// memoryBudgeter is implemented by params that can work on parts of the
+// image at a time to stay within a working memory budget.
+type memoryBudgeter interface {
+	SetMemoryBudget(budget int64)
+}
+
+// setMemoryBudget hands budget, in bytes, to the steps that can keep to it.
+func setMemoryBudget(steps []step, budget int64) {
+	for _, s := range steps {
+		if budgeter, ok := s.params.(memoryBudgeter); ok {
+			budgeter.SetMemoryBudget(budget)
+		}
+	}
+}
+
+// upscaleLimiter is implemented by params that can be kept from enlarging
+// the image.
+type upscaleLimiter interface {
+	SetUpscaleDefaults(allow bool, onUpscale string)
+}
+
+// setUpscaleDefaults applies Options.DenyUpscale and Options.OnUpscale to
+// the steps that leave allow_upscale and on_upscale out.
+func (opts Options) setUpscaleDefaults(steps []step) {
+	onUpscale := opts.OnUpscale
+	if onUpscale == "" {
+		onUpscale = resize.UpscaleClamp
+	}
+
+	for _, s := range steps {
+		if limiter, ok := s.params.(upscaleLimiter); ok {
+			limiter.SetUpscaleDefaults(!opts.DenyUpscale, onUpscale)
+		}
+	}
+}
+
+// sourceDPIUser is implemented by params that may fall back to the
+// resolution recorded in the source image.
+type sourceDPIUser interface {
+	NeedsSourceDPI() bool
+	SetSourceDPI(dpi int)
+}
+
+// needsSourceDPI reports whether any step needs the resolution recorded in
+// the source image.
+func needsSourceDPI(steps []step) bool {
+	for _, s := range steps {
+		if user, ok := s.params.(sourceDPIUser); ok && user.NeedsSourceDPI() {
+			return true
+		}
+	}
+	return false
+}
+
+// setSourceDPI hands dpi, the resolution recorded in the source image, to
+// the steps that need it.
+func setSourceDPI(steps []step, dpi int) {
+	for _, s := range steps {
+		if user, ok := s.params.(sourceDPIUser); ok && user.NeedsSourceDPI() {
+			user.SetSourceDPI(dpi)
+		}
+	}
+}
+
+// captureTimeUser is implemented by params that use the time the source
+// image was taken.
+type captureTimeUser interface {
+	NeedsCaptureTime() bool
+	SetCaptureTime(t time.Time)
+}
+
+// needsCaptureTime reports whether any step needs the time the source
+// image was taken.
+func needsCaptureTime(steps []step) bool {
+	for _, s := range steps {
+		if user, ok := s.params.(captureTimeUser); ok && user.NeedsCaptureTime() {
+			return true
+		}
+	}
+	return false
+}
+
+// setCaptureTime hands t, the time the source image was taken, to the
+// steps that need it.
+func setCaptureTime(steps []step, t time.Time) {
+	for _, s := range steps {
+		if user, ok := s.params.(captureTimeUser); ok && user.NeedsCaptureTime() {
+			user.SetCaptureTime(t)
+		}
+	}
+}
+
+// actionError is a client error in the request, reported with status.
+type actionError struct {
+	status int
+	msg    string
+	err    error
+}
+
+func (e *actionError) Error() string {
+	if e.err == nil {
+		return e.msg
+	}
+	return fmt.Sprintf("%s: %v", e.msg, e.err)
+}
+
+func (e *actionError) Unwrap() error {
+	return e.err
+}
+
+// message is what the client is told. Only bad requests include the
+// underlying error, as it explains what to change.
+func (e *actionError) message() string {
+	if e.status == http.StatusBadRequest && e.err != nil {
+		return e.Error()
+	}
+	return e.msg
+}
+
+// parseActions decodes and validates every action into a step, without
+// touching the image. A convert only picks the format the result is saved
+// in, so it must be the last action: pixel ops after it would not see the
+// conversion. With continueOnError an image an action refers to that can't
+// be found fails only that action, when it runs.
+func parseActions(actions []ImageAction, imgProcessor ImageProcessor, settings profile.Settings, continueOnError bool) ([]step, error) {
+	steps := make([]step, 0, len(actions))
+
+	for i, action := range actions {
+		if action.Action == convertAction && i != len(actions)-1 {
+			return nil, &actionError{status: http.StatusBadRequest, msg: "convert must be the last action"}
+		}
+
+		s, err := parseAction(action, imgProcessor, settings)
+		var actionErr *actionError
+		if continueOnError && errors.As(err, &actionErr) && actionErr.status == http.StatusNotFound {
+			s = step{action: action.Action, apply: func(image.Image) (image.Image, error) { return nil, actionErr }}
+		} else if err != nil {
+			return nil, err
+		}
+		steps = append(steps, s)
+	}
+
+	return steps, nil
+}
+
+func parseAction(action ImageAction, imgProcessor ImageProcessor, settings profile.Settings) (step, error) {
+	if err := validateStruct(action); err != nil {
+		return step{}, err
+	}
+
+	s := step{action: action.Action}
+
+	switch action.Action {
+	case cropAction:
+		var params crop.CropParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.CropImage
+	case resizeAction:
+		var params resize.ResizeParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		if params.Filter == "" {
+			params.Filter = settings.ResizeFilter
+		}
+		s.params, s.apply = &params, params.ResizeImage
+	case letterboxAction:
+		var params resize.LetterboxParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		if params.Filter == "" {
+			params.Filter = settings.ResizeFilter
+		}
+		s.params, s.apply = &params, params.LetterboxImage
+	case blurAction:
+		var params blur.BlurParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.BlurImage
+	case motionBlurAction:
+		var params motionblur.MotionBlurParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.MotionBlurImage
+	case swirlAction:
+		var params swirl.SwirlParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.SwirlImage
+	case distortAction:
+		var params distort.DistortParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.DistortImage
+	case dewarpAction:
+		var params dewarp.DewarpParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.DewarpImage
+	case removeBgAction:
+		var params replacebg.RemoveBgParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.RemoveBgImage
+	case reflectionAction:
+		var params reflection.ReflectionParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.ReflectionImage
+	case gradientMapAction:
+		var params gradientmap.GradientMapParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.GradientMapImage
+	case channelMixerAction:
+		var params channelmixer.ChannelMixerParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.ChannelMixerImage
+	case perspectiveAction:
+		var params perspective.PerspectiveParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.PerspectiveImage
+	case gammaAction:
+		var params gamma.GammaParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.GammaImage
+	case contrastAction:
+		var params contrast.ContrastParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.ContrastImage
+	case sharpenAction:
+		var params sharpen.SharpenParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.SharpenImage
+	case brightnessAction:
+		var params brightness.BrightnessParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.BrightnessImage
+	case saturationAction:
+		var params saturation.SaturationParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.SaturationImage
+	case guidesAction:
+		var params guides.GuidesParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.GuidesImage
+	case straightenAction:
+		var params straighten.StraightenParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.StraightenImage
+	case replaceColorAction:
+		var params replacecolor.ReplaceColorParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.ReplaceColorImage
+	case exposureAction:
+		var params exposure.ExposureParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.ExposureImage
+	case shadowsHighlightsAction:
+		var params shadowshighlights.ShadowsHighlightsParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.ShadowsHighlightsImage
+	case replaceBgAction:
+		var params replacebg.ReplaceBgParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+
+		if params.ImageName != "" {
+			if _, err := imgProcessor.FindImage(params.ImageName); err != nil {
+				return step{}, &actionError{status: http.StatusNotFound, msg: "failed to find background image", err: err}
+			}
+		}
+
+		s.params = &params
+		s.apply = func(img image.Image) (image.Image, error) {
+			var bgImg image.Image
+			if params.ImageName != "" {
+				var err error
+				if bgImg, err = imgProcessor.LoadImage(params.ImageName); err != nil {
+					return nil, err
+				}
+			}
+			return params.ReplaceBgImage(img, bgImg)
+		}
+	case watermarkAction:
+		var params watermark.WatermarkParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+
+		if _, err := imgProcessor.FindImage(params.ImageName); err != nil {
+			return step{}, &actionError{status: http.StatusNotFound, msg: "failed to find watermark image", err: err}
+		}
+
+		s.params = &params
+		s.apply = func(img image.Image) (image.Image, error) {
+			mark, err := imgProcessor.LoadImage(params.ImageName)
+			if err != nil {
+				return nil, err
+			}
+			return params.WatermarkImage(img, mark)
+		}
+	case frameAction:
+		var params frame.FrameParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+
+		if _, err := imgProcessor.FindImage(params.FrameName); err != nil {
+			return step{}, &actionError{status: http.StatusNotFound, msg: "failed to find frame image", err: err}
+		}
+
+		s.params = &params
+		s.apply = func(img image.Image) (image.Image, error) {
+			frameImg, err := imgProcessor.LoadImage(params.FrameName)
+			if err != nil {
+				return nil, err
+			}
+			return params.FrameImage(img, frameImg)
+		}
+	case lutAction:
+		var params lut.LUTParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+
+		cube, err := loadLUT(imgProcessor, params.LUTName)
+		if err != nil {
+			return step{}, err
+		}
+
+		s.params = &params
+		s.apply = func(img image.Image) (image.Image, error) {
+			return params.LUTImage(img, cube)
+		}
+	case socialCardAction:
+		var params socialcard.SocialCardParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.SocialCardImage
+	case reduceColorsAction:
+		var params reducecolors.ReduceColorsParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.ReduceColorsImage
+	case portraitAction:
+		var params portrait.PortraitParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.PortraitImage
+	case autoCropAction:
+		var params autocrop.AutoCropParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.AutoCropImage
+	case datestampAction:
+		var params datestamp.DatestampParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+		s.params, s.apply = &params, params.DatestampImage
+	case convertAction:
+		var params convert.ConvertParams
+		if err := decodeStep(action, &params); err != nil {
+			return step{}, err
+		}
+
+		format, err := params.ConvertImage()
+		if errors.Is(err, convert.ErrAutoQuality) {
+			return step{}, &actionError{status: http.StatusBadRequest, msg: convert.ErrAutoQuality.Error()}
+		}
+		if err != nil {
+			return step{}, &actionError{status: http.StatusUnsupportedMediaType, msg: convert.Message(params.Format, err), err: err}
+		}
+		s.params, s.format, s.quality = &params, format, int(params.Quality)
+		s.bitDepth, s.dither = params.BitDepth, params.Dither
+	default:
+		return step{}, &actionError{
+			status: http.StatusBadRequest,
+			msg:    fmt.Sprintf("field %s must be one of the allowed values", action.Action),
+		}
+	}
+
+	return s, nil
+}
+
+// loadLUT reads and parses the stored .cube file lutName, so a missing or
+// malformed one fails the request before any image is processed.
+func loadLUT(imgProcessor ImageProcessor, lutName string) (*lut.Cube, error) {
+	file, err := imgProcessor.OpenImage(lutName)
+	if err != nil {
+		return nil, &actionError{status: http.StatusNotFound, msg: "failed to find lut", err: err}
+	}
+	defer file.Close()
+
+	cube, err := lut.Parse(file)
+	if err != nil {
+		return nil, &actionError{status: http.StatusBadRequest, msg: "invalid lut file", err: err}
+	}
+
+	return cube, nil
+}
+
+// checkSteps runs the bounds checks of every step against a width×height
+// source image, following size changes through the chain. Once a step's
+// output size is unknown the remaining checks are left to run time. It
+// returns the final size and whether it is known.
+func checkSteps(steps []step, width, height int) (int, int, bool, error) {
+	for _, s := range steps {
+		if checker, ok := s.params.(boundsChecker); ok {
+			if err := checker.CheckBounds(width, height); err != nil {
+				return 0, 0, false, &actionError{
+					status: http.StatusBadRequest,
+					msg:    fmt.Sprintf("failed to perform action %s", s.action),
+					err:    err,
+				}
+			}
+		}
+
+		if resizer, ok := s.params.(sizer); ok {
+			var known bool
+			if width, height, known = resizer.OutputSize(width, height); !known {
+				return 0, 0, false, nil
+			}
+		}
+	}
+
+	return width, height, true, nil
+}
+
+// decodeStep decodes and validates the params of action.
+func decodeStep(action ImageAction, params interface{}) error {
+	if err := decodeParams(action.Params, params); err != nil {
+		return &actionError{
+			status: http.StatusBadRequest,
+			msg:    fmt.Sprintf("invalid %s params: %v", action.Action, err),
+		}
+	}
+
+	return validateStruct(params)
+}
+
+func validateStruct(s interface{}) error {
+	if err := response.ValidateStruct(s); err != nil {
+		var validateErr validator.ValidationErrors
+		if !errors.As(err, &validateErr) {
+			return &actionError{status: http.StatusBadRequest, msg: "invalid request", err: err}
+		}
+		return &actionError{status: http.StatusBadRequest, msg: response.ValidationError(validateErr).Error}
+	}
+
+	return nil
+}
+
+// decodeParams decodes action params into output. Fields output does not
+// declare are rejected, so a typo fails loudly instead of leaving the
+// intended field at its zero value.
+func decodeParams(input interface{}, output interface{}) error {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(output); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return fmt.Errorf("field %s must be %s", typeErr.Field, typeErr.Type)
+		}
+		// The decoder reports unknown fields as `json: unknown field "name"`.
+		return errors.New(strings.TrimPrefix(err.Error(), "json: "))
+	}
+
+	return nil
+}
@@ -0,0 +1,332 @@
+package processor
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/logger/sl"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	maxBatchSize = 100
+	// maxRunningBatches caps the batches being processed at once; more
+	// are turned away with 503 instead of queueing up.
+	maxRunningBatches = 10
+	// batchTTL is how long the status of a finished batch is kept.
+	batchTTL = time.Hour
+)
+
+// The states of a batch and of its images.
+const (
+	StatePending = "pending"
+	StateDone    = "done"
+	StateFailed  = "failed"
+)
+
+type BatchRequest struct {
+	Requests []Request `json:"requests" validate:"required,min=1"`
+}
+
+type BatchCreatedResponse struct {
+	response.Response
+	BatchID   string `json:"batch_id"`
+	StatusURL string `json:"status_url"`
+}
+
+// BatchResult is the outcome of one request of a batch, in the order of
+// the batch.
+type BatchResult struct {
+	State string `json:"state"`
+	// Code is the status the request would have been answered with alone.
+	Code      int               `json:"code,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	ImageUrl  string            `json:"image_url,omitempty"`
+	Width     int               `json:"width,omitempty"`
+	Height    int               `json:"height,omitempty"`
+	Format    string            `json:"format,omitempty"`
+	Size      int64             `json:"size,omitempty"`
+	Scale     float64           `json:"scale,omitempty"`
+	Fallbacks []Fallback        `json:"fallbacks,omitempty"`
+	Outputs   map[string]string `json:"outputs,omitempty"`
+	Warnings  []string          `json:"warnings,omitempty"`
+	DataURI   string            `json:"data_uri,omitempty"`
+	Temp      bool              `json:"temp,omitempty"`
+}
+
+type BatchStatusResponse struct {
+	response.Response
+	BatchID string `json:"batch_id"`
+	// State is pending until every request is done or failed, then done.
+	State   string        `json:"state"`
+	Pending int           `json:"pending"`
+	Done    int           `json:"done"`
+	Failed  int           `json:"failed"`
+	Results []BatchResult `json:"results"`
+}
+
+type batch struct {
+	results  []BatchResult
+	finished time.Time
+}
+
+// Batches keeps the batches submitted with NewBatch for NewBatchStatus.
+type Batches struct {
+	mu      sync.Mutex
+	batches map[string]*batch
+	running int
+}
+
+func NewBatches() *Batches {
+	return &Batches{batches: make(map[string]*batch)}
+}
+
+// add registers a batch of n pending requests, dropping the finished
+// batches past batchTTL. It fails when maxRunningBatches are running.
+func (b *Batches) add(n int) (string, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(idBytes)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for id, bt := range b.batches {
+		if !bt.finished.IsZero() && time.Since(bt.finished) > batchTTL {
+			delete(b.batches, id)
+		}
+	}
+
+	if b.running >= maxRunningBatches {
+		return "", errBatchesBusy
+	}
+	b.running++
+
+	results := make([]BatchResult, n)
+	for i := range results {
+		results[i].State = StatePending
+	}
+	b.batches[id] = &batch{results: results}
+
+	return id, nil
+}
+
+func (b *Batches) set(id string, i int, result BatchResult) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.batches[id].results[i] = result
+}
+
+func (b *Batches) finish(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.batches[id].finished = time.Now()
+	b.running--
+}
+
+// status returns the aggregate status of batch id, false if there is none.
+func (b *Batches) status(id string) (BatchStatusResponse, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bt, ok := b.batches[id]
+	if !ok {
+		return BatchStatusResponse{}, false
+	}
+
+	resp := BatchStatusResponse{
+		Response: response.OK(),
+		BatchID:  id,
+		State:    StatePending,
+		Results:  append([]BatchResult(nil), bt.results...),
+	}
+	for _, result := range bt.results {
+		switch result.State {
+		case StatePending:
+			resp.Pending++
+		case StateDone:
+			resp.Done++
+		case StateFailed:
+			resp.Failed++
+		}
+	}
+	if !bt.finished.IsZero() {
+		resp.State = StateDone
+	}
+
+	return resp, true
+}
+
+var errBatchesBusy = errors.New("too many batches are running, try again later")
+
+// NewBatch accepts up to maxBatchSize processing requests at once,
+// answers 202 with the batch id at once and processes the requests one
+// after the other in the background. Every request is validated up front;
+// one that doesn't fails the whole batch with 400. Previews and presets
+// are not supported in batches.
+func NewBatch(log *slog.Logger, imgProcessor ImageProcessor, opts Options, batches *Batches) http.HandlerFunc {
+	// Identical requests in flight at the same time share one run.
+	var group singleflight.Group
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.img.processor.NewBatch"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req BatchRequest
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error("failed to decode request"))
+			return
+		}
+
+		if len(req.Requests) == 0 || len(req.Requests) > maxBatchSize {
+			log.Error("invalid batch size", slog.Int("size", len(req.Requests)))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error(fmt.Sprintf("a batch holds 1 to %d requests", maxBatchSize)))
+			return
+		}
+
+		for i, item := range req.Requests {
+			msg := batchItemError(item)
+			if msg == "" && item.Return == ReturnDataURI {
+				msg = "data uris can't be batched"
+			}
+
+			if msg != "" {
+				log.Error("invalid batch request", slog.Int("index", i), slog.String("error", msg))
+				render.Status(r, http.StatusBadRequest)
+				render.JSON(w, r, response.Error(fmt.Sprintf("request %d: %s", i, msg)))
+				return
+			}
+		}
+
+		id, err := batches.add(len(req.Requests))
+		if errors.Is(err, errBatchesBusy) {
+			log.Error("too many batches running")
+			render.Status(r, http.StatusServiceUnavailable)
+			render.JSON(w, r, response.Error(errBatchesBusy.Error()))
+			return
+		}
+		if err != nil {
+			log.Error("failed to create batch", sl.Err(err))
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, response.Error("failed to create batch"))
+			return
+		}
+
+		log.Info("batch created", slog.String("batch_id", id), slog.Int("size", len(req.Requests)))
+
+		// The batch outlives the request that submitted it.
+		ctx := context.WithoutCancel(r.Context())
+		origin := audit.OriginOf(r)
+		go func() {
+			defer batches.finish(id)
+
+			for i, item := range req.Requests {
+				batches.set(id, i, opts.batchResult(ctx, log, imgProcessor, &group, item, origin))
+			}
+		}()
+
+		render.Status(r, http.StatusAccepted)
+		render.JSON(w, r, BatchCreatedResponse{
+			Response:  response.OK(),
+			BatchID:   id,
+			StatusURL: "/batches/" + id,
+		})
+	}
+}
+
+// batchItemError returns why req can't be part of a batch, or "" if it
+// can.
+func batchItemError(req Request) string {
+	var validateErr validator.ValidationErrors
+	if err := response.ValidateStruct(req); errors.As(err, &validateErr) {
+		return response.ValidationError(validateErr).Error
+	} else if err != nil {
+		return err.Error()
+	}
+
+	if req.Preview {
+		return "previews can't be batched"
+	}
+	return ""
+}
+
+// batchResult processes one request of a batch.
+func (opts Options) batchResult(ctx context.Context, log *slog.Logger, imgProcessor ImageProcessor, group *singleflight.Group, req Request, origin audit.Origin) BatchResult {
+	resp, err := opts.process(ctx, log, imgProcessor, group, nil, req, origin)
+	if err != nil {
+		log.Error("batch request failed", sl.Err(err))
+
+		var actionErr *actionError
+		if !errors.As(err, &actionErr) {
+			return BatchResult{State: StateFailed, Code: http.StatusInternalServerError, Error: "failed to process image"}
+		}
+		return BatchResult{State: StateFailed, Code: actionErr.status, Error: actionErr.message()}
+	}
+
+	return BatchResult{
+		State:     StateDone,
+		Code:      http.StatusOK,
+		ImageUrl:  resp.ImageUrl,
+		Width:     resp.Width,
+		Height:    resp.Height,
+		Format:    resp.Format,
+		Size:      resp.Size,
+		Scale:     resp.Scale,
+		Fallbacks: resp.Fallbacks,
+		Outputs:   resp.Outputs,
+		Warnings:  resp.Warnings,
+		DataURI:   resp.DataURI,
+		Temp:      resp.Temp,
+	}
+}
+
+// NewBatchStatus reports the aggregate status of the batch in the id URL
+// param: how many of its requests are pending, done and failed, and the
+// result of each.
+func NewBatchStatus(log *slog.Logger, batches *Batches) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.img.processor.NewBatchStatus"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		id := chi.URLParam(r, "id")
+
+		resp, ok := batches.status(id)
+		if !ok {
+			log.Error("batch not found", slog.String("batch_id", id))
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, response.Error("batch not found"))
+			return
+		}
+
+		render.Status(r, http.StatusOK)
+		render.JSON(w, r, resp)
+	}
+}
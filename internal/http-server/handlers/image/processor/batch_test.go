@@ -0,0 +1,158 @@
+package processor_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"online-photo-editor/internal/http-server/handlers/image/processor"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/logger/handlers/slogdiscard"
+	"online-photo-editor/internal/storage/memory"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func batchRouter(mem *memory.MemStorage) *chi.Mux {
+	log := slogdiscard.NewDiscardLogger()
+	batches := processor.NewBatches()
+
+	router := chi.NewRouter()
+	router.Post("/batches", processor.NewBatch(log, mem, processor.Options{}, batches))
+	router.Get("/batches/{id}", processor.NewBatchStatus(log, batches))
+	return router
+}
+
+func TestHandler_Batch(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(image.NewRGBA(image.Rect(0, 0, 200, 100)), "test-image.png", encoding.Options{})
+	require.NoError(t, err)
+
+	router := batchRouter(mem)
+
+	resize := func(width int) []processor.ImageAction {
+		return []processor.ImageAction{{Action: "resize", Params: map[string]interface{}{"width": width}}}
+	}
+	body, err := json.Marshal(processor.BatchRequest{Requests: []processor.Request{
+		{Actions: resize(50), ImageName: "test-image.png"},
+		{Actions: resize(50), ImageName: "missing.png"},
+		{Actions: resize(100), ImageName: "test-image.png"},
+	}})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/batches", bytes.NewReader(body)))
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	var created processor.BatchCreatedResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, "/batches/"+created.BatchID, created.StatusURL)
+
+	var status processor.BatchStatusResponse
+	require.Eventually(t, func() bool {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, created.StatusURL, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+
+		assert.Equal(t, 3, status.Pending+status.Done+status.Failed)
+		return status.State == processor.StateDone
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, 0, status.Pending)
+	assert.Equal(t, 2, status.Done)
+	assert.Equal(t, 1, status.Failed)
+	require.Len(t, status.Results, 3)
+
+	assert.Equal(t, processor.StateDone, status.Results[0].State)
+	assert.Equal(t, 50, status.Results[0].Width)
+	assert.NotEmpty(t, status.Results[0].ImageUrl)
+
+	assert.Equal(t, processor.StateFailed, status.Results[1].State)
+	assert.Equal(t, http.StatusNotFound, status.Results[1].Code)
+	assert.Equal(t, "failed to find image", status.Results[1].Error)
+
+	assert.Equal(t, processor.StateDone, status.Results[2].State)
+	assert.Equal(t, [2]int{100, 50}, [2]int{status.Results[2].Width, status.Results[2].Height})
+}
+
+func TestHandler_Batch_Invalid(t *testing.T) {
+	router := batchRouter(memory.New())
+
+	for _, batch := range []processor.BatchRequest{
+		{},
+		// The second request has no image.
+		{Requests: []processor.Request{
+			{Actions: []processor.ImageAction{{Action: "blur", Params: map[string]interface{}{"sigma": 1}}}, ImageName: "a.png"},
+			{Actions: []processor.ImageAction{{Action: "blur", Params: map[string]interface{}{"sigma": 1}}}},
+		}},
+	} {
+		body, err := json.Marshal(batch)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/batches", bytes.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/batches/nope", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandler_SyncBatch(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(image.NewRGBA(image.Rect(0, 0, 200, 100)), "test-image.png", encoding.Options{})
+	require.NoError(t, err)
+
+	handler := processor.NewSyncBatch(slogdiscard.NewDiscardLogger(), mem, processor.Options{})
+
+	send := func(batch processor.BatchRequest) *httptest.ResponseRecorder {
+		body, err := json.Marshal(batch)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/batch", bytes.NewReader(body)))
+		return w
+	}
+
+	w := send(processor.BatchRequest{Requests: []processor.Request{
+		{Actions: []processor.ImageAction{{Action: "resize", Params: map[string]interface{}{"width": 50}}}, ImageName: "test-image.png"},
+		{Actions: []processor.ImageAction{{Action: "crop", Params: map[string]interface{}{"width": 30, "height": 80}}}, ImageName: "test-image.png", OutputFormat: "jpg"},
+		{Actions: []processor.ImageAction{{Action: "blur", Params: map[string]interface{}{"sigma": 1}}}, ImageName: "missing.png"},
+		{Actions: []processor.ImageAction{{Action: "blur", Params: map[string]interface{}{"sigma": 1}}}},
+	}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp processor.SyncBatchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Results, 4)
+
+	assert.Equal(t, processor.StateDone, resp.Results[0].State)
+	assert.Equal(t, [2]int{50, 25}, [2]int{resp.Results[0].Width, resp.Results[0].Height})
+	assert.Equal(t, "png", resp.Results[0].Format)
+
+	assert.Equal(t, processor.StateDone, resp.Results[1].State)
+	assert.Equal(t, [2]int{30, 80}, [2]int{resp.Results[1].Width, resp.Results[1].Height})
+	assert.Equal(t, "jpg", resp.Results[1].Format)
+	assert.NotEqual(t, resp.Results[0].ImageUrl, resp.Results[1].ImageUrl)
+
+	assert.Equal(t, processor.StateFailed, resp.Results[2].State)
+	assert.Equal(t, http.StatusNotFound, resp.Results[2].Code)
+
+	assert.Equal(t, processor.StateFailed, resp.Results[3].State)
+	assert.Equal(t, http.StatusBadRequest, resp.Results[3].Code, "an invalid request fails alone")
+
+	blurs := make([]processor.ImageAction, 101)
+	for i := range blurs {
+		blurs[i] = processor.ImageAction{Action: "blur", Params: map[string]interface{}{"sigma": 1}}
+	}
+	w = send(processor.BatchRequest{Requests: []processor.Request{{Actions: blurs, ImageName: "test-image.png"}}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "at most 100 actions")
+}
@@ -0,0 +1,88 @@
+package processor
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/logger/sl"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	maxSyncBatchSize = 20
+	// maxSyncBatchActions caps the actions of all the requests of a
+	// synchronous batch together, so one round trip stays bounded.
+	maxSyncBatchActions = 100
+)
+
+type SyncBatchResponse struct {
+	response.Response
+	Results []BatchResult `json:"results"`
+}
+
+// NewSyncBatch processes up to maxSyncBatchSize requests in one round trip
+// and answers with the result of each, in order, once all are done. The
+// requests are independent: one that is invalid or fails gets a failed
+// result with the status it would have been answered with alone, and the
+// others still run. Previews and presets are not supported.
+func NewSyncBatch(log *slog.Logger, imgProcessor ImageProcessor, opts Options) http.HandlerFunc {
+	// Identical requests in flight at the same time share one run.
+	var group singleflight.Group
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.img.processor.NewSyncBatch"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req BatchRequest
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error("failed to decode request"))
+			return
+		}
+
+		if len(req.Requests) == 0 || len(req.Requests) > maxSyncBatchSize {
+			log.Error("invalid batch size", slog.Int("size", len(req.Requests)))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error(fmt.Sprintf("a batch holds 1 to %d requests", maxSyncBatchSize)))
+			return
+		}
+
+		actions := 0
+		for _, item := range req.Requests {
+			actions += len(item.Actions)
+		}
+		if actions > maxSyncBatchActions {
+			log.Error("too many actions in batch", slog.Int("actions", actions))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error(fmt.Sprintf("a batch holds at most %d actions in all", maxSyncBatchActions)))
+			return
+		}
+
+		results := make([]BatchResult, len(req.Requests))
+		for i, item := range req.Requests {
+			if msg := batchItemError(item); msg != "" {
+				log.Error("invalid batch request", slog.Int("index", i), slog.String("error", msg))
+				results[i] = BatchResult{State: StateFailed, Code: http.StatusBadRequest, Error: msg}
+				continue
+			}
+
+			results[i] = opts.batchResult(r.Context(), log, imgProcessor, &group, item, audit.OriginOf(r))
+		}
+
+		render.Status(r, http.StatusOK)
+		render.JSON(w, r, SyncBatchResponse{
+			Response: response.OK(),
+			Results:  results,
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package processor
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// coster is implemented by params whose work per pixel depends on the
+// params, such as a blur's sigma.
+type coster interface {
+	Cost(width, height int) float64
+}
+
+// actionCosts is the rough work per pixel of actions whose cost doesn't
+// depend on their params, counted in passes over the image.
+var actionCosts = map[string]float64{
+	cropAction:         1,
+	resizeAction:       10,
+	letterboxAction:    10,
+	gammaAction:        1,
+	contrastAction:     1,
+	brightnessAction:   1,
+	saturationAction:   1,
+	guidesAction:       2,
+	straightenAction:   20,
+	replaceColorAction: 2,
+	exposureAction:     1,
+	replaceBgAction:    30,
+	watermarkAction:    2,
+	socialCardAction:   12,
+	reduceColorsAction: 3,
+	portraitAction:     2,
+	autoCropAction:     1,
+	datestampAction:    2,
+	lutAction:          2,
+	swirlAction:        2,
+	distortAction:      2,
+	dewarpAction:       2,
+	perspectiveAction:  2,
+	removeBgAction:     30,
+	reflectionAction:   1,
+	gradientMapAction:  1,
+	channelMixerAction: 1,
+	frameAction:        2,
+}
+
+// estimateCost returns the estimated work of running steps on a
+// width×height image, in passes over a megapixel. Sizes are followed
+// through the chain; once a step's output size is unknown the last known
+// size is assumed.
+func estimateCost(steps []step, width, height int) float64 {
+	var cost float64
+
+	for _, s := range steps {
+		if s.apply == nil {
+			continue
+		}
+
+		perPixel := actionCosts[s.action]
+		if c, ok := s.params.(coster); ok {
+			perPixel = c.Cost(width, height)
+		}
+
+		pixels := width * height
+		if resizer, ok := s.params.(sizer); ok {
+			if w, h, known := resizer.OutputSize(width, height); known {
+				// Resampling work grows with whichever side is bigger.
+				pixels = max(pixels, w*h)
+				width, height = w, h
+			}
+		}
+
+		cost += perPixel * float64(pixels) / 1e6
+	}
+
+	return cost
+}
+
+// checkCost rejects steps whose estimated cost exceeds opts.MaxCost.
+func (opts Options) checkCost(steps []step, width, height int) error {
+	if opts.MaxCost <= 0 {
+		return nil
+	}
+
+	if cost := estimateCost(steps, width, height); cost > opts.MaxCost {
+		return &actionError{
+			status: http.StatusUnprocessableEntity,
+			msg:    fmt.Sprintf("request is too expensive: estimated cost %.0f exceeds the limit of %.0f", cost, opts.MaxCost),
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package processor
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/imgformat"
+)
+
+// The values of Request.Return.
+const (
+	ReturnURL     = "url"
+	ReturnDataURI = "datauri"
+)
+
+// inlineResponse returns the result of an inline job as a data URI. It
+// was encoded in memory and never saved, so it takes no room in storage.
+// A result larger than Options.MaxDataURISize fails with 413.
+func (opts Options) inlineResponse(out output) (Response, error) {
+	if opts.MaxDataURISize > 0 && int64(len(out.data)) > opts.MaxDataURISize {
+		return Response{}, &actionError{
+			status: http.StatusRequestEntityTooLarge,
+			msg:    fmt.Sprintf("result is larger than the %d bytes a data uri may hold", opts.MaxDataURISize),
+		}
+	}
+
+	return Response{
+		Response: response.OK(),
+		DataURI:  "data:" + imgformat.ContentType(out.format) + ";base64," + base64.StdEncoding.EncodeToString(out.data),
+		Width:    out.width,
+		Height:   out.height,
+		Format:   out.format,
+		Size:     int64(len(out.data)),
+		Quality:  out.quality,
+		Scale:    out.scale,
+		Warnings: out.warnings,
+	}, nil
+}
@@ -0,0 +1,125 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/imgformat"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// maxNameTries bounds the names generateNames drops because a fallback
+// name next to them is taken.
+const maxNameTries = 10
+
+// Fallback is the result saved in one of Request.Fallbacks, named like the
+// main result but for its extension.
+type Fallback struct {
+	Format   string `json:"format"`
+	ImageUrl string `json:"image_url"`
+	Size     int64  `json:"size,omitempty"`
+}
+
+// fallbackName returns imgName with the extension of format.
+func fallbackName(imgName, format string) string {
+	return strings.TrimSuffix(imgName, filepath.Ext(imgName)) + "." + imgformat.Normalize(format)
+}
+
+// fallbackFormats returns the formats of fallbacks other than the one of
+// the main result, fileExt, without duplicates.
+func fallbackFormats(fallbacks []string, fileExt string) []string {
+	var formats []string
+	for _, format := range fallbacks {
+		format = imgformat.Normalize(format)
+		if format != imgformat.Normalize(fileExt) && !slices.Contains(formats, format) {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+// generateNames returns a new name for the result with a free name next
+// to it for every extra output. A generated name whose fallback names are
+// taken is held while another is generated, so no fallback ever replaces
+// another image, and deleted once done. With temp the names are for the
+// temp area.
+func generateNames(imgProcessor ImageProcessor, prefix, fileExt string, extras []Output, temp bool) (string, error) {
+	generate := imgProcessor.GenerateName
+	if temp {
+		generate = imgProcessor.GenerateTempName
+	}
+
+	var dropped []string
+	defer func() {
+		for _, name := range dropped {
+			imgProcessor.DeleteImage(name)
+		}
+	}()
+
+	for range maxNameTries {
+		imgName, err := generate(prefix, fileExt)
+		if err != nil {
+			return "", err
+		}
+
+		free := true
+		for _, extra := range extras {
+			if _, err := imgProcessor.FindImage(fallbackName(imgName, extra.Format)); err == nil {
+				free = false
+				break
+			}
+		}
+		if free {
+			return imgName, nil
+		}
+		dropped = append(dropped, imgName)
+	}
+
+	return "", fmt.Errorf("no name with free fallback names after %d attempts", maxNameTries)
+}
+
+// saveFallbacks saves img as every one of extras next to the main result
+// imgName, at the quality of encodeOpts unless the extra sets its own. On
+// failure the ones already saved are deleted and the error is an
+// *actionError.
+func (opts Options) saveFallbacks(ctx context.Context, imgProcessor ImageProcessor, img image.Image, imgName string, extras []Output, encodeOpts encoding.Options) ([]Fallback, error) {
+	var saved []Fallback
+	var names []string
+
+	for _, extra := range extras {
+		format := imgformat.Normalize(extra.Format)
+		name := fallbackName(imgName, format)
+
+		extraOpts := encodeOpts
+		if extra.Quality > 0 {
+			extraOpts.Quality = extra.Quality
+		}
+
+		imgUrl, err := saveWithTimeout(ctx, opts.ActionTimeouts[convertAction], imgProcessor, img, name, extraOpts)
+		if err != nil {
+			for _, name := range names {
+				imgProcessor.DeleteImage(name)
+			}
+		}
+		if cause := context.Cause(ctx); cause != nil && err != nil {
+			return nil, canceledError(cause)
+		}
+		if errors.Is(err, errTimeout) {
+			return nil, &actionError{status: http.StatusGatewayTimeout, msg: fmt.Sprintf("action %s timed out", convertAction)}
+		}
+		if err != nil {
+			return nil, saveError(err, fmt.Sprintf("failed to save %s image", format))
+		}
+		names = append(names, name)
+
+		size, _ := imgProcessor.FileSize(name)
+		saved = append(saved, Fallback{Format: format, ImageUrl: imgUrl, Size: size})
+	}
+
+	return saved, nil
+}
@@ -3,10 +3,17 @@
 package mocks
 
 import (
+	gif "image/gif"
+	audit "online-photo-editor/internal/lib/audit"
+	encoding "online-photo-editor/internal/lib/encoding"
+
 	image "image"
-	multipart "mime/multipart"
+
+	io "io"
 
 	mock "github.com/stretchr/testify/mock"
+
+	time "time"
 )
 
 // ImageProcessor is an autogenerated mock type for the ImageProcessor type
@@ -14,6 +21,52 @@ type ImageProcessor struct {
 	mock.Mock
 }
 
+// CommitImage provides a mock function with given fields: imgName
+func (_m *ImageProcessor) CommitImage(imgName string) (string, error) {
+	ret := _m.Called(imgName)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CommitImage")
+	}
+
+	var r0 string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string) (string, error)); ok {
+		return rf(imgName)
+	}
+	if rf, ok := ret.Get(0).(func(string) string); ok {
+		r0 = rf(imgName)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(imgName)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Committed provides a mock function with given fields: imgName
+func (_m *ImageProcessor) Committed(imgName string) bool {
+	ret := _m.Called(imgName)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Committed")
+	}
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(string) bool); ok {
+		r0 = rf(imgName)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
+
 // DeleteImage provides a mock function with given fields: imgName
 func (_m *ImageProcessor) DeleteImage(imgName string) error {
 	ret := _m.Called(imgName)
@@ -32,6 +85,34 @@ func (_m *ImageProcessor) DeleteImage(imgName string) error {
 	return r0
 }
 
+// FileSize provides a mock function with given fields: imgName
+func (_m *ImageProcessor) FileSize(imgName string) (int64, error) {
+	ret := _m.Called(imgName)
+
+	if len(ret) == 0 {
+		panic("no return value specified for FileSize")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string) (int64, error)); ok {
+		return rf(imgName)
+	}
+	if rf, ok := ret.Get(0).(func(string) int64); ok {
+		r0 = rf(imgName)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(imgName)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // FindImage provides a mock function with given fields: imgName
 func (_m *ImageProcessor) FindImage(imgName string) (string, error) {
 	ret := _m.Called(imgName)
@@ -88,6 +169,257 @@ func (_m *ImageProcessor) GenerateName(prefix string, fileExt string) (string, e
 	return r0, r1
 }
 
+// GenerateTempName provides a mock function with given fields: prefix, fileExt
+func (_m *ImageProcessor) GenerateTempName(prefix string, fileExt string) (string, error) {
+	ret := _m.Called(prefix, fileExt)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GenerateTempName")
+	}
+
+	var r0 string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string, string) (string, error)); ok {
+		return rf(prefix, fileExt)
+	}
+	if rf, ok := ret.Get(0).(func(string, string) string); ok {
+		r0 = rf(prefix, fileExt)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	if rf, ok := ret.Get(1).(func(string, string) error); ok {
+		r1 = rf(prefix, fileExt)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ImageCaptureTime provides a mock function with given fields: imgName
+func (_m *ImageProcessor) ImageCaptureTime(imgName string) (time.Time, error) {
+	ret := _m.Called(imgName)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ImageCaptureTime")
+	}
+
+	var r0 time.Time
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string) (time.Time, error)); ok {
+		return rf(imgName)
+	}
+	if rf, ok := ret.Get(0).(func(string) time.Time); ok {
+		r0 = rf(imgName)
+	} else {
+		r0 = ret.Get(0).(time.Time)
+	}
+
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(imgName)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ImageDPI provides a mock function with given fields: imgName
+func (_m *ImageProcessor) ImageDPI(imgName string) (int, error) {
+	ret := _m.Called(imgName)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ImageDPI")
+	}
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string) (int, error)); ok {
+		return rf(imgName)
+	}
+	if rf, ok := ret.Get(0).(func(string) int); ok {
+		r0 = rf(imgName)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(imgName)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ImageETag provides a mock function with given fields: imgName
+func (_m *ImageProcessor) ImageETag(imgName string) (string, error) {
+	ret := _m.Called(imgName)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ImageETag")
+	}
+
+	var r0 string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string) (string, error)); ok {
+		return rf(imgName)
+	}
+	if rf, ok := ret.Get(0).(func(string) string); ok {
+		r0 = rf(imgName)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(imgName)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ImageFrames provides a mock function with given fields: imgName
+func (_m *ImageProcessor) ImageFrames(imgName string) (int, error) {
+	ret := _m.Called(imgName)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ImageFrames")
+	}
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string) (int, error)); ok {
+		return rf(imgName)
+	}
+	if rf, ok := ret.Get(0).(func(string) int); ok {
+		r0 = rf(imgName)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(imgName)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ImageModTime provides a mock function with given fields: imgName
+func (_m *ImageProcessor) ImageModTime(imgName string) (time.Time, error) {
+	ret := _m.Called(imgName)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ImageModTime")
+	}
+
+	var r0 time.Time
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string) (time.Time, error)); ok {
+		return rf(imgName)
+	}
+	if rf, ok := ret.Get(0).(func(string) time.Time); ok {
+		r0 = rf(imgName)
+	} else {
+		r0 = ret.Get(0).(time.Time)
+	}
+
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(imgName)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ImageSize provides a mock function with given fields: imgName
+func (_m *ImageProcessor) ImageSize(imgName string) (int, int, error) {
+	ret := _m.Called(imgName)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ImageSize")
+	}
+
+	var r0 int
+	var r1 int
+	var r2 error
+	if rf, ok := ret.Get(0).(func(string) (int, int, error)); ok {
+		return rf(imgName)
+	}
+	if rf, ok := ret.Get(0).(func(string) int); ok {
+		r0 = rf(imgName)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(string) int); ok {
+		r1 = rf(imgName)
+	} else {
+		r1 = ret.Get(1).(int)
+	}
+
+	if rf, ok := ret.Get(2).(func(string) error); ok {
+		r2 = rf(imgName)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// ImageURL provides a mock function with given fields: imgName
+func (_m *ImageProcessor) ImageURL(imgName string) string {
+	ret := _m.Called(imgName)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ImageURL")
+	}
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string) string); ok {
+		r0 = rf(imgName)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// LoadGIF provides a mock function with given fields: imgName
+func (_m *ImageProcessor) LoadGIF(imgName string) (*gif.GIF, error) {
+	ret := _m.Called(imgName)
+
+	if len(ret) == 0 {
+		panic("no return value specified for LoadGIF")
+	}
+
+	var r0 *gif.GIF
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string) (*gif.GIF, error)); ok {
+		return rf(imgName)
+	}
+	if rf, ok := ret.Get(0).(func(string) *gif.GIF); ok {
+		r0 = rf(imgName)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*gif.GIF)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(imgName)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // LoadImage provides a mock function with given fields: imgName
 func (_m *ImageProcessor) LoadImage(imgName string) (image.Image, error) {
 	ret := _m.Called(imgName)
@@ -118,9 +450,67 @@ func (_m *ImageProcessor) LoadImage(imgName string) (image.Image, error) {
 	return r0, r1
 }
 
-// SaveImage provides a mock function with given fields: inputImg, imgName
-func (_m *ImageProcessor) SaveImage(inputImg image.Image, imgName string) (string, error) {
-	ret := _m.Called(inputImg, imgName)
+// OpenImage provides a mock function with given fields: imgName
+func (_m *ImageProcessor) OpenImage(imgName string) (io.ReadSeekCloser, error) {
+	ret := _m.Called(imgName)
+
+	if len(ret) == 0 {
+		panic("no return value specified for OpenImage")
+	}
+
+	var r0 io.ReadSeekCloser
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string) (io.ReadSeekCloser, error)); ok {
+		return rf(imgName)
+	}
+	if rf, ok := ret.Get(0).(func(string) io.ReadSeekCloser); ok {
+		r0 = rf(imgName)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(io.ReadSeekCloser)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(imgName)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SaveGIF provides a mock function with given fields: anim, imgName, origin
+func (_m *ImageProcessor) SaveGIF(anim *gif.GIF, imgName string, origin audit.Origin) (string, error) {
+	ret := _m.Called(anim, imgName, origin)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SaveGIF")
+	}
+
+	var r0 string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(*gif.GIF, string, audit.Origin) (string, error)); ok {
+		return rf(anim, imgName, origin)
+	}
+	if rf, ok := ret.Get(0).(func(*gif.GIF, string, audit.Origin) string); ok {
+		r0 = rf(anim, imgName, origin)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	if rf, ok := ret.Get(1).(func(*gif.GIF, string, audit.Origin) error); ok {
+		r1 = rf(anim, imgName, origin)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SaveImage provides a mock function with given fields: inputImg, imgName, opts
+func (_m *ImageProcessor) SaveImage(inputImg image.Image, imgName string, opts encoding.Options) (string, error) {
+	ret := _m.Called(inputImg, imgName, opts)
 
 	if len(ret) == 0 {
 		panic("no return value specified for SaveImage")
@@ -128,17 +518,17 @@ func (_m *ImageProcessor) SaveImage(inputImg image.Image, imgName string) (strin
 
 	var r0 string
 	var r1 error
-	if rf, ok := ret.Get(0).(func(image.Image, string) (string, error)); ok {
-		return rf(inputImg, imgName)
+	if rf, ok := ret.Get(0).(func(image.Image, string, encoding.Options) (string, error)); ok {
+		return rf(inputImg, imgName, opts)
 	}
-	if rf, ok := ret.Get(0).(func(image.Image, string) string); ok {
-		r0 = rf(inputImg, imgName)
+	if rf, ok := ret.Get(0).(func(image.Image, string, encoding.Options) string); ok {
+		r0 = rf(inputImg, imgName, opts)
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
-	if rf, ok := ret.Get(1).(func(image.Image, string) error); ok {
-		r1 = rf(inputImg, imgName)
+	if rf, ok := ret.Get(1).(func(image.Image, string, encoding.Options) error); ok {
+		r1 = rf(inputImg, imgName, opts)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -146,9 +536,9 @@ func (_m *ImageProcessor) SaveImage(inputImg image.Image, imgName string) (strin
 	return r0, r1
 }
 
-// UploadImage provides a mock function with given fields: file, handler
-func (_m *ImageProcessor) UploadImage(file multipart.File, handler *multipart.FileHeader) (string, error) {
-	ret := _m.Called(file, handler)
+// UploadImage provides a mock function with given fields: file, fileName
+func (_m *ImageProcessor) UploadImage(file io.Reader, fileName string) (string, error) {
+	ret := _m.Called(file, fileName)
 
 	if len(ret) == 0 {
 		panic("no return value specified for UploadImage")
@@ -156,17 +546,17 @@ func (_m *ImageProcessor) UploadImage(file multipart.File, handler *multipart.Fi
 
 	var r0 string
 	var r1 error
-	if rf, ok := ret.Get(0).(func(multipart.File, *multipart.FileHeader) (string, error)); ok {
-		return rf(file, handler)
+	if rf, ok := ret.Get(0).(func(io.Reader, string) (string, error)); ok {
+		return rf(file, fileName)
 	}
-	if rf, ok := ret.Get(0).(func(multipart.File, *multipart.FileHeader) string); ok {
-		r0 = rf(file, handler)
+	if rf, ok := ret.Get(0).(func(io.Reader, string) string); ok {
+		r0 = rf(file, fileName)
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
-	if rf, ok := ret.Get(1).(func(multipart.File, *multipart.FileHeader) error); ok {
-		r1 = rf(file, handler)
+	if rf, ok := ret.Get(1).(func(io.Reader, string) error); ok {
+		r1 = rf(file, fileName)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -0,0 +1,55 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"online-photo-editor/internal/lib/imgformat"
+)
+
+// Output is one of Request.Outputs: a format and the quality to encode it
+// at, zero for the usual one. It also decodes from a bare format, e.g.
+// "webp".
+type Output struct {
+	Format  string `json:"format" validate:"required,lowercase,max=10"`
+	Quality int    `json:"quality,omitempty" validate:"min=0,max=100"`
+}
+
+func (o *Output) UnmarshalJSON(data []byte) error {
+	var format string
+	if err := json.Unmarshal(data, &format); err == nil {
+		*o = Output{Format: format}
+		return nil
+	}
+
+	type plain Output
+	return json.Unmarshal(data, (*plain)(o))
+}
+
+// checkOutputs fails a request listing a format twice in outputs, e.g. as
+// jpg and jpeg.
+func checkOutputs(req Request) error {
+	seen := make(map[string]bool)
+	for _, out := range req.Outputs {
+		format := imgformat.Normalize(out.Format)
+		if seen[format] {
+			return &actionError{status: http.StatusBadRequest, msg: fmt.Sprintf("outputs list %s more than once", format)}
+		}
+		seen[format] = true
+	}
+	return nil
+}
+
+// extraOutputs returns what is saved next to the main result, in format
+// fileExt: the fallbacks or the outputs after the first.
+func extraOutputs(req Request, fileExt string) []Output {
+	if len(req.Outputs) > 0 {
+		return req.Outputs[1:]
+	}
+
+	var extras []Output
+	for _, format := range fallbackFormats(req.Fallbacks, fileExt) {
+		extras = append(extras, Output{Format: format})
+	}
+	return extras
+}
@@ -0,0 +1,335 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"online-photo-editor/internal/lib/api/convert"
+	"online-photo-editor/internal/lib/api/resize"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/imgformat"
+	"online-photo-editor/internal/storage"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// job is everything needed to turn a source image into a saved result.
+type job struct {
+	req      Request
+	steps    []step
+	imgPath  string
+	encoding encoding.Options
+	// filter resamples a source downscaled to req.MaxMegapixels.
+	filter string
+	// inline encodes the result into output.data, for a data URI, instead
+	// of saving it.
+	inline bool
+}
+
+// output describes a saved result, or an inline one.
+type output struct {
+	name string
+	url  string
+	// data is the encoded result of an inline job, which has no name or
+	// url.
+	data   []byte
+	width  int
+	height int
+	format string
+	// quality is the quality picked by a convert with quality auto, zero
+	// without one.
+	quality int
+	// scale is the factor the source was downscaled by, zero if it
+	// wasn't.
+	scale     float64
+	fallbacks []Fallback
+	outputs   map[string]string
+	// warnings are the failures of skipped actions.
+	warnings []string
+}
+
+// dedupKey identifies the result of req applied to a source whose content
+// hash is etag. The image name is left out, but for its namespace the
+// result is saved in: the same bytes under another name give the same
+// result.
+func dedupKey(req Request, etag string) (string, error) {
+	data, err := json.Marshal(struct {
+		ETag            string        `json:"etag"`
+		Namespace       string        `json:"namespace"`
+		Actions         []ImageAction `json:"actions"`
+		OutputFormat    string        `json:"output_format"`
+		Profile         string        `json:"profile"`
+		ContinueOnError bool          `json:"continue_on_error"`
+		Fallbacks       []string      `json:"fallbacks"`
+		Outputs         []Output      `json:"outputs"`
+		MaxMegapixels   float64       `json:"max_megapixels"`
+	}{etag, storage.Namespace(req.ImageName), req.Actions, req.OutputFormat, req.Profile, req.ContinueOnError, req.Fallbacks, req.Outputs, req.MaxMegapixels})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// run loads the source image, applies every step and saves the result.
+// Failures are *actionError carrying the response status.
+func (opts Options) run(ctx context.Context, imgProcessor ImageProcessor, j job) (output, error) {
+	if opts.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, opts.MaxDuration, errDeadline)
+		defer cancel()
+	}
+
+	inputImg, err := imgProcessor.LoadImage(j.req.ImageName)
+	if decodeErr := decodeError(err); decodeErr != nil {
+		return output{}, decodeErr
+	}
+	if err != nil {
+		return output{}, &actionError{status: http.StatusNotFound, msg: "failed to load image", err: err}
+	}
+
+	scale := 0.0
+	if j.req.MaxMegapixels > 0 {
+		var applied float64
+		if inputImg, applied = resize.Downscale(inputImg, j.req.MaxMegapixels, j.filter, opts.MemoryBudget); applied < 1 {
+			scale = applied
+		}
+	}
+
+	if needsSourceDPI(j.steps) {
+		dpi, err := imgProcessor.ImageDPI(j.req.ImageName)
+		if err != nil {
+			return output{}, &actionError{status: http.StatusNotFound, msg: "failed to load image", err: err}
+		}
+		setSourceDPI(j.steps, dpi)
+	}
+
+	if needsCaptureTime(j.steps) {
+		captured, err := imgProcessor.ImageCaptureTime(j.req.ImageName)
+		if err != nil {
+			return output{}, &actionError{status: http.StatusNotFound, msg: "failed to load image", err: err}
+		}
+		setCaptureTime(j.steps, captured)
+	}
+
+	// Catch a crop that won't fit after an earlier resize before
+	// spending time on the actions in between.
+	if _, _, _, err := checkSteps(j.steps, inputImg.Bounds().Dx(), inputImg.Bounds().Dy()); err != nil {
+		return output{}, err
+	}
+
+	if err := opts.checkCost(j.steps, inputImg.Bounds().Dx(), inputImg.Bounds().Dy()); err != nil {
+		return output{}, err
+	}
+
+	fileExt := strings.ToLower(filepath.Ext(j.imgPath))
+	converted, autoQuality := false, false
+	encodeOpts := j.encoding
+	var warnings []string
+
+	for _, s := range j.steps {
+		// Actions can't be interrupted, so a canceled run stops between
+		// them.
+		if cause := context.Cause(ctx); cause != nil {
+			return output{}, canceledError(cause)
+		}
+
+		if s.apply == nil {
+			fileExt, converted = s.format, true
+			autoQuality = s.quality == int(convert.QualityAuto)
+			if s.quality > 0 {
+				encodeOpts.Quality = s.quality
+			}
+			encodeOpts.BitDepth, encodeOpts.Dither = s.bitDepth, s.dither
+			continue
+		}
+
+		img := inputImg
+		result, err := withTimeout(ctx, opts.ActionTimeouts[s.action], func() (image.Image, error) {
+			return s.apply(img)
+		})
+		if cause := context.Cause(ctx); cause != nil {
+			return output{}, canceledError(cause)
+		}
+		if failure := stepError(s, err); failure != nil {
+			if !j.req.ContinueOnError {
+				return output{}, failure
+			}
+			// The step is skipped and the chain goes on from its input.
+			warnings = append(warnings, fmt.Sprintf("action %s skipped: %s", s.action, failure.message()))
+			continue
+		}
+		inputImg = result
+
+		if d, ok := s.params.(dpiSetter); ok && d.OutputDPI() > 0 {
+			encodeOpts.DPI = d.OutputDPI()
+		}
+	}
+
+	switch {
+	case len(j.req.Outputs) > 0:
+		fileExt = j.req.Outputs[0].Format
+	case j.req.OutputFormat != "":
+		fileExt = j.req.OutputFormat
+	case !converted:
+		if format, ok := opts.defaultFormat(fileExt); ok {
+			fileExt = format
+		}
+		// checkAlpha has turned away asked for formats without alpha.
+		if _, ok := alphaStep(j.steps); ok && !imgformat.HasAlpha(fileExt) {
+			fileExt = ".png"
+		}
+	}
+
+	// The quality is picked from the final pixels, after every action.
+	pickedQuality := 0
+	if autoQuality {
+		pickedQuality = convert.AutoQuality(inputImg)
+		encodeOpts.Quality = pickedQuality
+	}
+
+	// The extra outputs get the quality of the main result unless they
+	// set their own, as the first output may.
+	extraOpts := encodeOpts
+	if len(j.req.Outputs) > 0 && j.req.Outputs[0].Quality > 0 {
+		encodeOpts.Quality, pickedQuality = j.req.Outputs[0].Quality, 0
+	}
+
+	// Converting is instant, the cost is in the encode, so the convert
+	// timeout covers saving whenever the format changes.
+	var saveTimeout time.Duration
+	if imgformat.Normalize(fileExt) != imgformat.Normalize(filepath.Ext(j.imgPath)) {
+		saveTimeout = opts.ActionTimeouts[convertAction]
+	}
+
+	if j.inline {
+		data, err := withTimeout(ctx, saveTimeout, func() ([]byte, error) {
+			var buf bytes.Buffer
+			err := encoding.Encode(&buf, inputImg, fileExt, encodeOpts)
+			return buf.Bytes(), err
+		})
+		if cause := context.Cause(ctx); cause != nil && err != nil {
+			return output{}, canceledError(cause)
+		}
+		if errors.Is(err, errTimeout) {
+			return output{}, &actionError{status: http.StatusGatewayTimeout, msg: fmt.Sprintf("action %s timed out", convertAction)}
+		}
+		if err != nil {
+			return output{}, saveError(fmt.Errorf("%w: %w", storage.ErrEncode, err), "failed to encode image")
+		}
+
+		return output{
+			data:     data,
+			width:    inputImg.Bounds().Dx(),
+			height:   inputImg.Bounds().Dy(),
+			format:   imgformat.Normalize(fileExt),
+			quality:  pickedQuality,
+			scale:    scale,
+			warnings: warnings,
+		}, nil
+	}
+
+	extras := extraOutputs(j.req, fileExt)
+
+	imgName, err := generateNames(imgProcessor, storage.InNamespaceOf(j.req.ImageName, "proc"), fileExt, extras, encodeOpts.Temp)
+	if err != nil {
+		return output{}, &actionError{status: http.StatusInternalServerError, msg: "failed to generate name", err: err}
+	}
+
+	imgUrl, err := saveWithTimeout(ctx, saveTimeout, imgProcessor, inputImg, imgName, encodeOpts)
+	if cause := context.Cause(ctx); cause != nil && err != nil {
+		return output{}, canceledError(cause)
+	}
+	if errors.Is(err, errTimeout) {
+		return output{}, &actionError{status: http.StatusGatewayTimeout, msg: fmt.Sprintf("action %s timed out", convertAction)}
+	}
+	if err != nil {
+		return output{}, saveError(err, "failed to save image")
+	}
+
+	var saved []Fallback
+	if len(extras) > 0 {
+		if saved, err = opts.saveFallbacks(ctx, imgProcessor, inputImg, imgName, extras, extraOpts); err != nil {
+			imgProcessor.DeleteImage(imgName)
+			return output{}, err
+		}
+	}
+
+	var outputs map[string]string
+	if len(j.req.Outputs) > 0 {
+		outputs = map[string]string{imgformat.Normalize(fileExt): imgUrl}
+		for _, fallback := range saved {
+			outputs[fallback.Format] = fallback.ImageUrl
+		}
+		saved = nil
+	}
+
+	return output{
+		name:      imgName,
+		url:       imgUrl,
+		width:     inputImg.Bounds().Dx(),
+		height:    inputImg.Bounds().Dy(),
+		format:    imgformat.Normalize(fileExt),
+		quality:   pickedQuality,
+		scale:     scale,
+		fallbacks: saved,
+		outputs:   outputs,
+		warnings:  warnings,
+	}, nil
+}
+
+// saveError reports a failure to save a result with msg: 507 over the
+// quota, 415 when the result can't be encoded in its format and 500 for
+// any other failure, such as a disk error.
+func saveError(err error, msg string) *actionError {
+	switch {
+	case errors.Is(err, storage.ErrQuotaExceeded):
+		return &actionError{status: http.StatusInsufficientStorage, msg: storage.QuotaError(err).Error(), err: err}
+	case errors.Is(err, storage.ErrEncode):
+		return &actionError{status: http.StatusUnsupportedMediaType, msg: msg, err: err}
+	default:
+		return &actionError{status: http.StatusInternalServerError, msg: msg, err: err}
+	}
+}
+
+// stepError returns the failure of running s, or nil if err is nil.
+func stepError(s step, err error) *actionError {
+	if err == nil {
+		return nil
+	}
+
+	var actionErr *actionError
+	if errors.As(err, &actionErr) {
+		return actionErr
+	}
+	if errors.Is(err, errTimeout) {
+		return &actionError{status: http.StatusGatewayTimeout, msg: fmt.Sprintf("action %s timed out", s.action)}
+	}
+	// An image loaded by the action itself, e.g. a watermark.
+	if decodeErr := decodeError(err); decodeErr != nil {
+		return decodeErr
+	}
+	return &actionError{
+		status: http.StatusBadRequest,
+		msg:    fmt.Sprintf("failed to perform action %s", s.action),
+		err:    err,
+	}
+}
+
+// decodeError reports a stored image that can't be decoded, or is too
+// large to, with 422. It returns nil for any other err.
+func decodeError(err error) *actionError {
+	if target := storage.ContentError(err); target != nil {
+		return &actionError{status: http.StatusUnprocessableEntity, msg: target.Error(), err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package processor
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// expandPreset returns the actions of the named preset combined with the
+// request's own actions. An action of a type the preset already has
+// overrides that action's params key by key; any other action is added
+// after the preset's, but before a final convert so it stays last.
+func (opts Options) expandPreset(name string, actions []ImageAction) ([]ImageAction, error) {
+	preset, ok := opts.Presets[name]
+	if !ok {
+		return nil, &actionError{status: http.StatusBadRequest, msg: fmt.Sprintf("unknown preset %s", name)}
+	}
+
+	expanded := make([]ImageAction, len(preset), len(preset)+len(actions))
+	copy(expanded, preset)
+
+	overridden := make([]bool, len(preset))
+	var added []ImageAction
+
+	for _, action := range actions {
+		i := presetIndex(preset, overridden, action.Action)
+		if i < 0 {
+			added = append(added, action)
+			continue
+		}
+
+		overridden[i] = true
+		expanded[i].Params = mergeParams(preset[i].Params, action.Params)
+	}
+
+	if n := len(expanded); n > 0 && expanded[n-1].Action == convertAction {
+		last := expanded[n-1]
+		return append(append(expanded[:n-1], added...), last), nil
+	}
+
+	return append(expanded, added...), nil
+}
+
+// presetIndex returns the first preset action of the given type that
+// isn't overridden yet, or -1.
+func presetIndex(preset []ImageAction, overridden []bool, action string) int {
+	for i, a := range preset {
+		if a.Action == action && !overridden[i] {
+			return i
+		}
+	}
+	return -1
+}
+
+// mergeParams returns base with the keys of override set on a copy. Params
+// that aren't objects are replaced whole.
+func mergeParams(base, override interface{}) interface{} {
+	baseMap, ok := base.(map[string]interface{})
+	overrideMap, ok2 := override.(map[string]interface{})
+	if !ok || !ok2 {
+		return override
+	}
+
+	merged := make(map[string]interface{}, len(baseMap)+len(overrideMap))
+	for k, v := range baseMap {
+		merged[k] = v
+	}
+	for k, v := range overrideMap {
+		merged[k] = v
+	}
+
+	return merged
+}
@@ -0,0 +1,92 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"online-photo-editor/internal/http-server/middleware/auth"
+	"sync"
+	"time"
+)
+
+// errSuperseded cancels a preview when a newer one for the same session
+// arrives.
+var errSuperseded = errors.New("superseded by a newer preview")
+
+// previews tracks the preview in flight for each session, so that only
+// the latest one of a burst, e.g. while a slider is dragged, is rendered.
+type previews struct {
+	mu      sync.Mutex
+	running map[session]*preview
+}
+
+// session identifies an edit session. Session IDs are picked by the
+// clients, so they are scoped by the namespace of the caller's API key:
+// a caller can't cancel the previews of another key by using its IDs.
+type session struct {
+	namespace string
+	id        string
+}
+
+type preview struct {
+	cancel context.CancelCauseFunc
+}
+
+func newPreviews() *previews {
+	return &previews{running: make(map[session]*preview)}
+}
+
+// start cancels the preview in flight for the session key, if any, and
+// registers a new one. The returned func must be called once it is done.
+func (p *previews) start(ctx context.Context, key session) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	current := &preview{cancel: cancel}
+
+	p.mu.Lock()
+	if prev, ok := p.running[key]; ok {
+		prev.cancel(errSuperseded)
+	}
+	p.running[key] = current
+	p.mu.Unlock()
+
+	return ctx, func() {
+		p.mu.Lock()
+		if p.running[key] == current {
+			delete(p.running, key)
+		}
+		p.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// preview runs j as the latest preview of its session. It waits for
+// PreviewDebounce first, so a burst of previews only renders the last
+// one, and stops between actions once a newer preview arrives.
+func (opts Options) preview(ctx context.Context, p *previews, imgProcessor ImageProcessor, j job) (output, error) {
+	ctx, done := p.start(ctx, session{namespace: auth.Namespace(ctx), id: j.req.SessionID})
+	defer done()
+
+	timer := time.NewTimer(opts.PreviewDebounce)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return output{}, canceledError(context.Cause(ctx))
+	}
+
+	return opts.run(ctx, imgProcessor, j)
+}
+
+// canceledError reports a run stopped by its context: 409 for a
+// superseded preview, 504 past Options.MaxDuration, 503 when the client
+// went away.
+func canceledError(cause error) *actionError {
+	if errors.Is(cause, errSuperseded) {
+		return &actionError{status: http.StatusConflict, msg: errSuperseded.Error(), err: cause}
+	}
+	if errors.Is(cause, errDeadline) {
+		return &actionError{status: http.StatusGatewayTimeout, msg: errDeadline.Error(), err: cause}
+	}
+	return &actionError{status: http.StatusServiceUnavailable, msg: "request canceled", err: cause}
+}
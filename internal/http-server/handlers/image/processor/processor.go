@@ -1,286 +1,619 @@
 package processor
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"image"
+	"image/gif"
 	"io"
 	"log/slog"
-	"mime/multipart"
 	"net/http"
-	"online-photo-editor/internal/lib/api/blur"
-	"online-photo-editor/internal/lib/api/brightness"
-	"online-photo-editor/internal/lib/api/contrast"
 	"online-photo-editor/internal/lib/api/convert"
-	"online-photo-editor/internal/lib/api/crop"
-	"online-photo-editor/internal/lib/api/gamma"
-	"online-photo-editor/internal/lib/api/resize"
 	"online-photo-editor/internal/lib/api/response"
-	"online-photo-editor/internal/lib/api/saturation"
-	"online-photo-editor/internal/lib/api/sharpen"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/imgformat"
 	"online-photo-editor/internal/lib/logger/sl"
-
-	"path/filepath"
-	"strings"
+	"online-photo-editor/internal/lib/profile"
+	"online-photo-editor/internal/lib/remote"
+	"runtime/debug"
+	"sync"
+	"time"
 
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/render"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
-	cropAction       = "crop"
-	resizeAction     = "resize"
-	convertAction    = "convert"
-	blurAction       = "blur"
-	gammaAction      = "gamma"
-	contrastAction   = "contrast"
-	sharpenAction    = "sharpen"
-	brightnessAction = "brightness"
-	saturationAction = "saturation"
+	cropAction              = "crop"
+	resizeAction            = "resize"
+	convertAction           = "convert"
+	blurAction              = "blur"
+	gammaAction             = "gamma"
+	contrastAction          = "contrast"
+	sharpenAction           = "sharpen"
+	brightnessAction        = "brightness"
+	saturationAction        = "saturation"
+	guidesAction            = "guides"
+	straightenAction        = "straighten"
+	replaceColorAction      = "replace_color"
+	exposureAction          = "exposure"
+	shadowsHighlightsAction = "shadows_highlights"
+	replaceBgAction         = "replacebg"
+	watermarkAction         = "watermark"
+	socialCardAction        = "social_card"
+	reduceColorsAction      = "reduce_colors"
+	portraitAction          = "portrait"
+	autoCropAction          = "autocrop"
+	datestampAction         = "datestamp"
+	motionBlurAction        = "motionblur"
+	lutAction               = "lut"
+	swirlAction             = "swirl"
+	distortAction           = "distort"
+	letterboxAction         = "letterbox"
+	dewarpAction            = "dewarp"
+	perspectiveAction       = "perspective"
+	removeBgAction          = "remove_bg"
+	reflectionAction        = "reflection"
+	gradientMapAction       = "gradient_map"
+	channelMixerAction      = "channel_mixer"
+	frameAction             = "frame"
 )
 
 type ImageAction struct {
-	Action string      `json:"action" validate:"required,max=10"`
+	Action string      `json:"action" validate:"required,max=20"`
 	Params interface{} `json:"params" validate:"required"`
 }
 
 type Request struct {
 	Actions   []ImageAction `json:"actions" validate:"required,min=1"`
-	ImageName string        `json:"image_name" validate:"required,max=100"`
+	ImageName string        `json:"image_name,omitempty" validate:"required_without=SourceURL,excluded_with=SourceURL,max=100,image_name"`
+	// SourceURL edits a remote image instead of a stored one. It is
+	// fetched into storage and cached, see Options.Remote.
+	SourceURL    string `json:"source_url,omitempty" validate:"omitempty,url,max=2000"`
+	OutputFormat string `json:"output_format,omitempty" validate:"omitempty,lowercase,max=10"`
+	Profile      string `json:"profile,omitempty" validate:"omitempty,oneof=fast balanced quality"`
+	// ContinueOnError skips actions that fail instead of failing the
+	// request, reporting them in Response.Warnings. Loading the source
+	// and saving the result still fail the request.
+	ContinueOnError bool `json:"continue_on_error,omitempty"`
+	// Preview marks an interactive preview of the edit session SessionID:
+	// a newer preview of the session cancels it. Requests that aren't
+	// previews, such as the final save, always run.
+	Preview   bool   `json:"preview,omitempty"`
+	SessionID string `json:"session_id,omitempty" validate:"required_if=Preview true,max=100"`
+	// Fallbacks also saves the result in these formats, for the sources
+	// of a <picture> element: each is named like the result, but for its
+	// extension, and listed in Response.Fallbacks. Only WebP for now: AVIF
+	// joins once the storage can write it.
+	Fallbacks []string `json:"fallbacks,omitempty" validate:"omitempty,max=1,dive,oneof=webp"`
+	// Outputs saves the result of one run in every one of these formats,
+	// each at its own quality, listed in Response.Outputs. The first is
+	// the main result, as if it were OutputFormat; the others are named
+	// like it but for the extension.
+	Outputs []Output `json:"outputs,omitempty" validate:"omitempty,max=4,excluded_with=OutputFormat Fallbacks,dive"`
+	// MaxMegapixels downscales a larger source to this many million
+	// pixels before any action runs, so the actions' coordinates and
+	// sizes refer to the downscaled image. Zero means no limit.
+	MaxMegapixels float64 `json:"max_megapixels,omitempty" validate:"min=0,max=1000"`
+	// Return is how the result is handed back: url, the default, keeps
+	// it in storage, datauri returns it in Response.DataURI instead.
+	Return string `json:"return,omitempty" validate:"omitempty,oneof=url datauri"`
 }
 
 type Response struct {
 	response.Response
 	ImageUrl string `json:"image_url"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Format   string `json:"format"`
+	// Size is the file size in bytes.
+	Size int64 `json:"size,omitempty"`
+	// Quality is the quality picked for a convert with quality auto.
+	Quality int `json:"quality,omitempty"`
+	// Scale is the factor the source was downscaled by to fit
+	// max_megapixels, left out when it wasn't.
+	Scale     float64    `json:"scale,omitempty"`
+	Fallbacks []Fallback `json:"fallbacks,omitempty"`
+	// Outputs maps every format of Request.Outputs to the URL it was
+	// saved at.
+	Outputs map[string]string `json:"outputs,omitempty"`
+	// Warnings lists the actions skipped with continue_on_error.
+	Warnings []string `json:"warnings,omitempty"`
+	// DataURI is the result, for requests with return datauri.
+	DataURI string `json:"data_uri,omitempty"`
+	// Temp is set when the result waits in the temp area, as previews
+	// and the results of uncommitted sources do, to be removed unless
+	// committed.
+	Temp bool `json:"temp,omitempty"`
 }
 
 //go:generate go run github.com/vektra/mockery/v2@v2.28.2 --name=ImageProcessor
 type ImageProcessor interface {
 	FindImage(imgName string) (string, error)
 	LoadImage(imgName string) (image.Image, error)
-	SaveImage(inputImg image.Image, imgName string) (string, error)
-	UploadImage(file multipart.File, handler *multipart.FileHeader) (string, error)
+	SaveImage(inputImg image.Image, imgName string, opts encoding.Options) (string, error)
+	// UploadImage returns the name the file is stored under.
+	UploadImage(file io.Reader, fileName string) (string, error)
+	ImageURL(imgName string) string
 	DeleteImage(imgName string) error
 	GenerateName(prefix string, fileExt string) (string, error)
+	GenerateTempName(prefix string, fileExt string) (string, error)
+	OpenImage(imgName string) (io.ReadSeekCloser, error)
+	ImageETag(imgName string) (string, error)
+	ImageModTime(imgName string) (time.Time, error)
+	CommitImage(imgName string) (string, error)
+	Committed(imgName string) bool
+	ImageSize(imgName string) (int, int, error)
+	ImageFrames(imgName string) (int, error)
+	FileSize(imgName string) (int64, error)
+	ImageDPI(imgName string) (int, error)
+	ImageCaptureTime(imgName string) (time.Time, error)
+	LoadGIF(imgName string) (*gif.GIF, error)
+	SaveGIF(anim *gif.GIF, imgName string, origin audit.Origin) (string, error)
 }
 
-func New(log *slog.Logger, imgProcessor ImageProcessor) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		const op = "handlers.img.processor.New"
+type Options struct {
+	// DefaultFormats maps an input format to the output format used
+	// when the request has neither a convert action nor output_format.
+	DefaultFormats map[string]string
+	// ActionTimeouts bounds how long a single action may run, keyed by
+	// action name. Actions without an entry are not limited.
+	ActionTimeouts map[string]time.Duration
+	// Profile is used for requests that don't pick one. Empty means
+	// balanced.
+	Profile string
+	// MaxCost rejects requests whose estimated cost, in passes over a
+	// megapixel, is higher. Zero means no limit.
+	MaxCost float64
+	// EXIFThumbnail embeds a thumbnail of JPEG results in their EXIF.
+	EXIFThumbnail bool
+	// OptimizeJPEG saves JPEG results with Huffman tables fitted to the
+	// image.
+	OptimizeJPEG bool
+	// MemoryBudget is the working memory, in bytes, a blur, sharpen or
+	// resize, the max_megapixels downscale included, may take before it
+	// works on parts of the image at a time. Zero means no limit.
+	MemoryBudget int64
+	// Presets are named action lists a request picks with ?preset=.
+	Presets map[string][]ImageAction
+	// PreviewDebounce is how long a preview waits for a newer one of the
+	// same session before it starts.
+	PreviewDebounce time.Duration
+	// Remote fetches the images of requests with a source_url; nil
+	// rejects them.
+	Remote *remote.Cache
+	// MaxDuration bounds loading, processing and saving an image
+	// altogether, whatever the ActionTimeouts. Zero means no limit.
+	MaxDuration time.Duration
+	// MaxDataURISize is the largest result, in bytes, returned as a data
+	// URI. Zero means no limit.
+	MaxDataURISize int64
+	// DenyUpscale keeps resizes that don't set allow_upscale from
+	// enlarging the image, as OnUpscale says: clamp, the default, or
+	// reject.
+	DenyUpscale bool
+	OnUpscale   string
+}
 
-		log.With(
-			slog.String("op", op),
-			slog.String("request_id", middleware.GetReqID(r.Context())),
-		)
+func (opts Options) settings(req Request) profile.Settings {
+	if req.Profile != "" {
+		return profile.Get(req.Profile)
+	}
+	return profile.Get(opts.Profile)
+}
 
-		var req Request
+var (
+	errTimeout = errors.New("action timed out")
+	// errDeadline is the cause of a run stopped by Options.MaxDuration.
+	errDeadline = errors.New("processing took too long")
+)
 
-		err := render.DecodeJSON(r.Body, &req)
-		if errors.Is(err, io.EOF) {
-			log.Error("request body is empty")
-			render.Status(r, http.StatusBadRequest)
-			render.JSON(w, r, response.Error("empty request"))
+// withTimeout runs fn and gives up after timeout, or when ctx is done.
+// Image operations can't be interrupted, so an abandoned fn finishes in
+// the background and its result is dropped.
+func withTimeout[T any](ctx context.Context, timeout time.Duration, fn func() (T, error)) (T, error) {
+	return withCleanup(ctx, timeout, fn, nil)
+}
 
-			return
-		}
+// saveWithTimeout is withTimeout for saving img as imgName, a generated
+// name. A save that is abandoned still writes the image when it
+// finishes, so the image is deleted then: nobody was handed its URL.
+func saveWithTimeout(ctx context.Context, timeout time.Duration, imgProcessor ImageProcessor, img image.Image, imgName string, opts encoding.Options) (string, error) {
+	save := func() (string, error) { return imgProcessor.SaveImage(img, imgName, opts) }
+	return withCleanup(ctx, timeout, save, func(string) { imgProcessor.DeleteImage(imgName) })
+}
 
-		if err != nil {
-			log.Error("failed to decode request body", sl.Err(err))
-			render.Status(r, http.StatusBadRequest)
-			render.JSON(w, r, response.Error("failed to decode request"))
+// withCleanup is withTimeout calling cleanup, if set, with the result of
+// an abandoned fn that succeeds. A panic in fn is raised again on the
+// caller's goroutine, for the handler's recoverer to catch as it would
+// without the timeout; once fn is abandoned it is dropped.
+func withCleanup[T any](ctx context.Context, timeout time.Duration, fn func() (T, error), cleanup func(T)) (T, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	} else if _, ok := ctx.Deadline(); !ok {
+		return fn()
+	}
 
-			return
-		}
+	type result struct {
+		value T
+		err   error
+	}
 
-		if !response.Validation(log, w, r, req, http.StatusBadRequest) {
-			return
-		}
+	var mu sync.Mutex
+	abandoned := false
 
-		log.Info("request body decoded", slog.Any("request", req))
+	done := make(chan result, 1)
+	go func() {
+		value, err := recovered(fn)
 
-		imgPath, err := imgProcessor.FindImage(req.ImageName)
-		if err != nil {
-			log.Error("failed to find image", sl.Err(err))
-			render.Status(r, http.StatusNotFound)
-			render.JSON(w, r, response.Error("failed to find image"))
-			return
+		mu.Lock()
+		late := abandoned
+		done <- result{value, err}
+		mu.Unlock()
+
+		if late && err == nil && cleanup != nil {
+			cleanup(value)
 		}
+	}()
 
-		fileExt := strings.ToLower(filepath.Ext(imgPath))
+	finished := func(res result) (T, error) {
+		repanic(res.err)
+		return res.value, res.err
+	}
 
-		inputImg, err := imgProcessor.LoadImage(req.ImageName)
-		if err != nil {
-			log.Error("failed to load image", sl.Err(err))
-			render.Status(r, http.StatusNotFound)
-			render.JSON(w, r, response.Error("failed to load image"))
-			return
+	select {
+	case res := <-done:
+		return finished(res)
+	case <-ctx.Done():
+	}
+
+	mu.Lock()
+	// fn may have finished while ctx was done; then it isn't abandoned.
+	select {
+	case res := <-done:
+		mu.Unlock()
+		return finished(res)
+	default:
+		abandoned = true
+	}
+	mu.Unlock()
+
+	var zero T
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return zero, errTimeout
+	}
+	return zero, ctx.Err()
+}
+
+// panicError is a panic recovered on another goroutine than the
+// handler's, with the stack it was raised from.
+type panicError struct {
+	value any
+	stack []byte
+}
+
+func (p *panicError) Error() string {
+	return fmt.Sprintf("%v\n\n%s", p.value, p.stack)
+}
+
+// recovered runs fn, returning a panic in it as a *panicError.
+func recovered[T any](fn func() (T, error)) (value T, err error) {
+	defer func() {
+		switch r := recover().(type) {
+		case nil:
+		case *panicError:
+			// Raised again by repanic, with its stack already.
+			err = r
+		default:
+			err = &panicError{value: r, stack: debug.Stack()}
 		}
+	}()
+	return fn()
+}
 
-		for _, action := range req.Actions {
-			if !response.Validation(log, w, r, action, http.StatusBadRequest) {
-				return
-			}
-			switch action.Action {
-			case cropAction:
-				var params crop.CropParams
-				if err := decodeParams(action.Params, &params); err != nil {
-					log.Error("invalid crop params", sl.Err(err))
-					render.Status(r, http.StatusBadRequest)
-					render.JSON(w, r, response.Error("invalid crop params"))
-					return
-				}
-
-				if !response.Validation(log, w, r, params, http.StatusBadRequest) {
-					return
-				}
-
-				inputImg, err = params.CropImage(inputImg)
-			case resizeAction:
-				var params resize.ResizeParams
-				if err := decodeParams(action.Params, &params); err != nil {
-					log.Error("invalid resize params", sl.Err(err))
-					render.Status(r, http.StatusBadRequest)
-					render.JSON(w, r, response.Error("invalid resize params"))
-					return
-				}
-				if !response.Validation(log, w, r, params, http.StatusBadRequest) {
-					return
-				}
-				inputImg, err = params.ResizeImage(inputImg)
-			case blurAction:
-				var params blur.BlurParams
-				if err := decodeParams(action.Params, &params); err != nil {
-					log.Error("invalid blur params", sl.Err(err))
-					render.Status(r, http.StatusBadRequest)
-					render.JSON(w, r, response.Error("invalid blur params"))
-					return
-				}
-				if !response.Validation(log, w, r, params, http.StatusBadRequest) {
-					return
-				}
-				inputImg, err = params.BlurImage(inputImg)
-			case gammaAction:
-				var params gamma.GammaParams
-				if err := decodeParams(action.Params, &params); err != nil {
-					log.Error("invalid gamma params", sl.Err(err))
-					render.Status(r, http.StatusBadRequest)
-					render.JSON(w, r, response.Error("invalid gamma params"))
-					return
-				}
-				if !response.Validation(log, w, r, params, http.StatusBadRequest) {
-					return
-				}
-				inputImg, err = params.GammaImage(inputImg)
-			case contrastAction:
-				var params contrast.ContrastParams
-				if err := decodeParams(action.Params, &params); err != nil {
-					log.Error("invalid gamma params", sl.Err(err))
-					render.Status(r, http.StatusBadRequest)
-					render.JSON(w, r, response.Error("invalid gamma params"))
-					return
-				}
-				if !response.Validation(log, w, r, params, http.StatusBadRequest) {
-					return
-				}
-				inputImg, err = params.ContrastImage(inputImg)
-			case sharpenAction:
-				var params sharpen.SharpenParams
-				if err := decodeParams(action.Params, &params); err != nil {
-					log.Error("invalid sharpen params", sl.Err(err))
-					render.Status(r, http.StatusBadRequest)
-					render.JSON(w, r, response.Error("invalid sharpen params"))
-					return
-				}
-				if !response.Validation(log, w, r, params, http.StatusBadRequest) {
-					return
-				}
-				inputImg, err = params.SharpenImage(inputImg)
-			case brightnessAction:
-				var params brightness.BrightnessParams
-				if err := decodeParams(action.Params, &params); err != nil {
-					log.Error("invalid sharpen params", sl.Err(err))
-					render.Status(r, http.StatusBadRequest)
-					render.JSON(w, r, response.Error("invalid sharpen params"))
-					return
-				}
-				if !response.Validation(log, w, r, params, http.StatusBadRequest) {
-					return
-				}
-				inputImg, err = params.BrightnessImage(inputImg)
-			case saturationAction:
-				var params saturation.SaturationParams
-				if err := decodeParams(action.Params, &params); err != nil {
-					log.Error("invalid sharpen params", sl.Err(err))
-					render.Status(r, http.StatusBadRequest)
-					render.JSON(w, r, response.Error("invalid sharpen params"))
-					return
-				}
-				if !response.Validation(log, w, r, params, http.StatusBadRequest) {
-					return
-				}
-				inputImg, err = params.SaturationImage(inputImg)
-			case convertAction:
-				var params convert.ConvertParams
-				if err := decodeParams(action.Params, &params); err != nil {
-					log.Error("invalid convert params", sl.Err(err))
-					render.Status(r, http.StatusBadRequest)
-					render.JSON(w, r, response.Error("invalid convert params"))
-					return
-				}
-				if !response.Validation(log, w, r, params, http.StatusBadRequest) {
-					return
-				}
-				fileExt, err = params.ConvertImage()
-			default:
-				err = fmt.Errorf("field %s must be one of the allowed values`", action.Action)
-				log.Error("invalid action", sl.Err(err))
-				render.Status(r, http.StatusBadRequest)
-				render.JSON(w, r, response.Error(err.Error()))
-				return
-			}
-			if err != nil {
-				log.Error("failed to perform action", sl.Err(err))
-				render.Status(r, http.StatusBadRequest)
-				render.JSON(w, r, response.Error(fmt.Sprintf("failed to perform action %s: %v", action.Action, err)))
-				return
-			}
+// repanic raises again on the calling goroutine a panic recovered as err,
+// so the handler's recoverer catches it.
+func repanic(err error) {
+	var p *panicError
+	if errors.As(err, &p) {
+		panic(p)
+	}
+}
+
+func (opts Options) defaultFormat(fileExt string) (string, bool) {
+	input := imgformat.Normalize(fileExt)
+
+	for in, out := range opts.DefaultFormats {
+		if imgformat.Normalize(in) == input {
+			return out, true
 		}
+	}
 
-		imgName, err := imgProcessor.GenerateName("proc", fileExt)
-		if err != nil {
-			log.Error("failed to generate name", sl.Err(err))
-			render.Status(r, http.StatusInternalServerError)
-			render.JSON(w, r, response.Error("failed to generate name"))
+	return "", false
+}
+
+func New(log *slog.Logger, imgProcessor ImageProcessor, opts Options) http.HandlerFunc {
+	// Identical requests in flight at the same time share one run.
+	var group singleflight.Group
+	sessions := newPreviews()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.img.processor.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		req, ok := opts.decodeRequest(log, w, r)
+		if !ok {
 			return
 		}
 
-		imgUrl, err := imgProcessor.SaveImage(inputImg, imgName)
+		resp, err := opts.process(r.Context(), log, imgProcessor, &group, sessions, req, audit.OriginOf(r))
 		if err != nil {
-			log.Error("failed to save image", sl.Err(err))
-			render.Status(r, http.StatusUnsupportedMediaType)
-			render.JSON(w, r, response.Error("failed to save image"))
+			renderError(log, w, r, err)
 			return
 		}
 
-		log.Info("image saved", slog.String("image url", imgUrl))
-
-		responseOK(w, r, imgUrl)
+		render.Status(r, http.StatusOK)
+		render.JSON(w, r, resp)
 	}
 }
 
-func decodeParams(input interface{}, output interface{}) error {
-	data, err := json.Marshal(input)
+// process runs req, decoded and validated, against its source image and
+// saves the result. Failures are *actionError carrying the response
+// status. Previews only run with sessions. origin is the request asking,
+// for the audit log.
+func (opts Options) process(ctx context.Context, log *slog.Logger, imgProcessor ImageProcessor, group *singleflight.Group, sessions *previews, req Request, origin audit.Origin) (Response, error) {
+	if err := checkOutputFormat(req); err != nil {
+		return Response{}, err
+	}
+	if req.Return == ReturnDataURI && len(req.Fallbacks) > 0 {
+		return Response{}, &actionError{status: http.StatusBadRequest, msg: "fallbacks can't be returned as a data uri"}
+	}
+	if req.Return == ReturnDataURI && len(req.Outputs) > 0 {
+		return Response{}, &actionError{status: http.StatusBadRequest, msg: "outputs can't be returned as a data uri"}
+	}
+
+	settings := opts.settings(req)
+
+	steps, err := parseActions(req.Actions, imgProcessor, settings, req.ContinueOnError)
 	if err != nil {
-		return err
+		return Response{}, err
+	}
+
+	if err := checkAlpha(req, steps); err != nil {
+		return Response{}, err
+	}
+	setMemoryBudget(steps, opts.MemoryBudget)
+	opts.setUpscaleDefaults(steps)
+
+	// A remote source is only cached, its results are the client's.
+	// Previews are thrown away as the edit goes on, so they are only
+	// kept if committed.
+	temp := req.Preview || (req.SourceURL == "" && !imgProcessor.Committed(req.ImageName))
+
+	if err := opts.fetchSource(ctx, log, &req); err != nil {
+		return Response{}, err
 	}
-	return json.Unmarshal(data, output)
+
+	imgPath, err := imgProcessor.FindImage(req.ImageName)
+	if err != nil {
+		return Response{}, &actionError{status: http.StatusNotFound, msg: "failed to find image", err: err}
+	}
+
+	j := job{req: req, steps: steps, imgPath: imgPath, encoding: settings.Encoding, filter: settings.ResizeFilter, inline: req.Return == ReturnDataURI}
+	j.encoding.EXIFThumbnail = opts.EXIFThumbnail
+	j.encoding.OptimizeJPEG = opts.OptimizeJPEG
+	j.encoding.Origin = origin
+	j.encoding.Temp = temp
+
+	var out output
+	if req.Preview && sessions != nil {
+		// Previews are only ever wanted by the session asking, so
+		// they aren't shared with other requests.
+		out, err = opts.preview(ctx, sessions, imgProcessor, j)
+	} else if j.inline {
+		// The result is never saved, so there is none to share.
+		out, err = opts.run(ctx, imgProcessor, j)
+	} else {
+		out, err = opts.dedup(ctx, log, imgProcessor, group, j)
+	}
+	if errors.Is(err, errDeadline) {
+		log.Warn("processing deadline exceeded", slog.Duration("max_duration", opts.MaxDuration))
+	}
+	if err != nil {
+		return Response{}, err
+	}
+
+	if out.scale > 0 {
+		log.Info("source downscaled to max megapixels", slog.Float64("max_megapixels", req.MaxMegapixels), slog.Float64("scale", out.scale))
+	}
+	if j.inline {
+		return opts.inlineResponse(out)
+	}
+
+	log.Info("image saved", slog.String("image url", out.url))
+
+	size, err := imgProcessor.FileSize(out.name)
+	if err != nil {
+		log.Warn("failed to read file size", sl.Err(err))
+	}
+
+	resp := Response{
+		Response:  response.OK(),
+		ImageUrl:  out.url,
+		Width:     out.width,
+		Height:    out.height,
+		Format:    out.format,
+		Size:      size,
+		Quality:   out.quality,
+		Scale:     out.scale,
+		Fallbacks: out.fallbacks,
+		Outputs:   out.outputs,
+		Warnings:  out.warnings,
+		Temp:      !imgProcessor.Committed(out.name),
+	}
+
+	return resp, nil
 }
 
-func responseOK(w http.ResponseWriter, r *http.Request, imgUrl string) {
-	render.Status(r, http.StatusOK)
-	render.JSON(w, r, Response{
-		Response: response.OK(),
-		ImageUrl: imgUrl,
+// dedup runs j, joining a run already in flight for the same source
+// content and request. The shared run is detached from the caller's
+// context so one client going away doesn't fail the others; each caller
+// still stops waiting when its own context is done.
+func (opts Options) dedup(ctx context.Context, log *slog.Logger, imgProcessor ImageProcessor, group *singleflight.Group, j job) (output, error) {
+	etag, err := imgProcessor.ImageETag(j.req.ImageName)
+	if err != nil {
+		log.Warn("failed to hash image, running without deduplication", sl.Err(err))
+		return opts.run(ctx, imgProcessor, j)
+	}
+
+	key, err := dedupKey(j.req, etag)
+	if err != nil {
+		return output{}, err
+	}
+
+	runCtx := context.WithoutCancel(ctx)
+	// singleflight ends the process when a DoChan function panics, so
+	// the panic is handed to every caller instead.
+	ch := group.DoChan(key, func() (interface{}, error) {
+		return recovered(func() (interface{}, error) {
+			return opts.run(runCtx, imgProcessor, j)
+		})
 	})
+
+	select {
+	case res := <-ch:
+		repanic(res.Err)
+		if res.Err != nil {
+			return output{}, res.Err
+		}
+		if res.Shared {
+			log.Debug("joined identical request in flight")
+		}
+		return res.Val.(output), nil
+	case <-ctx.Done():
+		return output{}, ctx.Err()
+	}
+}
+
+// decodeRequest decodes and validates the request body, first expanding
+// the preset named in the query, if any.
+func (opts Options) decodeRequest(log *slog.Logger, w http.ResponseWriter, r *http.Request) (Request, bool) {
+	var req Request
+
+	err := render.DecodeJSON(r.Body, &req)
+	if errors.Is(err, io.EOF) {
+		log.Error("request body is empty")
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, response.Error("empty request"))
+
+		return req, false
+	}
+
+	if err != nil {
+		log.Error("failed to decode request body", sl.Err(err))
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, response.Error("failed to decode request"))
+
+		return req, false
+	}
+
+	if name := r.URL.Query().Get("preset"); name != "" {
+		if req.Actions, err = opts.expandPreset(name, req.Actions); err != nil {
+			renderError(log, w, r, err)
+			return req, false
+		}
+	}
+
+	if !response.Validation(log, w, r, req, http.StatusBadRequest) {
+		return req, false
+	}
+
+	log.Info("request body decoded", slog.Any("request", req))
+
+	return req, true
+}
+
+// checkOutputFormat fails a request whose output_format, or one of its
+// fallbacks, can't be saved, before any work is done.
+func checkOutputFormat(req Request) error {
+	formats := req.Fallbacks
+	if req.OutputFormat != "" {
+		formats = append([]string{req.OutputFormat}, formats...)
+	}
+	for _, out := range req.Outputs {
+		formats = append(formats, out.Format)
+	}
+
+	for _, format := range formats {
+		if err := convert.CheckFormat(format); err != nil {
+			return &actionError{status: http.StatusUnsupportedMediaType, msg: convert.Message(format, err), err: err}
+		}
+	}
+
+	return checkOutputs(req)
+}
+
+// checkAlpha fails a request with a step leaving transparency whose
+// output format, asked for with output_format or a convert, can't keep it.
+// Without either the result is saved as PNG instead, see run.
+func checkAlpha(req Request, steps []step) error {
+	action, ok := alphaStep(steps)
+	if !ok {
+		return nil
+	}
+
+	format := req.OutputFormat
+	if format == "" && len(steps) > 0 && steps[len(steps)-1].apply == nil {
+		format = steps[len(steps)-1].format
+	}
+
+	formats := []string{format}
+	if len(req.Outputs) > 0 {
+		formats = nil
+		for _, out := range req.Outputs {
+			formats = append(formats, out.Format)
+		}
+	}
+
+	for _, format := range formats {
+		if format != "" && !imgformat.HasAlpha(format) {
+			return &actionError{
+				status: http.StatusBadRequest,
+				msg:    fmt.Sprintf("%s needs an output format with transparency, such as png or webp", action),
+			}
+		}
+	}
+
+	return nil
+}
+
+func renderError(log *slog.Logger, w http.ResponseWriter, r *http.Request, err error) {
+	var actionErr *actionError
+	if !errors.As(err, &actionErr) {
+		log.Error("failed to process image", sl.Err(err))
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, response.Error("failed to process image"))
+		return
+	}
+
+	log.Error("invalid request", sl.Err(err))
+
+	render.Status(r, actionErr.status)
+	if errors.Is(err, convert.ErrUnsupportedFormat) {
+		render.JSON(w, r, response.UnsupportedFormatResponse{
+			Response:         response.Error(actionErr.message()),
+			SupportedFormats: convert.Formats,
+		})
+		return
+	}
+	render.JSON(w, r, response.Error(actionErr.message()))
 }
@@ -2,25 +2,45 @@ package processor_test
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"image"
+	"image/png"
 	"net/http"
 	"net/http/httptest"
 	"online-photo-editor/internal/http-server/handlers/image/processor"
 	"online-photo-editor/internal/http-server/handlers/image/processor/mocks"
+	"online-photo-editor/internal/http-server/middleware/auth"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/encoding"
 	"online-photo-editor/internal/lib/logger/handlers/slogdiscard"
+	"online-photo-editor/internal/lib/remote"
+	"online-photo-editor/internal/storage"
+	"online-photo-editor/internal/storage/filesystem"
+	"online-photo-editor/internal/storage/memory"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHandler_ProcessImage_Success(t *testing.T) {
 	mockProcessor := new(mocks.ImageProcessor)
 	logger := slogdiscard.NewDiscardLogger()
-	handler := processor.New(logger, mockProcessor)
+	handler := processor.New(logger, mockProcessor, processor.Options{})
 
 	reqBody := processor.Request{
 		Actions: []processor.ImageAction{
@@ -33,9 +53,12 @@ func TestHandler_ProcessImage_Success(t *testing.T) {
 	assert.NoError(t, err)
 
 	mockProcessor.On("FindImage", "test-image.png").Return("/path/to/test-image.png", nil)
+	mockProcessor.On("Committed", mock.Anything).Return(true)
 	mockProcessor.On("LoadImage", "test-image.png").Return(image.NewRGBA(image.Rect(0, 0, 100, 100)), nil)
+	mockProcessor.On("ImageETag", "test-image.png").Return("\"etag\"", nil)
 	mockProcessor.On("GenerateName", "proc", ".png").Return("new-image.png", nil)
-	mockProcessor.On("SaveImage", mock.Anything, "new-image.png").Return("/path/to/new-image.png", nil)
+	mockProcessor.On("SaveImage", mock.Anything, "new-image.png", mock.Anything).Return("/path/to/new-image.png", nil)
+	mockProcessor.On("FileSize", mock.Anything).Return(int64(1234), nil)
 
 	req := httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
@@ -52,24 +75,1161 @@ func TestHandler_ProcessImage_Success(t *testing.T) {
 	err = render.DecodeJSON(resp.Body, &response)
 	assert.NoError(t, err)
 	assert.Equal(t, "/path/to/new-image.png", response.ImageUrl)
+	assert.Equal(t, 100, response.Width)
+	assert.Equal(t, 100, response.Height)
+	assert.Equal(t, "png", response.Format)
+	assert.Equal(t, int64(1234), response.Size)
+}
+
+func TestHandler_ProcessImage_MemStorage(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(image.NewRGBA(image.Rect(0, 0, 200, 100)), "test-image.png", encoding.Options{})
+	assert.NoError(t, err)
+
+	handler := processor.New(slogdiscard.NewDiscardLogger(), mem, processor.Options{})
+
+	reqBody := processor.Request{
+		Actions: []processor.ImageAction{
+			{Action: "resize", Params: map[string]interface{}{"width": 50}},
+			{Action: "blur", Params: map[string]interface{}{"sigma": 1}},
+			{Action: "convert", Params: map[string]interface{}{"format": "jpg"}},
+		},
+		ImageName: "test-image.png",
+	}
+
+	body, err := json.Marshal(reqBody)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var response processor.Response
+	err = render.DecodeJSON(resp.Body, &response)
+	assert.NoError(t, err)
+	assert.Equal(t, 50, response.Width)
+	assert.Equal(t, 25, response.Height)
+	assert.Equal(t, "jpg", response.Format)
+
+	out, err := mem.LoadImage(strings.TrimPrefix(response.ImageUrl, "/images/"))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 50, 25), out.Bounds())
+
+	size, err := mem.FileSize(strings.TrimPrefix(response.ImageUrl, "/images/"))
+	assert.NoError(t, err)
+	assert.Equal(t, size, response.Size)
+}
+
+func TestHandler_ProcessImage_QualityAuto(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(image.NewRGBA(image.Rect(0, 0, 200, 100)), "test-image.png", encoding.Options{})
+	assert.NoError(t, err)
+
+	handler := processor.New(slogdiscard.NewDiscardLogger(), mem, processor.Options{})
+
+	for format, code := range map[string]int{"webp": http.StatusOK, "jpg": http.StatusBadRequest} {
+		body, err := json.Marshal(processor.Request{
+			Actions:   []processor.ImageAction{{Action: "convert", Params: map[string]interface{}{"format": format, "quality": "auto"}}},
+			ImageName: "test-image.png",
+		})
+		assert.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body)))
+		assert.Equal(t, code, w.Code, format)
+		if code != http.StatusOK {
+			continue
+		}
+
+		var response processor.Response
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		// A blank image is as flat as it gets.
+		assert.Equal(t, 65, response.Quality)
+	}
+}
+
+func TestHandler_ProcessImage_Fallbacks(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(image.NewRGBA(image.Rect(0, 0, 200, 100)), "photo.jpg", encoding.Options{})
+	assert.NoError(t, err)
+
+	handler := processor.New(slogdiscard.NewDiscardLogger(), mem, processor.Options{})
+
+	body, err := json.Marshal(processor.Request{
+		Actions:   []processor.ImageAction{{Action: "resize", Params: map[string]interface{}{"width": 40}}},
+		ImageName: "photo.jpg",
+		Fallbacks: []string{"webp"},
+	})
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body)))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response processor.Response
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "jpg", response.Format)
+	if !assert.Len(t, response.Fallbacks, 1) {
+		return
+	}
+	assert.Equal(t, "webp", response.Fallbacks[0].Format)
+
+	name := strings.TrimPrefix(response.ImageUrl, "/images/")
+	fallback := strings.TrimPrefix(response.Fallbacks[0].ImageUrl, "/images/")
+	assert.Equal(t, ".jpg", filepath.Ext(name))
+	assert.Equal(t, strings.TrimSuffix(name, ".jpg")+".webp", fallback, "the names only differ in the extension")
+
+	for _, imgName := range []string{name, fallback} {
+		out, err := mem.LoadImage(imgName)
+		if assert.NoError(t, err, imgName) {
+			assert.Equal(t, image.Rect(0, 0, 40, 20), out.Bounds(), imgName)
+		}
+	}
+	size, err := mem.FileSize(fallback)
+	assert.NoError(t, err)
+	assert.Equal(t, size, response.Fallbacks[0].Size)
+
+	// The storage can't write AVIF, so it isn't accepted.
+	body, err = json.Marshal(processor.Request{
+		Actions:   []processor.ImageAction{{Action: "resize", Params: map[string]interface{}{"width": 40}}},
+		ImageName: "photo.jpg",
+		Fallbacks: []string{"avif"},
+	})
+	assert.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "field Fallbacks[0] must be one of the allowed values")
+}
+
+func TestHandler_ProcessImage_Outputs(t *testing.T) {
+	// Noise, so the encode quality shows in the file size.
+	src := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 7919 % 251)
+		if i%4 == 3 {
+			src.Pix[i] = 0xff
+		}
+	}
+
+	mem := memory.New()
+	_, err := mem.SaveImage(src, "photo.png", encoding.Options{})
+	assert.NoError(t, err)
+
+	handler := processor.New(slogdiscard.NewDiscardLogger(), mem, processor.Options{})
+
+	send := func(outputs string) *httptest.ResponseRecorder {
+		body := `{"actions": [{"action": "brightness", "params": {"percentage": 5}}], "image_name": "photo.png", "outputs": ` + outputs + `}`
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/process", strings.NewReader(body)))
+		return w
+	}
+
+	w := send(`["webp", {"format": "jpeg", "quality": 10}, {"format": "png"}]`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response processor.Response
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "webp", response.Format, "the first output is the main result")
+	assert.Empty(t, response.Fallbacks)
+	if !assert.Len(t, response.Outputs, 3) {
+		return
+	}
+	assert.Equal(t, response.ImageUrl, response.Outputs["webp"])
+
+	name := strings.TrimPrefix(response.ImageUrl, "/images/")
+	for format, url := range response.Outputs {
+		imgName := strings.TrimPrefix(url, "/images/")
+		assert.Equal(t, strings.TrimSuffix(name, ".webp")+"."+format, imgName, "the names only differ in the extension")
+
+		out, err := mem.LoadImage(imgName)
+		if assert.NoError(t, err, imgName) {
+			assert.Equal(t, image.Rect(0, 0, 64, 64), out.Bounds(), imgName)
+		}
+	}
+
+	// The same jpg, at the default quality.
+	w = send(`["jpg"]`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var plain processor.Response
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &plain))
+	low, err := mem.FileSize(strings.TrimPrefix(response.Outputs["jpg"], "/images/"))
+	assert.NoError(t, err)
+	assert.Less(t, low, plain.Size, "each output has its own quality")
+
+	for _, outputs := range []string{`["jpg", "jpeg"]`, `[{"quality": 50}]`, `["webp", {"format": "jpg", "quality": 101}]`} {
+		w = send(outputs)
+		assert.Equal(t, http.StatusBadRequest, w.Code, outputs)
+	}
+	w = send(`["webp", "bmp2"]`)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func TestHandler_ProcessImage_MaxMegapixels(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(image.NewRGBA(image.Rect(0, 0, 2000, 1000)), "large.png", encoding.Options{})
+	assert.NoError(t, err)
+	_, err = mem.SaveImage(image.NewRGBA(image.Rect(0, 0, 200, 100)), "small.png", encoding.Options{})
+	assert.NoError(t, err)
+
+	handler := processor.New(slogdiscard.NewDiscardLogger(), mem, processor.Options{})
+
+	tests := []struct {
+		name       string
+		imgName    string
+		wantBounds image.Rectangle
+		wantScale  float64
+	}{
+		{"oversized", "large.png", image.Rect(0, 0, 1000, 500), 0.5},
+		{"within limit", "small.png", image.Rect(0, 0, 200, 100), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(processor.Request{
+				Actions:       []processor.ImageAction{{Action: "gamma", Params: map[string]interface{}{"sigma": 1.2}}},
+				ImageName:     tt.imgName,
+				MaxMegapixels: 0.5,
+			})
+			assert.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body)))
+			assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+			var response processor.Response
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.InDelta(t, tt.wantScale, response.Scale, 1e-9)
+			assert.Equal(t, tt.wantBounds.Dx(), response.Width)
+
+			out, err := mem.LoadImage(strings.TrimPrefix(response.ImageUrl, "/images/"))
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.wantBounds, out.Bounds())
+			}
+		})
+	}
+}
+
+func TestHandler_ProcessImage_RemoveBgKeepsAlpha(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(image.NewRGBA(image.Rect(0, 0, 60, 40)), "product.jpg", encoding.Options{})
+	assert.NoError(t, err)
+
+	handler := processor.New(slogdiscard.NewDiscardLogger(), mem, processor.Options{})
+	send := func(outputFormat string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(processor.Request{
+			Actions:      []processor.ImageAction{{Action: "remove_bg", Params: map[string]interface{}{}}},
+			ImageName:    "product.jpg",
+			OutputFormat: outputFormat,
+		})
+		assert.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body)))
+		return w
+	}
+
+	w := send("")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response processor.Response
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "png", response.Format, "a JPEG source is saved as PNG")
+
+	w = send("jpg")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "remove_bg needs an output format with transparency")
+}
+
+func TestHandler_ProcessImage_OnePixel(t *testing.T) {
+	mem := memory.New()
+	dot := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	copy(dot.Pix, []uint8{200, 120, 40, 255})
+	_, err := mem.SaveImage(dot, "dot.png", encoding.Options{})
+	assert.NoError(t, err)
+	_, err = mem.SaveImage(image.NewGray(image.Rect(0, 0, 1, 1)), "logo.png", encoding.Options{})
+	assert.NoError(t, err)
+	lutName, err := mem.UploadImage(strings.NewReader("LUT_3D_SIZE 2\n0 0 0\n1 0 0\n0 1 0\n1 1 0\n0 0 1\n1 0 1\n0 1 1\n1 1 1\n"), "identity.cube")
+	assert.NoError(t, err)
+
+	handler := processor.New(slogdiscard.NewDiscardLogger(), mem, processor.Options{})
+
+	// Every action on a 1×1 image either works or fails with a client
+	// error, and the result keeps the one pixel unless the action changes
+	// the size on purpose.
+	tests := []struct {
+		action string
+		params map[string]interface{}
+		width  int
+		height int
+	}{
+		{"crop", map[string]interface{}{"x": 0, "y": 0, "width": 1, "height": 1}, 1, 1},
+		{"crop", map[string]interface{}{"aspect_ratio": "16:9"}, 1, 1},
+		{"resize", map[string]interface{}{"width": 1}, 1, 1},
+		{"resize", map[string]interface{}{"width": 1, "height": 1, "mode": "fill", "sharpen": true}, 1, 1},
+		{"resize", map[string]interface{}{"width": 4, "height": 2, "mode": "fit", "pad": true}, 4, 2},
+		{"convert", map[string]interface{}{"format": "jpg"}, 1, 1},
+		{"blur", map[string]interface{}{"sigma": 5}, 1, 1},
+		{"gamma", map[string]interface{}{"sigma": 1.5}, 1, 1},
+		{"contrast", map[string]interface{}{"percentage": 20}, 1, 1},
+		{"sharpen", map[string]interface{}{"sigma": 2}, 1, 1},
+		{"brightness", map[string]interface{}{"percentage": 20}, 1, 1},
+		{"saturation", map[string]interface{}{"percentage": 20}, 1, 1},
+		{"guides", map[string]interface{}{"guide": "thirds"}, 1, 1},
+		{"straighten", map[string]interface{}{}, 1, 1},
+		{"replace_color", map[string]interface{}{"from": "white", "to": "black"}, 1, 1},
+		{"exposure", map[string]interface{}{"ev": 1}, 1, 1},
+		{"shadows_highlights", map[string]interface{}{"shadows": 50, "highlights": 50}, 1, 1},
+		{"replacebg", map[string]interface{}{"background": "red"}, 1, 1},
+		{"watermark", map[string]interface{}{"image_name": "logo.png"}, 1, 1},
+		{"frame", map[string]interface{}{"frame_name": "logo.png", "fit": "fit"}, 1, 1},
+		{"social_card", map[string]interface{}{"title": "Hello"}, 1200, 630},
+		{"reduce_colors", map[string]interface{}{"colors": 2}, 1, 1},
+		{"portrait", map[string]interface{}{}, 1, 1},
+		{"autocrop", map[string]interface{}{"method": "color"}, 1, 1},
+		{"datestamp", map[string]interface{}{}, 1, 1},
+		{"motionblur", map[string]interface{}{"distance": 10}, 1, 1},
+		{"lut", map[string]interface{}{"lut_name": lutName}, 1, 1},
+		{"swirl", map[string]interface{}{"radius": 5, "angle": 90}, 1, 1},
+		{"distort", map[string]interface{}{"type": "bulge", "strength": 0.5, "radius": 5}, 1, 1},
+		{"letterbox", map[string]interface{}{"width": 3, "height": 2}, 3, 2},
+		{"dewarp", map[string]interface{}{"k": 0.3}, 1, 1},
+		{"perspective", map[string]interface{}{"corners": [][]float64{{0, 0}, {1, 0}, {1, 1}, {0, 1}}}, 1, 1},
+		{"remove_bg", map[string]interface{}{}, 1, 1},
+		{"reflection", map[string]interface{}{}, 1, 2},
+		{"gradient_map", map[string]interface{}{"stops": []map[string]interface{}{{"pos": 0, "color": "navy"}, {"pos": 1, "color": "gold"}}, "bands": 4}, 1, 1},
+		{"channel_mixer", map[string]interface{}{"matrix": [][]float64{{0.3, 0.6, 0.1}, {0.3, 0.6, 0.1}, {0.3, 0.6, 0.1}}}, 1, 1},
+	}
+
+	for _, tt := range tests {
+		body, err := json.Marshal(processor.Request{
+			Actions:   []processor.ImageAction{{Action: tt.action, Params: tt.params}},
+			ImageName: "dot.png",
+		})
+		assert.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/process", bytes.NewReader(body)))
+		if !assert.Equal(t, http.StatusOK, w.Code, "%s %v: %s", tt.action, tt.params, w.Body.String()) {
+			continue
+		}
+
+		var response processor.Response
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, [2]int{tt.width, tt.height}, [2]int{response.Width, response.Height}, "%s %v", tt.action, tt.params)
+	}
+}
+
+// slowStorage takes delay to load an image.
+type slowStorage struct {
+	*memory.MemStorage
+	delay time.Duration
+}
+
+func (s slowStorage) LoadImage(imgName string) (image.Image, error) {
+	time.Sleep(s.delay)
+	return s.MemStorage.LoadImage(imgName)
+}
+
+// failingStorage fails to save any image with err.
+type failingStorage struct {
+	*memory.MemStorage
+	err error
+}
+
+func (s failingStorage) SaveImage(inputImg image.Image, imgName string, opts encoding.Options) (string, error) {
+	return "", s.err
+}
+
+func TestHandler_ProcessImage_SaveError(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(image.NewRGBA(image.Rect(0, 0, 40, 40)), "photo.png", encoding.Options{})
+	assert.NoError(t, err)
+
+	body, err := json.Marshal(processor.Request{
+		Actions:   []processor.ImageAction{{Action: "gamma", Params: map[string]interface{}{"sigma": 1.2}}},
+		ImageName: "photo.png",
+	})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{name: "encode error", err: fmt.Errorf("%w: unsupported file format: .png", storage.ErrEncode), status: http.StatusUnsupportedMediaType},
+		{name: "io error", err: &os.PathError{Op: "write", Path: "photo_1.png", Err: syscall.EIO}, status: http.StatusInternalServerError},
+		{name: "quota", err: storage.ErrQuotaExceeded, status: http.StatusInsufficientStorage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := processor.New(slogdiscard.NewDiscardLogger(), failingStorage{MemStorage: mem, err: tt.err}, processor.Options{})
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/process", bytes.NewReader(body)))
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestHandler_ProcessImage_MaxDuration(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(image.NewRGBA(image.Rect(0, 0, 40, 40)), "photo.png", encoding.Options{})
+	assert.NoError(t, err)
+
+	body, err := json.Marshal(processor.Request{
+		Actions:   []processor.ImageAction{{Action: "gamma", Params: map[string]interface{}{"sigma": 1.2}}},
+		ImageName: "photo.png",
+	})
+	assert.NoError(t, err)
+
+	send := func(maxDuration time.Duration) *httptest.ResponseRecorder {
+		storage := slowStorage{MemStorage: mem, delay: 50 * time.Millisecond}
+		handler := processor.New(slogdiscard.NewDiscardLogger(), storage, processor.Options{MaxDuration: maxDuration})
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/process", bytes.NewReader(body)))
+		return w
+	}
+
+	w := send(10 * time.Millisecond)
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Contains(t, w.Body.String(), "processing took too long")
+	assert.Equal(t, []string{"photo.png"}, mem.List(), "nothing is saved")
+
+	w = send(time.Second)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+func TestHandler_ProcessImage_MaxDurationDuringSave(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(image.NewRGBA(image.Rect(0, 0, 40, 40)), "photo.png", encoding.Options{})
+	assert.NoError(t, err)
+
+	body, err := json.Marshal(processor.Request{
+		Actions:   []processor.ImageAction{{Action: "gamma", Params: map[string]interface{}{"sigma": 1.2}}},
+		ImageName: "photo.png",
+	})
+	assert.NoError(t, err)
+
+	// The default deadline is on, so it must not leave results behind
+	// when it stops a save either.
+	storage := slowSaveStorage{MemStorage: mem, delay: 100 * time.Millisecond}
+	handler := processor.New(slogdiscard.NewDiscardLogger(), storage, processor.Options{MaxDuration: 30 * time.Millisecond})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/process", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Contains(t, w.Body.String(), "processing took too long")
+
+	time.Sleep(200 * time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return slices.Equal([]string{"photo.png"}, mem.List())
+	}, time.Second, 10*time.Millisecond, "left behind: %v", mem.List())
+}
+
+func TestHandler_ProcessImage_DenyUpscale(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(image.NewNRGBA(image.Rect(0, 0, 300, 200)), "photo.png", encoding.Options{})
+	assert.NoError(t, err)
+
+	send := func(opts processor.Options, params map[string]interface{}) *httptest.ResponseRecorder {
+		body, err := json.Marshal(processor.Request{
+			Actions:   []processor.ImageAction{{Action: "resize", Params: params}},
+			ImageName: "photo.png",
+		})
+		assert.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		processor.New(slogdiscard.NewDiscardLogger(), mem, opts).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/process", bytes.NewReader(body)))
+		return w
+	}
+
+	tests := []struct {
+		name   string
+		opts   processor.Options
+		params map[string]interface{}
+		status int
+		width  int
+	}{
+		{"allowed", processor.Options{}, map[string]interface{}{"width": 600}, http.StatusOK, 600},
+		{"clamped", processor.Options{DenyUpscale: true}, map[string]interface{}{"width": 600}, http.StatusOK, 300},
+		{"rejected", processor.Options{DenyUpscale: true, OnUpscale: "reject"}, map[string]interface{}{"width": 600}, http.StatusBadRequest, 0},
+		{"allowed by the request", processor.Options{DenyUpscale: true, OnUpscale: "reject"}, map[string]interface{}{"width": 600, "allow_upscale": true}, http.StatusOK, 600},
+	}
+
+	for _, tt := range tests {
+		w := send(tt.opts, tt.params)
+		if !assert.Equal(t, tt.status, w.Code, "%s: %s", tt.name, w.Body.String()) || tt.status != http.StatusOK {
+			continue
+		}
+
+		var response processor.Response
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, tt.width, response.Width, tt.name)
+	}
+}
+
+func TestHandler_ProcessImage_DataURI(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	for i := range src.Pix {
+		src.Pix[i] = 0xff
+	}
+
+	mem := memory.New()
+	_, err := mem.SaveImage(src, "photo.png", encoding.Options{})
+	assert.NoError(t, err)
+
+	body, err := json.Marshal(processor.Request{
+		Actions:   []processor.ImageAction{{Action: "resize", Params: map[string]interface{}{"width": 10}}},
+		ImageName: "photo.png",
+		Return:    processor.ReturnDataURI,
+	})
+	assert.NoError(t, err)
+
+	send := func(maxSize int64) *httptest.ResponseRecorder {
+		handler := processor.New(slogdiscard.NewDiscardLogger(), mem, processor.Options{MaxDataURISize: maxSize})
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/process", bytes.NewReader(body)))
+		return w
+	}
+
+	w := send(1 << 20)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response processor.Response
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Empty(t, response.ImageUrl)
+	assert.Equal(t, []string{"photo.png"}, mem.List(), "the result isn't kept")
+
+	const prefix = "data:image/png;base64,"
+	assert.True(t, strings.HasPrefix(response.DataURI, prefix), response.DataURI)
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(response.DataURI, prefix))
+	assert.NoError(t, err)
+	assert.Equal(t, response.Size, int64(len(data)))
+
+	img, err := png.Decode(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 10, 5), img.Bounds())
+	r, g, b, a := img.At(5, 2).RGBA()
+	assert.Equal(t, [4]uint32{0xffff, 0xffff, 0xffff, 0xffff}, [4]uint32{r, g, b, a})
+
+	w = send(16)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "data uri")
+	assert.Equal(t, []string{"photo.png"}, mem.List(), "the result isn't kept")
+
+	// The result is never saved, so a full storage evicts nothing for it.
+	mem.MaxImages = 1
+	w = send(1 << 20)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, []string{"photo.png"}, mem.List())
+}
+
+func TestHandler_ProcessImage_SourceURL(t *testing.T) {
+	var src bytes.Buffer
+	assert.NoError(t, png.Encode(&src, image.NewRGBA(image.Rect(0, 0, 200, 100))))
+
+	var hits atomic.Int32
+	remoteSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Cache-Control", "max-age=300")
+		w.Write(src.Bytes())
+	}))
+	defer remoteSrv.Close()
+
+	mem := memory.New()
+	handler := processor.New(slogdiscard.NewDiscardLogger(), mem, processor.Options{
+		Remote: remote.New(mem, remote.Options{AllowedHosts: []string{"127.0.0.1"}}),
+	})
+
+	for _, width := range []int{50, 80} {
+		body, err := json.Marshal(processor.Request{
+			Actions:   []processor.ImageAction{{Action: "resize", Params: map[string]interface{}{"width": width}}},
+			SourceURL: remoteSrv.URL + "/photo.png",
+		})
+		assert.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body)))
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var response processor.Response
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, width, response.Width)
+	}
+
+	// The second edit used the cached source.
+	assert.EqualValues(t, 1, hits.Load())
+
+	// A keyed caller's source is fetched into the namespace of its key.
+	keyed := auth.New(slogdiscard.NewDiscardLogger(), auth.NewKeys([]string{"key"}))(handler)
+	body, err := json.Marshal(processor.Request{
+		Actions:   []processor.ImageAction{{Action: "resize", Params: map[string]interface{}{"width": 50}}},
+		SourceURL: remoteSrv.URL + "/photo.png",
+	})
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body))
+	req.Header.Set(audit.APIKeyHeader, "key")
+	w := httptest.NewRecorder()
+	keyed.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response processor.Response
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.True(t, strings.HasPrefix(response.ImageUrl, "/images/key-"), response.ImageUrl)
+	assert.EqualValues(t, 2, hits.Load())
+}
+
+func TestHandler_ProcessImage_SourceURLDisabled(t *testing.T) {
+	handler := processor.New(slogdiscard.NewDiscardLogger(), new(mocks.ImageProcessor), processor.Options{})
+
+	for _, tc := range []processor.Request{
+		{SourceURL: "https://example.com/photo.png"},
+		// image_name and source_url are exclusive.
+		{SourceURL: "https://example.com/photo.png", ImageName: "test-image.png"},
+	} {
+		tc.Actions = []processor.ImageAction{{Action: "resize", Params: map[string]interface{}{"width": 50}}}
+		body, err := json.Marshal(tc)
+		assert.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body)))
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	}
+}
+
+func TestHandler_ProcessImage_ContinueOnError(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(image.NewRGBA(image.Rect(0, 0, 200, 100)), "test-image.png", encoding.Options{})
+	assert.NoError(t, err)
+
+	handler := processor.New(slogdiscard.NewDiscardLogger(), mem, processor.Options{})
+
+	actions := []processor.ImageAction{
+		{Action: "resize", Params: map[string]interface{}{"width": 100}},
+		{Action: "watermark", Params: map[string]interface{}{"image_name": "missing-logo.png"}},
+		{Action: "crop", Params: map[string]interface{}{"x": 10, "y": 10, "width": 20, "height": 30}},
+	}
+
+	send := func(continueOnError bool) (*http.Response, processor.Response) {
+		body, err := json.Marshal(processor.Request{Actions: actions, ImageName: "test-image.png", ContinueOnError: continueOnError})
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		resp := w.Result()
+		var response processor.Response
+		assert.NoError(t, render.DecodeJSON(resp.Body, &response))
+		resp.Body.Close()
+		return resp, response
+	}
+
+	resp, _ := send(false)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, response := send(true)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 20, response.Width)
+	assert.Equal(t, 30, response.Height)
+	if assert.Len(t, response.Warnings, 1) {
+		assert.Equal(t, "action watermark skipped: failed to find watermark image", response.Warnings[0])
+	}
+
+	out, err := mem.LoadImage(strings.TrimPrefix(response.ImageUrl, "/images/"))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 20, 30), out.Bounds())
+}
+
+func TestHandler_ProcessImage_LUT(t *testing.T) {
+	// The filesystem storage opens LUTs by name as the memory one does.
+	storages := map[string]func(t *testing.T) processor.ImageProcessor{
+		"memory": func(t *testing.T) processor.ImageProcessor { return memory.New() },
+		"filesystem": func(t *testing.T) processor.ImageProcessor {
+			store, err := filesystem.New(t.TempDir(), filesystem.Options{})
+			require.NoError(t, err)
+			return store
+		},
+	}
+
+	for name, newStorage := range storages {
+		t.Run(name, func(t *testing.T) {
+			testLUT(t, newStorage(t))
+		})
+	}
+}
+
+func testLUT(t *testing.T, mem processor.ImageProcessor) {
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for i := 0; i < len(src.Pix); i += 4 {
+		copy(src.Pix[i:], []uint8{255, 0, 0, 255})
+	}
+	_, err := mem.SaveImage(src, "test-image.png", encoding.Options{})
+	assert.NoError(t, err)
+
+	// A 2³ LUT inverting every channel.
+	var cube strings.Builder
+	cube.WriteString("TITLE \"invert\"\nLUT_3D_SIZE 2\n")
+	for i := 0; i < 8; i++ {
+		fmt.Fprintf(&cube, "%d %d %d\n", 1-i&1, 1-i>>1&1, 1-i>>2&1)
+	}
+	lutName, err := mem.UploadImage(strings.NewReader(cube.String()), "invert.cube")
+	assert.NoError(t, err)
+	badName, err := mem.UploadImage(strings.NewReader("LUT_3D_SIZE 2\n0 0 0\n"), "short.cube")
+	assert.NoError(t, err)
+
+	handler := processor.New(slogdiscard.NewDiscardLogger(), mem, processor.Options{})
+
+	send := func(lutName string) (int, processor.Response) {
+		body, err := json.Marshal(processor.Request{
+			Actions:   []processor.ImageAction{{Action: "lut", Params: map[string]interface{}{"lut_name": lutName}}},
+			ImageName: "test-image.png",
+		})
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		var response processor.Response
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return w.Code, response
+	}
+
+	status, response := send(lutName)
+	assert.Equal(t, http.StatusOK, status, response.Error)
+
+	out, err := mem.LoadImage(strings.TrimPrefix(response.ImageUrl, "/images/"))
+	assert.NoError(t, err)
+	r, g, b, _ := out.At(1, 1).RGBA()
+	assert.Equal(t, [3]uint32{0, 0xffff, 0xffff}, [3]uint32{r, g, b})
+
+	status, response = send(badName)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, response.Error, "invalid lut file")
+	assert.Contains(t, response.Error, "LUT_3D_SIZE 2 needs 8")
+
+	status, _ = send("missing.cube")
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestHandler_ProcessImage_TempResults(t *testing.T) {
+	dir, tempDir := t.TempDir(), t.TempDir()
+	store, err := filesystem.New(dir, filesystem.Options{TempPath: tempDir})
+	assert.NoError(t, err)
+
+	var src bytes.Buffer
+	assert.NoError(t, png.Encode(&src, image.NewRGBA(image.Rect(0, 0, 40, 40))))
+	uploaded, err := store.UploadImage(&src, "photo.png")
+	assert.NoError(t, err)
+	_, err = store.SaveImage(image.NewRGBA(image.Rect(0, 0, 40, 40)), "kept.png", encoding.Options{})
+	assert.NoError(t, err)
+
+	handler := processor.New(slogdiscard.NewDiscardLogger(), store, processor.Options{})
+	send := func(req processor.Request) processor.Response {
+		req.Actions = []processor.ImageAction{{Action: "gamma", Params: map[string]interface{}{"sigma": 1.2}}}
+		body, err := json.Marshal(req)
+		assert.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/process", bytes.NewReader(body)))
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var resp processor.Response
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	name := func(resp processor.Response) string { return strings.TrimPrefix(resp.ImageUrl, "/images/") }
+
+	tests := []struct {
+		name string
+		req  processor.Request
+		temp bool
+	}{
+		{"uncommitted source with a fallback", processor.Request{ImageName: uploaded, Fallbacks: []string{"webp"}}, true},
+		{"uncommitted source", processor.Request{ImageName: uploaded}, true},
+		{"preview", processor.Request{ImageName: "kept.png", Preview: true, SessionID: "s1"}, true},
+		{"committed source", processor.Request{ImageName: "kept.png"}, false},
+	}
+
+	var temps []processor.Response
+	for _, tt := range tests {
+		resp := send(tt.req)
+		assert.Equal(t, tt.temp, resp.Temp, tt.name)
+
+		_, err := os.Stat(filepath.Join(tempDir, name(resp)))
+		assert.Equal(t, tt.temp, err == nil, "%s: %s in the temp area", tt.name, name(resp))
+		for _, fallback := range resp.Fallbacks {
+			_, err := os.Stat(filepath.Join(tempDir, strings.TrimPrefix(fallback.ImageUrl, "/images/")))
+			assert.Equal(t, tt.temp, err == nil, "%s: %s in the temp area", tt.name, fallback.ImageUrl)
+		}
+		if tt.temp {
+			temps = append(temps, resp)
+		}
+	}
+
+	// Committing keeps a result; the janitor takes the others with the
+	// uploads.
+	_, err = store.CommitImage(name(temps[0]))
+	assert.NoError(t, err)
+	_, err = store.CleanupTemp(0)
+	assert.NoError(t, err)
+
+	assert.True(t, store.Committed(name(temps[0])))
+	for _, resp := range temps[1:] {
+		_, err = store.FindImage(name(resp))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	}
+}
+
+func TestHandler_ProcessImage_PreviewDebounce(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(image.NewRGBA(image.Rect(0, 0, 200, 100)), "test-image.png", encoding.Options{})
+	assert.NoError(t, err)
+
+	handler := processor.New(slogdiscard.NewDiscardLogger(), mem, processor.Options{PreviewDebounce: 200 * time.Millisecond})
+
+	send := func(req processor.Request) int {
+		req.ImageName = "test-image.png"
+		body, err := json.Marshal(req)
+		assert.NoError(t, err)
+
+		r := httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	brightness := func(v float64) []processor.ImageAction {
+		return []processor.ImageAction{{Action: "brightness", Params: map[string]interface{}{"percentage": v}}}
+	}
+
+	// A slider dragged through three values, then the final save, all
+	// while the first previews are still waiting.
+	requests := []processor.Request{
+		{Actions: brightness(10), Preview: true, SessionID: "s1"},
+		{Actions: brightness(20), Preview: true, SessionID: "s1"},
+		{Actions: brightness(30), Preview: true, SessionID: "s1"},
+		{Actions: brightness(30), SessionID: "s1"},
+		// Another session isn't affected.
+		{Actions: brightness(10), Preview: true, SessionID: "s2"},
+	}
+
+	codes := make([]int, len(requests))
+	var wg sync.WaitGroup
+	for i, req := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = send(req)
+		}()
+		time.Sleep(30 * time.Millisecond)
+	}
+	wg.Wait()
+
+	assert.Equal(t, []int{http.StatusConflict, http.StatusConflict, http.StatusOK, http.StatusOK, http.StatusOK}, codes)
+
+	assert.Equal(t, http.StatusBadRequest, send(processor.Request{Actions: brightness(10), Preview: true}), "a preview needs a session")
+}
+
+func TestHandler_ProcessImage_PreviewSessionsPerKey(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(image.NewRGBA(image.Rect(0, 0, 200, 100)), "test-image.png", encoding.Options{})
+	assert.NoError(t, err)
+
+	handler := processor.New(slogdiscard.NewDiscardLogger(), mem, processor.Options{PreviewDebounce: 200 * time.Millisecond})
+	keyed := auth.New(slogdiscard.NewDiscardLogger(), auth.NewKeys([]string{"key-a", "key-b"}))(handler)
+
+	send := func(key string) int {
+		body, err := json.Marshal(processor.Request{
+			ImageName: "test-image.png",
+			Actions:   []processor.ImageAction{{Action: "brightness", Params: map[string]interface{}{"percentage": 10}}},
+			Preview:   true,
+			SessionID: "s1",
+		})
+		assert.NoError(t, err)
+
+		r := httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(audit.APIKeyHeader, key)
+		w := httptest.NewRecorder()
+
+		keyed.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Both keys use the session ID s1, but neither supersedes the other.
+	codes := make([]int, 2)
+	var wg sync.WaitGroup
+	for i, key := range []string{"key-a", "key-b"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = send(key)
+		}()
+		time.Sleep(30 * time.Millisecond)
+	}
+	wg.Wait()
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK}, codes)
+}
+
+func TestHandler_ProcessImage_Preset(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(image.NewRGBA(image.Rect(0, 0, 2000, 1000)), "test-image.png", encoding.Options{})
+	assert.NoError(t, err)
+
+	opts := processor.Options{Presets: map[string][]processor.ImageAction{
+		"web": {
+			{Action: "resize", Params: map[string]interface{}{"width": 1024, "height": 1024, "mode": "fit"}},
+			{Action: "sharpen", Params: map[string]interface{}{"sigma": 0.5}},
+			{Action: "convert", Params: map[string]interface{}{"format": "webp", "quality": 80}},
+		},
+	}}
+	handler := processor.New(slogdiscard.NewDiscardLogger(), mem, opts)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantWidth  int
+		wantHeight int
+		wantError  string
+	}{
+		{name: "preset only", body: `{"image_name": "test-image.png"}`, wantStatus: http.StatusOK, wantWidth: 1024, wantHeight: 512},
+		{
+			name:       "override params",
+			body:       `{"image_name": "test-image.png", "actions": [{"action": "resize", "params": {"width": 500, "height": 500}}]}`,
+			wantStatus: http.StatusOK, wantWidth: 500, wantHeight: 250,
+		},
+		{
+			name:       "extend before convert",
+			body:       `{"image_name": "test-image.png", "actions": [{"action": "crop", "params": {"x": 10, "y": 10, "width": 100, "height": 100}}]}`,
+			wantStatus: http.StatusOK, wantWidth: 100, wantHeight: 100,
+		},
+		{
+			name:       "unknown preset",
+			body:       `{"image_name": "test-image.png"}`,
+			wantStatus: http.StatusBadRequest, wantError: "unknown preset print",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preset := "web"
+			if tt.wantError != "" {
+				preset = "print"
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/process?preset="+preset, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+
+			var response processor.Response
+			err := render.DecodeJSON(resp.Body, &response)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantError, response.Error)
+			if tt.wantError != "" {
+				return
+			}
+
+			assert.Equal(t, tt.wantWidth, response.Width)
+			assert.Equal(t, tt.wantHeight, response.Height)
+			assert.Equal(t, "webp", response.Format)
+		})
+	}
 }
 
 func TestHandler_ProcessImage_ImageNotFound(t *testing.T) {
 	mockProcessor := new(mocks.ImageProcessor)
 	logger := slogdiscard.NewDiscardLogger()
-	handler := processor.New(logger, mockProcessor)
+	handler := processor.New(logger, mockProcessor, processor.Options{})
+
+	reqBody := processor.Request{
+		Actions: []processor.ImageAction{
+			{Action: "resize", Params: map[string]interface{}{"width": 100, "height": 100}},
+		},
+		ImageName: "nonexistent-image.png",
+	}
+
+	body, err := json.Marshal(reqBody)
+	assert.NoError(t, err)
+
+	mockProcessor.On("FindImage", "nonexistent-image.png").Return("", errors.New("image not found"))
+	mockProcessor.On("Committed", mock.Anything).Return(true)
+
+	req := httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	var response map[string]string
+	err = render.DecodeJSON(resp.Body, &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "failed to find image", response["error"])
+}
+
+func TestHandler_ProcessImage_CorruptImage(t *testing.T) {
+	mockProcessor := new(mocks.ImageProcessor)
+	logger := slogdiscard.NewDiscardLogger()
+	handler := processor.New(logger, mockProcessor, processor.Options{})
+
+	body, err := json.Marshal(processor.Request{
+		Actions:   []processor.ImageAction{{Action: "blur", Params: map[string]interface{}{"sigma": 1}}},
+		ImageName: "test-image.png",
+	})
+	assert.NoError(t, err)
+
+	mockProcessor.On("FindImage", "test-image.png").Return("/path/to/test-image.png", nil)
+	mockProcessor.On("Committed", mock.Anything).Return(true)
+	mockProcessor.On("ImageETag", "test-image.png").Return("\"etag\"", nil)
+	mockProcessor.On("LoadImage", "test-image.png").Return(nil, fmt.Errorf("load: %w: unexpected EOF", storage.ErrCorruptImage))
+
+	req := httptest.NewRequest(http.MethodPost, "/process", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	var response map[string]string
+	assert.NoError(t, render.DecodeJSON(w.Body, &response))
+	assert.Equal(t, "corrupt or truncated image", response["error"])
+}
+
+func TestHandler_ProcessImage_QuotaExceeded(t *testing.T) {
+	mockProcessor := new(mocks.ImageProcessor)
+	logger := slogdiscard.NewDiscardLogger()
+	handler := processor.New(logger, mockProcessor, processor.Options{})
+
+	body, err := json.Marshal(processor.Request{
+		Actions:   []processor.ImageAction{{Action: "blur", Params: map[string]interface{}{"sigma": 1}}},
+		ImageName: "test-image.png",
+	})
+	assert.NoError(t, err)
+
+	mockProcessor.On("FindImage", "test-image.png").Return("/path/to/test-image.png", nil)
+	mockProcessor.On("Committed", mock.Anything).Return(true)
+	mockProcessor.On("ImageETag", "test-image.png").Return("\"etag\"", nil)
+	mockProcessor.On("LoadImage", "test-image.png").Return(image.NewRGBA(image.Rect(0, 0, 10, 10)), nil)
+	mockProcessor.On("GenerateName", "proc", ".png").Return("new-image.png", nil)
+	mockProcessor.On("SaveImage", mock.Anything, "new-image.png", mock.Anything).Return("", fmt.Errorf("save: %w", storage.ErrQuotaExceeded))
+
+	req := httptest.NewRequest(http.MethodPost, "/process", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInsufficientStorage, w.Code)
+
+	var response map[string]string
+	assert.NoError(t, render.DecodeJSON(w.Body, &response))
+	assert.Equal(t, "storage quota exceeded", response["error"])
+}
+
+func TestHandler_ProcessImage_DefaultFormat(t *testing.T) {
+	tests := []struct {
+		name         string
+		outputFormat string
+		wantExt      string
+	}{
+		{name: "mapped default", wantExt: "webp"},
+		{name: "explicit output format wins", outputFormat: "jpg", wantExt: "jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockProcessor := new(mocks.ImageProcessor)
+			logger := slogdiscard.NewDiscardLogger()
+			handler := processor.New(logger, mockProcessor, processor.Options{
+				DefaultFormats: map[string]string{"png": "webp"},
+			})
+
+			reqBody := processor.Request{
+				Actions: []processor.ImageAction{
+					{Action: "blur", Params: map[string]interface{}{"sigma": 1.0}},
+				},
+				ImageName:    "test-image.png",
+				OutputFormat: tt.outputFormat,
+			}
+
+			body, err := json.Marshal(reqBody)
+			assert.NoError(t, err)
+
+			mockProcessor.On("FindImage", "test-image.png").Return("/path/to/test-image.png", nil)
+			mockProcessor.On("Committed", mock.Anything).Return(true)
+			mockProcessor.On("LoadImage", "test-image.png").Return(image.NewRGBA(image.Rect(0, 0, 10, 10)), nil)
+			mockProcessor.On("ImageETag", "test-image.png").Return("\"etag\"", nil)
+			mockProcessor.On("GenerateName", "proc", tt.wantExt).Return("new-image."+tt.wantExt, nil)
+			mockProcessor.On("SaveImage", mock.Anything, "new-image."+tt.wantExt, mock.Anything).Return("/images/new-image."+tt.wantExt, nil)
+			mockProcessor.On("FileSize", mock.Anything).Return(int64(1234), nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			mockProcessor.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandler_ProcessImage_UnknownParamField(t *testing.T) {
+	mockProcessor := new(mocks.ImageProcessor)
+	logger := slogdiscard.NewDiscardLogger()
+	handler := processor.New(logger, mockProcessor, processor.Options{})
 
 	reqBody := processor.Request{
 		Actions: []processor.ImageAction{
-			{Action: "resize", Params: map[string]interface{}{"width": 100, "height": 100}},
+			{Action: "resize", Params: map[string]interface{}{"widht": 100, "height": 100}},
 		},
-		ImageName: "nonexistent-image.png",
+		ImageName: "test-image.png",
 	}
 
 	body, err := json.Marshal(reqBody)
 	assert.NoError(t, err)
 
-	mockProcessor.On("FindImage", "nonexistent-image.png").Return("", errors.New("image not found"))
+	mockProcessor.On("FindImage", "test-image.png").Return("/path/to/test-image.png", nil)
+	mockProcessor.On("Committed", mock.Anything).Return(true)
+	mockProcessor.On("LoadImage", "test-image.png").Return(image.NewRGBA(image.Rect(0, 0, 100, 100)), nil)
+	mockProcessor.On("ImageETag", "test-image.png").Return("\"etag\"", nil)
 
 	req := httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
@@ -80,10 +1240,535 @@ func TestHandler_ProcessImage_ImageNotFound(t *testing.T) {
 	resp := w.Result()
 	defer resp.Body.Close()
 
-	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
 
-	var response map[string]string
+	var response processor.Response
 	err = render.DecodeJSON(resp.Body, &response)
 	assert.NoError(t, err)
-	assert.Equal(t, "failed to find image", response["error"])
+	assert.Contains(t, response.Error, `unknown field "widht"`)
+	mockProcessor.AssertNotCalled(t, "SaveImage", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestHandler_ProcessImage_PathTraversal(t *testing.T) {
+	for _, name := range []string{"../../etc/passwd", "/etc/passwd", `..\secret.png`, "img.png\x00.jpg"} {
+		t.Run(name, func(t *testing.T) {
+			mockProcessor := new(mocks.ImageProcessor)
+			handler := processor.New(slogdiscard.NewDiscardLogger(), mockProcessor, processor.Options{})
+
+			body, err := json.Marshal(processor.Request{
+				Actions:   []processor.ImageAction{{Action: "gamma", Params: map[string]interface{}{"gamma": 1.2}}},
+				ImageName: name,
+			})
+			assert.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), "field ImageName must be a plain file name")
+			mockProcessor.AssertNotCalled(t, "FindImage", mock.Anything)
+			mockProcessor.AssertNotCalled(t, "LoadImage", mock.Anything)
+		})
+	}
+}
+
+func TestHandler_ProcessImage_ConvertMustBeLast(t *testing.T) {
+	mockProcessor := new(mocks.ImageProcessor)
+	logger := slogdiscard.NewDiscardLogger()
+	handler := processor.New(logger, mockProcessor, processor.Options{})
+
+	reqBody := processor.Request{
+		Actions: []processor.ImageAction{
+			{Action: "convert", Params: map[string]interface{}{"format": "jpg"}},
+			{Action: "blur", Params: map[string]interface{}{"sigma": 2}},
+			{Action: "resize", Params: map[string]interface{}{"width": 50}},
+		},
+		ImageName: "test-image.png",
+	}
+
+	body, err := json.Marshal(reqBody)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	var response processor.Response
+	err = render.DecodeJSON(resp.Body, &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "convert must be the last action", response.Error)
+	mockProcessor.AssertNotCalled(t, "LoadImage", mock.Anything)
+}
+
+func TestHandler_ProcessImage_UnsupportedFormat(t *testing.T) {
+	mockProcessor := new(mocks.ImageProcessor)
+	logger := slogdiscard.NewDiscardLogger()
+	handler := processor.New(logger, mockProcessor, processor.Options{})
+
+	reqBody := processor.Request{
+		Actions: []processor.ImageAction{
+			{Action: "resize", Params: map[string]interface{}{"width": 50}},
+			{Action: "convert", Params: map[string]interface{}{"format": "avif"}},
+		},
+		ImageName: "test-image.png",
+	}
+
+	body, err := json.Marshal(reqBody)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+
+	var response struct {
+		Error            string   `json:"error"`
+		SupportedFormats []string `json:"supported_formats"`
+	}
+	err = render.DecodeJSON(resp.Body, &response)
+	assert.NoError(t, err)
+	assert.Equal(t, `unsupported format "avif"`, response.Error)
+	assert.Contains(t, response.SupportedFormats, "webp")
+	mockProcessor.AssertNotCalled(t, "LoadImage", mock.Anything)
+}
+
+func TestHandler_ProcessImage_VectorFormat(t *testing.T) {
+	mockProcessor := new(mocks.ImageProcessor)
+	handler := processor.New(slogdiscard.NewDiscardLogger(), mockProcessor, processor.Options{})
+
+	resize := processor.ImageAction{Action: "resize", Params: map[string]interface{}{"width": 50}}
+	for _, reqBody := range []processor.Request{
+		{Actions: []processor.ImageAction{resize, {Action: "convert", Params: map[string]interface{}{"format": "svg"}}}, ImageName: "test-image.png"},
+		{Actions: []processor.ImageAction{resize}, ImageName: "test-image.png", OutputFormat: "pdf"},
+	} {
+		body, err := json.Marshal(reqBody)
+		assert.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body)))
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+		var response struct {
+			Error            string   `json:"error"`
+			SupportedFormats []string `json:"supported_formats"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "cannot convert raster image to vector format", response.Error)
+		assert.Contains(t, response.SupportedFormats, "png")
+	}
+
+	// Rejected before the image is even looked up.
+	mockProcessor.AssertNotCalled(t, "FindImage", mock.Anything)
+}
+
+func TestHandler_ProcessImage_ActionTimeout(t *testing.T) {
+	mockProcessor := new(mocks.ImageProcessor)
+	logger := slogdiscard.NewDiscardLogger()
+	handler := processor.New(logger, mockProcessor, processor.Options{
+		ActionTimeouts: map[string]time.Duration{"convert": time.Millisecond},
+	})
+
+	reqBody := processor.Request{
+		Actions: []processor.ImageAction{
+			{Action: "crop", Params: map[string]interface{}{"x": 10, "y": 10, "width": 50, "height": 50}},
+			{Action: "convert", Params: map[string]interface{}{"format": "webp"}},
+		},
+		ImageName: "test-image.png",
+	}
+
+	body, err := json.Marshal(reqBody)
+	assert.NoError(t, err)
+
+	mockProcessor.On("FindImage", "test-image.png").Return("/path/to/test-image.png", nil)
+	mockProcessor.On("Committed", mock.Anything).Return(true)
+	mockProcessor.On("LoadImage", "test-image.png").Return(image.NewRGBA(image.Rect(0, 0, 100, 100)), nil)
+	mockProcessor.On("ImageETag", "test-image.png").Return("\"etag\"", nil)
+	mockProcessor.On("GenerateName", "proc", "webp").Return("new-image.webp", nil)
+	// A slow WebP encode.
+	mockProcessor.On("SaveImage", mock.Anything, "new-image.webp", mock.Anything).
+		After(200*time.Millisecond).
+		Return("/path/to/new-image.webp", nil)
+	// The abandoned save deletes what it wrote once it is done.
+	mockProcessor.On("DeleteImage", "new-image.webp").Return(nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	start := time.Now()
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	assert.Less(t, time.Since(start), 100*time.Millisecond, "the handler must not wait for the encode")
+
+	var response processor.Response
+	err = render.DecodeJSON(resp.Body, &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "action convert timed out", response.Error)
+}
+
+// slowSaveStorage takes delay to save an image, as a slow encode would.
+type slowSaveStorage struct {
+	*memory.MemStorage
+	delay time.Duration
+}
+
+func (s slowSaveStorage) SaveImage(inputImg image.Image, imgName string, opts encoding.Options) (string, error) {
+	time.Sleep(s.delay)
+	return s.MemStorage.SaveImage(inputImg, imgName, opts)
+}
+
+// panicSaveStorage panics when saving an image, as a buggy encoder would.
+type panicSaveStorage struct {
+	*memory.MemStorage
+}
+
+func (panicSaveStorage) SaveImage(image.Image, string, encoding.Options) (string, error) {
+	panic("encoder bug")
+}
+
+func TestHandler_ProcessImage_PanicUnderDeadline(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(image.NewRGBA(image.Rect(0, 0, 4, 4)), "photo.png", encoding.Options{})
+	require.NoError(t, err)
+
+	body, err := json.Marshal(processor.Request{
+		Actions:   []processor.ImageAction{{Action: "gamma", Params: map[string]interface{}{"sigma": 1.2}}},
+		ImageName: "photo.png",
+	})
+	require.NoError(t, err)
+
+	// The save runs under the deadline, off the handler's goroutine; its
+	// panic must still reach the recoverer rather than end the process.
+	handler := middleware.Recoverer(processor.New(slogdiscard.NewDiscardLogger(), panicSaveStorage{mem}, processor.Options{MaxDuration: time.Minute}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/process", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestHandler_ProcessImage_TimeoutLeavesNoResult(t *testing.T) {
+	tests := []struct {
+		name string
+		opts processor.Options
+		req  processor.Request
+	}{
+		{
+			name: "action timeout",
+			opts: processor.Options{ActionTimeouts: map[string]time.Duration{"convert": 10 * time.Millisecond}},
+			req:  processor.Request{OutputFormat: ".webp"},
+		},
+		{
+			name: "fallback timeout",
+			opts: processor.Options{ActionTimeouts: map[string]time.Duration{"convert": 50 * time.Millisecond}},
+			req:  processor.Request{Fallbacks: []string{"webp"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := memory.New()
+			_, err := mem.SaveImage(image.NewRGBA(image.Rect(0, 0, 40, 40)), "photo.png", encoding.Options{})
+			assert.NoError(t, err)
+
+			req := tt.req
+			req.ImageName = "photo.png"
+			req.Actions = []processor.ImageAction{{Action: "gamma", Params: map[string]interface{}{"sigma": 1.2}}}
+			body, err := json.Marshal(req)
+			assert.NoError(t, err)
+
+			storage := slowSaveStorage{MemStorage: mem, delay: 100 * time.Millisecond}
+			handler := processor.New(slogdiscard.NewDiscardLogger(), storage, tt.opts)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/process", bytes.NewReader(body)))
+			assert.Equal(t, http.StatusGatewayTimeout, w.Code, w.Body.String())
+
+			// The saves given up on still finish, then remove what they
+			// wrote.
+			time.Sleep(300 * time.Millisecond)
+			assert.Eventually(t, func() bool {
+				return slices.Equal([]string{"photo.png"}, mem.List())
+			}, time.Second, 10*time.Millisecond, "left behind: %v", mem.List())
+		})
+	}
+}
+
+func TestHandler_ValidateImage(t *testing.T) {
+	tests := []struct {
+		name       string
+		cropWidth  int
+		wantStatus int
+		wantError  string
+	}{
+		{name: "crop fits after resize", cropWidth: 80, wantStatus: http.StatusOK},
+		{
+			name:       "crop exceeds resized image",
+			cropWidth:  150,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "failed to perform action crop: api.crop.CheckBounds crop area exceeds image boundaries",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockProcessor := new(mocks.ImageProcessor)
+			logger := slogdiscard.NewDiscardLogger()
+			handler := processor.NewValidate(logger, mockProcessor, processor.Options{})
+
+			reqBody := processor.Request{
+				Actions: []processor.ImageAction{
+					{Action: "resize", Params: map[string]interface{}{"width": 100, "height": 100}},
+					{Action: "crop", Params: map[string]interface{}{"x": 10, "y": 10, "width": tt.cropWidth, "height": 50}},
+				},
+				ImageName: "test-image.png",
+			}
+
+			body, err := json.Marshal(reqBody)
+			assert.NoError(t, err)
+
+			mockProcessor.On("ImageSize", "test-image.png").Return(4000, 3000, nil)
+			mockProcessor.On("ImageDPI", "test-image.png").Return(0, nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+
+			var response processor.ValidateResponse
+			err = render.DecodeJSON(resp.Body, &response)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantError, response.Error)
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, tt.cropWidth, response.Width)
+				assert.Equal(t, 50, response.Height)
+			}
+
+			mockProcessor.AssertNotCalled(t, "LoadImage", mock.Anything)
+		})
+	}
+}
+
+func TestHandler_ValidateImage_PrintSizeUsesSourceDPI(t *testing.T) {
+	mockProcessor := new(mocks.ImageProcessor)
+	logger := slogdiscard.NewDiscardLogger()
+	handler := processor.NewValidate(logger, mockProcessor, processor.Options{})
+
+	reqBody := processor.Request{
+		Actions: []processor.ImageAction{
+			{Action: "resize", Params: map[string]interface{}{"print_width": 4, "print_height": 6}},
+		},
+		ImageName: "test-image.jpg",
+	}
+
+	body, err := json.Marshal(reqBody)
+	assert.NoError(t, err)
+
+	mockProcessor.On("ImageSize", "test-image.jpg").Return(3000, 4500, nil)
+	mockProcessor.On("ImageDPI", "test-image.jpg").Return(300, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var response processor.ValidateResponse
+	err = render.DecodeJSON(resp.Body, &response)
+	assert.NoError(t, err)
+	assert.Equal(t, 1200, response.Width)
+	assert.Equal(t, 1800, response.Height)
+	assert.Equal(t, 300, response.DPI)
+	assert.Equal(t, 10.0, response.PrintWidth)
+	assert.Equal(t, 15.0, response.PrintHeight)
+}
+func TestHandler_ProcessImage_Profile(t *testing.T) {
+	tests := []struct {
+		name        string
+		profile     string
+		quality     int
+		exif        bool
+		wantQuality int
+	}{
+		{name: "default is balanced", wantQuality: 85},
+		{name: "fast profile", profile: "fast", wantQuality: 75},
+		{name: "explicit quality wins", profile: "quality", quality: 60, wantQuality: 60},
+		{name: "exif thumbnail", exif: true, wantQuality: 85},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockProcessor := new(mocks.ImageProcessor)
+			logger := slogdiscard.NewDiscardLogger()
+			handler := processor.New(logger, mockProcessor, processor.Options{EXIFThumbnail: tt.exif})
+
+			convertParams := map[string]interface{}{"format": "jpg"}
+			if tt.quality > 0 {
+				convertParams["quality"] = tt.quality
+			}
+
+			reqBody := processor.Request{
+				Actions:   []processor.ImageAction{{Action: "convert", Params: convertParams}},
+				ImageName: "test-image.png",
+				Profile:   tt.profile,
+			}
+
+			body, err := json.Marshal(reqBody)
+			assert.NoError(t, err)
+
+			mockProcessor.On("FindImage", "test-image.png").Return("/path/to/test-image.png", nil)
+			mockProcessor.On("Committed", mock.Anything).Return(true)
+			mockProcessor.On("LoadImage", "test-image.png").Return(image.NewRGBA(image.Rect(0, 0, 10, 10)), nil)
+			mockProcessor.On("ImageETag", "test-image.png").Return("\"etag\"", nil)
+			mockProcessor.On("GenerateName", "proc", "jpg").Return("new-image.jpg", nil)
+			mockProcessor.On("SaveImage", mock.Anything, "new-image.jpg", mock.MatchedBy(func(opts encoding.Options) bool {
+				return opts.Quality == tt.wantQuality && opts.EXIFThumbnail == tt.exif
+			})).Return("/images/new-image.jpg", nil)
+			mockProcessor.On("FileSize", mock.Anything).Return(int64(1234), nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/process", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			mockProcessor.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandler_ProcessImage_DeduplicatesConcurrentRequests(t *testing.T) {
+	const n = 10
+
+	mockProcessor := new(mocks.ImageProcessor)
+	logger := slogdiscard.NewDiscardLogger()
+	handler := processor.New(logger, mockProcessor, processor.Options{})
+
+	body, err := json.Marshal(processor.Request{
+		Actions: []processor.ImageAction{
+			{Action: "resize", Params: map[string]interface{}{"width": 50, "height": 50}},
+		},
+		ImageName: "test-image.png",
+	})
+	assert.NoError(t, err)
+
+	mockProcessor.On("FindImage", "test-image.png").Return("/path/to/test-image.png", nil)
+	mockProcessor.On("Committed", mock.Anything).Return(true)
+	mockProcessor.On("ImageETag", "test-image.png").Return("\"etag\"", nil)
+	// Slow enough that every request joins the first one.
+	mockProcessor.On("LoadImage", "test-image.png").
+		After(200*time.Millisecond).
+		Return(image.NewRGBA(image.Rect(0, 0, 100, 100)), nil)
+	mockProcessor.On("GenerateName", "proc", ".png").Return("new-image.png", nil)
+	mockProcessor.On("SaveImage", mock.Anything, "new-image.png", mock.Anything).Return("/path/to/new-image.png", nil)
+	mockProcessor.On("FileSize", mock.Anything).Return(int64(1234), nil)
+
+	urls := make([]string, n)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			req := httptest.NewRequest(http.MethodPost, "/process", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			var response processor.Response
+			if assert.Equal(t, http.StatusOK, w.Code) && assert.NoError(t, render.DecodeJSON(w.Body, &response)) {
+				urls[i] = response.ImageUrl
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, url := range urls {
+		assert.Equal(t, "/path/to/new-image.png", url)
+	}
+	mockProcessor.AssertNumberOfCalls(t, "LoadImage", 1)
+	mockProcessor.AssertNumberOfCalls(t, "SaveImage", 1)
+}
+
+func TestHandler_ProcessImage_CostLimit(t *testing.T) {
+	blur := func(sigma float64, n int) []processor.ImageAction {
+		actions := make([]processor.ImageAction, n)
+		for i := range actions {
+			actions[i] = processor.ImageAction{Action: "blur", Params: map[string]interface{}{"sigma": sigma}}
+		}
+		return actions
+	}
+
+	tests := []struct {
+		name       string
+		actions    []processor.ImageAction
+		wantStatus int
+	}{
+		{name: "huge blur chain", actions: blur(100, 5), wantStatus: http.StatusUnprocessableEntity},
+		{name: "modest blur", actions: blur(2, 1), wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockProcessor := new(mocks.ImageProcessor)
+			logger := slogdiscard.NewDiscardLogger()
+			handler := processor.New(logger, mockProcessor, processor.Options{MaxCost: 250})
+
+			body, err := json.Marshal(processor.Request{Actions: tt.actions, ImageName: "test-image.png"})
+			assert.NoError(t, err)
+
+			mockProcessor.On("FindImage", "test-image.png").Return("/path/to/test-image.png", nil)
+			mockProcessor.On("Committed", mock.Anything).Return(true)
+			mockProcessor.On("ImageETag", "test-image.png").Return("\"etag\"", nil)
+			mockProcessor.On("LoadImage", "test-image.png").Return(image.NewGray(image.Rect(0, 0, 1000, 1000)), nil)
+			mockProcessor.On("GenerateName", "proc", ".png").Return("new-image.png", nil)
+			mockProcessor.On("SaveImage", mock.Anything, "new-image.png", mock.Anything).Return("/path/to/new-image.png", nil)
+			mockProcessor.On("FileSize", mock.Anything).Return(int64(1234), nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/process", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus != http.StatusOK {
+				assert.Contains(t, w.Body.String(), "too expensive")
+				mockProcessor.AssertNotCalled(t, "SaveImage", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
 }
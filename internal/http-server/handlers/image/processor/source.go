@@ -0,0 +1,52 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"online-photo-editor/internal/http-server/middleware/auth"
+	"online-photo-editor/internal/lib/remote"
+	"online-photo-editor/internal/storage"
+)
+
+// fetchSource fetches the image of a request with a source_url and points
+// req at the stored copy, kept in the namespace of the caller's key.
+// Failures are *actionError.
+func (opts Options) fetchSource(ctx context.Context, log *slog.Logger, req *Request) error {
+	if req.SourceURL == "" {
+		return nil
+	}
+
+	if opts.Remote == nil {
+		return &actionError{status: http.StatusBadRequest, msg: "source_url is not enabled"}
+	}
+
+	imgName, err := opts.Remote.Fetch(ctx, auth.Namespace(ctx), req.SourceURL)
+	if err != nil {
+		var statusErr *remote.StatusError
+		switch {
+		case errors.Is(err, remote.ErrInvalidURL):
+			return &actionError{status: http.StatusBadRequest, msg: remote.ErrInvalidURL.Error()}
+		case errors.Is(err, remote.ErrHostDenied):
+			return &actionError{status: http.StatusForbidden, msg: remote.ErrHostDenied.Error(), err: err}
+		case errors.Is(err, remote.ErrAddressDenied):
+			return &actionError{status: http.StatusForbidden, msg: remote.ErrAddressDenied.Error(), err: err}
+		case errors.Is(err, remote.ErrTooLarge):
+			return &actionError{status: http.StatusRequestEntityTooLarge, msg: remote.ErrTooLarge.Error(), err: err}
+		case errors.Is(err, storage.ErrQuotaExceeded):
+			return &actionError{status: http.StatusInsufficientStorage, msg: storage.QuotaError(err).Error(), err: err}
+		case errors.As(err, &statusErr):
+			return &actionError{status: http.StatusBadGateway, msg: "failed to fetch source image: " + statusErr.Error(), err: err}
+		case errors.Is(err, remote.ErrUnsupported):
+			return &actionError{status: http.StatusUnsupportedMediaType, msg: remote.ErrUnsupported.Error(), err: err}
+		default:
+			return &actionError{status: http.StatusBadGateway, msg: "failed to fetch source image", err: err}
+		}
+	}
+
+	log.Info("source image fetched", slog.String("url", req.SourceURL), slog.String("image", imgName))
+	req.ImageName = imgName
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package processor
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"online-photo-editor/internal/lib/api/resize"
+	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/logger/sl"
+	"online-photo-editor/internal/storage"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/render"
+)
+
+type ValidateResponse struct {
+	response.Response
+	// Width and Height are the dimensions the pipeline will produce, when
+	// they can be known without running it.
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// DPI is the resolution recorded in the source image, and PrintWidth
+	// and PrintHeight its physical size in inches at that resolution.
+	DPI         int     `json:"dpi,omitempty"`
+	PrintWidth  float64 `json:"print_width,omitempty"`
+	PrintHeight float64 `json:"print_height,omitempty"`
+}
+
+// NewValidate checks a processing request the same way New does,
+// including bounds against the real image dimensions, but only reads the
+// image header and never runs an action.
+func NewValidate(log *slog.Logger, imgProcessor ImageProcessor, opts Options) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.img.processor.NewValidate"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		req, ok := opts.decodeRequest(log, w, r)
+		if !ok {
+			return
+		}
+
+		if err := checkOutputFormat(req); err != nil {
+			renderError(log, w, r, err)
+			return
+		}
+
+		steps, err := parseActions(req.Actions, imgProcessor, opts.settings(req), req.ContinueOnError)
+		if err != nil {
+			renderError(log, w, r, err)
+			return
+		}
+
+		if err := checkAlpha(req, steps); err != nil {
+			renderError(log, w, r, err)
+			return
+		}
+		opts.setUpscaleDefaults(steps)
+
+		if err := opts.fetchSource(r.Context(), log, &req); err != nil {
+			renderError(log, w, r, err)
+			return
+		}
+
+		width, height, err := imgProcessor.ImageSize(req.ImageName)
+		if errors.Is(err, storage.ErrCorruptImage) {
+			log.Error("failed to decode image header", sl.Err(err))
+			render.Status(r, http.StatusUnprocessableEntity)
+			render.JSON(w, r, response.Error(storage.ErrCorruptImage.Error()))
+			return
+		}
+		if err != nil {
+			log.Error("failed to read image size", sl.Err(err))
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, response.Error("failed to find image"))
+			return
+		}
+
+		dpi, err := imgProcessor.ImageDPI(req.ImageName)
+		if err != nil {
+			log.Error("failed to read image dpi", sl.Err(err))
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, response.Error("failed to find image"))
+			return
+		}
+		setSourceDPI(steps, dpi)
+
+		resp := ValidateResponse{Response: response.OK(), DPI: dpi}
+		if dpi > 0 {
+			resp.PrintWidth = math.Round(float64(width)/float64(dpi)*100) / 100
+			resp.PrintHeight = math.Round(float64(height)/float64(dpi)*100) / 100
+		}
+
+		// The actions run on the source as downscaled to max_megapixels.
+		if req.MaxMegapixels > 0 {
+			width, height, _ = resize.DownscaledSize(width, height, req.MaxMegapixels)
+		}
+
+		if err := opts.checkCost(steps, width, height); err != nil {
+			renderError(log, w, r, err)
+			return
+		}
+
+		width, height, known, err := checkSteps(steps, width, height)
+		if err != nil {
+			renderError(log, w, r, err)
+			return
+		}
+
+		if known {
+			resp.Width, resp.Height = width, height
+		}
+
+		render.Status(r, http.StatusOK)
+		render.JSON(w, r, resp)
+	}
+}
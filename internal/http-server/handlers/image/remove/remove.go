@@ -0,0 +1,63 @@
+package remove
+
+import (
+	"log/slog"
+	"net/http"
+	"online-photo-editor/internal/http-server/handlers/image/processor"
+	"online-photo-editor/internal/http-server/handlers/image/serve"
+	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/logger/sl"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+// New deletes the image named in the URL, once the deletion is recorded
+// in auditSink.
+func New(log *slog.Logger, imgDeleter processor.ImageProcessor, auditSink audit.Sink) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.img.remove.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		imgName, err := serve.ImageName(r)
+		if err != nil {
+			log.Error("invalid image name", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error(err.Error()))
+			return
+		}
+
+		if _, err := imgDeleter.FindImage(imgName); err != nil {
+			log.Error("failed to find image", sl.Err(err))
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, response.Error("image not found"))
+			return
+		}
+
+		// The deletion is recorded before it happens, so that none goes
+		// unrecorded: one the audit log can't take doesn't happen.
+		if err := auditSink.Record(audit.FromRequest(r, audit.ActionDelete, imgName)); err != nil {
+			log.Error("failed to record audit entry", sl.Err(err))
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, response.Error("failed to record audit entry"))
+			return
+		}
+
+		if err := imgDeleter.DeleteImage(imgName); err != nil {
+			log.Error("failed to delete image", sl.Err(err))
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, response.Error("image not found"))
+			return
+		}
+
+		log.Info("image deleted", slog.String("image", imgName))
+
+		render.Status(r, http.StatusOK)
+		render.JSON(w, r, response.OK())
+	}
+}
@@ -0,0 +1,113 @@
+package remove_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"online-photo-editor/internal/http-server/handlers/image/processor/mocks"
+	"online-photo-editor/internal/http-server/handlers/image/remove"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/logger/handlers/slogdiscard"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingSink struct {
+	entries []audit.Entry
+	err     error
+}
+
+func (s *recordingSink) Record(entry audit.Entry) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func newRouter(mockDeleter *mocks.ImageProcessor, sink audit.Sink) *chi.Mux {
+	router := chi.NewRouter()
+	router.Use(middleware.RequestID, middleware.URLFormat)
+	router.Delete("/images/{name}", remove.New(slogdiscard.NewDiscardLogger(), mockDeleter, sink))
+
+	return router
+}
+
+func TestHandler_DeleteImage_Audited(t *testing.T) {
+	mockDeleter := new(mocks.ImageProcessor)
+	mockDeleter.On("FindImage", "img.png").Return("storage/img.png", nil)
+	mockDeleter.On("DeleteImage", "img.png").Return(nil)
+
+	sink := &recordingSink{}
+	router := newRouter(mockDeleter, sink)
+
+	req := httptest.NewRequest(http.MethodDelete, "/images/img.png", nil)
+	req.Header.Set(audit.APIKeyHeader, "secret-key")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockDeleter.AssertExpectations(t)
+
+	require.Len(t, sink.entries, 1)
+	entry := sink.entries[0]
+	assert.Equal(t, audit.ActionDelete, entry.Action)
+	assert.Equal(t, "img.png", entry.Image)
+	assert.Regexp(t, `^key:[0-9a-f]{16}$`, entry.Actor)
+	assert.NotContains(t, entry.Actor, "secret-key")
+	assert.NotEmpty(t, entry.RequestID)
+	assert.NotEmpty(t, entry.RemoteAddr)
+	assert.WithinDuration(t, time.Now(), entry.Time, time.Minute)
+}
+
+func TestHandler_DeleteImage_NotFoundIsNotAudited(t *testing.T) {
+	mockDeleter := new(mocks.ImageProcessor)
+	mockDeleter.On("FindImage", "missing.png").Return("", errors.New("not found"))
+
+	sink := &recordingSink{}
+	router := newRouter(mockDeleter, sink)
+
+	req := httptest.NewRequest(http.MethodDelete, "/images/missing.png", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Empty(t, sink.entries)
+	mockDeleter.AssertNotCalled(t, "DeleteImage", mock.Anything)
+}
+
+func TestHandler_DeleteImage_AuditFailureKeepsImage(t *testing.T) {
+	mockDeleter := new(mocks.ImageProcessor)
+	mockDeleter.On("FindImage", "img.png").Return("storage/img.png", nil)
+
+	sink := &recordingSink{err: errors.New("disk full")}
+	router := newRouter(mockDeleter, sink)
+
+	req := httptest.NewRequest(http.MethodDelete, "/images/img.png", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	mockDeleter.AssertNotCalled(t, "DeleteImage", mock.Anything)
+}
+
+func TestHandler_DeleteImage_PathTraversal(t *testing.T) {
+	mockDeleter := new(mocks.ImageProcessor)
+
+	sink := &recordingSink{}
+	router := newRouter(mockDeleter, sink)
+
+	req := httptest.NewRequest(http.MethodDelete, "/images/..%2F..%2Fetc%2Fpasswd", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockDeleter.AssertNotCalled(t, "DeleteImage", mock.Anything)
+	assert.Empty(t, sink.entries)
+}
@@ -8,7 +8,10 @@ import (
 	"online-photo-editor/internal/http-server/handlers/image/processor"
 	"online-photo-editor/internal/lib/api/resize"
 	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/encoding"
 	"online-photo-editor/internal/lib/logger/sl"
+	"online-photo-editor/internal/storage"
 
 	"path/filepath"
 
@@ -18,7 +21,7 @@ import (
 
 type Request struct {
 	resize.ResizeParams
-	ImageName string `json:"image_name" validate:"required,max=100"`
+	ImageName string `json:"image_name" validate:"required,max=100,image_name"`
 }
 
 type Response struct {
@@ -63,8 +66,7 @@ func New(log *slog.Logger, imgResize processor.ImageProcessor) http.HandlerFunc
 		inputImg, err := imgResize.LoadImage(req.ImageName)
 		if err != nil {
 			log.Error("failed to load image", sl.Err(err))
-			render.Status(r, http.StatusNotFound)
-			render.JSON(w, r, response.Error("failed to load image"))
+			response.LoadError(w, r, err)
 			return
 		}
 
@@ -72,6 +74,17 @@ func New(log *slog.Logger, imgResize processor.ImageProcessor) http.HandlerFunc
 			return
 		}
 
+		if req.ResizeParams.NeedsSourceDPI() {
+			dpi, err := imgResize.ImageDPI(req.ImageName)
+			if err != nil {
+				log.Error("failed to read image dpi", sl.Err(err))
+				render.Status(r, http.StatusNotFound)
+				render.JSON(w, r, response.Error("failed to load image"))
+				return
+			}
+			req.ResizeParams.SetSourceDPI(dpi)
+		}
+
 		inputImg, err = req.ResizeParams.ResizeImage(inputImg)
 		if err != nil {
 			log.Error("failed to crop image", sl.Err(err))
@@ -80,7 +93,7 @@ func New(log *slog.Logger, imgResize processor.ImageProcessor) http.HandlerFunc
 			return
 		}
 
-		imgName, err := imgResize.GenerateName("proc", filepath.Ext(req.ImageName))
+		imgName, err := imgResize.GenerateName(storage.InNamespaceOf(req.ImageName, "proc"), filepath.Ext(req.ImageName))
 		if err != nil {
 			log.Error("failed to generate name", sl.Err(err))
 			render.Status(r, http.StatusInternalServerError)
@@ -88,11 +101,10 @@ func New(log *slog.Logger, imgResize processor.ImageProcessor) http.HandlerFunc
 			return
 		}
 
-		imgUrl, err := imgResize.SaveImage(inputImg, imgName)
+		imgUrl, err := imgResize.SaveImage(inputImg, imgName, encoding.Options{DPI: req.ResizeParams.OutputDPI(), Origin: audit.OriginOf(r)})
 		if err != nil {
 			log.Error("failed to save image", sl.Err(err))
-			render.Status(r, http.StatusUnsupportedMediaType)
-			render.JSON(w, r, response.Error("failed to save image"))
+			response.SaveError(w, r, err, "failed to save image")
 			return
 		}
 
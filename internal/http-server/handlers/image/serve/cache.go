@@ -0,0 +1,96 @@
+package serve
+
+import (
+	"container/list"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VariantCache keeps rendered variants in memory, up to a total size,
+// dropping the least recently served ones past it. Variants are never
+// written to the storage, so they don't count against its quota and
+// can't take the place of the images there.
+//
+// VariantCache is safe for concurrent use.
+type VariantCache struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// order holds the entries, the most recently served in front.
+	order *list.List
+	bytes int64
+}
+
+// cachedVariant is a rendered variant, stored under its variant name.
+type cachedVariant struct {
+	name string
+	data []byte
+	// modTime is that of the source when it was rendered.
+	modTime time.Time
+}
+
+// etag returns the ETag of v: its name is a hash of its source and
+// params, so it changes with its content.
+func (v cachedVariant) etag() string {
+	return `"` + strings.TrimSuffix(v.name, filepath.Ext(v.name)) + `"`
+}
+
+// NewVariantCache returns a cache holding at most maxBytes of variants.
+// With maxBytes zero or less nothing is kept, and every variant is
+// rendered for each request asking for it, as with a nil cache.
+func NewVariantCache(maxBytes int64) *VariantCache {
+	return &VariantCache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// get returns the variant stored as name, marking it used.
+func (c *VariantCache) get(name string) (cachedVariant, bool) {
+	if c == nil {
+		return cachedVariant{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[name]
+	if !ok {
+		return cachedVariant{}, false
+	}
+
+	c.order.MoveToFront(elem)
+	return elem.Value.(cachedVariant), true
+}
+
+// put stores v, then drops the least recently used variants while they
+// take more than the cache may hold. A variant bigger than that on its
+// own isn't kept.
+func (c *VariantCache) put(v cachedVariant) {
+	size := int64(len(v.data))
+	if c == nil || size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[v.name]; ok {
+		c.bytes -= int64(len(elem.Value.(cachedVariant).data))
+		c.order.Remove(elem)
+	}
+
+	c.entries[v.name] = c.order.PushFront(v)
+	c.bytes += size
+
+	for c.bytes > c.maxBytes {
+		oldest := c.order.Back()
+		evicted := c.order.Remove(oldest).(cachedVariant)
+		delete(c.entries, evicted.name)
+		c.bytes -= int64(len(evicted.data))
+	}
+}
@@ -0,0 +1,51 @@
+package serve
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// negotiable are the source formats served as WebP to clients that
+// accept it when format negotiation is on.
+var negotiable = map[string]bool{".jpg": true, ".jpeg": true, ".png": true}
+
+// acceptsWebP reports whether an Accept header names image/webp with a
+// non-zero q. Wildcards don't count: browsers send */* whether they can
+// decode WebP or not.
+func acceptsWebP(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(mediaType), "image/webp") {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				parsed, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					return false
+				}
+				q = parsed
+			}
+		}
+		return q > 0
+	}
+
+	return false
+}
+
+// negotiateFormat picks the format of v for a request with the given
+// Accept header. negotiated is true when the response depends on the
+// header, and must then carry Vary: Accept.
+func negotiateFormat(imgName string, v variant, accept string) (out variant, negotiated bool) {
+	if v.Format != "" || !negotiable[strings.ToLower(filepath.Ext(imgName))] {
+		return v, false
+	}
+
+	if acceptsWebP(accept) {
+		v.Format = ".webp"
+	}
+	return v, true
+}
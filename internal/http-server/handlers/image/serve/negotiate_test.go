@@ -0,0 +1,85 @@
+package serve_test
+
+import (
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"online-photo-editor/internal/http-server/handlers/image/serve"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/logger/handlers/slogdiscard"
+	"online-photo-editor/internal/storage/memory"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_ServeImage_NegotiateFormat(t *testing.T) {
+	mem := memory.New()
+	for _, name := range []string{"photo.jpg", "anim.gif"} {
+		_, err := mem.SaveImage(imaging.New(40, 20, color.White), name, encoding.Options{})
+		require.NoError(t, err)
+	}
+
+	router := chi.NewRouter()
+	router.Use(middleware.URLFormat)
+	router.Get("/images/{name}", serve.New(slogdiscard.NewDiscardLogger(), mem, serve.Options{
+		NegotiateFormat: true,
+		Sizes:           []serve.Size{{Width: 20}, {Format: "png"}},
+	}))
+
+	tests := []struct {
+		name        string
+		url         string
+		accept      string
+		contentType string
+		vary        bool
+	}{
+		{name: "webp accepted", url: "/images/photo.jpg", accept: "image/avif,image/webp,*/*;q=0.8", contentType: "image/webp", vary: true},
+		{name: "webp not accepted", url: "/images/photo.jpg", accept: "image/*,*/*;q=0.8", contentType: "image/jpeg", vary: true},
+		{name: "webp refused", url: "/images/photo.jpg", accept: "image/webp;q=0", contentType: "image/jpeg", vary: true},
+		{name: "negotiated variant", url: "/images/photo.jpg?w=20", accept: "image/webp", contentType: "image/webp", vary: true},
+		// An explicit format is the same whatever the client accepts.
+		{name: "explicit format", url: "/images/photo.jpg?format=png", accept: "image/webp", contentType: "image/png"},
+		{name: "not negotiable", url: "/images/anim.gif", accept: "image/webp", contentType: "image/gif"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			req.Header.Set("Accept", tc.accept)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			assert.Equal(t, tc.contentType, w.Header().Get("Content-Type"))
+			if tc.vary {
+				assert.Equal(t, "Accept", w.Header().Get("Vary"))
+			} else {
+				assert.Empty(t, w.Header().Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestHandler_ServeImage_NegotiateFormatOff(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(imaging.New(40, 20, color.White), "photo.jpg", encoding.Options{})
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Use(middleware.URLFormat)
+	router.Get("/images/{name}", serve.New(slogdiscard.NewDiscardLogger(), mem, serve.Options{}))
+
+	req := httptest.NewRequest(http.MethodGet, "/images/photo.jpg", nil)
+	req.Header.Set("Accept", "image/webp")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+	assert.Empty(t, w.Header().Get("Vary"))
+}
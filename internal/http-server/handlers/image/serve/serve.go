@@ -0,0 +1,222 @@
+package serve
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"online-photo-editor/internal/http-server/handlers/image/processor"
+	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/imgformat"
+	"online-photo-editor/internal/lib/logger/sl"
+	"online-photo-editor/internal/lib/signedurl"
+	"online-photo-editor/internal/storage"
+	"slices"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+type Options struct {
+	// CacheMaxAge is the Cache-Control max-age of served images.
+	CacheMaxAge time.Duration
+	// Signer, when set, makes every download require a valid signature
+	// for the image name, see signedurl. Variants need the signature of
+	// their source.
+	Signer *signedurl.Signer
+	// NegotiateFormat serves JPEG and PNG images, and their variants, as
+	// WebP to clients whose Accept header asks for it, unless a format is
+	// given in the URL.
+	NegotiateFormat bool
+	// Sizes are the variants downloads may ask for; the query params of
+	// any other get 400 Bad Request.
+	Sizes []Size
+	// Cache keeps the rendered variants; nil renders them every time.
+	Cache *VariantCache
+}
+
+// cacheControl returns the Cache-Control header of served images. None is
+// immutable: stored names may be replaced, and variant names only live
+// in the cache, so they are gone once evicted or after a restart.
+func (opts Options) cacheControl() string {
+	return fmt.Sprintf("public, max-age=%d", int(opts.CacheMaxAge.Seconds()))
+}
+
+// New serves a stored image. With any of the w, h, format or q query
+// params it serves a resized or converted variant instead, one of
+// opts.Sizes, rendered on first request and kept in opts.Cache for the
+// next ones. With format negotiation on, the WebP variant is the one
+// served to clients that ask.
+func New(log *slog.Logger, imgServer processor.ImageProcessor, opts Options) http.HandlerFunc {
+	sizes := sizeVariants(log, opts.Sizes)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.img.serve.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		imgName, err := ImageName(r)
+		if err != nil {
+			log.Error("invalid image name", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error(err.Error()))
+			return
+		}
+
+		if opts.Signer != nil {
+			if err := opts.Signer.Verify(imgName, r.URL.Query()); err != nil {
+				log.Error("invalid url signature", sl.Err(err))
+				render.Status(r, http.StatusForbidden)
+				render.JSON(w, r, response.Error(signatureError(err)))
+				return
+			}
+		}
+
+		v, isVariant, err := parseVariant(r.URL.Query())
+		if err != nil {
+			log.Error("invalid variant params", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error(err.Error()))
+			return
+		}
+
+		if isVariant && !slices.Contains(sizes, v) {
+			log.Error("variant not configured", slog.Any("variant", v))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error("w, h, format and q must be one of the configured variant sizes"))
+			return
+		}
+
+		if isVariant {
+			v, isVariant = v.fallback(imgName)
+		}
+
+		if opts.NegotiateFormat {
+			var negotiated bool
+			if v, negotiated = negotiateFormat(imgName, v, r.Header.Get("Accept")); negotiated {
+				// The same URL serves different formats, so caches must
+				// key on Accept.
+				w.Header().Add("Vary", "Accept")
+				isVariant = v != variant{}
+			}
+		}
+
+		if isVariant {
+			if _, err := imgServer.FindImage(imgName); err != nil {
+				log.Error("failed to find image", sl.Err(err))
+				render.Status(r, http.StatusNotFound)
+				render.JSON(w, r, response.Error("image not found"))
+				return
+			}
+
+			rendered, err := ensureVariant(imgServer, opts.Cache, &renders, imgName, v)
+			if storage.ContentError(err) != nil {
+				log.Error("failed to decode image", sl.Err(err))
+				response.LoadError(w, r, err)
+				return
+			}
+			if err != nil {
+				log.Error("failed to render variant", sl.Err(err))
+				render.Status(r, http.StatusInternalServerError)
+				render.JSON(w, r, response.Error("failed to render variant"))
+				return
+			}
+
+			serveContent(w, r, rendered.name, bytes.NewReader(rendered.data), rendered.etag(), rendered.modTime, opts.cacheControl())
+			return
+		}
+
+		// The names of the variants a warm-up returned are served while
+		// they are cached.
+		if cached, ok := opts.Cache.get(imgName); ok {
+			serveContent(w, r, imgName, bytes.NewReader(cached.data), cached.etag(), cached.modTime, opts.cacheControl())
+			return
+		}
+
+		file, err := imgServer.OpenImage(imgName)
+		if err != nil {
+			log.Error("failed to open image", sl.Err(err))
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, response.Error("image not found"))
+			return
+		}
+		defer file.Close()
+
+		etag, err := imgServer.ImageETag(imgName)
+		if err != nil {
+			log.Error("failed to compute etag", sl.Err(err))
+		}
+
+		modTime, err := imgServer.ImageModTime(imgName)
+		if err != nil {
+			log.Error("failed to get modification time", sl.Err(err))
+		}
+
+		serveContent(w, r, imgName, file, etag, modTime, opts.cacheControl())
+	}
+}
+
+// serveContent writes content, the image served as name, with its
+// headers. ServeContent sets Last-Modified and answers If-None-Match, or
+// without it If-Modified-Since, with 304.
+func serveContent(w http.ResponseWriter, r *http.Request, name string, content io.ReadSeeker, etag string, modTime time.Time, cacheControl string) {
+	// The Content-Type is that of the bytes served: a name can claim
+	// another format, e.g. after a fallback or an upload with the wrong
+	// extension.
+	if contentType := sniffContentType(content); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+
+	http.ServeContent(w, r, name, modTime, content)
+}
+
+// sniffContentType returns the media type of the image in file, "" if
+// its format isn't known, and rewinds file.
+func sniffContentType(file io.ReadSeeker) string {
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return ""
+	}
+
+	return imgformat.ContentType(imgformat.Detect(head[:n]))
+}
+
+func signatureError(err error) string {
+	switch {
+	case errors.Is(err, signedurl.ErrMissing):
+		return signedurl.ErrMissing.Error()
+	case errors.Is(err, signedurl.ErrExpired):
+		return signedurl.ErrExpired.Error()
+	default:
+		return signedurl.ErrInvalid.Error()
+	}
+}
+
+// ImageName returns the {name} URL parameter. The URLFormat middleware
+// strips the extension off the route path, so it is put back here. Names
+// that aren't plain file names fail with storage.ErrInvalidName.
+func ImageName(r *http.Request) (string, error) {
+	name := chi.URLParam(r, "name")
+
+	if format, _ := r.Context().Value(middleware.URLFormatCtxKey).(string); format != "" {
+		name += "." + format
+	}
+
+	if err := storage.ValidateName(name); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
@@ -0,0 +1,365 @@
+package serve_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"online-photo-editor/internal/http-server/handlers/image/processor/mocks"
+	"online-photo-editor/internal/http-server/handlers/image/serve"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/logger/handlers/slogdiscard"
+	"online-photo-editor/internal/lib/signedurl"
+	"online-photo-editor/internal/storage/filesystem"
+	"online-photo-editor/internal/storage/memory"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/gen2brain/webp"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type readSeekNopCloser struct {
+	io.ReadSeeker
+}
+
+func (readSeekNopCloser) Close() error { return nil }
+
+func newRouter(mockServer *mocks.ImageProcessor) *chi.Mux {
+	router := chi.NewRouter()
+	router.Use(middleware.URLFormat)
+	router.Get("/images/{name}", serve.New(slogdiscard.NewDiscardLogger(), mockServer, serve.Options{CacheMaxAge: time.Hour}))
+
+	return router
+}
+
+func TestHandler_ServeImage_ETag(t *testing.T) {
+	content := []byte("\x89PNG\r\n\x1a\nfake image bytes")
+
+	mockServer := new(mocks.ImageProcessor)
+	mockServer.On("OpenImage", "img.png").Return(func(string) (io.ReadSeekCloser, error) {
+		return readSeekNopCloser{bytes.NewReader(content)}, nil
+	})
+	mockServer.On("ImageETag", "img.png").Return(`"abc123"`, nil)
+	mockServer.On("ImageModTime", "img.png").Return(time.Time{}, nil)
+
+	router := newRouter(mockServer)
+
+	req := httptest.NewRequest(http.MethodGet, "/images/img.png", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"abc123"`, w.Header().Get("ETag"))
+	assert.Equal(t, "public, max-age=3600", w.Header().Get("Cache-Control"))
+	assert.Equal(t, content, w.Body.Bytes())
+
+	req = httptest.NewRequest(http.MethodGet, "/images/img.png", nil)
+	req.Header.Set("If-None-Match", `"abc123"`)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.Bytes())
+}
+
+func TestHandler_ServeImage_LastModified(t *testing.T) {
+	content := []byte("\x89PNG\r\n\x1a\nfake image bytes")
+	modTime := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+
+	mockServer := new(mocks.ImageProcessor)
+	mockServer.On("OpenImage", "img.png").Return(func(string) (io.ReadSeekCloser, error) {
+		return readSeekNopCloser{bytes.NewReader(content)}, nil
+	})
+	mockServer.On("ImageETag", "img.png").Return(`"abc123"`, nil)
+	mockServer.On("ImageModTime", "img.png").Return(modTime, nil)
+
+	router := newRouter(mockServer)
+
+	req := httptest.NewRequest(http.MethodGet, "/images/img.png", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Fri, 01 Mar 2024 12:30:00 GMT", w.Header().Get("Last-Modified"))
+	assert.Equal(t, content, w.Body.Bytes())
+
+	cases := []struct {
+		since string
+		want  int
+	}{
+		{since: "Fri, 01 Mar 2024 12:30:00 GMT", want: http.StatusNotModified},
+		{since: "Sat, 02 Mar 2024 00:00:00 GMT", want: http.StatusNotModified},
+		{since: "Fri, 01 Mar 2024 12:29:59 GMT", want: http.StatusOK},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/images/img.png", nil)
+		req.Header.Set("If-Modified-Since", tc.since)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tc.want, w.Code, tc.since)
+	}
+
+	// If-None-Match wins over If-Modified-Since.
+	req = httptest.NewRequest(http.MethodGet, "/images/img.png", nil)
+	req.Header.Set("If-None-Match", `"other"`)
+	req.Header.Set("If-Modified-Since", "Sat, 02 Mar 2024 00:00:00 GMT")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestHandler_ServeImage_SignedURLs(t *testing.T) {
+	content := []byte("\x89PNG\r\n\x1a\nfake image bytes")
+
+	signer, err := signedurl.New([]byte("0123456789abcdef"), time.Hour)
+	require.NoError(t, err)
+
+	mockServer := new(mocks.ImageProcessor)
+	mockServer.On("OpenImage", "img.png").Return(func(string) (io.ReadSeekCloser, error) {
+		return readSeekNopCloser{bytes.NewReader(content)}, nil
+	})
+	mockServer.On("ImageETag", "img.png").Return(`"abc123"`, nil)
+	mockServer.On("ImageModTime", "img.png").Return(time.Time{}, nil)
+
+	router := chi.NewRouter()
+	router.Use(middleware.URLFormat)
+	router.Get("/images/{name}", serve.New(slogdiscard.NewDiscardLogger(), mockServer, serve.Options{Signer: signer}))
+
+	valid := signer.Sign("img.png", time.Now().Add(time.Minute))
+	expired := signer.Sign("img.png", time.Now().Add(-time.Minute))
+	tampered := strings.Replace(valid, "sig=", "sig=x", 1)
+
+	cases := []struct {
+		name    string
+		target  string
+		want    int
+		wantErr string
+	}{
+		{name: "valid", target: "/images/img.png?" + valid, want: http.StatusOK},
+		{name: "expired", target: "/images/img.png?" + expired, want: http.StatusForbidden, wantErr: "signature expired"},
+		{name: "tampered", target: "/images/img.png?" + tampered, want: http.StatusForbidden, wantErr: "invalid signature"},
+		{name: "other image", target: "/images/other.png?" + valid, want: http.StatusForbidden, wantErr: "invalid signature"},
+		{name: "unsigned", target: "/images/img.png", want: http.StatusForbidden, wantErr: "missing signature"},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tc.want, w.Code, tc.name)
+		if tc.wantErr != "" {
+			assert.Contains(t, w.Body.String(), tc.wantErr, tc.name)
+		} else {
+			assert.Equal(t, content, w.Body.Bytes(), tc.name)
+		}
+	}
+
+	mockServer.AssertNotCalled(t, "OpenImage", "other.png")
+}
+
+func TestHandler_ServeImage_NotFound(t *testing.T) {
+	mockServer := new(mocks.ImageProcessor)
+	mockServer.On("OpenImage", "missing.png").Return(nil, io.ErrUnexpectedEOF)
+
+	router := newRouter(mockServer)
+
+	req := httptest.NewRequest(http.MethodGet, "/images/missing.png", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandler_ServeImage_Variant(t *testing.T) {
+	mockServer := new(mocks.ImageProcessor)
+	mockServer.On("FindImage", "img.png").Return("/images/img.png", nil)
+	mockServer.On("ImageETag", "img.png").Return(`"abc123"`, nil)
+	mockServer.On("ImageModTime", "img.png").Return(time.Time{}, nil)
+	mockServer.On("LoadImage", "img.png").Return(image.NewNRGBA(image.Rect(0, 0, 600, 400)), nil)
+
+	router := chi.NewRouter()
+	router.Use(middleware.URLFormat)
+	router.Get("/images/{name}", serve.New(slogdiscard.NewDiscardLogger(), mockServer, serve.Options{
+		CacheMaxAge: time.Hour,
+		Sizes:       []serve.Size{{Width: 300, Format: "webp"}},
+		Cache:       serve.NewVariantCache(1 << 20),
+	}))
+
+	var etag string
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/images/img.png?w=300&format=webp", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "image/webp", w.Header().Get("Content-Type"))
+		img, err := webp.Decode(bytes.NewReader(w.Body.Bytes()))
+		require.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, 300, 200), img.Bounds())
+		// The source behind the URL may change.
+		assert.Equal(t, "public, max-age=3600", w.Header().Get("Cache-Control"))
+
+		assert.NotEmpty(t, w.Header().Get("ETag"))
+		if etag != "" {
+			assert.Equal(t, etag, w.Header().Get("ETag"))
+		}
+		etag = w.Header().Get("ETag")
+	}
+
+	// The second request is served from the cache, and the variant never
+	// goes to the storage.
+	mockServer.AssertNumberOfCalls(t, "LoadImage", 1)
+	mockServer.AssertNotCalled(t, "SaveImage", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestHandler_ServeImage_VariantNotConfigured(t *testing.T) {
+	mockServer := new(mocks.ImageProcessor)
+	router := chi.NewRouter()
+	router.Use(middleware.URLFormat)
+	router.Get("/images/{name}", serve.New(slogdiscard.NewDiscardLogger(), mockServer, serve.Options{
+		Sizes: []serve.Size{{Width: 300}},
+	}))
+
+	// Only the configured sizes can be asked for, so the variants of an
+	// image are bounded.
+	for _, query := range []string{"w=301", "w=300&q=90", "w=300&format=png", "h=300"} {
+		req := httptest.NewRequest(http.MethodGet, "/images/img.png?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+	mockServer.AssertNotCalled(t, "LoadImage", mock.Anything)
+}
+
+func TestHandler_ServeImage_VariantCacheOutsideQuota(t *testing.T) {
+	dir := t.TempDir()
+	store, err := filesystem.New(dir, filesystem.Options{MaxImages: 1, EvictOldest: true})
+	require.NoError(t, err)
+	_, err = store.SaveImage(imaging.New(600, 400, color.White), "photo.png", encoding.Options{})
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Use(middleware.URLFormat)
+	router.Get("/images/{name}", serve.New(slogdiscard.NewDiscardLogger(), store, serve.Options{
+		Sizes: []serve.Size{{Width: 100}, {Width: 200}},
+		// Room for one of the variants only.
+		Cache: serve.NewVariantCache(2000),
+	}))
+
+	for _, query := range []string{"w=100", "w=200", "w=100"} {
+		req := httptest.NewRequest(http.MethodGet, "/images/photo.png?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, query)
+	}
+
+	// The variants took no room in the storage, which keeps the only
+	// image it has room for.
+	_, err = store.FindImage("photo.png")
+	assert.NoError(t, err)
+	variants, err := filepath.Glob(filepath.Join(dir, "variant_*"))
+	require.NoError(t, err)
+	assert.Empty(t, variants)
+}
+
+func TestHandler_ServeImage_NeverImmutable(t *testing.T) {
+	content := []byte("\x89PNG\r\n\x1a\nfake image bytes")
+
+	// Variant names only live in the variant cache, so even they may be
+	// gone tomorrow.
+	cases := []struct {
+		name string
+		want string
+	}{
+		{name: "variant_0123456789abcdef01234567.png", want: "public, max-age=3600"},
+		{name: "proc_20240101120000.png", want: "public, max-age=3600"},
+	}
+
+	for _, tc := range cases {
+		mockServer := new(mocks.ImageProcessor)
+		mockServer.On("OpenImage", tc.name).Return(func(string) (io.ReadSeekCloser, error) {
+			return readSeekNopCloser{bytes.NewReader(content)}, nil
+		})
+		mockServer.On("ImageETag", tc.name).Return(`"abc123"`, nil)
+		mockServer.On("ImageModTime", tc.name).Return(time.Time{}, nil)
+
+		req := httptest.NewRequest(http.MethodGet, "/images/"+tc.name, nil)
+		w := httptest.NewRecorder()
+		newRouter(mockServer).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code, tc.name)
+		assert.Equal(t, tc.want, w.Header().Get("Cache-Control"), tc.name)
+	}
+}
+
+func TestHandler_ServeImage_VariantRejectsBadParams(t *testing.T) {
+	router := newRouter(new(mocks.ImageProcessor))
+
+	for _, query := range []string{"w=0", "w=abc", "h=100000", "q=101", "format=gif"} {
+		req := httptest.NewRequest(http.MethodGet, "/images/img.png?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestHandler_ServeImage_ContentTypeFromBytes(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(imaging.New(600, 400, color.White), "photo.jpg", encoding.Options{})
+	require.NoError(t, err)
+
+	// An upload whose name claims another format than its bytes.
+	var jpg bytes.Buffer
+	require.NoError(t, jpeg.Encode(&jpg, imaging.New(10, 10, color.White), nil))
+	imgName, err := mem.UploadImage(&jpg, "mislabeled.png")
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Use(middleware.URLFormat)
+	router.Get("/images/{name}", serve.New(slogdiscard.NewDiscardLogger(), mem, serve.Options{
+		Sizes: []serve.Size{{Width: 300, Format: "avif"}, {Format: "avif"}},
+	}))
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		require.Equal(t, http.StatusOK, w.Code, url)
+		return w
+	}
+
+	w := get(mem.ImageURL(imgName))
+	assert.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+
+	// The storage can't write AVIF, so the variant falls back to the
+	// format of the source.
+	w = get("/images/photo.jpg?w=300&format=avif")
+	assert.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+	img, err := jpeg.Decode(bytes.NewReader(w.Body.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 300, 200), img.Bounds())
+
+	w = get("/images/photo.jpg?format=avif")
+	assert.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+	original, err := mem.OpenImage("photo.jpg")
+	require.NoError(t, err)
+	want, err := io.ReadAll(original)
+	require.NoError(t, err)
+	assert.Equal(t, want, w.Body.Bytes(), "the original is served")
+}
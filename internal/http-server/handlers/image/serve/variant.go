@@ -0,0 +1,231 @@
+package serve
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"online-photo-editor/internal/http-server/handlers/image/processor"
+	"online-photo-editor/internal/lib/api/resize"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/imgformat"
+	"online-photo-editor/internal/lib/logger/sl"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	maxVariantSide = 4000
+	variantPrefix  = "variant_"
+	// variantHashLen is the length of the hex hash in variant names.
+	variantHashLen = 24
+)
+
+// variantFormats are the output formats a variant may ask for.
+var variantFormats = map[string]string{
+	"jpg":  ".jpg",
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"webp": ".webp",
+	"avif": ".avif",
+}
+
+// unencodable are the variant formats the storage can't write yet. They
+// fall back, see variant.fallback.
+var unencodable = map[string]bool{".avif": true}
+
+// renders makes concurrent requests for a variant that isn't stored yet,
+// downloads and warm-ups alike, render it once.
+var renders singleflight.Group
+
+// variant is an on-the-fly transform requested in the query string. Only
+// a resize, a format change and an encode quality are allowed so a GET
+// stays cheap, and only in the configured sizes, so the number of
+// variants of an image is bounded.
+type variant struct {
+	Width   int
+	Height  int
+	Format  string
+	Quality int
+}
+
+// parseVariant reads the w, h, format and q query params. ok is false when
+// none is given and the original should be served.
+func parseVariant(query url.Values) (v variant, ok bool, err error) {
+	if v.Width, err = queryInt(query, "w", maxVariantSide); err != nil {
+		return v, false, err
+	}
+	if v.Height, err = queryInt(query, "h", maxVariantSide); err != nil {
+		return v, false, err
+	}
+	if v.Quality, err = queryInt(query, "q", 100); err != nil {
+		return v, false, err
+	}
+
+	if format := query.Get("format"); format != "" {
+		ext, known := variantFormats[strings.ToLower(format)]
+		if !known {
+			return v, false, fmt.Errorf("format must be one of jpg, png, webp or avif")
+		}
+		v.Format = ext
+	}
+
+	return v, v != variant{}, nil
+}
+
+// fallback replaces a format of v the storage can't write with the one of
+// the source imgName, or JPEG for a source in a format no variant has.
+// ok is false when only the format was asked for and the source is then
+// served as it is.
+func (v variant) fallback(imgName string) (out variant, ok bool) {
+	if !unencodable[v.Format] {
+		return v, true
+	}
+
+	source, known := variantFormats[imgformat.Normalize(filepath.Ext(imgName))]
+	if !known || unencodable[source] {
+		v.Format = ".jpg"
+		return v, true
+	}
+
+	v.Format = source
+	return v, v != variant{Format: source}
+}
+
+// Size is a variant downloads may ask for, or the warm endpoint
+// precomputes, as the w, h, format and q query params ask for it.
+type Size struct {
+	Width   int
+	Height  int
+	Format  string
+	Quality int
+}
+
+func (s Size) variant() (variant, error) {
+	v := variant{Width: s.Width, Height: s.Height, Quality: s.Quality}
+
+	if s.Width < 0 || s.Width > maxVariantSide || s.Height < 0 || s.Height > maxVariantSide {
+		return v, fmt.Errorf("width and height must be from 0 to %d", maxVariantSide)
+	}
+	if s.Quality < 0 || s.Quality > 100 {
+		return v, fmt.Errorf("quality must be from 0 to 100")
+	}
+
+	if s.Format != "" {
+		ext, known := variantFormats[strings.ToLower(strings.TrimPrefix(s.Format, "."))]
+		if !known {
+			return v, fmt.Errorf("format must be one of jpg, png or webp")
+		}
+		v.Format = ext
+	}
+
+	if v == (variant{}) {
+		return v, fmt.Errorf("size is the original image")
+	}
+
+	return v, nil
+}
+
+// sizeVariants returns the variants of sizes, logging and leaving out the
+// invalid ones.
+func sizeVariants(log *slog.Logger, sizes []Size) []variant {
+	variants := make([]variant, 0, len(sizes))
+	for _, size := range sizes {
+		v, err := size.variant()
+		if err != nil {
+			log.Error("invalid variant size", slog.Any("size", size), sl.Err(err))
+			continue
+		}
+		variants = append(variants, v)
+	}
+	return variants
+}
+
+func queryInt(query url.Values, key string, maxValue int) (int, error) {
+	raw := query.Get(key)
+	if raw == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 || n > maxValue {
+		return 0, fmt.Errorf("%s must be a number from 1 to %d", key, maxValue)
+	}
+
+	return n, nil
+}
+
+// name returns the storage name of the variant of an image with the
+// given name and ETag. It changes whenever the source content does.
+func (v variant) name(imgName, etag string) string {
+	ext := v.Format
+	if ext == "" {
+		ext = strings.ToLower(filepath.Ext(imgName))
+	}
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d|%s|%d", etag, v.Width, v.Height, ext, v.Quality)))
+
+	return variantPrefix + hex.EncodeToString(sum[:variantHashLen/2]) + ext
+}
+
+// ensureVariant returns the variant v of imgName, rendering it and
+// keeping it in cache first unless an earlier request already has.
+func ensureVariant(imgServer processor.ImageProcessor, cache *VariantCache, group *singleflight.Group, imgName string, v variant) (cachedVariant, error) {
+	const op = "handlers.img.serve.ensureVariant"
+
+	etag, err := imgServer.ImageETag(imgName)
+	if err != nil {
+		return cachedVariant{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	name := v.name(imgName, etag)
+	if cached, ok := cache.get(name); ok {
+		return cached, nil
+	}
+
+	rendered, err, _ := group.Do(name, func() (interface{}, error) {
+		if cached, ok := cache.get(name); ok {
+			return cached, nil
+		}
+
+		modTime, err := imgServer.ImageModTime(imgName)
+		if err != nil {
+			return nil, err
+		}
+
+		img, err := imgServer.LoadImage(imgName)
+		if err != nil {
+			return nil, err
+		}
+
+		if v.Width > 0 || v.Height > 0 {
+			params := resize.ResizeParams{Width: v.Width, Height: v.Height}
+			if v.Width > 0 && v.Height > 0 {
+				params.Mode = resize.ModeFit
+			}
+
+			if img, err = params.ResizeImage(img); err != nil {
+				return nil, err
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := encoding.Encode(&buf, img, filepath.Ext(name), encoding.Options{Quality: v.Quality}); err != nil {
+			return nil, err
+		}
+
+		rendered := cachedVariant{name: name, data: buf.Bytes(), modTime: modTime}
+		cache.put(rendered)
+		return rendered, nil
+	})
+	if err != nil {
+		return cachedVariant{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return rendered.(cachedVariant), nil
+}
@@ -0,0 +1,110 @@
+package serve
+
+import (
+	"log/slog"
+	"net/http"
+	"online-photo-editor/internal/http-server/handlers/image/processor"
+	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/logger/sl"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+const (
+	warmWorkers   = 2
+	warmQueueSize = 100
+)
+
+type WarmResponse struct {
+	response.Response
+	// Variants are the names the variants are cached under once warmed,
+	// in the order of the configured sizes.
+	Variants []string `json:"variants"`
+	// URLs download the variants by name, signed when downloads need a
+	// signature.
+	URLs []string `json:"urls"`
+}
+
+type warmJob struct {
+	imgName  string
+	variants []variant
+}
+
+// NewWarm precomputes the variants of an image in sizes into cache, so
+// the first download of each is served from there. It only queues the
+// work and answers 202 at once; invalid sizes are logged and left out.
+func NewWarm(log *slog.Logger, imgServer processor.ImageProcessor, cache *VariantCache, sizes []Size) http.HandlerFunc {
+	variants := sizeVariants(log, sizes)
+
+	jobs := make(chan warmJob, warmQueueSize)
+	for range warmWorkers {
+		go warm(log, imgServer, cache, jobs)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.img.serve.NewWarm"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		imgName, err := ImageName(r)
+		if err != nil {
+			log.Error("invalid image name", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error(err.Error()))
+			return
+		}
+
+		if _, err := imgServer.FindImage(imgName); err != nil {
+			log.Error("failed to find image", sl.Err(err))
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, response.Error("image not found"))
+			return
+		}
+
+		etag, err := imgServer.ImageETag(imgName)
+		if err != nil {
+			log.Error("failed to compute etag", sl.Err(err))
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, response.Error("failed to warm image"))
+			return
+		}
+
+		names := make([]string, len(variants))
+		urls := make([]string, len(variants))
+		for i, v := range variants {
+			names[i] = v.name(imgName, etag)
+			urls[i] = imgServer.ImageURL(names[i])
+		}
+
+		select {
+		case jobs <- warmJob{imgName: imgName, variants: variants}:
+		default:
+			log.Error("warm queue is full")
+			render.Status(r, http.StatusServiceUnavailable)
+			render.JSON(w, r, response.Error("too many images are being warmed, try again later"))
+			return
+		}
+
+		render.Status(r, http.StatusAccepted)
+		render.JSON(w, r, WarmResponse{
+			Response: response.OK(),
+			Variants: names,
+			URLs:     urls,
+		})
+	}
+}
+
+// warm renders the variants of queued images until jobs is closed.
+func warm(log *slog.Logger, imgServer processor.ImageProcessor, cache *VariantCache, jobs <-chan warmJob) {
+	for job := range jobs {
+		for _, v := range job.variants {
+			if _, err := ensureVariant(imgServer, cache, &renders, job.imgName, v); err != nil {
+				log.Error("failed to warm variant", slog.String("image", job.imgName), sl.Err(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package serve_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"online-photo-editor/internal/http-server/handlers/image/serve"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/logger/handlers/slogdiscard"
+	"online-photo-editor/internal/lib/signedurl"
+	"online-photo-editor/internal/storage/filesystem"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_Warm(t *testing.T) {
+	dir := t.TempDir()
+	store, err := filesystem.New(dir, filesystem.Options{})
+	require.NoError(t, err)
+
+	_, err = store.SaveImage(imaging.New(800, 600, color.White), "photo.png", encoding.Options{})
+	require.NoError(t, err)
+
+	sizes := []serve.Size{
+		{Width: 150, Height: 150},
+		{Width: 640, Format: "webp", Quality: 80},
+		// Left out: not a variant at all.
+		{},
+	}
+
+	log := slogdiscard.NewDiscardLogger()
+	cache := serve.NewVariantCache(1 << 20)
+	router := chi.NewRouter()
+	router.Use(middleware.URLFormat)
+	router.Post("/images/{name}/warm", serve.NewWarm(log, store, cache, sizes))
+	router.Get("/images/{name}", serve.New(log, store, serve.Options{Sizes: sizes, Cache: cache, CacheMaxAge: time.Hour}))
+
+	req := httptest.NewRequest(http.MethodPost, "/images/photo.png/warm", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	var resp serve.WarmResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Variants, 2)
+	assert.Equal(t, ".png", filepath.Ext(resp.Variants[0]))
+	assert.Equal(t, ".webp", filepath.Ext(resp.Variants[1]))
+	assert.Equal(t, []string{"/images/" + resp.Variants[0], "/images/" + resp.Variants[1]}, resp.URLs)
+
+	// The warmed names are served from the cache once rendered.
+	for _, name := range resp.Variants {
+		assert.Eventually(t, func() bool {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/images/"+name, nil))
+			return w.Code == http.StatusOK
+		}, 5*time.Second, 10*time.Millisecond, name)
+	}
+
+	// Downloads of the warmed sizes get the same bytes.
+	for i, query := range []string{"w=150&h=150", "w=640&format=webp&q=80"} {
+		req = httptest.NewRequest(http.MethodGet, "/images/photo.png?"+query, nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, query)
+
+		req = httptest.NewRequest(http.MethodGet, "/images/"+resp.Variants[i], nil)
+		direct := httptest.NewRecorder()
+		router.ServeHTTP(direct, req)
+		require.Equal(t, http.StatusOK, direct.Code, query)
+		assert.Equal(t, direct.Body.Bytes(), w.Body.Bytes(), query)
+		assert.Equal(t, "public, max-age=3600", direct.Header().Get("Cache-Control"), query)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/images/"+resp.Variants[0], nil))
+	img, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 150, 112), img.Bounds())
+
+	// Nothing was written to the storage.
+	stored, err := filepath.Glob(filepath.Join(dir, "variant_*"))
+	require.NoError(t, err)
+	assert.Empty(t, stored)
+}
+
+func TestHandler_Warm_Signed(t *testing.T) {
+	signer, err := signedurl.New([]byte("0123456789abcdef"), time.Hour)
+	require.NoError(t, err)
+
+	store, err := filesystem.New(t.TempDir(), filesystem.Options{URLSigner: signer})
+	require.NoError(t, err)
+
+	_, err = store.SaveImage(imaging.New(400, 300, color.White), "photo.png", encoding.Options{})
+	require.NoError(t, err)
+
+	sizes := []serve.Size{{Width: 100}}
+	log := slogdiscard.NewDiscardLogger()
+	cache := serve.NewVariantCache(1 << 20)
+	router := chi.NewRouter()
+	router.Use(middleware.URLFormat)
+	router.Post("/images/{name}/warm", serve.NewWarm(log, store, cache, sizes))
+	router.Get("/images/{name}", serve.New(log, store, serve.Options{Sizes: sizes, Cache: cache, Signer: signer}))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/images/photo.png/warm", nil))
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	var resp serve.WarmResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.URLs, 1)
+
+	// The bare name needs a signature, which the returned URL carries.
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/images/"+resp.Variants[0], nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	assert.Eventually(t, func() bool {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, resp.URLs[0], nil))
+		return w.Code == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestHandler_Warm_NotFound(t *testing.T) {
+	store, err := filesystem.New(t.TempDir(), filesystem.Options{})
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Use(middleware.URLFormat)
+	router.Post("/images/{name}/warm", serve.NewWarm(slogdiscard.NewDiscardLogger(), store, nil, []serve.Size{{Width: 100}}))
+
+	req := httptest.NewRequest(http.MethodPost, "/images/missing.png/warm", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
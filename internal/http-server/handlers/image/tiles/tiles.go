@@ -0,0 +1,157 @@
+package tiles
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"online-photo-editor/internal/http-server/handlers/image/processor"
+	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/api/tiles"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/logger/sl"
+	"online-photo-editor/internal/storage"
+	"path/filepath"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+type Request struct {
+	tiles.TilesParams
+	ImageName string `json:"image_name" validate:"required,max=100,image_name"`
+}
+
+type Tile struct {
+	Column   int    `json:"column"`
+	Row      int    `json:"row"`
+	X        int    `json:"x"`
+	Y        int    `json:"y"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	ImageUrl string `json:"image_url"`
+}
+
+type Response struct {
+	response.Response
+	Columns  int    `json:"columns"`
+	Rows     int    `json:"rows"`
+	TileSize int    `json:"tile_size"`
+	Tiles    []Tile `json:"tiles"`
+}
+
+// New splits an image into a grid of tiles and saves each one as a new
+// image. If any tile fails to save, the ones already saved are deleted.
+func New(log *slog.Logger, imgSplitter processor.ImageProcessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.img.tiles.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error("empty request"))
+
+			return
+		}
+
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error("failed to decode request"))
+
+			return
+		}
+
+		if !response.Validation(log, w, r, req, http.StatusBadRequest) {
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		inputImg, err := imgSplitter.LoadImage(req.ImageName)
+		if err != nil {
+			log.Error("failed to load image", sl.Err(err))
+			response.LoadError(w, r, err)
+			return
+		}
+
+		split, err := req.TilesParams.SplitImage(inputImg)
+		if err != nil {
+			log.Error("failed to split image", sl.Err(err))
+			render.Status(r, http.StatusUnprocessableEntity)
+			render.JSON(w, r, response.Error("failed to split image"))
+			return
+		}
+
+		saved, names, err := saveTiles(imgSplitter, split, req.ImageName, audit.OriginOf(r))
+		if err != nil {
+			log.Error("failed to save tiles", sl.Err(err))
+			for _, name := range names {
+				if err := imgSplitter.DeleteImage(name); err != nil {
+					log.Warn("failed to delete saved tile", sl.Err(err))
+				}
+			}
+			response.SaveError(w, r, err, "failed to save tiles")
+			return
+		}
+
+		log.Info("image split", slog.Int("tiles", len(saved)))
+
+		columns, rows := req.TilesParams.Grid(inputImg.Bounds().Dx(), inputImg.Bounds().Dy())
+
+		render.Status(r, http.StatusOK)
+		render.JSON(w, r, Response{
+			Response: response.OK(),
+			Columns:  columns,
+			Rows:     rows,
+			TileSize: req.TilesParams.Size(),
+			Tiles:    saved,
+		})
+	}
+}
+
+// saveTiles saves every tile of source under a name of its own, in the
+// namespace of source. The names handed out so far are returned even on
+// error, so the caller can clean up.
+func saveTiles(imgSaver processor.ImageProcessor, split []tiles.Tile, source string, origin audit.Origin) ([]Tile, []string, error) {
+	const op = "handlers.img.tiles.saveTiles"
+
+	saved := make([]Tile, 0, len(split))
+	names := make([]string, 0, len(split))
+
+	for _, tile := range split {
+		name, err := imgSaver.GenerateName(storage.InNamespaceOf(source, fmt.Sprintf("tile_%d_%d", tile.Row, tile.Column)), filepath.Ext(source))
+		if err != nil {
+			return saved, names, fmt.Errorf("%s: %w", op, err)
+		}
+		names = append(names, name)
+
+		imgUrl, err := imgSaver.SaveImage(tile.Image, name, encoding.Options{Origin: origin})
+		if err != nil {
+			return saved, names, fmt.Errorf("%s: %w", op, err)
+		}
+
+		bounds := tile.Image.Bounds()
+		saved = append(saved, Tile{
+			Column:   tile.Column,
+			Row:      tile.Row,
+			X:        tile.X,
+			Y:        tile.Y,
+			Width:    bounds.Dx(),
+			Height:   bounds.Dy(),
+			ImageUrl: imgUrl,
+		})
+	}
+
+	return saved, names, nil
+}
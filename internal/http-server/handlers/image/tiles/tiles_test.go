@@ -0,0 +1,74 @@
+package tiles_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"online-photo-editor/internal/http-server/handlers/image/processor/mocks"
+	"online-photo-editor/internal/http-server/handlers/image/tiles"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/logger/handlers/slogdiscard"
+	"online-photo-editor/internal/storage/memory"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_Tiles(t *testing.T) {
+	mem := memory.New()
+	_, err := mem.SaveImage(imaging.New(600, 600, color.White), "img.png", encoding.Options{})
+	require.NoError(t, err)
+
+	body := `{"image_name": "img.png", "tile_size": 256}`
+	req := httptest.NewRequest(http.MethodPost, "/tiles", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	tiles.New(slogdiscard.NewDiscardLogger(), mem).ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp tiles.Response
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 3, resp.Columns)
+	assert.Equal(t, 3, resp.Rows)
+	assert.Equal(t, 256, resp.TileSize)
+	require.Len(t, resp.Tiles, 9)
+
+	sizes := []int{256, 256, 88}
+	for _, tile := range resp.Tiles {
+		name := strings.TrimPrefix(tile.ImageUrl, "/images/")
+		assert.Regexp(t, fmt.Sprintf(`^tile_%d_%d_\d+\.png$`, tile.Row, tile.Column), name)
+
+		width, height, err := mem.ImageSize(name)
+		require.NoError(t, err)
+		assert.Equal(t, sizes[tile.Column], width)
+		assert.Equal(t, sizes[tile.Row], height)
+		assert.Equal(t, [2]int{width, height}, [2]int{tile.Width, tile.Height})
+	}
+}
+
+func TestHandler_Tiles_CleansUpOnSaveError(t *testing.T) {
+	mockSplitter := new(mocks.ImageProcessor)
+	mockSplitter.On("LoadImage", "img.png").Return(imaging.New(600, 300, color.White), nil)
+	mockSplitter.On("GenerateName", mock.Anything, ".png").Return(func(prefix, ext string) (string, error) {
+		return prefix + ext, nil
+	})
+	mockSplitter.On("SaveImage", mock.Anything, "tile_0_0.png", mock.Anything).Return("/images/tile_0_0.png", nil)
+	mockSplitter.On("SaveImage", mock.Anything, "tile_0_1.png", mock.Anything).Return("", errors.New("disk full"))
+	mockSplitter.On("DeleteImage", "tile_0_0.png").Return(nil)
+	mockSplitter.On("DeleteImage", "tile_0_1.png").Return(nil)
+
+	body := `{"image_name": "img.png", "tile_size": 256}`
+	req := httptest.NewRequest(http.MethodPost, "/tiles", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	tiles.New(slogdiscard.NewDiscardLogger(), mockSplitter).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	mockSplitter.AssertExpectations(t)
+}
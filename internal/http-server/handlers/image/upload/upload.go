@@ -1,11 +1,16 @@
 package upload
 
 import (
+	"errors"
+	"io"
 	"log/slog"
 	"net/http"
 	"online-photo-editor/internal/http-server/handlers/image/processor"
+	"online-photo-editor/internal/http-server/middleware/auth"
 	"online-photo-editor/internal/lib/api/response"
 	"online-photo-editor/internal/lib/logger/sl"
+	"online-photo-editor/internal/storage"
+	"path"
 
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
@@ -16,19 +21,61 @@ type Response struct {
 	ImageUrl string `json:"image_url"`
 }
 
-// 10 MB max size
-const maxImageSize = 10 << 20
+const (
+	// 10 MB max size
+	maxImageSize = 10 << 20
+	// maxFormOverhead leaves room for multipart headers and small fields.
+	maxFormOverhead = 1 << 20
+)
+
+// NamespaceHeader is the header naming the namespace an upload is stored
+// in, see storage.Namespace. Without it the upload is in the default one.
+// Requests let through with an API key are in the namespace of their key
+// instead, see auth.Namespace: the header is the client's word only.
+const NamespaceHeader = "X-Namespace"
+
+var errTooLarge = errors.New("uploaded file exceeds the size limit")
+
+// limitedReader fails with errTooLarge as soon as more than limit bytes
+// have been read, so an oversized upload is aborted mid-stream.
+type limitedReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.limit {
+		return n, errTooLarge
+	}
+	return n, err
+}
 
 func New(log *slog.Logger, imgSaver processor.ImageProcessor) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.img.save.New"
 
-		log.With(
+		log := log.With(
 			slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())),
 		)
 
-		err := r.ParseMultipartForm(maxImageSize)
+		namespace := r.Header.Get(NamespaceHeader)
+		if keyNamespace := auth.Namespace(r.Context()); keyNamespace != "" {
+			namespace = keyNamespace
+		}
+		if err := storage.ValidateNamespace(namespace); err != nil {
+			log.Error("invalid namespace", slog.String("namespace", namespace))
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, response.Error(err.Error()))
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxImageSize+maxFormOverhead)
+
+		reader, err := r.MultipartReader()
 		if err != nil {
 			log.Error("failed to parse multipart/form-data", sl.Err(err))
 			render.Status(r, http.StatusBadRequest)
@@ -36,32 +83,61 @@ func New(log *slog.Logger, imgSaver processor.ImageProcessor) http.HandlerFunc {
 			return
 		}
 
-		log.Info("multipart/form-data parsed")
+		var imgName string
 
-		files := r.MultipartForm.File["image"]
-		if len(files) != 1 {
-			log.Error("invalid number of files uploaded", slog.Int("file_count", len(files)))
-			render.Status(r, http.StatusBadRequest)
-			render.JSON(w, r, response.Error("exactly one file must be uploaded"))
-			return
+		for {
+			part, err := reader.NextPart()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				log.Error("failed to read multipart/form-data", sl.Err(err))
+				removeUpload(imgSaver, imgName)
+				responseReadError(w, r, err)
+				return
+			}
+
+			if part.FormName() != "image" || part.FileName() == "" {
+				part.Close()
+				continue
+			}
+
+			if imgName != "" {
+				part.Close()
+				log.Error("more than one file uploaded")
+				removeUpload(imgSaver, imgName)
+				render.Status(r, http.StatusBadRequest)
+				render.JSON(w, r, response.Error("exactly one file must be uploaded"))
+				return
+			}
+
+			imgName, err = imgSaver.UploadImage(&limitedReader{r: part, limit: maxImageSize}, uploadName(namespace, part.FileName()))
+			part.Close()
+			if err != nil {
+				log.Error("failed to save image", sl.Err(err))
+				if isTooLarge(err) {
+					responseReadError(w, r, err)
+					return
+				}
+				if quotaErr := storage.QuotaError(err); quotaErr != nil {
+					render.Status(r, http.StatusInsufficientStorage)
+					render.JSON(w, r, response.Error(quotaErr.Error()))
+					return
+				}
+				render.Status(r, http.StatusInternalServerError)
+				render.JSON(w, r, response.Error("failed to save image"))
+				return
+			}
 		}
 
-		file, handler, err := r.FormFile("image")
-		if err != nil {
-			log.Error("no file uploaded", sl.Err(err))
+		if imgName == "" {
+			log.Error("no file uploaded")
 			render.Status(r, http.StatusBadRequest)
 			render.JSON(w, r, response.Error("no file uploaded"))
 			return
 		}
-		defer file.Close()
 
-		imgUrl, err := imgSaver.UploadImage(file, handler)
-		if err != nil {
-			log.Error("failed to save image", sl.Err(err))
-			render.Status(r, http.StatusInternalServerError)
-			render.JSON(w, r, response.Error("failed to save image"))
-			return
-		}
+		imgUrl := imgSaver.ImageURL(imgName)
 
 		log.Info("image saved", slog.String("image url", imgUrl))
 
@@ -69,6 +145,35 @@ func New(log *slog.Logger, imgSaver processor.ImageProcessor) http.HandlerFunc {
 	}
 }
 
+// uploadName is the file name an upload is stored from: only the
+// extension of the client's, so that can't pick a namespace, in namespace.
+func uploadName(namespace, fileName string) string {
+	return storage.InNamespace(namespace, "upload"+path.Ext(fileName))
+}
+
+// removeUpload drops an image stored earlier in a request that is rejected.
+func removeUpload(imgSaver processor.ImageProcessor, imgName string) {
+	if imgName != "" {
+		imgSaver.DeleteImage(imgName)
+	}
+}
+
+func isTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.Is(err, errTooLarge) || errors.As(err, &maxBytesErr)
+}
+
+func responseReadError(w http.ResponseWriter, r *http.Request, err error) {
+	if isTooLarge(err) {
+		render.Status(r, http.StatusRequestEntityTooLarge)
+		render.JSON(w, r, response.Error("file is too large"))
+		return
+	}
+
+	render.Status(r, http.StatusBadRequest)
+	render.JSON(w, r, response.Error("failed to parse multipart/form-data"))
+}
+
 func responseOK(w http.ResponseWriter, r *http.Request, imgUrl string) {
 	render.Status(r, http.StatusOK)
 	render.JSON(w, r, Response{
@@ -0,0 +1,229 @@
+package upload_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"online-photo-editor/internal/http-server/handlers/image/upload"
+	"online-photo-editor/internal/http-server/middleware/auth"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/logger/handlers/slogdiscard"
+	"online-photo-editor/internal/lib/signedurl"
+	"online-photo-editor/internal/storage"
+	"online-photo-editor/internal/storage/filesystem"
+	"os"
+	"path"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// streamedUpload returns a multipart request whose body is generated on
+// the fly: a PNG signature followed by size bytes of padding. The body is
+// never held in memory as a whole.
+func streamedUpload(t *testing.T, size int) *http.Request {
+	t.Helper()
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	t.Cleanup(func() { pr.Close() })
+
+	go func() {
+		part, err := mw.CreateFormFile("image", "large.png")
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		if _, err := part.Write([]byte("\x89PNG\r\n\x1a\n")); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		chunk := make([]byte, 32<<10)
+		for written := 0; written < size; written += len(chunk) {
+			if _, err := part.Write(chunk[:min(len(chunk), size-written)]); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+
+		pw.CloseWithError(mw.Close())
+	}()
+
+	req := httptest.NewRequest(http.MethodPost, "/image", pr)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	return req
+}
+
+func uploadAllocs(t *testing.T, handler http.HandlerFunc, size int) (*httptest.ResponseRecorder, uint64) {
+	t.Helper()
+
+	req := streamedUpload(t, size)
+	w := httptest.NewRecorder()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	handler(w, req)
+
+	runtime.ReadMemStats(&after)
+
+	return w, after.TotalAlloc - before.TotalAlloc
+}
+
+func TestHandler_UploadImage_StreamsToDisk(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := filesystem.New(dir, filesystem.Options{})
+	require.NoError(t, err)
+
+	handler := upload.New(slogdiscard.NewDiscardLogger(), storage)
+
+	const size = 9 << 20
+	w, allocated := uploadAllocs(t, handler, size)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Less(t, allocated, uint64(1<<20), "upload must not be buffered in memory")
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	info, err := entries[0].Info()
+	require.NoError(t, err)
+	assert.EqualValues(t, size+8, info.Size())
+}
+
+func TestHandler_UploadImage_SizeLimitAbortsMidStream(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := filesystem.New(dir, filesystem.Options{})
+	require.NoError(t, err)
+
+	handler := upload.New(slogdiscard.NewDiscardLogger(), storage)
+
+	w, allocated := uploadAllocs(t, handler, 64<<20)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Less(t, allocated, uint64(1<<20))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "partial upload must be removed")
+}
+
+func TestHandler_UploadImage_Namespace(t *testing.T) {
+	store, err := filesystem.New(t.TempDir(), filesystem.Options{NamespaceMaxImages: 1})
+	require.NoError(t, err)
+
+	handler := upload.New(slogdiscard.NewDiscardLogger(), store)
+
+	send := func(namespace string) *httptest.ResponseRecorder {
+		req := streamedUpload(t, 64)
+		if namespace != "" {
+			req.Header.Set(upload.NamespaceHeader, namespace)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	w := send("acme")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp upload.Response
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "acme", storage.Namespace(path.Base(resp.ImageUrl)))
+
+	w = send("acme")
+	assert.Equal(t, http.StatusInsufficientStorage, w.Code)
+	assert.Contains(t, w.Body.String(), storage.ErrNamespaceQuotaExceeded.Error())
+
+	w = send("")
+	assert.Equal(t, http.StatusOK, w.Code, "the default namespace has room of its own")
+
+	w = send("Not--Valid")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandler_UploadImage_KeyNamespace(t *testing.T) {
+	store, err := filesystem.New(t.TempDir(), filesystem.Options{NamespaceMaxImages: 1})
+	require.NoError(t, err)
+
+	log := slogdiscard.NewDiscardLogger()
+	handler := auth.New(log, auth.NewKeys([]string{"first", "second"}))(upload.New(log, store))
+
+	send := func(key string) *httptest.ResponseRecorder {
+		req := streamedUpload(t, 64)
+		req.Header.Set(audit.APIKeyHeader, key)
+		req.Header.Set(upload.NamespaceHeader, "shared")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("first")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp upload.Response
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	namespace := storage.Namespace(path.Base(resp.ImageUrl))
+	assert.Regexp(t, `^key-[0-9a-f]{16}$`, namespace, "the key decides the namespace, not the header")
+
+	w = send("first")
+	assert.Equal(t, http.StatusInsufficientStorage, w.Code)
+
+	w = send("second")
+	assert.Equal(t, http.StatusOK, w.Code, "another key has a namespace of its own")
+}
+
+func TestHandler_UploadImage_SignedURLRemovesRejected(t *testing.T) {
+	signer, err := signedurl.New([]byte("0123456789abcdef"), time.Hour)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	store, err := filesystem.New(dir, filesystem.Options{URLSigner: signer})
+	require.NoError(t, err)
+
+	handler := upload.New(slogdiscard.NewDiscardLogger(), store)
+
+	post := func(files ...string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for _, name := range files {
+			part, err := mw.CreateFormFile("image", name)
+			require.NoError(t, err)
+			require.NoError(t, png.Encode(part, image.NewNRGBA(image.Rect(0, 0, 2, 2))))
+		}
+		require.NoError(t, mw.Close())
+
+		req := httptest.NewRequest(http.MethodPost, "/image", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	w := post("a.png")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp upload.Response
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	imgURL, err := url.Parse(resp.ImageUrl)
+	require.NoError(t, err)
+	assert.NoError(t, signer.Verify(path.Base(imgURL.Path), imgURL.Query()))
+
+	w = post("b.png", "c.png")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "the first file of a rejected upload is removed")
+}
@@ -0,0 +1,159 @@
+// Package auth keeps the API to the callers holding one of its keys, so
+// that image names, which are guessable, don't give access on their own.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"online-photo-editor/internal/lib/api/response"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/logger/sl"
+	"online-photo-editor/internal/lib/signedurl"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// Keys are the API keys a request may carry in audit.APIKeyHeader.
+type Keys struct {
+	sums [][sha256.Size]byte
+}
+
+// NewKeys returns the Keys of keys; with none, every request is let
+// through.
+func NewKeys(keys []string) *Keys {
+	k := &Keys{}
+	for _, key := range keys {
+		k.sums = append(k.sums, sha256.Sum256([]byte(key)))
+	}
+	return k
+}
+
+// Enabled reports whether requests need a key at all.
+func (k *Keys) Enabled() bool {
+	return len(k.sums) > 0
+}
+
+type namespaceKey struct{}
+
+// Namespace returns the namespace of the key a request was let through
+// with, "key-" and a fingerprint of the key, or "" if it came without
+// one. Every key stores its uploads in a namespace of its own this way,
+// which the caller can't choose.
+func Namespace(ctx context.Context) string {
+	namespace, _ := ctx.Value(namespaceKey{}).(string)
+	return namespace
+}
+
+// match returns the hash of the key r carries if it is one of the keys.
+// The keys are compared through their hashes in constant time, so the
+// timing tells nothing about them, not even their length.
+func (k *Keys) match(r *http.Request) ([sha256.Size]byte, bool) {
+	key := r.Header.Get(audit.APIKeyHeader)
+	if key == "" {
+		return [sha256.Size]byte{}, false
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	valid := 0
+	for _, s := range k.sums {
+		valid |= subtle.ConstantTimeCompare(sum[:], s[:])
+	}
+	return sum, valid == 1
+}
+
+// withKey returns r with the Namespace of its key, if it carries one of
+// the keys.
+func (k *Keys) withKey(r *http.Request) (*http.Request, bool) {
+	sum, ok := k.match(r)
+	if !ok {
+		return r, false
+	}
+
+	namespace := "key-" + hex.EncodeToString(sum[:8])
+	return r.WithContext(context.WithValue(r.Context(), namespaceKey{}, namespace)), true
+}
+
+// New lets through the requests carrying one of keys, failing the others
+// with 401 Unauthorized. Without keys it lets through every request.
+func New(log *slog.Logger, keys *Keys) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		log := log.With(
+			slog.String("component", "middleware/auth"),
+		)
+
+		if !keys.Enabled() {
+			return next
+		}
+
+		log.Info("auth middleware enabled")
+
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			r, ok := keys.withKey(r)
+			if !ok {
+				log.Error("missing or invalid api key", slog.String("path", r.URL.Path))
+				render.Status(r, http.StatusUnauthorized)
+				render.JSON(w, r, response.Error("missing or invalid api key"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// NewSigned is New for the routes that read the image in their {name}
+// URL param: with signer set, a signed URL for that image, as downloads
+// need, lets a request through too; without signer it is New. Bad
+// signatures get 403 Forbidden.
+func NewSigned(log *slog.Logger, keys *Keys, signer *signedurl.Signer) func(next http.Handler) http.Handler {
+	if signer == nil {
+		return New(log, keys)
+	}
+
+	return func(next http.Handler) http.Handler {
+		log := log.With(
+			slog.String("component", "middleware/auth"),
+		)
+
+		log.Info("auth middleware enabled", slog.Bool("signed_urls", true))
+
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if r, ok := keys.withKey(r); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			err := signer.Verify(chi.URLParam(r, "name"), r.URL.Query())
+			if err == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			log.Error("neither an api key nor a valid signature", sl.Err(err), slog.String("path", r.URL.Path))
+			if errors.Is(err, signedurl.ErrMissing) {
+				render.Status(r, http.StatusUnauthorized)
+				render.JSON(w, r, response.Error("an api key or a signed url is required"))
+				return
+			}
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, response.Error(signatureError(err)))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+func signatureError(err error) string {
+	if errors.Is(err, signedurl.ErrExpired) {
+		return signedurl.ErrExpired.Error()
+	}
+	return signedurl.ErrInvalid.Error()
+}
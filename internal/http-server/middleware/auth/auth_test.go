@@ -0,0 +1,80 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"online-photo-editor/internal/http-server/middleware/auth"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/logger/handlers/slogdiscard"
+	"online-photo-editor/internal/lib/signedurl"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func router(keys []string, signer *signedurl.Signer) *chi.Mux {
+	log := slogdiscard.NewDiscardLogger()
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+	router := chi.NewRouter()
+	router.With(auth.New(log, auth.NewKeys(keys))).Post("/image/process", ok)
+	router.With(auth.NewSigned(log, auth.NewKeys(keys), signer)).Get("/images/{name}/info", ok)
+	return router
+}
+
+func request(router http.Handler, method, target, key string) int {
+	req := httptest.NewRequest(method, target, nil)
+	if key != "" {
+		req.Header.Set(audit.APIKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestNew(t *testing.T) {
+	keyed := router([]string{"first-key", "second-key"}, nil)
+
+	assert.Equal(t, http.StatusOK, request(keyed, http.MethodPost, "/image/process", "second-key"))
+	assert.Equal(t, http.StatusUnauthorized, request(keyed, http.MethodPost, "/image/process", ""))
+	assert.Equal(t, http.StatusUnauthorized, request(keyed, http.MethodPost, "/image/process", "second-ke"))
+
+	// Without a signer there is no signature to take instead of a key.
+	assert.Equal(t, http.StatusUnauthorized, request(keyed, http.MethodGet, "/images/a.png/info", ""))
+
+	open := router(nil, nil)
+	assert.Equal(t, http.StatusOK, request(open, http.MethodPost, "/image/process", ""))
+	assert.Equal(t, http.StatusOK, request(open, http.MethodGet, "/images/a.png/info", ""))
+}
+
+func TestNewSigned(t *testing.T) {
+	signer, err := signedurl.New([]byte("0123456789abcdef"), time.Hour)
+	require.NoError(t, err)
+	signed := router([]string{"key"}, signer)
+
+	tests := []struct {
+		name   string
+		target string
+		key    string
+		want   int
+	}{
+		{"key", "/images/a.png/info", "key", http.StatusOK},
+		{"signature", "/images/a.png/info?" + signer.Sign("a.png", time.Now().Add(time.Minute)), "", http.StatusOK},
+		{"signature of another image", "/images/b.png/info?" + signer.Sign("a.png", time.Now().Add(time.Minute)), "", http.StatusForbidden},
+		{"expired signature", "/images/a.png/info?" + signer.Sign("a.png", time.Now().Add(-time.Minute)), "", http.StatusForbidden},
+		{"neither", "/images/a.png/info", "", http.StatusUnauthorized},
+		{"wrong key", "/images/a.png/info", "other", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, request(signed, http.MethodGet, tt.target, tt.key))
+		})
+	}
+
+	// A signature never stands in for a key on the other routes.
+	assert.Equal(t, http.StatusUnauthorized, request(signed, http.MethodPost, "/image/process?"+signer.Sign("a.png", time.Now().Add(time.Minute)), ""))
+}
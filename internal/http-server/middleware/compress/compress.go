@@ -0,0 +1,220 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+// compressible are the content types worth compressing. Image formats
+// are compressed already, so they are always sent as they are.
+var compressible = []string{
+	"application/json",
+	"text/plain",
+	"text/html",
+	"text/css",
+}
+
+// New compresses JSON and text responses with gzip or deflate, whichever
+// the client prefers in Accept-Encoding. The compression itself is chi's
+// middleware.Compress; New holds the start of the body back until it
+// knows the response is at least minSize bytes long, as compressing
+// shorter ones saves nothing. It also picks the encoding by its q-value,
+// which chi doesn't look at, so "gzip;q=0" stays uncompressed.
+func New(log *slog.Logger, minSize int) func(next http.Handler) http.Handler {
+	compressor := middleware.Compress(flate.DefaultCompression, compressible...)
+
+	return func(next http.Handler) http.Handler {
+		log := log.With(
+			slog.String("component", "middleware/compress"),
+		)
+
+		log.Info("compress middleware enabled", slog.Int("min_size", minSize))
+
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			encoding := negotiate(r.Header.Get("Accept-Encoding"))
+
+			// chi only sees the encoding picked here, and only writes
+			// what is to be compressed; the handler gets the request as
+			// it came.
+			chiReq := r.Clone(r.Context())
+			chiReq.Header.Set("Accept-Encoding", encoding)
+
+			compressor(http.HandlerFunc(func(compressed http.ResponseWriter, _ *http.Request) {
+				// Clients that accept neither encoding still go through
+				// the writer, which adds Vary for caches.
+				cw := &writer{ResponseWriter: w, compressed: compressed, encoding: encoding, minSize: minSize}
+				defer cw.Close()
+
+				next.ServeHTTP(cw, r)
+			})).ServeHTTP(w, chiReq)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// negotiate returns the encoding to use for an Accept-Encoding header,
+// gzip on a tie, or "" if the client accepts neither.
+func negotiate(header string) string {
+	best, bestQ := "", 0.0
+
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != encodingGzip && name != encodingDeflate {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		// q=0 means "not acceptable".
+		if q <= 0 {
+			continue
+		}
+
+		if q > bestQ || (q == bestQ && name == encodingGzip) {
+			best, bestQ = name, q
+		}
+	}
+
+	return best
+}
+
+// writer holds back the start of the body until it knows whether the
+// response is compressible and at least minSize bytes long. It then
+// writes the response to compressed, chi's compressing writer, or
+// straight to the ResponseWriter.
+type writer struct {
+	http.ResponseWriter
+	compressed http.ResponseWriter
+	// encoding is empty when the client accepts no compression.
+	encoding string
+	minSize  int
+
+	status int
+	buf    bytes.Buffer
+	// out is where the body goes once it is decided, nil until then.
+	out http.ResponseWriter
+}
+
+func (cw *writer) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *writer) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if cw.out != nil {
+		return cw.out.Write(p)
+	}
+
+	if cw.encoding == "" || !cw.compressible() {
+		cw.decide(false)
+		return cw.out.Write(p)
+	}
+
+	cw.buf.Write(p)
+	if cw.buf.Len() >= cw.minSize {
+		if err := cw.flushBuffer(true); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// compressible reports whether the response may be compressed, judging by
+// its headers and status.
+func (cw *writer) compressible() bool {
+	header := cw.Header()
+
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	if cw.status < http.StatusOK || cw.status == http.StatusNoContent || cw.status == http.StatusNotModified {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && isCompressible(mediaType)
+}
+
+func isCompressible(mediaType string) bool {
+	for _, t := range compressible {
+		if t == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// decide sends the headers, through chi to compress the body or around
+// it not to. chi adds Vary when it compresses; an uncompressed response
+// that could have been needs it too.
+func (cw *writer) decide(compress bool) {
+	cw.out = cw.ResponseWriter
+	if compress {
+		cw.out = cw.compressed
+	} else if cw.compressible() {
+		cw.Header().Add("Vary", "Accept-Encoding")
+	}
+
+	if cw.status != 0 {
+		cw.out.WriteHeader(cw.status)
+	}
+}
+
+func (cw *writer) flushBuffer(compress bool) error {
+	cw.decide(compress)
+
+	_, err := cw.out.Write(cw.buf.Bytes())
+	cw.buf.Reset()
+	return err
+}
+
+// Close sends what is still held back. chi ends the compressed stream
+// once the handler returns.
+func (cw *writer) Close() error {
+	if cw.out == nil {
+		return cw.flushBuffer(false)
+	}
+	return nil
+}
+
+// Flush sends the held back body, so streaming handlers aren't delayed
+// until minSize bytes are written.
+func (cw *writer) Flush() {
+	if cw.out == nil && cw.status != 0 {
+		cw.flushBuffer(cw.encoding != "" && cw.compressible() && cw.buf.Len() > 0)
+	}
+
+	if f, ok := cw.out.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *writer) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
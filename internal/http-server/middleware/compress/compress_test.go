@@ -0,0 +1,127 @@
+package compress_test
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"online-photo-editor/internal/http-server/middleware/compress"
+	"online-photo-editor/internal/lib/logger/handlers/slogdiscard"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, contentType, body, acceptEncoding string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	handler := compress.New(slogdiscard.NewDiscardLogger(), 64)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, body)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestCompress(t *testing.T) {
+	large := `{"items": [` + strings.Repeat(`"value", `, 100) + `"value"]}`
+
+	tests := []struct {
+		name           string
+		contentType    string
+		body           string
+		acceptEncoding string
+		wantEncoding   string
+	}{
+		{"gzip", "application/json; charset=utf-8", large, "gzip, deflate", "gzip"},
+		{"deflate preferred", "application/json", large, "gzip;q=0.5, deflate", "deflate"},
+		{"not accepted", "application/json", large, "br", ""},
+		{"gzip refused", "application/json", large, "gzip;q=0", ""},
+		{"below min size", "application/json", `{"status": "OK"}`, "gzip", ""},
+		{"image bytes", "image/png", large, "gzip", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(t, tt.contentType, tt.body, tt.acceptEncoding)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.wantEncoding, w.Header().Get("Content-Encoding"))
+
+			var body io.Reader = w.Body
+			switch tt.wantEncoding {
+			case "gzip":
+				gz, err := gzip.NewReader(w.Body)
+				require.NoError(t, err)
+				body = gz
+				assert.Less(t, w.Body.Len(), len(tt.body))
+			case "deflate":
+				body = flate.NewReader(w.Body)
+			}
+
+			got, err := io.ReadAll(body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.body, string(got))
+
+			if strings.HasPrefix(tt.contentType, "application/json") {
+				assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+			} else {
+				assert.Empty(t, w.Header().Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestCompress_Flush(t *testing.T) {
+	handler := compress.New(slogdiscard.NewDiscardLogger(), 64)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The handler sees the Accept-Encoding the client sent.
+		assert.Equal(t, "gzip;q=0.8, deflate;q=0.2", r.Header.Get("Accept-Encoding"))
+
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "first, ")
+		w.(http.Flusher).Flush()
+		io.WriteString(w, "then the rest")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip;q=0.8, deflate;q=0.2")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	// A flush sends what was held back, compressed though it is short.
+	require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.True(t, w.Flushed)
+
+	gz, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	got, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, "first, then the rest", string(got))
+}
+
+func TestCompress_NotModified(t *testing.T) {
+	handler := compress.New(slogdiscard.NewDiscardLogger(), 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotModified)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Zero(t, w.Body.Len())
+}
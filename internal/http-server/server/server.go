@@ -0,0 +1,56 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"online-photo-editor/internal/config"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// New returns a server for handler configured by cfg. ReadTimeout and
+// WriteTimeout default to Timeout, ReadHeaderTimeout to ReadTimeout. With
+// HTTP2 the server also speaks HTTP/2, over TLS and in cleartext (h2c),
+// so many concurrent image transfers share one connection instead of
+// each waiting for its own.
+func New(cfg config.HTTPServer, handler http.Handler) (*http.Server, error) {
+	readTimeout := cfg.ReadTimeout
+	if readTimeout == 0 {
+		readTimeout = cfg.Timeout
+	}
+
+	readHeaderTimeout := cfg.ReadHeaderTimeout
+	if readHeaderTimeout == 0 {
+		readHeaderTimeout = readTimeout
+	}
+
+	srv := &http.Server{
+		Addr:              cfg.Address,
+		Handler:           handler,
+		ReadTimeout:       readTimeout,
+		ReadHeaderTimeout: readHeaderTimeout,
+		WriteTimeout:      cfg.EffectiveWriteTimeout(),
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+
+	if !cfg.HTTP2 {
+		// A non-nil empty map turns off the HTTP/2 support net/http would
+		// otherwise enable for TLS.
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		return srv, nil
+	}
+
+	h2s := &http2.Server{
+		IdleTimeout:          cfg.IdleTimeout,
+		MaxConcurrentStreams: cfg.MaxConcurrentStreams,
+	}
+
+	if err := http2.ConfigureServer(srv, h2s); err != nil {
+		return nil, err
+	}
+
+	srv.Handler = h2c.NewHandler(handler, h2s)
+
+	return srv, nil
+}
@@ -0,0 +1,105 @@
+package server_test
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"online-photo-editor/internal/config"
+	"online-photo-editor/internal/http-server/server"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+func TestNew_Timeouts(t *testing.T) {
+	cases := []struct {
+		name                                 string
+		cfg                                  config.HTTPServer
+		read, readHeader, write, idleTimeout time.Duration
+	}{
+		{
+			name:        "Explicit",
+			cfg:         config.HTTPServer{Timeout: 4 * time.Second, ReadTimeout: 30 * time.Second, ReadHeaderTimeout: 5 * time.Second, WriteTimeout: 60 * time.Second, IdleTimeout: 90 * time.Second},
+			read:        30 * time.Second,
+			readHeader:  5 * time.Second,
+			write:       60 * time.Second,
+			idleTimeout: 90 * time.Second,
+		},
+		{
+			name:        "Fallback to timeout",
+			cfg:         config.HTTPServer{Timeout: 4 * time.Second, IdleTimeout: time.Minute},
+			read:        4 * time.Second,
+			readHeader:  4 * time.Second,
+			write:       4 * time.Second,
+			idleTimeout: time.Minute,
+		},
+		{
+			name:        "Header timeout follows read timeout",
+			cfg:         config.HTTPServer{Timeout: 4 * time.Second, ReadTimeout: 20 * time.Second},
+			read:        20 * time.Second,
+			readHeader:  20 * time.Second,
+			write:       4 * time.Second,
+			idleTimeout: 0,
+		},
+	}
+
+	for _, tc := range cases {
+		for _, h2 := range []bool{true, false} {
+			tc.cfg.HTTP2 = h2
+
+			srv, err := server.New(tc.cfg, http.NotFoundHandler())
+			require.NoError(t, err, tc.name)
+
+			assert.Equal(t, tc.read, srv.ReadTimeout, tc.name)
+			assert.Equal(t, tc.readHeader, srv.ReadHeaderTimeout, tc.name)
+			assert.Equal(t, tc.write, srv.WriteTimeout, tc.name)
+			assert.Equal(t, tc.idleTimeout, srv.IdleTimeout, tc.name)
+		}
+	}
+}
+
+func TestNew_HTTP2(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	})
+
+	srv, err := server.New(config.HTTPServer{Timeout: 4 * time.Second, HTTP2: true, MaxConcurrentStreams: 10}, handler)
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	// Prior-knowledge h2c: HTTP/2 frames over a plain TCP connection.
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+
+	resp, err := client.Get("http://" + ln.Addr().String())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, "HTTP/2.0", string(body))
+}
+
+func TestNew_HTTP2Disabled(t *testing.T) {
+	srv, err := server.New(config.HTTPServer{Timeout: 4 * time.Second}, http.NotFoundHandler())
+	require.NoError(t, err)
+
+	require.NotNil(t, srv.TLSNextProto)
+	assert.Empty(t, srv.TLSNextProto)
+}
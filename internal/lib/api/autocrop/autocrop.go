@@ -0,0 +1,150 @@
+package autocrop
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"online-photo-editor/internal/lib/colors"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	MethodColor = "color"
+	MethodAlpha = "alpha"
+)
+
+const defaultColorTolerance = 16
+
+// AutoCropParams crops to the tight bounding box of the content, leaving
+// Padding pixels of margin where the image allows. With the color method
+// the background is Background, or the average color of the corners
+// without one, and Tolerance is the largest RGB distance from it, on a
+// 0-255 scale, that is still background (16 by default). With the alpha
+// method pixels whose alpha is at most Tolerance are background (0 by
+// default, only fully transparent ones). An image that is all background
+// is returned unchanged.
+type AutoCropParams struct {
+	Method     string `json:"method" validate:"required,oneof=color alpha"`
+	Background string `json:"background,omitempty" validate:"max=20"`
+	Tolerance  int    `json:"tolerance,omitempty" validate:"min=0,max=255"`
+	Padding    int    `json:"padding,omitempty" validate:"min=0,max=1000"`
+}
+
+// OutputSize reports the size as unknown: it depends on the content.
+func (params *AutoCropParams) OutputSize(width, height int) (int, int, bool) {
+	return 0, 0, false
+}
+
+func (params *AutoCropParams) AutoCropImage(img image.Image) (image.Image, error) {
+	const op = "api.autocrop.AutoCropImage"
+
+	src := imaging.Clone(img)
+
+	isBackground, err := params.background(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	box := contentBox(src, isBackground)
+	if box.Empty() {
+		return src, nil
+	}
+
+	box = image.Rect(
+		box.Min.X-params.Padding, box.Min.Y-params.Padding,
+		box.Max.X+params.Padding, box.Max.Y+params.Padding,
+	).Intersect(src.Bounds())
+
+	return imaging.Crop(src, box), nil
+}
+
+// background returns the test for background pixels of img.
+func (params *AutoCropParams) background(img *image.NRGBA) (func(p []uint8) bool, error) {
+	if params.Method == MethodAlpha {
+		threshold := uint8(params.Tolerance)
+		return func(p []uint8) bool { return p[3] <= threshold }, nil
+	}
+
+	ref := cornerColor(img)
+	if params.Background != "" {
+		c, err := colors.Parse(params.Background)
+		if err != nil {
+			return nil, err
+		}
+		ref = c
+	}
+
+	tolerance := params.Tolerance
+	if tolerance == 0 {
+		tolerance = defaultColorTolerance
+	}
+	// Compare squared distances to spare a square root per pixel.
+	limit := 3 * tolerance * tolerance
+
+	return func(p []uint8) bool {
+		if p[3] == 0 {
+			return true
+		}
+		dr := int(p[0]) - int(ref.R)
+		dg := int(p[1]) - int(ref.G)
+		db := int(p[2]) - int(ref.B)
+		return dr*dr+dg*dg+db*db <= limit
+	}, nil
+}
+
+// cornerColor averages the four corner pixels.
+func cornerColor(img *image.NRGBA) color.NRGBA {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+
+	var sum [3]float64
+	for _, corner := range []image.Point{{0, 0}, {w - 1, 0}, {0, h - 1}, {w - 1, h - 1}} {
+		p := img.Pix[corner.Y*img.Stride+corner.X*4:]
+		sum[0] += float64(p[0])
+		sum[1] += float64(p[1])
+		sum[2] += float64(p[2])
+	}
+
+	return color.NRGBA{
+		R: uint8(math.Round(sum[0] / 4)),
+		G: uint8(math.Round(sum[1] / 4)),
+		B: uint8(math.Round(sum[2] / 4)),
+		A: 255,
+	}
+}
+
+// contentBox returns the bounding box of the pixels that aren't
+// background, empty if there are none.
+func contentBox(img *image.NRGBA, isBackground func(p []uint8) bool) image.Rectangle {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	box := image.Rectangle{}
+
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+w*4]
+
+		left := -1
+		for x := 0; x < w; x++ {
+			if !isBackground(row[x*4 : x*4+4]) {
+				left = x
+				break
+			}
+		}
+		if left < 0 {
+			continue
+		}
+
+		right := left
+		for x := w - 1; x > left; x-- {
+			if !isBackground(row[x*4 : x*4+4]) {
+				right = x
+				break
+			}
+		}
+
+		box = box.Union(image.Rect(left, y, right+1, y+1))
+	}
+
+	return box
+}
@@ -0,0 +1,81 @@
+package autocrop_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/autocrop"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoCropImage_Color(t *testing.T) {
+	// A red subject on a slightly noisy white backdrop.
+	img := imaging.New(200, 100, color.White)
+	img.SetNRGBA(5, 5, color.NRGBA{R: 250, G: 248, B: 252, A: 255})
+	for y := 30; y < 60; y++ {
+		for x := 50; x < 120; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+
+	cases := []struct {
+		name   string
+		params autocrop.AutoCropParams
+		want   image.Point
+	}{
+		{name: "tight", params: autocrop.AutoCropParams{Method: autocrop.MethodColor}, want: image.Pt(70, 30)},
+		{name: "padding", params: autocrop.AutoCropParams{Method: autocrop.MethodColor, Padding: 10}, want: image.Pt(90, 50)},
+		// Padding stops at the image edges.
+		{name: "padding clipped", params: autocrop.AutoCropParams{Method: autocrop.MethodColor, Padding: 40}, want: image.Pt(150, 100)},
+		{name: "background", params: autocrop.AutoCropParams{Method: autocrop.MethodColor, Background: "white"}, want: image.Pt(70, 30)},
+	}
+
+	for _, tc := range cases {
+		out, err := tc.params.AutoCropImage(img)
+		require.NoError(t, err, tc.name)
+		assert.Equal(t, tc.want, out.Bounds().Size(), tc.name)
+	}
+}
+
+func TestAutoCropImage_Alpha(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	// A faint halo that only counts at the default tolerance.
+	img.SetNRGBA(10, 10, color.NRGBA{A: 8})
+	for y := 40; y < 50; y++ {
+		for x := 20; x < 80; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{B: 255, A: 255})
+		}
+	}
+
+	params := autocrop.AutoCropParams{Method: autocrop.MethodAlpha}
+	out, err := params.AutoCropImage(img)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 70, 40), out.Bounds())
+
+	params.Tolerance = 16
+	out, err = params.AutoCropImage(img)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 60, 10), out.Bounds())
+	assert.Equal(t, color.NRGBA{B: 255, A: 255}, out.(*image.NRGBA).NRGBAAt(0, 0))
+}
+
+func TestAutoCropImage_AllBackground(t *testing.T) {
+	for _, method := range []string{autocrop.MethodColor, autocrop.MethodAlpha} {
+		params := autocrop.AutoCropParams{Method: method}
+
+		out, err := params.AutoCropImage(image.NewNRGBA(image.Rect(0, 0, 30, 20)))
+		require.NoError(t, err, method)
+		assert.Equal(t, image.Rect(0, 0, 30, 20), out.Bounds(), method)
+	}
+}
+
+func TestAutoCropImage_InvalidBackground(t *testing.T) {
+	params := autocrop.AutoCropParams{Method: autocrop.MethodColor, Background: "nope"}
+
+	_, err := params.AutoCropImage(imaging.New(10, 10, color.White))
+	assert.Error(t, err)
+}
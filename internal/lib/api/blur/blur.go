@@ -2,14 +2,35 @@ package blur
 
 import (
 	"image"
+	"math"
+
+	"online-photo-editor/internal/lib/tiling"
 
 	"github.com/disintegration/imaging"
 )
 
 type BlurParams struct {
 	Sigma float64 `json:"sigma" validate:"required,min=0.1,max=100.0"`
+	// MemoryBudget bounds the working memory in bytes, see SetMemoryBudget.
+	MemoryBudget int64 `json:"-"`
+}
+
+// SetMemoryBudget makes the blur take at most about budget bytes of
+// working memory, blurring overlapping tiles when the whole image would
+// take more. Zero means no limit.
+func (params *BlurParams) SetMemoryBudget(budget int64) {
+	params.MemoryBudget = budget
+}
+
+// Cost is the work per pixel of a separable Gaussian blur: two passes
+// with a kernel of radius 3σ.
+func (params *BlurParams) Cost(width, height int) float64 {
+	return 2 * (2*math.Ceil(3*params.Sigma) + 1)
 }
 
 func (params *BlurParams) BlurImage(img image.Image) (image.Image, error) {
-	return imaging.Blur(img, float64(params.Sigma)), nil
+	blurred, _ := tiling.Apply(img, params.MemoryBudget, int(math.Ceil(3*params.Sigma)), func(img image.Image) *image.NRGBA {
+		return imaging.Blur(img, params.Sigma)
+	})
+	return blurred, nil
 }
@@ -0,0 +1,71 @@
+package channelmixer
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// ChannelMixerParams remixes the color channels, like the Channel Mixer of
+// photo editors. Row i of Matrix sets output channel i, red, green then
+// blue, as the weighted sum of the input red, green and blue, e.g.
+// [[1,0,0],[0,1,0],[0,0,1]] leaves the image as it is and three equal
+// rows of [0.3,0.6,0.1] make a custom black-and-white mix. Constants, if
+// given, add to each output channel a fraction of the full range. Alpha is
+// kept.
+type ChannelMixerParams struct {
+	Matrix    [][]float64 `json:"matrix" validate:"required,len=3,dive,len=3,dive,min=-2,max=2"`
+	Constants []float64   `json:"constants,omitempty" validate:"omitempty,len=3,dive,min=-1,max=1"`
+}
+
+func (params *ChannelMixerParams) identity() bool {
+	for i, row := range params.Matrix {
+		for j, w := range row {
+			if i == j && w != 1 || i != j && w != 0 {
+				return false
+			}
+		}
+	}
+	for _, c := range params.Constants {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (params *ChannelMixerParams) ChannelMixerImage(img image.Image) (image.Image, error) {
+	if params.identity() {
+		return img, nil
+	}
+
+	// The contribution of every 8-bit level of each input channel to each
+	// output channel, so a pixel only takes sums.
+	var luts [3][3][256]float64
+	for i, row := range params.Matrix {
+		for j, w := range row {
+			for v := range luts[i][j] {
+				luts[i][j][v] = w * float64(v)
+			}
+		}
+	}
+
+	var offsets [3]float64
+	for i, c := range params.Constants {
+		offsets[i] = c * 255
+	}
+
+	dst := imaging.Clone(img)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		p := dst.Pix[i : i+3 : i+3]
+		r, g, b := p[0], p[1], p[2]
+
+		for c := range p {
+			v := offsets[c] + luts[c][0][r] + luts[c][1][g] + luts[c][2][b]
+			p[c] = uint8(math.Round(min(max(v, 0), 255)))
+		}
+	}
+
+	return dst, nil
+}
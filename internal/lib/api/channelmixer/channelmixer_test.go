@@ -0,0 +1,96 @@
+package channelmixer_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/channelmixer"
+	"online-photo-editor/internal/lib/api/response"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func swatch() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 200, G: 100, B: 50, A: 255})
+	img.SetNRGBA(1, 0, color.NRGBA{R: 10, G: 250, B: 0, A: 128})
+	return img
+}
+
+func TestChannelMixerParams_ChannelMixerImage_Identity(t *testing.T) {
+	params := channelmixer.ChannelMixerParams{Matrix: [][]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}}
+
+	src := swatch()
+	out, err := params.ChannelMixerImage(src)
+	require.NoError(t, err)
+	assert.Same(t, src, out)
+}
+
+func TestChannelMixerParams_ChannelMixerImage(t *testing.T) {
+	tests := []struct {
+		name   string
+		params channelmixer.ChannelMixerParams
+		want   [2]color.NRGBA
+	}{
+		{
+			name:   "swap red and blue",
+			params: channelmixer.ChannelMixerParams{Matrix: [][]float64{{0, 0, 1}, {0, 1, 0}, {1, 0, 0}}},
+			want:   [2]color.NRGBA{{R: 50, G: 100, B: 200, A: 255}, {R: 0, G: 250, B: 10, A: 128}},
+		},
+		{
+			name: "black and white from red",
+			params: channelmixer.ChannelMixerParams{Matrix: [][]float64{
+				{1, 0, 0}, {1, 0, 0}, {1, 0, 0},
+			}},
+			want: [2]color.NRGBA{{R: 200, G: 200, B: 200, A: 255}, {R: 10, G: 10, B: 10, A: 128}},
+		},
+		{
+			name: "constants are clamped",
+			params: channelmixer.ChannelMixerParams{
+				Matrix:    [][]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}},
+				Constants: []float64{0.2, 0.2, -0.5},
+			},
+			want: [2]color.NRGBA{{R: 251, G: 151, B: 0, A: 255}, {R: 61, G: 255, B: 0, A: 128}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := tt.params.ChannelMixerImage(swatch())
+			require.NoError(t, err)
+
+			img := imaging.Clone(out)
+			assert.Equal(t, tt.want[0], img.NRGBAAt(0, 0))
+			assert.Equal(t, tt.want[1], img.NRGBAAt(1, 0))
+		})
+	}
+}
+
+func TestChannelMixerParams_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  channelmixer.ChannelMixerParams
+		wantErr bool
+	}{
+		{"identity", channelmixer.ChannelMixerParams{Matrix: [][]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}}, false},
+		{"missing matrix", channelmixer.ChannelMixerParams{}, true},
+		{"two rows", channelmixer.ChannelMixerParams{Matrix: [][]float64{{1, 0, 0}, {0, 1, 0}}}, true},
+		{"short row", channelmixer.ChannelMixerParams{Matrix: [][]float64{{1, 0}, {0, 1, 0}, {0, 0, 1}}}, true},
+		{"weight out of range", channelmixer.ChannelMixerParams{Matrix: [][]float64{{3, 0, 0}, {0, 1, 0}, {0, 0, 1}}}, true},
+		{"two constants", channelmixer.ChannelMixerParams{Matrix: [][]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}, Constants: []float64{0, 0}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := response.ValidateStruct(tt.params)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package contactsheet
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"online-photo-editor/internal/lib/api/resize"
+	"online-photo-editor/internal/lib/colors"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	defaultColumns    = 4
+	defaultCellSize   = 200
+	defaultBackground = "white"
+	// Labels are set at a 14th of the cell size, at least minLabelSize
+	// pixels.
+	labelSizeDivisor = 14
+	minLabelSize     = 10
+)
+
+var (
+	labelColor       = color.NRGBA{R: 0x33, G: 0x33, B: 0x33, A: 0xff}
+	errorColor       = color.NRGBA{R: 0xc0, G: 0x20, B: 0x20, A: 0xff}
+	placeholderColor = color.NRGBA{R: 0xe0, G: 0xe0, B: 0xe0, A: 0xff}
+)
+
+var regular = sync.OnceValues(func() (*opentype.Font, error) { return opentype.Parse(goregular.TTF) })
+
+// Cell is one image of a sheet, with the Label set under it. A cell
+// without an Image is drawn as a placeholder with Error in it, so one
+// image that can't be loaded doesn't spoil the sheet.
+type Cell struct {
+	Label string
+	Image image.Image
+	Error string
+}
+
+// ContactSheetParams lays the cells out left to right in Columns columns,
+// 4 by default or fewer when there are fewer cells. Every image is fitted
+// into a CellSize square, 200 pixels by default, over Background, white
+// by default.
+type ContactSheetParams struct {
+	Columns    int    `json:"columns,omitempty" validate:"min=0,max=10"`
+	CellSize   int    `json:"cell_size,omitempty" validate:"omitempty,min=32,max=500"`
+	Background string `json:"background,omitempty" validate:"max=20"`
+}
+
+func (params *ContactSheetParams) layout(cells int) (columns, cellSize, labelSize, gap int) {
+	columns = params.Columns
+	if columns == 0 {
+		columns = defaultColumns
+	}
+	columns = max(1, min(columns, cells))
+
+	cellSize = params.CellSize
+	if cellSize == 0 {
+		cellSize = defaultCellSize
+	}
+
+	labelSize = max(cellSize/labelSizeDivisor, minLabelSize)
+	gap = labelSize
+
+	return columns, cellSize, labelSize, gap
+}
+
+// Thumbnail fits img into a cell, as ContactSheetImage draws it. Cells
+// can be given their thumbnail right away, so that the full-size images
+// of a large sheet don't all have to be held until it is drawn; fitting a
+// thumbnail again leaves it as it is.
+func (params *ContactSheetParams) Thumbnail(img image.Image) (image.Image, error) {
+	_, cellSize, _, _ := params.layout(1)
+
+	fit := resize.ResizeParams{Width: cellSize, Height: cellSize, Mode: resize.ModeFit, Sharpen: true}
+	return fit.ResizeImage(img)
+}
+
+// OutputSize returns the size of the sheet of n cells.
+func (params *ContactSheetParams) OutputSize(n int) (int, int) {
+	columns, cellSize, labelSize, gap := params.layout(n)
+	rows := (n + columns - 1) / columns
+
+	return gap + columns*(cellSize+gap), gap + rows*(cellSize+2*labelSize+gap)
+}
+
+// ContactSheetImage draws the sheet of cells.
+func (params *ContactSheetParams) ContactSheetImage(cells []Cell) (image.Image, error) {
+	const op = "api.contactsheet.ContactSheetImage"
+
+	if len(cells) == 0 {
+		return nil, fmt.Errorf("%s: no cells", op)
+	}
+
+	background := defaultBackground
+	if params.Background != "" {
+		background = params.Background
+	}
+	bg, err := colors.Parse(background)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	columns, cellSize, labelSize, gap := params.layout(len(cells))
+
+	f, err := regular()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: float64(labelSize), DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer face.Close()
+
+	width, height := params.OutputSize(len(cells))
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+	for i, cell := range cells {
+		x := gap + (i%columns)*(cellSize+gap)
+		y := gap + (i/columns)*(cellSize+2*labelSize+gap)
+		box := image.Rect(x, y, x+cellSize, y+cellSize)
+
+		if cell.Image == nil {
+			draw.Draw(dst, box, image.NewUniform(placeholderColor), image.Point{}, draw.Src)
+			drawLabel(dst, face, cell.Error, errorColor, x, y+(cellSize+labelSize)/2, cellSize)
+		} else {
+			thumb, err := params.Thumbnail(cell.Image)
+			if err != nil {
+				return nil, fmt.Errorf("%s: cell %d: %w", op, i, err)
+			}
+
+			tb := thumb.Bounds()
+			at := box.Min.Add(image.Pt((cellSize-tb.Dx())/2, (cellSize-tb.Dy())/2))
+			draw.Draw(dst, image.Rectangle{Min: at, Max: at.Add(tb.Size())}, thumb, tb.Min, draw.Over)
+		}
+
+		drawLabel(dst, face, cell.Label, labelColor, x, y+cellSize+labelSize*3/2, cellSize)
+	}
+
+	return dst, nil
+}
+
+// drawLabel sets text centered in the width pixels from x, on the
+// baseline y, ellipsized when it is too wide.
+func drawLabel(dst draw.Image, face font.Face, text string, c color.Color, x, y, width int) {
+	if font.MeasureString(face, text).Ceil() > width {
+		text = ellipsize(face, text, width)
+	}
+
+	drawer := font.Drawer{Dst: dst, Src: image.NewUniform(c), Face: face}
+	offset := (width - font.MeasureString(face, text).Ceil()) / 2
+	drawer.Dot = fixed.P(x+offset, y)
+	drawer.DrawString(text)
+}
+
+// ellipsize ends text with "…", dropping as many runes as it takes to keep
+// it within width.
+func ellipsize(face font.Face, text string, width int) string {
+	runes := []rune(text)
+	for ; len(runes) > 0; runes = runes[:len(runes)-1] {
+		candidate := strings.TrimRight(string(runes), " ") + "…"
+		if font.MeasureString(face, candidate).Ceil() <= width {
+			return candidate
+		}
+	}
+	return "…"
+}
@@ -0,0 +1,78 @@
+package contactsheet_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/contactsheet"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContactSheetParams_ContactSheetImage(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	cells := []contactsheet.Cell{
+		{Label: "wide.png", Image: imaging.New(400, 100, red)},
+		{Label: "missing.png", Error: "image not found"},
+		{Label: "a-very-long-name-that-does-not-fit-under-the-thumbnail.png", Image: imaging.New(50, 50, red)},
+	}
+
+	params := contactsheet.ContactSheetParams{Columns: 2, CellSize: 100}
+	width, height := params.OutputSize(len(cells))
+
+	img, err := params.ContactSheetImage(cells)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, width, height), img.Bounds())
+
+	// Two columns and two rows of 100 pixel cells, with labels and gaps.
+	gap := 10
+	assert.Equal(t, gap+2*(100+gap), width)
+	assert.Equal(t, gap+2*(100+2*10+gap), height)
+
+	sheet := imaging.Clone(img)
+	// The wide image is fitted and centered, leaving background above it.
+	assert.Equal(t, red, sheet.NRGBAAt(gap+50, gap+50))
+	assert.Equal(t, color.NRGBA{R: 255, G: 255, B: 255, A: 255}, sheet.NRGBAAt(gap+50, gap+20))
+	// The placeholder is gray, not background.
+	assert.NotEqual(t, color.NRGBA{R: 255, G: 255, B: 255, A: 255}, sheet.NRGBAAt(2*gap+100+5, gap+5))
+	// The small image isn't blown up.
+	secondRow := gap + 100 + 2*10 + gap
+	assert.Equal(t, red, sheet.NRGBAAt(gap+50, secondRow+50))
+	assert.Equal(t, color.NRGBA{R: 255, G: 255, B: 255, A: 255}, sheet.NRGBAAt(gap+10, secondRow+10))
+}
+
+func TestContactSheetParams_ContactSheetImage_Defaults(t *testing.T) {
+	params := contactsheet.ContactSheetParams{}
+
+	// A single cell doesn't get empty columns next to it.
+	width, _ := params.OutputSize(1)
+	assert.Equal(t, 14+200+14, width)
+
+	_, err := params.ContactSheetImage(nil)
+	assert.Error(t, err)
+
+	params.Background = "not-a-color"
+	_, err = params.ContactSheetImage([]contactsheet.Cell{{Label: "x", Error: "image not found"}})
+	assert.Error(t, err)
+}
+
+func TestContactSheetParams_Thumbnail(t *testing.T) {
+	params := contactsheet.ContactSheetParams{CellSize: 64}
+
+	src := image.NewNRGBA(image.Rect(0, 0, 400, 100))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 7)
+	}
+
+	thumb, err := params.Thumbnail(src)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 64, 16), thumb.Bounds())
+
+	again, err := params.Thumbnail(thumb)
+	require.NoError(t, err)
+	assert.Equal(t, thumb.Bounds(), again.Bounds())
+	assert.Equal(t, imaging.Clone(thumb).Pix, imaging.Clone(again).Pix, "a thumbnail isn't sharpened twice")
+}
@@ -1,9 +1,74 @@
 package convert
 
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Formats are the formats an image can be converted to.
+var Formats = []string{"jpg", "jpeg", "png", "gif", "bmp", "webp", "tif", "tiff"}
+
+// VectorFormats are formats asked for that can't be had: the pixels of a
+// raster image don't turn into vector shapes.
+var VectorFormats = []string{"svg", "svgz", "pdf", "eps", "ai"}
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported format")
+	// ErrVectorFormat is an ErrUnsupportedFormat for one of VectorFormats.
+	ErrVectorFormat = fmt.Errorf("%w: cannot convert raster image to vector format", ErrUnsupportedFormat)
+	ErrAutoQuality  = errors.New("quality auto is only supported for webp")
+)
+
 type ConvertParams struct {
 	Format string `json:"format" validate:"required,lowercase,max=10"`
+	// Quality is the JPEG/WebP quality, overriding the one the profile
+	// picks. QualityAuto picks one from the image, for WebP only.
+	Quality Quality `json:"quality,omitempty" validate:"omitempty,min=-1,max=100"`
+	// BitDepth and Dither reduce the bits per channel of lossless
+	// formats, see encoding.Options.
+	BitDepth int  `json:"bit_depth,omitempty" validate:"omitempty,min=1,max=8"`
+	Dither   bool `json:"dither,omitempty"`
 }
 
+// ConvertImage returns the format to save the image in. A format outside
+// Formats fails as CheckFormat does.
 func (params *ConvertParams) ConvertImage() (string, error) {
+	const op = "api.convert.ConvertImage"
+
+	if err := CheckFormat(params.Format); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if params.Quality == QualityAuto && strings.TrimPrefix(params.Format, ".") != "webp" {
+		return "", fmt.Errorf("%s: %w", op, ErrAutoQuality)
+	}
+
 	return params.Format, nil
 }
+
+// CheckFormat reports whether images can be saved as format, with or
+// without a leading dot. One of VectorFormats fails with ErrVectorFormat,
+// any other outside Formats with ErrUnsupportedFormat.
+func CheckFormat(format string) error {
+	format = strings.TrimPrefix(format, ".")
+
+	switch {
+	case slices.Contains(Formats, format):
+		return nil
+	case slices.Contains(VectorFormats, format):
+		return fmt.Errorf("%w: %s", ErrVectorFormat, format)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+}
+
+// Message is the error shown to clients for the format failing
+// CheckFormat with err.
+func Message(format string, err error) string {
+	if errors.Is(err, ErrVectorFormat) {
+		return "cannot convert raster image to vector format"
+	}
+	return fmt.Sprintf("unsupported format %q", format)
+}
@@ -0,0 +1,62 @@
+package convert_test
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"math/rand/v2"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/convert"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoQuality(t *testing.T) {
+	// A flat graphic: two solid blocks.
+	flat := imaging.New(400, 300, color.White)
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 200; x++ {
+			flat.SetNRGBA(x, y, color.NRGBA{R: 20, G: 90, B: 200, A: 255})
+		}
+	}
+
+	// A detailed photo stand-in: noise over a gradient.
+	rng := rand.New(rand.NewPCG(1, 2))
+	detailed := image.NewNRGBA(image.Rect(0, 0, 400, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 400; x++ {
+			v := uint8(min(255, x*200/400+rng.IntN(56)))
+			detailed.SetNRGBA(x, y, color.NRGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+
+	low, high := convert.AutoQuality(flat), convert.AutoQuality(detailed)
+	assert.GreaterOrEqual(t, low, 65)
+	assert.LessOrEqual(t, high, 92)
+	assert.Less(t, low, 70, "flat graphic")
+	assert.Greater(t, high, 80, "detailed photo")
+}
+
+func TestConvertParams_QualityAuto(t *testing.T) {
+	var params convert.ConvertParams
+	require.NoError(t, json.Unmarshal([]byte(`{"format": "webp", "quality": "auto"}`), &params))
+	assert.Equal(t, convert.QualityAuto, params.Quality)
+
+	_, err := params.ConvertImage()
+	assert.NoError(t, err)
+
+	data, err := json.Marshal(params)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"format": "webp", "quality": "auto"}`, string(data))
+
+	// Only WebP picks its quality.
+	params.Format = "jpg"
+	_, err = params.ConvertImage()
+	assert.ErrorIs(t, err, convert.ErrAutoQuality)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"format": "jpg", "quality": 80}`), &params))
+	assert.Equal(t, convert.Quality(80), params.Quality)
+}
@@ -3,32 +3,117 @@ package crop
 import (
 	"fmt"
 	"image"
+	"math"
+	"online-photo-editor/internal/lib/aspect"
+	"strings"
 
 	"github.com/disintegration/imaging"
 )
 
+const (
+	GravityCenter = "center"
+	GravityTop    = "top"
+	GravityBottom = "bottom"
+	GravityLeft   = "left"
+	GravityRight  = "right"
+)
+
+// CropParams crops the X, Y, Width, Height rectangle. Alternatively
+// AspectRatio, e.g. "16:9", crops the largest rectangle of that ratio
+// that fits the image, placed by Gravity: center (the default), an edge
+// such as top, or a corner such as top-left.
 type CropParams struct {
-	X      int `json:"x" validate:"required,min=0"`
-	Y      int `json:"y" validate:"required,min=0"`
-	Width  int `json:"width" validate:"required,min=1"`
-	Height int `json:"height" validate:"required,min=1"`
+	X           int    `json:"x" validate:"excluded_with=AspectRatio,min=0"`
+	Y           int    `json:"y" validate:"excluded_with=AspectRatio,min=0"`
+	Width       int    `json:"width" validate:"required_without=AspectRatio,excluded_with=AspectRatio,omitempty,min=1"`
+	Height      int    `json:"height" validate:"required_without=AspectRatio,excluded_with=AspectRatio,omitempty,min=1"`
+	AspectRatio string `json:"aspect_ratio,omitempty" validate:"omitempty,max=20,aspect_ratio"`
+	Gravity     string `json:"gravity,omitempty" validate:"excluded_without=AspectRatio,omitempty,oneof=center top bottom left right top-left top-right bottom-left bottom-right"`
+}
+
+// rect returns the crop area on a width×height image.
+func (params *CropParams) rect(width, height int) (image.Rectangle, error) {
+	const op = "api.crop.rect"
+
+	if params.AspectRatio == "" {
+		return image.Rect(params.X, params.Y, params.X+params.Width, params.Y+params.Height), nil
+	}
+
+	ratio, err := aspect.Parse(params.AspectRatio)
+	if err != nil {
+		return image.Rectangle{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	w, h := width, height
+	if float64(width)/float64(height) > ratio {
+		w = min(max(int(math.Round(float64(height)*ratio)), 1), width)
+	} else {
+		h = min(max(int(math.Round(float64(width)/ratio)), 1), height)
+	}
+
+	vertical, horizontal, _ := strings.Cut(params.Gravity, "-")
+	if horizontal == "" && (vertical == GravityLeft || vertical == GravityRight) {
+		vertical, horizontal = "", vertical
+	}
+
+	x, y := (width-w)/2, (height-h)/2
+	switch horizontal {
+	case GravityLeft:
+		x = 0
+	case GravityRight:
+		x = width - w
+	}
+	switch vertical {
+	case GravityTop:
+		y = 0
+	case GravityBottom:
+		y = height - h
+	}
+
+	return image.Rect(x, y, x+w, y+h), nil
 }
 
-func (params *CropParams) validate(img image.Image) error {
-	const op = "api.crop.validate"
+// CheckBounds reports whether the crop area fits a width×height image.
+func (params *CropParams) CheckBounds(width, height int) error {
+	const op = "api.crop.CheckBounds"
 
-	if params.X+params.Width > img.Bounds().Max.X || params.Y+params.Height > img.Bounds().Max.Y {
+	rect, err := params.rect(width, height)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if rect.Max.X > width || rect.Max.Y > height {
 		return fmt.Errorf("%s crop area exceeds image boundaries", op)
 	}
 
 	return nil
 }
 
+// Rect returns the crop area on a width×height image, failing like
+// CheckBounds when it doesn't fit.
+func (params *CropParams) Rect(width, height int) (image.Rectangle, error) {
+	if err := params.CheckBounds(width, height); err != nil {
+		return image.Rectangle{}, err
+	}
+	return params.rect(width, height)
+}
+
+func (params *CropParams) OutputSize(width, height int) (int, int, bool) {
+	rect, err := params.rect(width, height)
+	if err != nil {
+		return 0, 0, false
+	}
+	return rect.Dx(), rect.Dy(), true
+}
+
 func (params *CropParams) CropImage(img image.Image) (image.Image, error) {
-	if err := params.validate(img); err != nil {
+	if err := params.CheckBounds(img.Bounds().Max.X, img.Bounds().Max.Y); err != nil {
 		return nil, err
 	}
 
-	rect := image.Rect(params.X, params.Y, params.X+params.Width, params.Y+params.Height)
+	rect, err := params.rect(img.Bounds().Max.X, img.Bounds().Max.Y)
+	if err != nil {
+		return nil, err
+	}
 	return imaging.Crop(img, rect), nil
 }
@@ -0,0 +1,89 @@
+package crop_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/crop"
+	"online-photo-editor/internal/lib/api/response"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCropParams_CropImage_AspectRatio(t *testing.T) {
+	// A 4:3 source; a 16:9 crop keeps the full width.
+	src := imaging.New(800, 600, color.White)
+	for y := 0; y < 600; y++ {
+		src.SetNRGBA(0, y, color.NRGBA{R: uint8(y), A: 255})
+	}
+
+	cases := []struct {
+		gravity string
+		want    image.Rectangle
+	}{
+		{gravity: "", want: image.Rect(0, 75, 800, 525)},
+		{gravity: crop.GravityCenter, want: image.Rect(0, 75, 800, 525)},
+		{gravity: crop.GravityTop, want: image.Rect(0, 0, 800, 450)},
+		{gravity: "bottom-left", want: image.Rect(0, 150, 800, 600)},
+	}
+
+	for _, tc := range cases {
+		params := crop.CropParams{AspectRatio: "16:9", Gravity: tc.gravity}
+		require.NoError(t, response.ValidateStruct(params), tc.gravity)
+
+		w, h, known := params.OutputSize(800, 600)
+		assert.True(t, known)
+		assert.Equal(t, tc.want.Size(), image.Pt(w, h), tc.gravity)
+
+		out, err := params.CropImage(src)
+		require.NoError(t, err, tc.gravity)
+		assert.Equal(t, tc.want.Size(), out.Bounds().Size(), tc.gravity)
+		// The red ramp on the left edge tells which rows were kept.
+		assert.Equal(t, uint8(tc.want.Min.Y), color.NRGBAModel.Convert(out.At(0, 0)).(color.NRGBA).R, tc.gravity)
+	}
+}
+
+func TestCropParams_CropImage_AspectRatioGravityOnWideSource(t *testing.T) {
+	params := crop.CropParams{AspectRatio: "1:1", Gravity: crop.GravityRight}
+
+	w, h, _ := params.OutputSize(1000, 400)
+	assert.Equal(t, 400, w)
+	assert.Equal(t, 400, h)
+
+	src := imaging.New(1000, 400, color.Black)
+	src.SetNRGBA(999, 0, color.NRGBA{G: 255, A: 255})
+
+	out, err := params.CropImage(src)
+	require.NoError(t, err)
+	assert.Equal(t, color.NRGBA{G: 255, A: 255}, color.NRGBAModel.Convert(out.At(399, 0)))
+}
+
+func TestCropParams_Validation(t *testing.T) {
+	valid := []crop.CropParams{
+		{X: 1, Y: 1, Width: 10, Height: 10},
+		// The origin, the only corner a 1×1 image has.
+		{X: 0, Y: 0, Width: 1, Height: 1},
+		{AspectRatio: "16:9"},
+		{AspectRatio: "1.91:1", Gravity: "top-right"},
+	}
+	for _, params := range valid {
+		assert.NoError(t, response.ValidateStruct(params), "%+v", params)
+	}
+
+	invalid := []crop.CropParams{
+		{AspectRatio: "16/9"},
+		{AspectRatio: "0:9"},
+		{AspectRatio: "16:"},
+		{AspectRatio: "wide:9"},
+		{AspectRatio: "16:9", Gravity: "middle"},
+		{AspectRatio: "16:9", Width: 100},
+		{X: 1, Y: 1, Width: 10, Height: 10, Gravity: crop.GravityTop},
+		{},
+	}
+	for _, params := range invalid {
+		assert.Error(t, response.ValidateStruct(params), "%+v", params)
+	}
+}
@@ -0,0 +1,212 @@
+package datestamp
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"online-photo-editor/internal/lib/colors"
+	"online-photo-editor/internal/lib/timefmt"
+	"sync"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	PositionTopLeft     = "top-left"
+	PositionTopRight    = "top-right"
+	PositionBottomLeft  = "bottom-left"
+	PositionBottomRight = "bottom-right"
+)
+
+const (
+	FontMono    = "mono"
+	FontRegular = "regular"
+	FontBold    = "bold"
+)
+
+const (
+	// Without a Size the text is a 30th of the image height, at least
+	// minAutoSize pixels.
+	autoSizeDivisor = 30
+	minAutoSize     = 12
+)
+
+var ErrNoCaptureTime = errors.New("the source image records no capture time")
+
+var fonts = map[string]func() (*opentype.Font, error){
+	FontMono:    sync.OnceValues(func() (*opentype.Font, error) { return opentype.Parse(gomono.TTF) }),
+	FontRegular: sync.OnceValues(func() (*opentype.Font, error) { return opentype.Parse(goregular.TTF) }),
+	FontBold:    sync.OnceValues(func() (*opentype.Font, error) { return opentype.Parse(gobold.TTF) }),
+}
+
+// DatestampParams burns a time into a corner of the image, like a
+// security camera: the current time in UTC, Time (RFC 3339) or, with
+// CaptureTime, when the photo was taken according to its EXIF. Format is
+// a timefmt layout such as "%d.%m.%Y %H:%M", timefmt.Default without one.
+// Font is mono (the default), regular or bold, Size the font size in
+// pixels, a 30th of the image height by default. The text is Color, white
+// by default, over an optional Background box, Margin pixels from the
+// edges at Position, bottom-right by default. Margin defaults to half the
+// font size.
+type DatestampParams struct {
+	Format      string `json:"format,omitempty" validate:"max=64,time_format"`
+	Time        string `json:"time,omitempty" validate:"omitempty,excluded_with=CaptureTime,datetime=2006-01-02T15:04:05Z07:00"`
+	CaptureTime bool   `json:"capture_time,omitempty"`
+	Font        string `json:"font,omitempty" validate:"omitempty,oneof=mono regular bold"`
+	Size        int    `json:"size,omitempty" validate:"omitempty,min=6,max=500"`
+	Color       string `json:"color,omitempty" validate:"max=20"`
+	Background  string `json:"background,omitempty" validate:"max=20"`
+	Position    string `json:"position,omitempty" validate:"omitempty,oneof=top-left top-right bottom-left bottom-right"`
+	Margin      int    `json:"margin,omitempty" validate:"min=0,max=1000"`
+
+	captureTime time.Time
+}
+
+// NeedsCaptureTime reports whether the source capture time must be set
+// with SetCaptureTime before the stamp is drawn.
+func (params *DatestampParams) NeedsCaptureTime() bool {
+	return params.CaptureTime
+}
+
+func (params *DatestampParams) SetCaptureTime(t time.Time) {
+	params.captureTime = t
+}
+
+// Text returns the stamp to draw.
+func (params *DatestampParams) Text() (string, error) {
+	const op = "api.datestamp.Text"
+
+	t := time.Now().UTC()
+	switch {
+	case params.CaptureTime:
+		if params.captureTime.IsZero() {
+			return "", fmt.Errorf("%s: %w", op, ErrNoCaptureTime)
+		}
+		t = params.captureTime
+	case params.Time != "":
+		parsed, err := time.Parse(time.RFC3339, params.Time)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+		t = parsed
+	}
+
+	layout := params.Format
+	if layout == "" {
+		layout = timefmt.Default
+	}
+
+	text, err := timefmt.Format(t, layout)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	return text, nil
+}
+
+// face returns the font face the stamp is drawn with on an image of the
+// given height. The caller closes it.
+func (params *DatestampParams) face(height int) (font.Face, error) {
+	name := params.Font
+	if name == "" {
+		name = FontMono
+	}
+
+	f, err := fonts[name]()
+	if err != nil {
+		return nil, err
+	}
+
+	size := params.Size
+	if size == 0 {
+		size = max(height/autoSizeDivisor, minAutoSize)
+	}
+
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: float64(size), DPI: 72, Hinting: font.HintingFull})
+}
+
+// origin returns where the baseline of text starts on a width×height
+// image.
+func (params *DatestampParams) origin(face font.Face, text string, width, height int) image.Point {
+	margin := params.Margin
+	if margin == 0 {
+		margin = face.Metrics().Height.Ceil() / 2
+	}
+
+	advance := font.MeasureString(face, text).Ceil()
+	metrics := face.Metrics()
+
+	x := width - margin - advance
+	if params.Position == PositionTopLeft || params.Position == PositionBottomLeft {
+		x = margin
+	}
+
+	y := height - margin - metrics.Descent.Ceil()
+	if params.Position == PositionTopLeft || params.Position == PositionTopRight {
+		y = margin + metrics.Ascent.Ceil()
+	}
+
+	return image.Pt(x, y)
+}
+
+func (params *DatestampParams) DatestampImage(img image.Image) (image.Image, error) {
+	const op = "api.datestamp.DatestampImage"
+
+	text, err := params.Text()
+	if err != nil {
+		return nil, err
+	}
+
+	var fg color.Color = color.White
+	if params.Color != "" {
+		c, err := colors.Parse(params.Color)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		fg = c
+	}
+
+	var bg color.Color
+	if params.Background != "" {
+		c, err := colors.Parse(params.Background)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		bg = c
+	}
+
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+
+	face, err := params.face(b.Dy())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer face.Close()
+
+	origin := params.origin(face, text, b.Dx(), b.Dy())
+
+	if bg != nil {
+		metrics := face.Metrics()
+		// The box pads the text by a quarter of the line height.
+		pad := metrics.Height.Ceil() / 4
+		box := image.Rect(
+			origin.X-pad, origin.Y-metrics.Ascent.Ceil()-pad,
+			origin.X+font.MeasureString(face, text).Ceil()+pad, origin.Y+metrics.Descent.Ceil()+pad,
+		)
+		draw.Draw(dst, box, image.NewUniform(bg), image.Point{}, draw.Over)
+	}
+
+	drawer := font.Drawer{Dst: dst, Src: image.NewUniform(fg), Face: face, Dot: fixed.P(origin.X, origin.Y)}
+	drawer.DrawString(text)
+
+	return dst, nil
+}
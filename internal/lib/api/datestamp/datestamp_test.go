@@ -0,0 +1,100 @@
+package datestamp_test
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+	"time"
+
+	"online-photo-editor/internal/lib/api/datestamp"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// drawText sets text in 20px Go Mono with its baseline starting at dot.
+func drawText(t *testing.T, dst draw.Image, text string, dot func(font.Face) image.Point) {
+	t.Helper()
+
+	f, err := opentype.Parse(gomono.TTF)
+	require.NoError(t, err)
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: 20, DPI: 72, Hinting: font.HintingFull})
+	require.NoError(t, err)
+	defer face.Close()
+
+	p := dot(face)
+	drawer := font.Drawer{Dst: dst, Src: image.NewUniform(color.White), Face: face, Dot: fixed.P(p.X, p.Y)}
+	drawer.DrawString(text)
+}
+
+func TestDatestampImage(t *testing.T) {
+	const width, height = 400, 200
+	src := imaging.New(width, height, color.Black)
+
+	params := datestamp.DatestampParams{
+		Format: "CAM1 %d.%m.%Y %H:%M:%S",
+		Time:   "2024-03-07T14:05:09+01:00",
+		Size:   20,
+		Margin: 10,
+	}
+
+	text, err := params.Text()
+	require.NoError(t, err)
+	assert.Equal(t, "CAM1 07.03.2024 14:05:09", text)
+
+	cases := []struct {
+		position string
+		dot      func(face font.Face) image.Point
+	}{
+		{position: datestamp.PositionTopLeft, dot: func(face font.Face) image.Point {
+			return image.Pt(10, 10+face.Metrics().Ascent.Ceil())
+		}},
+		{position: "", dot: func(face font.Face) image.Point {
+			return image.Pt(width-10-font.MeasureString(face, text).Ceil(), height-10-face.Metrics().Descent.Ceil())
+		}},
+	}
+
+	for _, tc := range cases {
+		params.Position = tc.position
+		out, err := params.DatestampImage(src)
+		require.NoError(t, err, tc.position)
+
+		want := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(want, want.Bounds(), src, image.Point{}, draw.Src)
+		drawText(t, want, text, tc.dot)
+
+		assert.Equal(t, want.Pix, out.(*image.RGBA).Pix, "position %q", tc.position)
+	}
+}
+
+func TestDatestampImage_CaptureTime(t *testing.T) {
+	params := datestamp.DatestampParams{CaptureTime: true, Format: "%Y"}
+	assert.True(t, params.NeedsCaptureTime())
+
+	_, err := params.DatestampImage(imaging.New(100, 50, color.Black))
+	assert.ErrorIs(t, err, datestamp.ErrNoCaptureTime)
+
+	params.SetCaptureTime(time.Date(2019, 8, 1, 9, 30, 0, 0, time.UTC))
+	text, err := params.Text()
+	require.NoError(t, err)
+	assert.Equal(t, "2019", text)
+}
+
+func TestDatestampImage_Background(t *testing.T) {
+	params := datestamp.DatestampParams{Time: "2024-03-07T14:05:09Z", Background: "#000000", Color: "#ff0000", Position: datestamp.PositionTopLeft}
+
+	out, err := params.DatestampImage(imaging.New(300, 300, color.White))
+	require.NoError(t, err)
+
+	// The box starts above the text, in the top-left corner.
+	r, g, b, _ := out.At(6, 4).RGBA()
+	assert.Equal(t, [3]uint32{0, 0, 0}, [3]uint32{r, g, b})
+	r, g, b, _ = out.At(299, 299).RGBA()
+	assert.Equal(t, [3]uint32{0xffff, 0xffff, 0xffff}, [3]uint32{r, g, b})
+}
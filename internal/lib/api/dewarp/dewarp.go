@@ -0,0 +1,62 @@
+package dewarp
+
+import (
+	"image"
+	"math"
+
+	"online-photo-editor/internal/lib/warp"
+)
+
+// DewarpParams corrects radial lens distortion. K is the distortion
+// coefficient: positive values straighten the lines bowed outward by the
+// barrel distortion of wide-angle lenses, negative ones the pincushion of
+// tele lenses. The source corners stay in the corners, so correcting
+// barrel distortion leaves the middle of the edges without source;
+// they're transparent unless Crop zooms in until they're filled.
+type DewarpParams struct {
+	K    float64 `json:"k" validate:"required,min=-0.9,max=0.9"`
+	Crop bool    `json:"crop"`
+}
+
+func (params *DewarpParams) DewarpImage(img image.Image) (image.Image, error) {
+	b := img.Bounds()
+	w, h := float64(b.Dx()), float64(b.Dy())
+	cx, cy := w/2, h/2
+	// Distances are in units of the half diagonal, so K means the same on
+	// any image size and the corners are at 1.
+	radius := math.Hypot(w, h) / 2
+	k := params.K
+
+	zoom := 1.0
+	if params.Crop {
+		zoom = cropZoom(k, min(w, h)/2/radius)
+	}
+
+	// An output pixel at distance r is sampled from (1+k)·zr/(1+k(zr)²),
+	// which maps 1 to itself and, for a positive k, pulls the middle in
+	// more than the edges.
+	return warp.RemapClipped(img, func(x, y float64) (float64, float64, bool) {
+		dx, dy := (x-cx)/radius, (y-cy)/radius
+		r := math.Hypot(dx, dy)
+		if r == 0 {
+			return x, y, true
+		}
+
+		zr := zoom * r
+		scale := (1 + k) * zr / (1 + k*zr*zr) / r
+		return cx + dx*scale*radius, cy + dy*scale*radius, true
+	}), nil
+}
+
+// cropZoom returns the zoom that fills the output when the nearest edge
+// of it is c from the center. Only a positive k leaves gaps there: the
+// middle of that edge must not be sampled from beyond the source, that
+// is (1+k)z ≤ 1 + kc²z², whose smaller root is the largest zoom that fits.
+func cropZoom(k, c float64) float64 {
+	if k <= 0 {
+		return 1
+	}
+
+	a := k * c * c
+	return ((1 + k) - math.Sqrt((1+k)*(1+k)-4*a)) / (2 * a)
+}
@@ -0,0 +1,104 @@
+package dewarp_test
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/dewarp"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lineX returns the mean x of the dark pixels of row y, -1 if none.
+func lineX(img image.Image, y int) float64 {
+	sum, n := 0.0, 0
+	for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+		if c := color.GrayModel.Convert(img.At(x, y)).(color.Gray); c.Y < 128 {
+			_, _, _, a := img.At(x, y).RGBA()
+			if a > 0 {
+				sum += float64(x)
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return -1
+	}
+	return sum / float64(n)
+}
+
+func TestDewarpImage_StraightensBarrel(t *testing.T) {
+	const w, h, k = 300, 200, 0.3
+	lineAt := 250.5
+
+	// The barrel distortion of a vertical line at x = 250.5 as the model
+	// has it: each of its points seen where the correction samples it.
+	radius := math.Hypot(w, h) / 2
+	src := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := range src.Pix {
+		src.Pix[i] = 255
+	}
+	for y := 0.5; y < h; y += 0.25 {
+		dx, dy := (lineAt-w/2)/radius, (y-h/2)/radius
+		r := math.Hypot(dx, dy)
+		scale := (1 + k) / (1 + k*r*r)
+		sx, sy := w/2+dx*scale*radius, h/2+dy*scale*radius
+		for x := int(sx) - 1; x <= int(sx)+1; x++ {
+			if x >= 0 && x < w && int(sy) >= 0 && int(sy) < h {
+				src.Set(x, int(sy), color.Black)
+			}
+		}
+	}
+
+	// The distorted line bows outward, its middle farther from the center
+	// than its ends.
+	mid, top := lineX(src, h/2), lineX(src, 30)
+	require.Greater(t, mid, top+3)
+
+	params := dewarp.DewarpParams{K: k}
+	out, err := params.DewarpImage(src)
+	require.NoError(t, err)
+
+	for _, y := range []int{30, 60, 100, 140, 170} {
+		assert.InDelta(t, lineAt, lineX(out, y)+0.5, 1.5, "row %d", y)
+	}
+}
+
+func TestDewarpImage_Crop(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 120, 80))
+	for i := range src.Pix {
+		src.Pix[i] = 200
+	}
+
+	params := dewarp.DewarpParams{K: 0.4}
+	out, err := params.DewarpImage(src)
+	require.NoError(t, err)
+
+	// Uncropped, the middle of the edges is left without source.
+	_, _, _, a := out.At(60, 0).RGBA()
+	assert.Zero(t, a)
+	_, _, _, a = out.At(0, 0).RGBA()
+	assert.NotZero(t, a, "the corners stay")
+
+	params.Crop = true
+	out, err = params.DewarpImage(src)
+	require.NoError(t, err)
+
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 120; x++ {
+			if _, _, _, a := out.At(x, y).RGBA(); a == 0 {
+				t.Fatalf("pixel %d,%d is transparent after crop", x, y)
+			}
+		}
+	}
+
+	// A pincushion correction has nothing to crop.
+	params = dewarp.DewarpParams{K: -0.4}
+	out, err = params.DewarpImage(src)
+	require.NoError(t, err)
+	_, _, _, a = out.At(60, 0).RGBA()
+	assert.NotZero(t, a)
+}
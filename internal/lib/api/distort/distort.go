@@ -0,0 +1,66 @@
+package distort
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"online-photo-editor/internal/lib/warp"
+)
+
+const (
+	TypeBulge = "bulge"
+	TypePinch = "pinch"
+)
+
+// DistortParams warps the disc of Radius pixels around CenterX, CenterY
+// like a lens: a bulge magnifies its middle, a pinch shrinks it. Strength
+// is how far it goes, the rim stays in place, so the image outside the
+// disc is left as it is.
+type DistortParams struct {
+	Type     string  `json:"type" validate:"required,oneof=bulge pinch"`
+	Strength float64 `json:"strength" validate:"required,gt=0,max=1"`
+	CenterX  int     `json:"center_x" validate:"min=0"`
+	CenterY  int     `json:"center_y" validate:"min=0"`
+	Radius   int     `json:"radius" validate:"required,min=1,max=10000"`
+}
+
+// CheckBounds reports whether the center lies in a width×height image.
+func (params *DistortParams) CheckBounds(width, height int) error {
+	const op = "api.distort.CheckBounds"
+
+	if params.CenterX >= width || params.CenterY >= height {
+		return fmt.Errorf("%s center is outside the image", op)
+	}
+
+	return nil
+}
+
+func (params *DistortParams) DistortImage(img image.Image) (image.Image, error) {
+	b := img.Bounds()
+	if err := params.CheckBounds(b.Dx(), b.Dy()); err != nil {
+		return nil, err
+	}
+
+	cx, cy := float64(params.CenterX)+0.5, float64(params.CenterY)+0.5
+	radius := float64(params.Radius)
+
+	// A pixel at distance d from the center is sampled from radius *
+	// (d/radius)^exp: closer to the center for a bulge, farther for a
+	// pinch.
+	exp := 1 + params.Strength
+	if params.Type == TypePinch {
+		exp = 1 / exp
+	}
+
+	return warp.Remap(img, func(x, y float64) (float64, float64, bool) {
+		dx, dy := x-cx, y-cy
+		d := math.Hypot(dx, dy)
+		if d >= radius || d == 0 {
+			return 0, 0, false
+		}
+
+		scale := math.Pow(d/radius, exp) * radius / d
+		return cx + dx*scale, cy + dy*scale, true
+	}), nil
+}
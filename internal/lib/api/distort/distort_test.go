@@ -0,0 +1,71 @@
+package distort_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/distort"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var red = color.NRGBA{R: 255, A: 255}
+
+// target is a white image with a red dot of radius 10 in the middle and
+// a red ring 70 to 75 pixels out.
+func target() *image.NRGBA {
+	img := imaging.New(200, 200, color.White)
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 200; x++ {
+			dx, dy := float64(x)+0.5-100.5, float64(y)+0.5-100.5
+			if d := dx*dx + dy*dy; d < 10*10 || d >= 70*70 && d < 75*75 {
+				img.SetNRGBA(x, y, red)
+			}
+		}
+	}
+	return img
+}
+
+func TestDistortImage(t *testing.T) {
+	img := target()
+
+	cases := []struct {
+		typ string
+		// inside is a distance from the center that is red after the
+		// distortion, outside one that is white.
+		inside, outside int
+	}{
+		// The dot grows to about 24 pixels.
+		{typ: distort.TypeBulge, inside: 20, outside: 28},
+		// The dot shrinks to under 2 pixels.
+		{typ: distort.TypePinch, inside: 0, outside: 5},
+	}
+
+	for _, tc := range cases {
+		params := distort.DistortParams{Type: tc.typ, Strength: 1, CenterX: 100, CenterY: 100, Radius: 60}
+
+		out, err := params.DistortImage(img)
+		require.NoError(t, err, tc.typ)
+		got := out.(*image.NRGBA)
+
+		assert.Equal(t, red, got.NRGBAAt(100+tc.inside, 100), tc.typ)
+		assert.Equal(t, red, got.NRGBAAt(100, 100-tc.inside), tc.typ)
+		assert.Equal(t, color.NRGBA{R: 255, G: 255, B: 255, A: 255}, got.NRGBAAt(100+tc.outside, 100), tc.typ)
+		assert.Equal(t, color.NRGBA{R: 255, G: 255, B: 255, A: 255}, got.NRGBAAt(100, 100-tc.outside), tc.typ)
+
+		// The ring and everything else outside the radius didn't move.
+		for _, p := range []image.Point{{0, 0}, {172, 100}, {100, 28}, {50, 50}, {199, 199}, {161, 100}} {
+			assert.Equal(t, img.NRGBAAt(p.X, p.Y), got.NRGBAAt(p.X, p.Y), "%s %v", tc.typ, p)
+		}
+	}
+}
+
+func TestDistortImage_CenterOutside(t *testing.T) {
+	params := distort.DistortParams{Type: distort.TypeBulge, Strength: 0.5, CenterX: 10, CenterY: 200, Radius: 5}
+
+	_, err := params.DistortImage(target())
+	assert.Error(t, err)
+}
@@ -0,0 +1,36 @@
+package exposure
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"online-photo-editor/internal/lib/colors"
+
+	"github.com/disintegration/imaging"
+)
+
+type ExposureParams struct {
+	EV float64 `json:"ev" validate:"min=-10,max=10"`
+}
+
+// ExposureImage scales the light in the image by 2^EV, so +1 doubles it.
+// The multiplication happens in linear light and values past white are
+// clipped. EV 0 returns the image unchanged.
+func (params *ExposureParams) ExposureImage(img image.Image) (image.Image, error) {
+	if params.EV == 0 {
+		return img, nil
+	}
+
+	factor := math.Pow(2, params.EV)
+
+	var lut [256]uint8
+	for i := range lut {
+		linear := colors.ToLinear(float64(i)/255) * factor
+		lut[i] = uint8(math.Round(colors.ToSRGB(math.Min(1, linear)) * 255))
+	}
+
+	return imaging.AdjustFunc(img, func(c color.NRGBA) color.NRGBA {
+		return color.NRGBA{R: lut[c.R], G: lut[c.G], B: lut[c.B], A: c.A}
+	}), nil
+}
@@ -0,0 +1,31 @@
+package exposure_test
+
+import (
+	"image/color"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/exposure"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExposureParams_ExposureImage(t *testing.T) {
+	// sRGB 118 is about 18% grey in linear light.
+	src := imaging.New(2, 2, color.NRGBA{R: 118, G: 118, B: 250, A: 255})
+
+	params := exposure.ExposureParams{EV: 1}
+	out, err := params.ExposureImage(src)
+	require.NoError(t, err)
+
+	c := color.NRGBAModel.Convert(out.At(0, 0)).(color.NRGBA)
+	assert.InDelta(t, 161, c.R, 1, "+1 EV doubles linear light")
+	assert.Equal(t, uint8(255), c.B, "highlights clip at white")
+	assert.Equal(t, uint8(255), c.A)
+
+	params = exposure.ExposureParams{EV: 0}
+	same, err := params.ExposureImage(src)
+	require.NoError(t, err)
+	assert.Same(t, src, same)
+}
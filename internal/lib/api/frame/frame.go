@@ -0,0 +1,59 @@
+package frame
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	FitExact = "exact"
+	FitFill  = "fill"
+	FitFit   = "fit"
+)
+
+// FrameParams overlays the stored image FrameName, a frame or template
+// with a transparent window, on the photo, like the borders of a photo
+// booth. The frame is scaled, up or down, to the photo as Fit says, as
+// for resize modes: exact, the default, stretches it to the photo, fill
+// covers the photo and crops what sticks out, fit keeps it whole and
+// centers it.
+type FrameParams struct {
+	FrameName string `json:"frame_name" validate:"required,max=100,image_name"`
+	Fit       string `json:"fit,omitempty" validate:"omitempty,oneof=exact fill fit"`
+}
+
+// FrameImage draws frame, the loaded FrameName, over img.
+func (params *FrameParams) FrameImage(img image.Image, frame image.Image) (image.Image, error) {
+	const op = "api.frame.FrameImage"
+
+	if frame == nil {
+		return nil, fmt.Errorf("%s frame image is missing", op)
+	}
+
+	dst := imaging.Clone(img)
+	width, height := dst.Bounds().Dx(), dst.Bounds().Dy()
+
+	var sized *image.NRGBA
+	switch params.Fit {
+	case FitFill:
+		sized = imaging.Fill(frame, width, height, imaging.Center, imaging.CatmullRom)
+	case FitFit:
+		fb := frame.Bounds()
+		scale := math.Min(float64(width)/float64(fb.Dx()), float64(height)/float64(fb.Dy()))
+		w := max(1, int(math.Round(float64(fb.Dx())*scale)))
+		h := max(1, int(math.Round(float64(fb.Dy())*scale)))
+		sized = imaging.Resize(frame, w, h, imaging.CatmullRom)
+	default:
+		sized = imaging.Resize(frame, width, height, imaging.CatmullRom)
+	}
+
+	fb := sized.Bounds()
+	at := image.Pt((width-fb.Dx())/2, (height-fb.Dy())/2)
+	draw.Draw(dst, image.Rectangle{Min: at, Max: at.Add(fb.Size())}, sized, fb.Min, draw.Over)
+
+	return dst, nil
+}
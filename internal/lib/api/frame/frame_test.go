@@ -0,0 +1,80 @@
+package frame_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/frame"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	photoColor = color.NRGBA{R: 30, G: 120, B: 200, A: 255}
+	frameColor = color.NRGBA{R: 250, G: 210, B: 0, A: 255}
+)
+
+// window is a size×size frame with a border a quarter of size wide around
+// a transparent window.
+func window(size int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if x < size/4 || y < size/4 || x >= size-size/4 || y >= size-size/4 {
+				img.SetNRGBA(x, y, frameColor)
+			}
+		}
+	}
+	return img
+}
+
+func TestFrameParams_FrameImage(t *testing.T) {
+	params := frame.FrameParams{FrameName: "frame.png"}
+	photo := imaging.New(80, 40, photoColor)
+
+	// The frame is scaled up to the photo.
+	out, err := params.FrameImage(photo, window(20))
+	require.NoError(t, err)
+	img := imaging.Clone(out)
+
+	assert.Equal(t, image.Rect(0, 0, 80, 40), img.Bounds())
+	assert.Equal(t, frameColor, img.NRGBAAt(2, 2), "the opaque border covers the photo")
+	assert.Equal(t, frameColor, img.NRGBAAt(77, 37))
+	assert.Equal(t, photoColor, img.NRGBAAt(40, 20), "the window shows the photo")
+}
+
+func TestFrameParams_FrameImage_Fit(t *testing.T) {
+	photo := imaging.New(80, 40, photoColor)
+
+	params := frame.FrameParams{FrameName: "frame.png", Fit: frame.FitFit}
+	out, err := params.FrameImage(photo, window(20))
+	require.NoError(t, err)
+	img := imaging.Clone(out)
+
+	// A 40×40 frame centered on the photo, which shows on either side.
+	assert.Equal(t, photoColor, img.NRGBAAt(10, 20))
+	assert.Equal(t, frameColor, img.NRGBAAt(22, 20))
+	assert.Equal(t, photoColor, img.NRGBAAt(40, 20))
+	assert.Equal(t, frameColor, img.NRGBAAt(57, 20))
+	assert.Equal(t, photoColor, img.NRGBAAt(70, 20))
+
+	params.Fit = frame.FitFill
+	out, err = params.FrameImage(photo, window(20))
+	require.NoError(t, err)
+	img = imaging.Clone(out)
+
+	// An 80×80 frame cropped to its middle 40 rows: the top and bottom
+	// borders are cut off.
+	assert.Equal(t, frameColor, img.NRGBAAt(5, 20))
+	assert.Equal(t, photoColor, img.NRGBAAt(40, 2))
+}
+
+func TestFrameParams_FrameImage_Missing(t *testing.T) {
+	params := frame.FrameParams{FrameName: "frame.png"}
+
+	_, err := params.FrameImage(imaging.New(10, 10, photoColor), nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,68 @@
+package framerate
+
+import (
+	"errors"
+	"image/gif"
+	"math"
+	"time"
+)
+
+// maxDelay is the longest delay a GIF frame can store, in hundredths of
+// a second.
+const maxDelay = math.MaxUint16
+
+var (
+	ErrDelayTooShort = errors.New("frame delays must stay at least 10ms")
+	ErrDelayTooLong  = errors.New("frame delays must not exceed 655.35s")
+)
+
+// FrameRateParams retimes an animation. Exactly one is given: Speed scales
+// every delay proportionally (2 plays twice as fast), while Delay, in
+// milliseconds, and FPS set every frame to the same delay.
+type FrameRateParams struct {
+	Speed float64 `json:"speed,omitempty" validate:"required_without_all=Delay FPS,excluded_with=Delay FPS,omitempty,gt=0,max=100"`
+	Delay int     `json:"delay,omitempty" validate:"required_without_all=Speed FPS,excluded_with=Speed FPS,omitempty,min=10,max=655350"`
+	FPS   float64 `json:"fps,omitempty" validate:"required_without_all=Speed Delay,excluded_with=Speed Delay,omitempty,gt=0,max=100"`
+}
+
+// Retime replaces the frame delays of anim. GIF stores delays in
+// hundredths of a second, so new delays are rounded to those. With Speed,
+// frames that had no delay keep none.
+func (params *FrameRateParams) Retime(anim *gif.GIF) error {
+	delays := make([]int, len(anim.Delay))
+
+	for i, delay := range anim.Delay {
+		switch {
+		case params.Speed > 0:
+			if delay == 0 {
+				continue
+			}
+			delays[i] = int(math.Round(float64(delay) / params.Speed))
+		case params.FPS > 0:
+			delays[i] = int(math.Round(100 / params.FPS))
+		default:
+			delays[i] = int(math.Round(float64(params.Delay) / 10))
+		}
+
+		if delays[i] < 1 {
+			return ErrDelayTooShort
+		}
+		if delays[i] > maxDelay {
+			return ErrDelayTooLong
+		}
+	}
+
+	anim.Delay = delays
+
+	return nil
+}
+
+// Duration returns how long one loop of anim plays.
+func Duration(anim *gif.GIF) time.Duration {
+	var total int
+	for _, delay := range anim.Delay {
+		total += delay
+	}
+
+	return time.Duration(total) * 10 * time.Millisecond
+}
@@ -0,0 +1,117 @@
+package gradientmap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"online-photo-editor/internal/lib/colors"
+
+	"github.com/disintegration/imaging"
+)
+
+// Stop is a color of the gradient at Pos, from 0 for black to 1 for
+// white.
+type Stop struct {
+	Pos   float64 `json:"pos" validate:"min=0,max=1"`
+	Color string  `json:"color" validate:"required,max=20"`
+}
+
+// GradientMapParams recolors the image by its luminance, like a gradient
+// map in photo editors: every pixel takes the color of Stops at its
+// luminance, blended between the stops around it. Stops are sorted by
+// Pos, from 0 to 1. With Bands the luminance is posterized into that many
+// flat bands first, for a screen-print look. Alpha is kept, scaled by the
+// alpha of the color.
+type GradientMapParams struct {
+	Stops []Stop `json:"stops" validate:"required,min=2,max=16,dive"`
+	Bands int    `json:"bands,omitempty" validate:"omitempty,min=2,max=256"`
+}
+
+// CheckBounds reports whether the stops make a gradient: their colors
+// parse and they are sorted by position, from 0 to 1.
+func (params *GradientMapParams) CheckBounds(width, height int) error {
+	_, err := params.stops()
+	return err
+}
+
+// stops returns the parsed colors of Stops.
+func (params *GradientMapParams) stops() ([]color.NRGBA, error) {
+	const op = "api.gradientmap.stops"
+
+	if len(params.Stops) < 2 {
+		return nil, fmt.Errorf("%s at least 2 stops are required", op)
+	}
+	if first, last := params.Stops[0].Pos, params.Stops[len(params.Stops)-1].Pos; first != 0 || last != 1 {
+		return nil, fmt.Errorf("%s stops must run from pos 0 to pos 1", op)
+	}
+
+	parsed := make([]color.NRGBA, len(params.Stops))
+	for i, stop := range params.Stops {
+		if i > 0 && stop.Pos < params.Stops[i-1].Pos {
+			return nil, fmt.Errorf("%s stop %d is before the one preceding it", op, i)
+		}
+
+		c, err := colors.Parse(stop.Color)
+		if err != nil {
+			return nil, fmt.Errorf("%s: stop %d: %w", op, i, err)
+		}
+		parsed[i] = c
+	}
+
+	return parsed, nil
+}
+
+// at returns the color of the gradient at t, in [0, 1].
+func (params *GradientMapParams) at(stops []color.NRGBA, t float64) color.NRGBA {
+	i := 1
+	for i < len(stops)-1 && params.Stops[i].Pos < t {
+		i++
+	}
+
+	from, to := params.Stops[i-1].Pos, params.Stops[i].Pos
+	w := 0.0
+	if to > from {
+		w = min(max((t-from)/(to-from), 0), 1)
+	}
+
+	a, b := stops[i-1], stops[i]
+	mix := func(x, y uint8) uint8 {
+		return uint8(math.Round(float64(x) + (float64(y)-float64(x))*w))
+	}
+	return color.NRGBA{R: mix(a.R, b.R), G: mix(a.G, b.G), B: mix(a.B, b.B), A: mix(a.A, b.A)}
+}
+
+func (params *GradientMapParams) GradientMapImage(img image.Image) (image.Image, error) {
+	const op = "api.gradientmap.GradientMapImage"
+
+	stops, err := params.stops()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The gradient color of every 8-bit luminance.
+	var lut [256]color.NRGBA
+	for l := range lut {
+		t := float64(l) / 255
+		if params.Bands > 0 {
+			band := min(math.Floor(t*float64(params.Bands)), float64(params.Bands-1))
+			t = band / float64(params.Bands-1)
+		}
+		lut[l] = params.at(stops, t)
+	}
+
+	dst := imaging.Clone(img)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		p := dst.Pix[i : i+4 : i+4]
+
+		l := 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+		c := lut[uint8(math.Round(l))]
+
+		p[0], p[1], p[2] = c.R, c.G, c.B
+		p[3] = uint8((int(p[3])*int(c.A) + 127) / 255)
+	}
+
+	return dst, nil
+}
@@ -0,0 +1,81 @@
+package gradientmap_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/gradientmap"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ramp is a 256 pixel wide gray ramp, half transparent on its second row.
+func ramp() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 256, 2))
+	for x := 0; x < 256; x++ {
+		img.SetNRGBA(x, 0, color.NRGBA{R: uint8(x), G: uint8(x), B: uint8(x), A: 255})
+		img.SetNRGBA(x, 1, color.NRGBA{R: uint8(x), G: uint8(x), B: uint8(x), A: 128})
+	}
+	return img
+}
+
+func TestGradientMapParams_GradientMapImage(t *testing.T) {
+	params := gradientmap.GradientMapParams{Stops: []gradientmap.Stop{
+		{Pos: 0, Color: "#000080"},
+		{Pos: 0.5, Color: "#ff0000"},
+		{Pos: 1, Color: "#ffff00"},
+	}}
+	require.NoError(t, params.CheckBounds(256, 2))
+
+	out, err := params.GradientMapImage(ramp())
+	require.NoError(t, err)
+	img := imaging.Clone(out)
+
+	assert.Equal(t, color.NRGBA{B: 0x80, A: 255}, img.NRGBAAt(0, 0))
+	assert.Equal(t, color.NRGBA{R: 255, G: 1, A: 255}, img.NRGBAAt(128, 0), "just past the middle stop")
+	assert.Equal(t, color.NRGBA{R: 255, G: 255, A: 255}, img.NRGBAAt(255, 0))
+	// Halfway between the first two stops.
+	assert.Equal(t, color.NRGBA{R: 128, B: 0x40, A: 255}, img.NRGBAAt(64, 0))
+	assert.Equal(t, uint8(128), img.NRGBAAt(64, 1).A, "alpha is kept")
+}
+
+func TestGradientMapParams_GradientMapImage_Bands(t *testing.T) {
+	params := gradientmap.GradientMapParams{
+		Stops: []gradientmap.Stop{{Pos: 0, Color: "black"}, {Pos: 1, Color: "white"}},
+		Bands: 3,
+	}
+
+	out, err := params.GradientMapImage(ramp())
+	require.NoError(t, err)
+	img := imaging.Clone(out)
+
+	levels := map[uint8]bool{}
+	for x := 0; x < 256; x++ {
+		levels[img.NRGBAAt(x, 0).R] = true
+	}
+	assert.Equal(t, map[uint8]bool{0: true, 128: true, 255: true}, levels)
+	assert.Equal(t, uint8(0), img.NRGBAAt(80, 0).R)
+	assert.Equal(t, uint8(128), img.NRGBAAt(90, 0).R)
+	assert.Equal(t, uint8(255), img.NRGBAAt(200, 0).R)
+}
+
+func TestGradientMapParams_CheckBounds(t *testing.T) {
+	for name, stops := range map[string][]gradientmap.Stop{
+		"unsorted":      {{Pos: 0, Color: "black"}, {Pos: 0.8, Color: "red"}, {Pos: 0.2, Color: "blue"}, {Pos: 1, Color: "white"}},
+		"not from 0":    {{Pos: 0.1, Color: "black"}, {Pos: 1, Color: "white"}},
+		"not to 1":      {{Pos: 0, Color: "black"}, {Pos: 0.9, Color: "white"}},
+		"unknown color": {{Pos: 0, Color: "black"}, {Pos: 1, Color: "no-such-color"}},
+		"single stop":   {{Pos: 0, Color: "black"}},
+	} {
+		params := gradientmap.GradientMapParams{Stops: stops}
+		assert.Error(t, params.CheckBounds(10, 10), name)
+	}
+
+	hard := gradientmap.GradientMapParams{Stops: []gradientmap.Stop{
+		{Pos: 0, Color: "black"}, {Pos: 0.5, Color: "black"}, {Pos: 0.5, Color: "white"}, {Pos: 1, Color: "white"},
+	}}
+	assert.NoError(t, hard.CheckBounds(10, 10), "a hard edge")
+}
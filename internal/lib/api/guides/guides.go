@@ -0,0 +1,101 @@
+package guides
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	GuideThirds = "thirds"
+	GuideCrop   = "crop"
+)
+
+var (
+	lineColor  = color.NRGBA{R: 255, G: 255, B: 255, A: 200}
+	shadeColor = color.NRGBA{A: 128}
+)
+
+// GuidesParams draws editor guides on top of the image. It is meant for
+// previews only: the guides are burned into the output, the source image
+// is never touched.
+type GuidesParams struct {
+	Guide  string `json:"guide" validate:"required,oneof=thirds crop"`
+	X      int    `json:"x" validate:"min=0"`
+	Y      int    `json:"y" validate:"min=0"`
+	Width  int    `json:"width" validate:"min=0"`
+	Height int    `json:"height" validate:"min=0"`
+}
+
+// CheckBounds reports whether the crop guide fits a width×height image.
+func (params *GuidesParams) CheckBounds(width, height int) error {
+	const op = "api.guides.CheckBounds"
+
+	if params.Guide != GuideCrop {
+		return nil
+	}
+
+	if params.Width == 0 || params.Height == 0 {
+		return fmt.Errorf("%s crop guide requires width and height", op)
+	}
+
+	if params.X+params.Width > width || params.Y+params.Height > height {
+		return fmt.Errorf("%s crop area exceeds image boundaries", op)
+	}
+
+	return nil
+}
+
+func (params *GuidesParams) GuidesImage(img image.Image) (image.Image, error) {
+	if err := params.CheckBounds(img.Bounds().Dx(), img.Bounds().Dy()); err != nil {
+		return nil, err
+	}
+
+	dst := imaging.Clone(img)
+	thickness := max(1, min(dst.Bounds().Dx(), dst.Bounds().Dy())/300)
+
+	switch params.Guide {
+	case GuideThirds:
+		drawThirds(dst, dst.Bounds(), thickness)
+	case GuideCrop:
+		rect := image.Rect(params.X, params.Y, params.X+params.Width, params.Y+params.Height)
+		shadeOutside(dst, rect)
+		drawThirds(dst, rect, thickness)
+		drawOutline(dst, rect, thickness)
+	}
+
+	return dst, nil
+}
+
+func drawThirds(dst draw.Image, rect image.Rectangle, thickness int) {
+	for i := 1; i <= 2; i++ {
+		x := rect.Min.X + rect.Dx()*i/3
+		y := rect.Min.Y + rect.Dy()*i/3
+
+		drawRect(dst, image.Rect(x, rect.Min.Y, x+thickness, rect.Max.Y), lineColor)
+		drawRect(dst, image.Rect(rect.Min.X, y, rect.Max.X, y+thickness), lineColor)
+	}
+}
+
+func drawOutline(dst draw.Image, rect image.Rectangle, thickness int) {
+	drawRect(dst, image.Rect(rect.Min.X, rect.Min.Y, rect.Max.X, rect.Min.Y+thickness), lineColor)
+	drawRect(dst, image.Rect(rect.Min.X, rect.Max.Y-thickness, rect.Max.X, rect.Max.Y), lineColor)
+	drawRect(dst, image.Rect(rect.Min.X, rect.Min.Y, rect.Min.X+thickness, rect.Max.Y), lineColor)
+	drawRect(dst, image.Rect(rect.Max.X-thickness, rect.Min.Y, rect.Max.X, rect.Max.Y), lineColor)
+}
+
+func shadeOutside(dst draw.Image, rect image.Rectangle) {
+	b := dst.Bounds()
+
+	drawRect(dst, image.Rect(b.Min.X, b.Min.Y, b.Max.X, rect.Min.Y), shadeColor)
+	drawRect(dst, image.Rect(b.Min.X, rect.Max.Y, b.Max.X, b.Max.Y), shadeColor)
+	drawRect(dst, image.Rect(b.Min.X, rect.Min.Y, rect.Min.X, rect.Max.Y), shadeColor)
+	drawRect(dst, image.Rect(rect.Max.X, rect.Min.Y, b.Max.X, rect.Max.Y), shadeColor)
+}
+
+func drawRect(dst draw.Image, rect image.Rectangle, c color.Color) {
+	draw.Draw(dst, rect.Intersect(dst.Bounds()), image.NewUniform(c), image.Point{}, draw.Over)
+}
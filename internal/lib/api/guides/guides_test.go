@@ -0,0 +1,140 @@
+package guides_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/guides"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gradient returns a w×h image with no two neighbouring pixels alike, so
+// a guide drawn a pixel off shows.
+func gradient(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(20 + x%200), G: uint8(20 + y%200), B: uint8(20 + (x+y)%200), A: 255})
+		}
+	}
+	return img
+}
+
+type mark int
+
+const (
+	untouched mark = iota
+	line
+	shade
+)
+
+// checkMarks asserts that every pixel of out is src's pixel lightened
+// where want says line, darkened where it says shade and unchanged
+// elsewhere.
+func checkMarks(t *testing.T, src *image.NRGBA, out image.Image, want func(x, y int) mark) {
+	t.Helper()
+	require.Equal(t, src.Bounds(), out.Bounds())
+
+	got := imaging.Clone(out)
+	for y := range src.Bounds().Dy() {
+		for x := range src.Bounds().Dx() {
+			s, o := src.NRGBAAt(x, y), got.NRGBAAt(x, y)
+
+			var ok bool
+			switch want(x, y) {
+			case line:
+				ok = o.R > s.R && o.G > s.G && o.B > s.B
+			case shade:
+				ok = o.R < s.R && o.G < s.G && o.B < s.B
+			default:
+				ok = o == s
+			}
+			if !ok {
+				t.Fatalf("pixel (%d, %d) is %v from %v, want mark %d", x, y, o, s, want(x, y))
+			}
+		}
+	}
+}
+
+func TestGuidesParams_GuidesImage_Thirds(t *testing.T) {
+	src := gradient(300, 240)
+	orig := imaging.Clone(src)
+
+	params := guides.GuidesParams{Guide: guides.GuideThirds}
+	out, err := params.GuidesImage(src)
+	require.NoError(t, err)
+
+	checkMarks(t, src, out, func(x, y int) mark {
+		if x == 100 || x == 200 || y == 80 || y == 160 {
+			return line
+		}
+		return untouched
+	})
+	assert.Equal(t, orig.Pix, src.Pix, "the source is left as it was")
+}
+
+func TestGuidesParams_GuidesImage_Crop(t *testing.T) {
+	src := gradient(300, 240)
+
+	// The crop is 120×90 at (30, 60): thirds at x 70 and 110 and y 90
+	// and 120, the outline on its outermost pixels.
+	params := guides.GuidesParams{Guide: guides.GuideCrop, X: 30, Y: 60, Width: 120, Height: 90}
+	out, err := params.GuidesImage(src)
+	require.NoError(t, err)
+
+	crop := image.Rect(30, 60, 150, 150)
+	checkMarks(t, src, out, func(x, y int) mark {
+		switch {
+		case !image.Pt(x, y).In(crop):
+			return shade
+		case x == 30 || x == 70 || x == 110 || x == 149 || y == 60 || y == 90 || y == 120 || y == 149:
+			return line
+		default:
+			return untouched
+		}
+	})
+}
+
+func TestGuidesParams_GuidesImage_Thickness(t *testing.T) {
+	src := gradient(900, 600)
+
+	// Lines are a pixel thick for every 300 of the shorter side.
+	params := guides.GuidesParams{Guide: guides.GuideThirds}
+	out, err := params.GuidesImage(src)
+	require.NoError(t, err)
+
+	checkMarks(t, src, out, func(x, y int) mark {
+		if x == 300 || x == 301 || x == 600 || x == 601 || y == 200 || y == 201 || y == 400 || y == 401 {
+			return line
+		}
+		return untouched
+	})
+}
+
+func TestGuidesParams_CheckBounds(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  guides.GuidesParams
+		wantErr bool
+	}{
+		{"thirds", guides.GuidesParams{Guide: guides.GuideThirds}, false},
+		{"crop inside", guides.GuidesParams{Guide: guides.GuideCrop, X: 10, Y: 10, Width: 290, Height: 230}, false},
+		{"crop without size", guides.GuidesParams{Guide: guides.GuideCrop, X: 10, Y: 10}, true},
+		{"crop past the edge", guides.GuidesParams{Guide: guides.GuideCrop, X: 10, Y: 10, Width: 291, Height: 100}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.params.CheckBounds(300, 240)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
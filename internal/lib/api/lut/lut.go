@@ -0,0 +1,200 @@
+package lut
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// Ext is the extension of the LUT files Parse reads.
+const Ext = ".cube"
+
+// The sizes of 3D LUTs Parse accepts. Common ones are 17, 33 and 65.
+const (
+	MinSize = 2
+	MaxSize = 65
+)
+
+// maxFileSize bounds how much of a file Parse reads, a 65³ LUT being
+// about 8 MB of text.
+const maxFileSize = 16 << 20
+
+var ErrInvalidCube = errors.New("invalid cube file")
+
+// Cube is a parsed 3D LUT: Size³ output colors, red varying fastest,
+// for inputs spread evenly from DomainMin to DomainMax.
+type Cube struct {
+	Size      int
+	DomainMin [3]float64
+	DomainMax [3]float64
+	Table     [][3]float64
+}
+
+// Parse reads a LUT in the Adobe/Resolve .cube format. 1D LUTs, sizes
+// outside MinSize to MaxSize and tables of the wrong length fail with
+// ErrInvalidCube.
+func Parse(r io.Reader) (*Cube, error) {
+	const op = "api.lut.Parse"
+
+	cube := &Cube{DomainMax: [3]float64{1, 1, 1}}
+
+	scanner := bufio.NewScanner(io.LimitReader(r, maxFileSize))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		// Data lines start with a number, keywords with a letter.
+		if c := fields[0][0]; c == '-' || c == '.' || c == '+' || (c >= '0' && c <= '9') {
+			if cube.Size == 0 {
+				return nil, fmt.Errorf("%s: %w: line %d: data before LUT_3D_SIZE", op, ErrInvalidCube, line)
+			}
+			rgb, err := parseTriple(fields)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w: line %d: %w", op, ErrInvalidCube, line, err)
+			}
+			cube.Table = append(cube.Table, rgb)
+			continue
+		}
+
+		var err error
+		switch fields[0] {
+		case "LUT_3D_SIZE":
+			if len(fields) != 2 {
+				err = fmt.Errorf("LUT_3D_SIZE takes one value")
+				break
+			}
+			cube.Size, err = strconv.Atoi(fields[1])
+			if err == nil && (cube.Size < MinSize || cube.Size > MaxSize) {
+				err = fmt.Errorf("size %d is outside %d to %d", cube.Size, MinSize, MaxSize)
+			}
+		case "LUT_1D_SIZE":
+			err = fmt.Errorf("1D LUTs are not supported")
+		case "DOMAIN_MIN":
+			cube.DomainMin, err = parseTriple(fields[1:])
+		case "DOMAIN_MAX":
+			cube.DomainMax, err = parseTriple(fields[1:])
+		default:
+			// TITLE and the keywords of other tools don't change the
+			// table.
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w: line %d: %w", op, ErrInvalidCube, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if cube.Size == 0 {
+		return nil, fmt.Errorf("%s: %w: no LUT_3D_SIZE", op, ErrInvalidCube)
+	}
+	if want := cube.Size * cube.Size * cube.Size; len(cube.Table) != want {
+		return nil, fmt.Errorf("%s: %w: %d entries, LUT_3D_SIZE %d needs %d", op, ErrInvalidCube, len(cube.Table), cube.Size, want)
+	}
+	for i := range cube.DomainMin {
+		if cube.DomainMax[i] <= cube.DomainMin[i] {
+			return nil, fmt.Errorf("%s: %w: DOMAIN_MAX must be above DOMAIN_MIN", op, ErrInvalidCube)
+		}
+	}
+
+	return cube, nil
+}
+
+func parseTriple(fields []string) ([3]float64, error) {
+	var out [3]float64
+	if len(fields) != 3 {
+		return out, fmt.Errorf("want 3 values, got %d", len(fields))
+	}
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return out, fmt.Errorf("invalid value %q", field)
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+// lookup maps an RGB color, on a 0-1 scale, through the LUT with
+// trilinear interpolation between the 8 surrounding entries.
+func (cube *Cube) lookup(rgb [3]float64) [3]float64 {
+	n := cube.Size
+	var idx [3]int
+	var frac [3]float64
+	for i, v := range rgb {
+		pos := (v - cube.DomainMin[i]) / (cube.DomainMax[i] - cube.DomainMin[i]) * float64(n-1)
+		pos = min(max(pos, 0), float64(n-1))
+		idx[i] = min(int(pos), n-2)
+		frac[i] = pos - float64(idx[i])
+	}
+
+	var out [3]float64
+	for corner := 0; corner < 8; corner++ {
+		weight := 1.0
+		var at [3]int
+		for i := range at {
+			if corner&(1<<i) != 0 {
+				at[i] = idx[i] + 1
+				weight *= frac[i]
+			} else {
+				at[i] = idx[i]
+				weight *= 1 - frac[i]
+			}
+		}
+		if weight == 0 {
+			continue
+		}
+
+		entry := cube.Table[at[0]+at[1]*n+at[2]*n*n]
+		out[0] += entry[0] * weight
+		out[1] += entry[1] * weight
+		out[2] += entry[2] * weight
+	}
+
+	return out
+}
+
+// LUTParams grades the image with the 3D LUT stored as LUTName, a .cube
+// file. Strength, from 0 for the original colors to 1 for the graded
+// ones, blends the two; left out it is 1.
+type LUTParams struct {
+	LUTName  string   `json:"lut_name" validate:"required,max=100,image_name"`
+	Strength *float64 `json:"strength,omitempty" validate:"omitempty,min=0,max=1"`
+}
+
+// LUTImage maps every pixel of img through cube, the parsed LUTName.
+// Alpha is left unchanged.
+func (params *LUTParams) LUTImage(img image.Image, cube *Cube) (image.Image, error) {
+	const op = "api.lut.LUTImage"
+
+	if cube == nil {
+		return nil, fmt.Errorf("%s: no lut", op)
+	}
+
+	strength := 1.0
+	if params.Strength != nil {
+		strength = *params.Strength
+	}
+
+	return imaging.AdjustFunc(img, func(c color.NRGBA) color.NRGBA {
+		in := [3]float64{float64(c.R) / 255, float64(c.G) / 255, float64(c.B) / 255}
+		graded := cube.lookup(in)
+
+		var out [3]uint8
+		for i := range out {
+			v := in[i] + (graded[i]-in[i])*strength
+			out[i] = uint8(math.Round(min(max(v, 0), 1) * 255))
+		}
+		return color.NRGBA{R: out[0], G: out[1], B: out[2], A: c.A}
+	}), nil
+}
@@ -0,0 +1,121 @@
+package lut_test
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/lut"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cubeFile writes a size³ .cube whose entries are fn of the input color.
+func cubeFile(size int, fn func(r, g, b float64) (float64, float64, float64)) string {
+	var sb strings.Builder
+	sb.WriteString("# test lut\nTITLE \"test\"\n")
+	fmt.Fprintf(&sb, "LUT_3D_SIZE %d\n\n", size)
+	step := 1 / float64(size-1)
+	for b := 0; b < size; b++ {
+		for g := 0; g < size; g++ {
+			for r := 0; r < size; r++ {
+				or, og, ob := fn(float64(r)*step, float64(g)*step, float64(b)*step)
+				fmt.Fprintf(&sb, "%.6f %.6f %.6f\n", or, og, ob)
+			}
+		}
+	}
+	return sb.String()
+}
+
+func identity(r, g, b float64) (float64, float64, float64) { return r, g, b }
+
+func invert(r, g, b float64) (float64, float64, float64) { return 1 - r, 1 - g, 1 - b }
+
+// swap maps red to blue, green to red and blue to green.
+func swap(r, g, b float64) (float64, float64, float64) { return g, b, r }
+
+func TestParse(t *testing.T) {
+	cube, err := lut.Parse(strings.NewReader(cubeFile(17, identity)))
+	require.NoError(t, err)
+	assert.Equal(t, 17, cube.Size)
+	assert.Len(t, cube.Table, 17*17*17)
+	assert.Equal(t, [3]float64{1, 1, 1}, cube.DomainMax)
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"no size":     "0 0 0\n",
+		"too small":   "LUT_3D_SIZE 1\n0 0 0\n",
+		"too big":     "LUT_3D_SIZE 129\n",
+		"1d":          "LUT_1D_SIZE 4\n0 0 0\n",
+		"short table": strings.Join(strings.Split(cubeFile(2, identity), "\n")[:8], "\n"),
+		"bad value":   "LUT_3D_SIZE 2\n0 0 x\n",
+		"two values":  "LUT_3D_SIZE 2\n0 0\n",
+		"bad domain":  "DOMAIN_MIN 1 1 1\nDOMAIN_MAX 0 0 0\n" + cubeFile(2, identity),
+	}
+
+	for name, data := range tests {
+		_, err := lut.Parse(strings.NewReader(data))
+		assert.ErrorIs(t, err, lut.ErrInvalidCube, name)
+	}
+}
+
+func TestLUTImage(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 255})
+	img.SetNRGBA(1, 0, color.NRGBA{R: 10, G: 100, B: 200, A: 128})
+	img.SetNRGBA(2, 0, color.NRGBA{R: 77, G: 77, B: 77, A: 255})
+
+	quarter, zero := 0.25, 0.0
+
+	tests := []struct {
+		name     string
+		fn       func(r, g, b float64) (float64, float64, float64)
+		size     int
+		strength *float64
+		want     []color.NRGBA
+	}{
+		{
+			name: "identity", fn: identity, size: 2,
+			want: []color.NRGBA{{R: 255, A: 255}, {R: 10, G: 100, B: 200, A: 128}, {R: 77, G: 77, B: 77, A: 255}},
+		},
+		{
+			// Linear maps are exact with trilinear interpolation.
+			name: "invert", fn: invert, size: 17,
+			want: []color.NRGBA{{G: 255, B: 255, A: 255}, {R: 245, G: 155, B: 55, A: 128}, {R: 178, G: 178, B: 178, A: 255}},
+		},
+		{
+			// Red moves to the blue channel.
+			name: "swap", fn: swap, size: 2,
+			want: []color.NRGBA{{B: 255, A: 255}, {R: 100, G: 200, B: 10, A: 128}, {R: 77, G: 77, B: 77, A: 255}},
+		},
+		{
+			// A quarter of the way from the original to the inverse.
+			name: "partial strength", fn: invert, size: 2, strength: &quarter,
+			want: []color.NRGBA{{R: 191, G: 64, B: 64, A: 255}, {R: 69, G: 114, B: 164, A: 128}, {R: 102, G: 102, B: 102, A: 255}},
+		},
+		{
+			// A strength of 0 keeps the original colors.
+			name: "zero strength", fn: invert, size: 2, strength: &zero,
+			want: []color.NRGBA{{R: 255, A: 255}, {R: 10, G: 100, B: 200, A: 128}, {R: 77, G: 77, B: 77, A: 255}},
+		},
+	}
+
+	for _, tc := range tests {
+		cube, err := lut.Parse(strings.NewReader(cubeFile(tc.size, tc.fn)))
+		require.NoError(t, err, tc.name)
+
+		params := lut.LUTParams{LUTName: "look.cube", Strength: tc.strength}
+		out, err := params.LUTImage(img, cube)
+		require.NoError(t, err, tc.name)
+
+		got := imaging.Clone(out)
+		for x, want := range tc.want {
+			assert.Equal(t, want, got.NRGBAAt(x, 0), "%s: pixel %d", tc.name, x)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package motionblur_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/motionblur"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withEdges is black with a white right half, and a white bottom band
+// across the left half: a vertical edge at x=20 and a horizontal one at
+// y=30 away from it.
+func withEdges() *image.NRGBA {
+	img := imaging.New(40, 40, color.Black)
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 40; x++ {
+			if x >= 20 || y >= 30 {
+				img.SetNRGBA(x, y, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+			}
+		}
+	}
+	return img
+}
+
+func gray(img image.Image, x, y int) uint8 {
+	return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA).R
+}
+
+func TestMotionBlurImage_Horizontal(t *testing.T) {
+	params := motionblur.MotionBlurParams{Distance: 9}
+
+	out, err := params.MotionBlurImage(withEdges())
+	require.NoError(t, err)
+
+	// The vertical edge is smeared across the distance...
+	assert.Equal(t, uint8(0), gray(out, 15, 10))
+	assert.InDelta(t, 4*255/9, int(gray(out, 19, 10)), 1)
+	assert.InDelta(t, 5*255/9, int(gray(out, 20, 10)), 1)
+	assert.Equal(t, uint8(255), gray(out, 24, 10))
+
+	// ...but the horizontal one stays sharp.
+	assert.Equal(t, uint8(0), gray(out, 8, 29))
+	assert.Equal(t, uint8(255), gray(out, 8, 30))
+}
+
+func TestMotionBlurImage_Vertical(t *testing.T) {
+	params := motionblur.MotionBlurParams{Distance: 9, Angle: 90}
+
+	out, err := params.MotionBlurImage(withEdges())
+	require.NoError(t, err)
+
+	assert.Equal(t, uint8(0), gray(out, 19, 5))
+	assert.Equal(t, uint8(255), gray(out, 20, 5))
+
+	assert.InDelta(t, 4*255/9, int(gray(out, 8, 29)), 1)
+	assert.InDelta(t, 5*255/9, int(gray(out, 8, 30)), 1)
+}
+
+func TestMotionBlurImage_Transparent(t *testing.T) {
+	// Transparent pixels don't darken the color they are averaged with.
+	img := image.NewNRGBA(image.Rect(0, 0, 10, 1))
+	img.SetNRGBA(5, 0, color.NRGBA{R: 255, A: 255})
+	params := motionblur.MotionBlurParams{Distance: 3}
+
+	out, err := params.MotionBlurImage(img)
+	require.NoError(t, err)
+
+	assert.Equal(t, color.NRGBA{R: 255, A: 85}, out.(*image.NRGBA).NRGBAAt(4, 0))
+}
@@ -0,0 +1,117 @@
+package perspective
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"online-photo-editor/internal/lib/warp"
+)
+
+const maxOutputSize = 8000
+
+// PerspectiveParams maps the quadrilateral of Corners, given as x, y in
+// the order top-left, top-right, bottom-right, bottom-left, to an upright
+// OutputWidth×OutputHeight rectangle, straightening a photographed
+// document or whiteboard. An output size left 0 is the mean length of the
+// quadrilateral's edges along it.
+type PerspectiveParams struct {
+	Corners      [4][2]float64 `json:"corners" validate:"required"`
+	OutputWidth  int           `json:"output_width,omitempty" validate:"omitempty,min=1,max=8000"`
+	OutputHeight int           `json:"output_height,omitempty" validate:"omitempty,min=1,max=8000"`
+}
+
+func (params *PerspectiveParams) CheckBounds(width, height int) error {
+	const op = "api.perspective.CheckBounds"
+
+	for i, p := range params.Corners {
+		if p[0] < 0 || p[1] < 0 || p[0] > float64(width) || p[1] > float64(height) {
+			return fmt.Errorf("%s corner %d is outside the image", op, i)
+		}
+		for _, q := range params.Corners[:i] {
+			if p == q {
+				return fmt.Errorf("%s corners must be distinct", op)
+			}
+		}
+	}
+
+	// The turns at every corner are all the same way round only for a
+	// convex quadrilateral in order; any other leaves the mapping without
+	// an inverse somewhere inside.
+	sign := 0.0
+	for i := range params.Corners {
+		a, b, c := params.Corners[i], params.Corners[(i+1)%4], params.Corners[(i+2)%4]
+		cross := (b[0]-a[0])*(c[1]-b[1]) - (b[1]-a[1])*(c[0]-b[0])
+		if cross == 0 || sign != 0 && (cross > 0) != (sign > 0) {
+			return fmt.Errorf("%s corners must form a convex quadrilateral in order", op)
+		}
+		sign = cross
+	}
+
+	return nil
+}
+
+func (params *PerspectiveParams) OutputSize(width, height int) (int, int, bool) {
+	outWidth, outHeight := params.size()
+	return outWidth, outHeight, true
+}
+
+func (params *PerspectiveParams) size() (int, int) {
+	c := params.Corners
+	dist := func(a, b [2]float64) float64 {
+		return math.Hypot(b[0]-a[0], b[1]-a[1])
+	}
+
+	width, height := params.OutputWidth, params.OutputHeight
+	if width == 0 {
+		width = int(math.Round((dist(c[0], c[1]) + dist(c[3], c[2])) / 2))
+	}
+	if height == 0 {
+		height = int(math.Round((dist(c[0], c[3]) + dist(c[1], c[2])) / 2))
+	}
+
+	return min(max(width, 1), maxOutputSize), min(max(height, 1), maxOutputSize)
+}
+
+func (params *PerspectiveParams) PerspectiveImage(img image.Image) (image.Image, error) {
+	b := img.Bounds()
+	if err := params.CheckBounds(b.Dx(), b.Dy()); err != nil {
+		return nil, err
+	}
+
+	width, height := params.size()
+	h := squareToQuad(params.Corners)
+
+	return warp.RemapTo(img, width, height, func(x, y float64) (float64, float64, bool) {
+		u, v := x/float64(width), y/float64(height)
+		d := h[6]*u + h[7]*v + 1
+		return (h[0]*u + h[1]*v + h[2]) / d, (h[3]*u + h[4]*v + h[5]) / d, true
+	}), nil
+}
+
+// squareToQuad returns the homography a, b, c, d, e, f, g, h taking u, v of
+// the unit square to ((au+bv+c)/(gu+hv+1), (du+ev+f)/(gu+hv+1)), with the
+// corners of the square going to q in order. q must be convex.
+func squareToQuad(q [4][2]float64) [8]float64 {
+	x0, y0 := q[0][0], q[0][1]
+	x1, y1 := q[1][0], q[1][1]
+	x2, y2 := q[2][0], q[2][1]
+	x3, y3 := q[3][0], q[3][1]
+
+	sx, sy := x0-x1+x2-x3, y0-y1+y2-y3
+	if sx == 0 && sy == 0 {
+		return [8]float64{x1 - x0, x3 - x0, x0, y1 - y0, y3 - y0, y0, 0, 0}
+	}
+
+	dx1, dy1 := x1-x2, y1-y2
+	dx2, dy2 := x3-x2, y3-y2
+	det := dx1*dy2 - dx2*dy1
+	g := (sx*dy2 - dx2*sy) / det
+	h := (dx1*sy - sx*dy1) / det
+
+	return [8]float64{
+		x1 - x0 + g*x1, x3 - x0 + h*x3, x0,
+		y1 - y0 + g*y1, y3 - y0 + h*y3, y0,
+		g, h,
+	}
+}
@@ -0,0 +1,113 @@
+package perspective_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/perspective"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// positions returns a 256×256 image whose red and green are the x and y
+// of every pixel, so the output tells where it was sampled from.
+func positions() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), A: 255})
+		}
+	}
+	return img
+}
+
+func sourceOf(img image.Image, x, y int) (float64, float64) {
+	c := img.(*image.NRGBA).NRGBAAt(x, y)
+	return float64(c.R) + 0.5, float64(c.G) + 0.5
+}
+
+func TestPerspectiveImage_Rectangle(t *testing.T) {
+	params := perspective.PerspectiveParams{
+		Corners:      [4][2]float64{{10, 20}, {70, 20}, {70, 60}, {10, 60}},
+		OutputWidth:  60,
+		OutputHeight: 40,
+	}
+
+	out, err := params.PerspectiveImage(positions())
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 60, 40), out.Bounds())
+
+	for _, p := range []image.Point{{0, 0}, {59, 0}, {59, 39}, {0, 39}, {30, 17}} {
+		c := out.(*image.NRGBA).NRGBAAt(p.X, p.Y)
+		assert.Equal(t, color.NRGBA{R: uint8(10 + p.X), G: uint8(20 + p.Y), A: 255}, c, "pixel %v", p)
+	}
+}
+
+func TestPerspectiveImage_Keystone(t *testing.T) {
+	// A page photographed from below: its top edge is shorter.
+	params := perspective.PerspectiveParams{
+		Corners: [4][2]float64{{80, 40}, {176, 40}, {236, 220}, {20, 220}},
+	}
+
+	w, h, ok := params.OutputSize(256, 256)
+	require.True(t, ok)
+	assert.Equal(t, 156, w)
+	assert.Equal(t, 190, h)
+
+	out, err := params.PerspectiveImage(positions())
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, w, h), out.Bounds())
+
+	near := func(p image.Point, x, y float64) {
+		sx, sy := sourceOf(out, p.X, p.Y)
+		assert.InDelta(t, x, sx, 2, "x of %v", p)
+		assert.InDelta(t, y, sy, 2, "y of %v", p)
+	}
+	near(image.Pt(0, 0), 80, 40)
+	near(image.Pt(w-1, 0), 176, 40)
+	near(image.Pt(w-1, h-1), 236, 220)
+	near(image.Pt(0, h-1), 20, 220)
+	// Straight lines stay straight: the middle column is the symmetry axis.
+	for y := 0; y < h; y += 20 {
+		sx, _ := sourceOf(out, w/2, y)
+		assert.InDelta(t, 128, sx, 1.5, "row %d", y)
+	}
+
+	// Perspective foreshortening: the far top half of the page spans less
+	// of the source than the near bottom half.
+	_, top := sourceOf(out, w/2, 0)
+	_, mid := sourceOf(out, w/2, h/2)
+	_, bottom := sourceOf(out, w/2, h-1)
+	assert.Less(t, mid-top, bottom-mid)
+}
+
+func TestCheckBounds(t *testing.T) {
+	tests := []struct {
+		name    string
+		corners [4][2]float64
+		wantErr string
+	}{
+		{"valid", [4][2]float64{{0, 0}, {100, 0}, {100, 100}, {0, 100}}, ""},
+		{"outside", [4][2]float64{{0, 0}, {101, 0}, {100, 100}, {0, 100}}, "corner 1 is outside the image"},
+		{"duplicate", [4][2]float64{{0, 0}, {100, 0}, {100, 0}, {0, 100}}, "corners must be distinct"},
+		{"collinear", [4][2]float64{{0, 0}, {50, 0}, {100, 0}, {0, 100}}, "convex"},
+		{"crossed", [4][2]float64{{0, 0}, {100, 0}, {0, 100}, {100, 100}}, "convex"},
+		{"concave", [4][2]float64{{0, 0}, {100, 0}, {30, 30}, {0, 100}}, "convex"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := perspective.PerspectiveParams{Corners: tt.corners}
+			err := params.CheckBounds(100, 100)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
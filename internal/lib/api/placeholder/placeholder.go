@@ -0,0 +1,64 @@
+package placeholder
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	defaultWidth   = 20
+	defaultFormat  = "webp"
+	defaultQuality = 30
+)
+
+// PlaceholderParams makes a low-quality image placeholder: the image
+// shrunk to Width pixels wide, 20 by default, keeping its aspect ratio,
+// optionally blurred by Blur, to be saved as Format, webp by default, at
+// Quality, 30 by default. Browsers scale it up to the size of the image,
+// which blurs it further.
+type PlaceholderParams struct {
+	Width   int     `json:"width,omitempty" validate:"omitempty,min=4,max=64"`
+	Format  string  `json:"format,omitempty" validate:"omitempty,oneof=jpg jpeg webp"`
+	Quality int     `json:"quality,omitempty" validate:"omitempty,min=1,max=100"`
+	Blur    float64 `json:"blur,omitempty" validate:"min=0,max=10"`
+}
+
+func (params *PlaceholderParams) FileExt() string {
+	if params.Format == "" {
+		return "." + defaultFormat
+	}
+	return "." + params.Format
+}
+
+func (params *PlaceholderParams) EncodeQuality() int {
+	if params.Quality == 0 {
+		return defaultQuality
+	}
+	return params.Quality
+}
+
+// OutputSize returns the size of the placeholder of a width×height image.
+func (params *PlaceholderParams) OutputSize(width, height int) (int, int) {
+	outWidth := params.Width
+	if outWidth == 0 {
+		outWidth = defaultWidth
+	}
+	outWidth = min(outWidth, width)
+
+	return outWidth, max(1, int(math.Round(float64(height)*float64(outWidth)/float64(width))))
+}
+
+func (params *PlaceholderParams) PlaceholderImage(img image.Image) (image.Image, error) {
+	b := img.Bounds()
+	width, height := params.OutputSize(b.Dx(), b.Dy())
+
+	// Box is plenty for a few dozen pixels and cheap on a large source.
+	dst := imaging.Resize(img, width, height, imaging.Box)
+	if params.Blur > 0 {
+		dst = imaging.Blur(dst, params.Blur)
+	}
+
+	return dst, nil
+}
@@ -0,0 +1,33 @@
+package placeholder_test
+
+import (
+	"image"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/placeholder"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlaceholderParams_PlaceholderImage(t *testing.T) {
+	tests := []struct {
+		name          string
+		params        placeholder.PlaceholderParams
+		width, height int
+		want          image.Rectangle
+	}{
+		{"default width", placeholder.PlaceholderParams{}, 1600, 900, image.Rect(0, 0, 20, 11)},
+		{"width", placeholder.PlaceholderParams{Width: 32, Blur: 2}, 400, 800, image.Rect(0, 0, 32, 64)},
+		{"never upscales", placeholder.PlaceholderParams{}, 10, 5, image.Rect(0, 0, 10, 5)},
+		{"thin", placeholder.PlaceholderParams{}, 4000, 10, image.Rect(0, 0, 20, 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := tt.params.PlaceholderImage(image.NewNRGBA(image.Rect(0, 0, tt.width, tt.height)))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out.Bounds())
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package portrait
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	defaultAspectWidth  = 4
+	defaultAspectHeight = 5
+	defaultHeadroom     = 0.1
+
+	// eyeLine is how far down the face box the eyes are, as a fraction of
+	// its height.
+	eyeLine = 0.45
+	// thumbSide is the longest side faces are searched at.
+	thumbSide = 160
+	// minFaceArea is the smallest share of the image a face may cover.
+	minFaceArea = 0.005
+)
+
+// PortraitParams crops a headshot out of an image: the crop has an
+// AspectWidth:AspectHeight aspect ratio (4:5 by default), is centered on
+// the primary face and puts the eyes on the upper third line. Headroom is
+// the space left above the head, as a fraction of the crop height (0.1
+// by default); less headroom means a tighter crop. Faces are found by
+// skin tone, and the largest face-shaped skin region wins. Without one
+// the image is center-cropped to the aspect ratio.
+type PortraitParams struct {
+	AspectWidth  int     `json:"aspect_width,omitempty" validate:"required_with=AspectHeight,min=0,max=100"`
+	AspectHeight int     `json:"aspect_height,omitempty" validate:"required_with=AspectWidth,min=0,max=100"`
+	Headroom     float64 `json:"headroom,omitempty" validate:"min=0,max=0.3"`
+}
+
+func (params *PortraitParams) aspect() float64 {
+	if params.AspectWidth == 0 || params.AspectHeight == 0 {
+		return float64(defaultAspectWidth) / defaultAspectHeight
+	}
+	return float64(params.AspectWidth) / float64(params.AspectHeight)
+}
+
+func (params *PortraitParams) headroom() float64 {
+	if params.Headroom == 0 {
+		return defaultHeadroom
+	}
+	return params.Headroom
+}
+
+// OutputSize reports the size as unknown: it depends on where the face is.
+func (params *PortraitParams) OutputSize(width, height int) (int, int, bool) {
+	return 0, 0, false
+}
+
+func (params *PortraitParams) PortraitImage(img image.Image) (image.Image, error) {
+	bounds := img.Bounds()
+
+	face, ok := findFace(img)
+	if !ok {
+		width, height := fitAspect(bounds.Dx(), bounds.Dy(), params.aspect())
+		return imaging.CropCenter(img, width, height), nil
+	}
+
+	return imaging.Crop(img, params.cropRect(bounds, face)), nil
+}
+
+// fitAspect returns the size of the largest rectangle with the aspect
+// ratio that fits a width×height image.
+func fitAspect(width, height int, aspect float64) (int, int) {
+	if float64(width)/float64(height) > aspect {
+		return max(int(math.Round(float64(height)*aspect)), 1), height
+	}
+	return width, max(int(math.Round(float64(width)/aspect)), 1)
+}
+
+// cropRect places the crop around face. The eyes sit on the upper third
+// line and the top of the head headroom below the top edge, which fixes
+// the crop height; a crop too big for the image is shrunk to fit and
+// then shifted as little as possible to stay inside.
+func (params *PortraitParams) cropRect(bounds, face image.Rectangle) image.Rectangle {
+	aspect := params.aspect()
+	eyes := float64(face.Min.Y) + eyeLine*float64(face.Dy())
+
+	height := eyeLine * float64(face.Dy()) / (1.0/3 - params.headroom())
+	width := height * aspect
+
+	maxWidth, maxHeight := fitAspect(bounds.Dx(), bounds.Dy(), aspect)
+	if width > float64(maxWidth) || height > float64(maxHeight) {
+		width, height = float64(maxWidth), float64(maxHeight)
+	}
+
+	w, h := max(int(math.Round(width)), 1), max(int(math.Round(height)), 1)
+	centerX := float64(face.Min.X+face.Max.X) / 2
+
+	x := clamp(int(math.Round(centerX-width/2)), bounds.Min.X, bounds.Max.X-w)
+	y := clamp(int(math.Round(eyes-height/3)), bounds.Min.Y, bounds.Max.Y-h)
+
+	return image.Rect(x, y, x+w, y+h)
+}
+
+func clamp(v, lo, hi int) int {
+	return min(max(v, lo), hi)
+}
+
+// findFace returns the bounding box, in img coordinates, of the largest
+// skin region shaped like a face.
+func findFace(img image.Image) (image.Rectangle, bool) {
+	bounds := img.Bounds()
+
+	thumb := imaging.Fit(img, thumbSide, thumbSide, imaging.Box)
+	tw, th := thumb.Rect.Dx(), thumb.Rect.Dy()
+	if tw == 0 || th == 0 {
+		return image.Rectangle{}, false
+	}
+
+	mask := make([]bool, tw*th)
+	for y := 0; y < th; y++ {
+		for x := 0; x < tw; x++ {
+			p := thumb.Pix[y*thumb.Stride+x*4 : y*thumb.Stride+x*4+4 : y*thumb.Stride+x*4+4]
+			mask[y*tw+x] = p[3] >= 128 && isSkin(p[0], p[1], p[2])
+		}
+	}
+
+	var best image.Rectangle
+	bestArea := int(minFaceArea * float64(tw*th))
+	for _, region := range regions(mask, tw) {
+		if region.area < bestArea || !faceShaped(region) {
+			continue
+		}
+		best, bestArea = region.box, region.area
+	}
+	if best.Empty() {
+		return image.Rectangle{}, false
+	}
+
+	// Skin below the chin is usually the neck; faces are about 1.3 times
+	// as tall as they are wide.
+	if maxHeight := int(math.Ceil(1.3 * float64(best.Dx()))); best.Dy() > maxHeight {
+		best.Max.Y = best.Min.Y + maxHeight
+	}
+
+	sx, sy := float64(bounds.Dx())/float64(tw), float64(bounds.Dy())/float64(th)
+	return image.Rect(
+		bounds.Min.X+int(float64(best.Min.X)*sx),
+		bounds.Min.Y+int(float64(best.Min.Y)*sy),
+		bounds.Min.X+int(math.Ceil(float64(best.Max.X)*sx)),
+		bounds.Min.Y+int(math.Ceil(float64(best.Max.Y)*sy)),
+	), true
+}
+
+// isSkin tells skin tones apart by their chroma, which unlike brightness
+// varies little between skin colors (Chai and Ngan).
+func isSkin(r, g, b uint8) bool {
+	_, cb, cr := color.RGBToYCbCr(r, g, b)
+	return cb >= 77 && cb <= 127 && cr >= 133 && cr <= 173
+}
+
+type region struct {
+	box  image.Rectangle
+	area int
+}
+
+// faceShaped rejects regions too wide, too tall or too hollow for a face,
+// such as arms, bare shoulders or scattered skin-colored background.
+func faceShaped(r region) bool {
+	ratio := float64(r.box.Dy()) / float64(r.box.Dx())
+	fill := float64(r.area) / float64(r.box.Dx()*r.box.Dy())
+	return ratio >= 0.8 && ratio <= 2.5 && fill >= 0.4
+}
+
+// regions returns the 4-connected regions of set cells in a mask of rows
+// width cells long.
+func regions(mask []bool, width int) []region {
+	seen := make([]bool, len(mask))
+	var out []region
+	var stack []int
+
+	for start, set := range mask {
+		if !set || seen[start] {
+			continue
+		}
+
+		r := region{box: image.Rect(start%width, start/width, start%width+1, start/width+1)}
+		seen[start] = true
+		stack = append(stack[:0], start)
+
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			x, y := i%width, i/width
+			r.area++
+			r.box = r.box.Union(image.Rect(x, y, x+1, y+1))
+
+			for _, n := range [4]int{i - width, i + width, i - 1, i + 1} {
+				if n < 0 || n >= len(mask) || (n == i-1 && x == 0) || (n == i+1 && x == width-1) {
+					continue
+				}
+				if mask[n] && !seen[n] {
+					seen[n] = true
+					stack = append(stack, n)
+				}
+			}
+		}
+
+		out = append(out, r)
+	}
+
+	return out
+}
@@ -0,0 +1,97 @@
+package portrait_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/portrait"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var skin = color.NRGBA{R: 224, G: 172, B: 140, A: 255}
+
+// withFace draws a skin-colored ellipse, rx×ry, centered at cx, cy on a
+// blue background.
+func withFace(width, height, cx, cy, rx, ry int) *image.NRGBA {
+	img := imaging.New(width, height, color.NRGBA{R: 40, G: 60, B: 160, A: 255})
+	for y := cy - ry; y <= cy+ry; y++ {
+		for x := cx - rx; x <= cx+rx; x++ {
+			dx, dy := float64(x-cx)/float64(rx), float64(y-cy)/float64(ry)
+			if dx*dx+dy*dy <= 1 {
+				img.SetNRGBA(x, y, skin)
+			}
+		}
+	}
+	return img
+}
+
+func TestPortraitImage_Face(t *testing.T) {
+	// A 100×130 face in the top right of a landscape image.
+	img := withFace(1200, 800, 850, 260, 50, 65)
+	params := portrait.PortraitParams{}
+
+	out, err := params.PortraitImage(img)
+	require.NoError(t, err)
+
+	b := out.Bounds()
+	assert.InDelta(t, 4.0/5, float64(b.Dx())/float64(b.Dy()), 0.01)
+	assert.Less(t, b.Dy(), 800, "tighter than the whole image")
+
+	// Find the face again in the output: it is centered horizontally and
+	// its eyes are near the upper third line.
+	face := image.Rectangle{}
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			if color.NRGBAModel.Convert(out.At(x, y)) == skin {
+				face = face.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	require.False(t, face.Empty())
+
+	assert.InDelta(t, b.Dx()/2, (face.Min.X+face.Max.X)/2, 3)
+	eyes := float64(face.Min.Y) + 0.45*float64(face.Dy())
+	assert.InDelta(t, float64(b.Dy())/3, eyes, float64(b.Dy())*0.03)
+	assert.InDelta(t, 0.1*float64(b.Dy()), face.Min.Y, float64(b.Dy())*0.03, "headroom")
+}
+
+func TestPortraitImage_Headroom(t *testing.T) {
+	img := withFace(1000, 1000, 500, 500, 60, 78)
+
+	tight := portrait.PortraitParams{Headroom: 0.05}
+	loose := portrait.PortraitParams{Headroom: 0.2}
+
+	tightOut, err := tight.PortraitImage(img)
+	require.NoError(t, err)
+	looseOut, err := loose.PortraitImage(img)
+	require.NoError(t, err)
+
+	assert.Less(t, tightOut.Bounds().Dy(), looseOut.Bounds().Dy())
+}
+
+func TestPortraitImage_EdgeOfImage(t *testing.T) {
+	// The face is too close to the top for the eyes to reach the third
+	// line; the crop stays inside the image.
+	img := withFace(800, 1000, 100, 70, 50, 65)
+	params := portrait.PortraitParams{AspectWidth: 1, AspectHeight: 1}
+
+	out, err := params.PortraitImage(img)
+	require.NoError(t, err)
+
+	b := out.Bounds()
+	assert.Equal(t, b.Dx(), b.Dy())
+	assert.Equal(t, skin, color.NRGBAModel.Convert(out.At(100, 70)))
+}
+
+func TestPortraitImage_NoFace(t *testing.T) {
+	img := imaging.New(900, 600, color.NRGBA{R: 40, G: 60, B: 160, A: 255})
+	params := portrait.PortraitParams{AspectWidth: 2, AspectHeight: 3}
+
+	out, err := params.PortraitImage(img)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 400, 600), out.Bounds())
+}
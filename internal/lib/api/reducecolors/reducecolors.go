@@ -0,0 +1,245 @@
+package reducecolors
+
+import (
+	"image"
+	"image/color"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// bits is the precision per channel colors are grouped at while building
+// the palette and looking up the nearest entry.
+const bits = 5
+
+// ReduceColorsParams snaps an image to a palette of at most Colors
+// colors picked by median cut, but keeps the output RGBA rather than
+// indexed. Dither spreads the rounding error over neighbouring pixels
+// (Floyd-Steinberg) so gradients don't band. Alpha is kept as is.
+type ReduceColorsParams struct {
+	Colors int  `json:"colors" validate:"required,min=2,max=256"`
+	Dither bool `json:"dither"`
+}
+
+// bucket accumulates the pixels whose color falls in one cell of the
+// reduced-precision color cube.
+type bucket struct {
+	count            int
+	sumR, sumG, sumB int
+}
+
+func (b bucket) mean() [3]int {
+	return [3]int{b.sumR / b.count, b.sumG / b.count, b.sumB / b.count}
+}
+
+func (params *ReduceColorsParams) ReduceColorsImage(img image.Image) (image.Image, error) {
+	dst := imaging.Clone(img)
+
+	if fewColors(dst, params.Colors) {
+		return dst, nil
+	}
+
+	palette := medianCut(histogram(dst), params.Colors)
+
+	// nearest caches the palette entry of every cell that has been looked
+	// up, -1 for the ones that haven't.
+	nearest := make([]int, 1<<(3*bits))
+	for i := range nearest {
+		nearest[i] = -1
+	}
+	lookup := func(r, g, b int) color.NRGBA {
+		cell := cellOf(r, g, b)
+		if nearest[cell] < 0 {
+			nearest[cell] = closest(palette, r, g, b)
+		}
+		return palette[nearest[cell]]
+	}
+
+	if params.Dither {
+		dither(dst, lookup)
+		return dst, nil
+	}
+
+	for i := 0; i < len(dst.Pix); i += 4 {
+		p := dst.Pix[i : i+4 : i+4]
+		c := lookup(int(p[0]), int(p[1]), int(p[2]))
+		p[0], p[1], p[2] = c.R, c.G, c.B
+	}
+
+	return dst, nil
+}
+
+// fewColors reports whether img already has at most n distinct colors.
+func fewColors(img *image.NRGBA, n int) bool {
+	seen := make(map[[3]uint8]struct{}, n+1)
+
+	for i := 0; i < len(img.Pix); i += 4 {
+		seen[[3]uint8{img.Pix[i], img.Pix[i+1], img.Pix[i+2]}] = struct{}{}
+		if len(seen) > n {
+			return false
+		}
+	}
+
+	return true
+}
+
+func cellOf(r, g, b int) int {
+	const shift = 8 - bits
+	return r>>shift<<(2*bits) | g>>shift<<bits | b>>shift
+}
+
+// histogram groups the visible pixels of img into cells. Fully
+// transparent pixels don't show, so they don't get a say in the palette.
+func histogram(img *image.NRGBA) []bucket {
+	cells := make(map[int]*bucket)
+
+	for i := 0; i < len(img.Pix); i += 4 {
+		if img.Pix[i+3] == 0 {
+			continue
+		}
+		r, g, b := int(img.Pix[i]), int(img.Pix[i+1]), int(img.Pix[i+2])
+
+		cell := cells[cellOf(r, g, b)]
+		if cell == nil {
+			cell = &bucket{}
+			cells[cellOf(r, g, b)] = cell
+		}
+		cell.count++
+		cell.sumR += r
+		cell.sumG += g
+		cell.sumB += b
+	}
+
+	buckets := make([]bucket, 0, len(cells))
+	for _, cell := range cells {
+		buckets = append(buckets, *cell)
+	}
+
+	return buckets
+}
+
+// medianCut splits the buckets into at most n boxes, each time halving the
+// box with the widest channel range at its pixel-weighted median, and
+// returns the mean color of every box.
+func medianCut(buckets []bucket, n int) []color.NRGBA {
+	if len(buckets) == 0 {
+		return []color.NRGBA{{A: 255}}
+	}
+
+	boxes := [][]bucket{buckets}
+
+	for len(boxes) < n {
+		widest, channel, extent := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			if c, e := widestChannel(box); e > extent {
+				widest, channel, extent = i, c, e
+			}
+		}
+		if widest < 0 {
+			break
+		}
+
+		box := boxes[widest]
+		sort.Slice(box, func(i, j int) bool { return box[i].mean()[channel] < box[j].mean()[channel] })
+
+		total := 0
+		for _, b := range box {
+			total += b.count
+		}
+
+		split, seen := 1, box[0].count
+		for split < len(box)-1 && seen+box[split].count <= total/2 {
+			seen += box[split].count
+			split++
+		}
+
+		boxes[widest] = box[:split]
+		boxes = append(boxes, box[split:])
+	}
+
+	palette := make([]color.NRGBA, len(boxes))
+	for i, box := range boxes {
+		var sum bucket
+		for _, b := range box {
+			sum.count += b.count
+			sum.sumR += b.sumR
+			sum.sumG += b.sumG
+			sum.sumB += b.sumB
+		}
+		m := sum.mean()
+		palette[i] = color.NRGBA{R: uint8(m[0]), G: uint8(m[1]), B: uint8(m[2]), A: 255}
+	}
+
+	return palette
+}
+
+func widestChannel(box []bucket) (channel, extent int) {
+	for c := 0; c < 3; c++ {
+		lo, hi := 255, 0
+		for _, b := range box {
+			v := b.mean()[c]
+			lo, hi = min(lo, v), max(hi, v)
+		}
+		if hi-lo > extent {
+			channel, extent = c, hi-lo
+		}
+	}
+	return channel, extent
+}
+
+func closest(palette []color.NRGBA, r, g, b int) int {
+	best, bestDist := 0, -1
+	for i, c := range palette {
+		dr, dg, db := r-int(c.R), g-int(c.G), b-int(c.B)
+		if dist := dr*dr + dg*dg + db*db; bestDist < 0 || dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	return best
+}
+
+// dither maps every pixel of img with Floyd-Steinberg error diffusion.
+func dither(img *image.NRGBA, lookup func(r, g, b int) color.NRGBA) {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+
+	// Errors carried into the current and the next row, per channel.
+	cur := make([][3]int, width+2)
+	next := make([][3]int, width+2)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := img.Pix[y*img.Stride+x*4 : y*img.Stride+x*4+4 : y*img.Stride+x*4+4]
+
+			var want [3]int
+			for c := 0; c < 3; c++ {
+				want[c] = clamp(int(p[c]) + cur[x+1][c]/16)
+			}
+
+			got := lookup(want[0], want[1], want[2])
+			p[0], p[1], p[2] = got.R, got.G, got.B
+
+			// Transparent pixels don't show, so their error isn't spread.
+			if p[3] == 0 {
+				continue
+			}
+
+			for c, v := range [3]uint8{got.R, got.G, got.B} {
+				e := want[c] - int(v)
+				cur[x+2][c] += e * 7
+				next[x][c] += e * 3
+				next[x+1][c] += e * 5
+				next[x+2][c] += e
+			}
+		}
+
+		cur, next = next, cur
+		clear(next)
+	}
+}
+
+func clamp(v int) int {
+	return min(max(v, 0), 255)
+}
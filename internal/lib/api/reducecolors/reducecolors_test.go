@@ -0,0 +1,78 @@
+package reducecolors_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand/v2"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/reducecolors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noisyGradient looks like a photo to PNG: smooth overall, noisy up
+// close.
+func noisyGradient() *image.NRGBA {
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	img := image.NewNRGBA(image.Rect(0, 0, 128, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 128; x++ {
+			noise := rng.IntN(16)
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x + noise), G: uint8(y*3 + noise), B: uint8(x + y), A: uint8(255 - y)})
+		}
+	}
+	return img
+}
+
+func distinct(img *image.NRGBA) int {
+	seen := make(map[[3]uint8]bool)
+	for i := 0; i < len(img.Pix); i += 4 {
+		seen[[3]uint8{img.Pix[i], img.Pix[i+1], img.Pix[i+2]}] = true
+	}
+	return len(seen)
+}
+
+func pngSize(t *testing.T, img image.Image) int {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Len()
+}
+
+func TestReduceColorsParams_ReduceColorsImage(t *testing.T) {
+	src := noisyGradient()
+
+	for _, dither := range []bool{false, true} {
+		params := reducecolors.ReduceColorsParams{Colors: 16, Dither: dither}
+
+		out, err := params.ReduceColorsImage(src)
+		require.NoError(t, err)
+
+		nrgba, ok := out.(*image.NRGBA)
+		require.True(t, ok, "the output stays RGBA, not paletted")
+		assert.LessOrEqual(t, distinct(nrgba), 16)
+		if !dither {
+			assert.Less(t, pngSize(t, out), pngSize(t, src))
+		}
+
+		for y := 0; y < 64; y++ {
+			assert.Equal(t, uint8(255-y), nrgba.NRGBAAt(0, y).A, "alpha is kept")
+		}
+	}
+}
+
+func TestReduceColorsParams_ReduceColorsImage_FewColorsUnchanged(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{R: 10, G: 20, B: 30, A: 255})
+	src.SetNRGBA(1, 0, color.NRGBA{R: 12, G: 20, B: 30, A: 255})
+	src.SetNRGBA(2, 0, color.NRGBA{R: 200, B: 30, A: 128})
+
+	params := reducecolors.ReduceColorsParams{Colors: 4}
+	out, err := params.ReduceColorsImage(src)
+	require.NoError(t, err)
+	assert.Equal(t, src.Pix, out.(*image.NRGBA).Pix)
+}
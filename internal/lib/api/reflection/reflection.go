@@ -0,0 +1,78 @@
+package reflection
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	defaultHeight  = 0.3
+	defaultOpacity = 0.5
+	defaultFalloff = 1
+)
+
+// ReflectionParams appends a mirrored copy of the image below it that
+// fades to transparent, like a product standing on a glossy floor.
+// Height is the reflection height as a fraction of the image height,
+// Opacity its opacity at the top and Falloff the exponent of the fade:
+// 1 fades linearly, higher values fade out faster. Gap leaves that many
+// transparent pixels between the image and its reflection.
+type ReflectionParams struct {
+	Height  float64 `json:"height,omitempty" validate:"min=0,max=1"`
+	Opacity float64 `json:"opacity,omitempty" validate:"min=0,max=1"`
+	Falloff float64 `json:"falloff,omitempty" validate:"min=0,max=10"`
+	Gap     int     `json:"gap,omitempty" validate:"min=0,max=1000"`
+}
+
+func (params *ReflectionParams) OutputSize(width, height int) (int, int, bool) {
+	return width, height + params.Gap + params.reflectionHeight(height), true
+}
+
+// NeedsAlpha reports that the fade must be saved in a format with
+// transparency.
+func (params *ReflectionParams) NeedsAlpha() bool {
+	return true
+}
+
+func (params *ReflectionParams) reflectionHeight(height int) int {
+	fraction := params.Height
+	if fraction == 0 {
+		fraction = defaultHeight
+	}
+	return max(1, int(math.Round(float64(height)*fraction)))
+}
+
+func (params *ReflectionParams) ReflectionImage(img image.Image) (image.Image, error) {
+	src := imaging.Clone(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+
+	opacity := params.Opacity
+	if opacity == 0 {
+		opacity = defaultOpacity
+	}
+	falloff := params.Falloff
+	if falloff == 0 {
+		falloff = defaultFalloff
+	}
+
+	_, outH, _ := params.OutputSize(w, h)
+	dst := image.NewNRGBA(image.Rect(0, 0, w, outH))
+	copy(dst.Pix, src.Pix)
+
+	rh := params.reflectionHeight(h)
+	top := h + params.Gap
+	for i := 0; i < min(rh, h); i++ {
+		fade := opacity * math.Pow(1-float64(i)/float64(rh), falloff)
+
+		row := src.Pix[(h-1-i)*src.Stride : (h-1-i)*src.Stride+w*4]
+		out := dst.Pix[(top+i)*dst.Stride : (top+i)*dst.Stride+w*4]
+		copy(out, row)
+		for x := 3; x < len(out); x += 4 {
+			out[x] = uint8(math.Round(float64(out[x]) * fade))
+		}
+	}
+
+	return dst, nil
+}
@@ -0,0 +1,58 @@
+package reflection_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/reflection"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReflectionParams_ReflectionImage(t *testing.T) {
+	// Red on top, blue at the bottom.
+	src := image.NewNRGBA(image.Rect(0, 0, 20, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 20; x++ {
+			c := color.NRGBA{R: 255, A: 255}
+			if y >= 50 {
+				c = color.NRGBA{B: 255, A: 255}
+			}
+			src.SetNRGBA(x, y, c)
+		}
+	}
+
+	params := reflection.ReflectionParams{Height: 0.4, Opacity: 0.6, Gap: 5}
+	w, h, ok := params.OutputSize(20, 100)
+	require.True(t, ok)
+	assert.Equal(t, 20, w)
+	assert.Equal(t, 145, h)
+
+	out, err := params.ReflectionImage(src)
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 20, 145), out.Bounds())
+	img := out.(*image.NRGBA)
+
+	assert.Equal(t, color.NRGBA{R: 255, A: 255}, img.NRGBAAt(10, 0), "the image is kept")
+	assert.Equal(t, color.NRGBA{B: 255, A: 255}, img.NRGBAAt(10, 99))
+	assert.Equal(t, uint8(0), img.NRGBAAt(10, 102).A, "the gap is transparent")
+
+	// The reflection starts with the bottom row, mirrored, and fades out.
+	first := img.NRGBAAt(10, 105)
+	assert.Equal(t, uint8(255), first.B)
+	assert.Equal(t, uint8(153), first.A)
+	assert.Equal(t, uint8(77), img.NRGBAAt(10, 125).A, "half way down")
+	assert.Less(t, img.NRGBAAt(10, 144).A, uint8(5))
+}
+
+func TestReflectionParams_Defaults(t *testing.T) {
+	var params reflection.ReflectionParams
+	_, h, _ := params.OutputSize(10, 10)
+	assert.Equal(t, 13, h)
+
+	out, err := params.ReflectionImage(image.NewNRGBA(image.Rect(0, 0, 10, 10)))
+	require.NoError(t, err)
+	assert.Equal(t, 13, out.Bounds().Dy())
+}
@@ -0,0 +1,103 @@
+package replacebg
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// Subject is a rectangle, in pixels, known to hold the whole subject.
+type Subject struct {
+	X      int `json:"x" validate:"min=0"`
+	Y      int `json:"y" validate:"min=0"`
+	Width  int `json:"width" validate:"required,min=1"`
+	Height int `json:"height" validate:"required,min=1"`
+}
+
+// RemoveBgParams cuts the subject out of a near-uniform backdrop, leaving
+// the backdrop transparent. Tolerance is as for ReplaceBgParams. With a
+// Subject everything outside it is backdrop and the backdrop color is
+// taken from its edges instead of the image corners.
+type RemoveBgParams struct {
+	Tolerance int      `json:"tolerance,omitempty" validate:"min=0,max=255"`
+	Subject   *Subject `json:"subject,omitempty"`
+}
+
+func (params *RemoveBgParams) CheckBounds(width, height int) error {
+	const op = "api.replacebg.CheckBounds"
+
+	if s := params.Subject; s != nil && (s.X+s.Width > width || s.Y+s.Height > height) {
+		return fmt.Errorf("%s subject exceeds image boundaries", op)
+	}
+
+	return nil
+}
+
+// NeedsAlpha reports that the cutout must be saved in a format with
+// transparency.
+func (params *RemoveBgParams) NeedsAlpha() bool {
+	return true
+}
+
+func (params *RemoveBgParams) RemoveBgImage(img image.Image) (image.Image, error) {
+	b := img.Bounds()
+	if err := params.CheckBounds(b.Dx(), b.Dy()); err != nil {
+		return nil, err
+	}
+
+	src := imaging.Clone(img)
+
+	tolerance := params.Tolerance
+	if tolerance == 0 {
+		tolerance = defaultTolerance
+	}
+
+	region, ref := src.Bounds(), cornerColor(src)
+	if s := params.Subject; s != nil {
+		region = image.Rect(s.X, s.Y, s.X+s.Width, s.Y+s.Height)
+		ref = edgeColor(src, region)
+	}
+
+	mask := backdropMask(src, region, ref, float64(tolerance))
+	mask = imaging.Blur(mask, edgeSoftness)
+
+	for i := 0; i < len(src.Pix); i += 4 {
+		a := float64(src.Pix[i+3]) * (1 - float64(mask.Pix[i])/255)
+		src.Pix[i+3] = uint8(math.Round(a))
+	}
+
+	return src, nil
+}
+
+// edgeColor averages the pixels along the edges of region.
+func edgeColor(img *image.NRGBA, region image.Rectangle) color.NRGBA {
+	var sum [3]float64
+	var n float64
+
+	add := func(x, y int) {
+		p := img.Pix[y*img.Stride+x*4:]
+		sum[0] += float64(p[0])
+		sum[1] += float64(p[1])
+		sum[2] += float64(p[2])
+		n++
+	}
+
+	for x := region.Min.X; x < region.Max.X; x++ {
+		add(x, region.Min.Y)
+		add(x, region.Max.Y-1)
+	}
+	for y := region.Min.Y; y < region.Max.Y; y++ {
+		add(region.Min.X, y)
+		add(region.Max.X-1, y)
+	}
+
+	return color.NRGBA{
+		R: uint8(math.Round(sum[0] / n)),
+		G: uint8(math.Round(sum[1] / n)),
+		B: uint8(math.Round(sum[2] / n)),
+		A: 255,
+	}
+}
@@ -0,0 +1,48 @@
+package replacebg_test
+
+import (
+	"image/color"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/replacebg"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveBgParams_RemoveBgImage(t *testing.T) {
+	var params replacebg.RemoveBgParams
+
+	out, err := params.RemoveBgImage(product())
+	require.NoError(t, err)
+
+	assert.Equal(t, uint8(0), at(out, 2, 2).A, "backdrop is transparent")
+	assert.Equal(t, uint8(0), at(out, 110, 50).A)
+	assert.Equal(t, color.NRGBA{R: 200, G: 20, B: 30, A: 255}, at(out, 40, 50), "subject is kept")
+	assert.Equal(t, color.NRGBA{R: 250, G: 250, B: 250, A: 255}, at(out, 60, 50), "enclosed highlight is kept")
+}
+
+func TestRemoveBgParams_RemoveBgImage_Subject(t *testing.T) {
+	// A stray blue label beside the product isn't backdrop colored.
+	img := product()
+	for y := 40; y < 60; y++ {
+		for x := 100; x < 115; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{B: 200, A: 255})
+		}
+	}
+
+	var params replacebg.RemoveBgParams
+	out, err := params.RemoveBgImage(img)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(255), at(out, 107, 50).A, "kept without a subject")
+
+	params.Subject = &replacebg.Subject{X: 25, Y: 15, Width: 70, Height: 70}
+	out, err = params.RemoveBgImage(img)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0), at(out, 107, 50).A, "outside the subject is backdrop")
+	assert.Equal(t, uint8(0), at(out, 27, 17).A, "backdrop inside the subject")
+	assert.Equal(t, color.NRGBA{R: 200, G: 20, B: 30, A: 255}, at(out, 40, 50))
+
+	params.Subject = &replacebg.Subject{X: 60, Y: 50, Width: 70, Height: 70}
+	assert.Error(t, params.CheckBounds(120, 100))
+}
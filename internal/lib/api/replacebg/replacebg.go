@@ -0,0 +1,187 @@
+package replacebg
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"online-photo-editor/internal/lib/colors"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	defaultTolerance = 32
+	// cornerFraction is the size of the corner patches the backdrop color
+	// is sampled from, relative to the shorter image side.
+	cornerFraction = 0.02
+	// edgeSoftness is the blur sigma applied to the mask so the subject
+	// outline is anti-aliased instead of jagged.
+	edgeSoftness = 1.0
+)
+
+// ReplaceBgParams removes a near-uniform backdrop and puts the subject on
+// Background, a color, or on the stored image ImageName, scaled to cover
+// the whole frame. Tolerance is the largest RGB distance, on a 0-255 scale,
+// from the backdrop color that still counts as backdrop.
+type ReplaceBgParams struct {
+	Background string `json:"background,omitempty" validate:"required_without=ImageName,excluded_with=ImageName,max=20"`
+	ImageName  string `json:"image_name,omitempty" validate:"required_without=Background,max=100,image_name"`
+	Tolerance  int    `json:"tolerance,omitempty" validate:"min=0,max=255"`
+}
+
+// ReplaceBgImage keys the backdrop off the image corners and flood fills
+// it from the border, so regions of a similar color enclosed by the
+// subject are kept. bg is the loaded ImageName and is ignored when
+// Background is set.
+func (params *ReplaceBgParams) ReplaceBgImage(img image.Image, bg image.Image) (image.Image, error) {
+	const op = "api.replacebg.ReplaceBgImage"
+
+	src := imaging.Clone(img)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	var canvas *image.NRGBA
+	if params.Background != "" {
+		c, err := colors.Parse(params.Background)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		canvas = imaging.New(w, h, c)
+	} else {
+		if bg == nil {
+			return nil, fmt.Errorf("%s background image is missing", op)
+		}
+		canvas = imaging.Fill(bg, w, h, imaging.Center, imaging.Lanczos)
+	}
+
+	tolerance := params.Tolerance
+	if tolerance == 0 {
+		tolerance = defaultTolerance
+	}
+
+	mask := backdropMask(src, src.Bounds(), cornerColor(src), float64(tolerance))
+	mask = imaging.Blur(mask, edgeSoftness)
+
+	for i := 0; i < len(src.Pix); i += 4 {
+		// weight is how much of the subject shows at this pixel.
+		weight := 1 - float64(mask.Pix[i])/255
+		fg := src.Pix[i : i+4 : i+4]
+		out := canvas.Pix[i : i+4 : i+4]
+
+		fgA := float64(fg[3]) / 255 * weight
+		bgA := float64(out[3]) / 255 * (1 - fgA)
+		a := fgA + bgA
+		if a == 0 {
+			out[0], out[1], out[2], out[3] = 0, 0, 0, 0
+			continue
+		}
+
+		for c := 0; c < 3; c++ {
+			out[c] = uint8(math.Round((float64(fg[c])*fgA + float64(out[c])*bgA) / a))
+		}
+		out[3] = uint8(math.Round(a * 255))
+	}
+
+	return canvas, nil
+}
+
+// cornerColor averages small patches in the four corners.
+func cornerColor(img *image.NRGBA) color.NRGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	size := max(1, int(cornerFraction*float64(min(w, h))))
+
+	var sum [3]float64
+	var n float64
+
+	for _, corner := range []image.Point{{0, 0}, {w - size, 0}, {0, h - size}, {w - size, h - size}} {
+		for y := corner.Y; y < corner.Y+size; y++ {
+			for x := corner.X; x < corner.X+size; x++ {
+				p := img.Pix[y*img.Stride+x*4:]
+				sum[0] += float64(p[0])
+				sum[1] += float64(p[1])
+				sum[2] += float64(p[2])
+				n++
+			}
+		}
+	}
+
+	return color.NRGBA{
+		R: uint8(math.Round(sum[0] / n)),
+		G: uint8(math.Round(sum[1] / n)),
+		B: uint8(math.Round(sum[2] / n)),
+		A: 255,
+	}
+}
+
+// backdropMask returns a mask that is white where the backdrop is: every
+// pixel outside region, and every pixel of it within tolerance of ref that
+// is connected to its border.
+func backdropMask(img *image.NRGBA, region image.Rectangle, ref color.NRGBA, tolerance float64) *image.NRGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	mask := image.NewNRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if !image.Pt(x, y).In(region) {
+				m := mask.Pix[y*mask.Stride+x*4:]
+				m[0], m[1], m[2], m[3] = 255, 255, 255, 255
+			}
+		}
+	}
+
+	matches := func(x, y int) bool {
+		p := img.Pix[y*img.Stride+x*4:]
+		dr := float64(p[0]) - float64(ref.R)
+		dg := float64(p[1]) - float64(ref.G)
+		db := float64(p[2]) - float64(ref.B)
+		return math.Sqrt((dr*dr+dg*dg+db*db)/3) <= tolerance
+	}
+
+	visited := make([]bool, w*h)
+	queue := make([]int, 0, 2*(region.Dx()+region.Dy()))
+
+	push := func(x, y int) {
+		i := y*w + x
+		if visited[i] {
+			return
+		}
+		visited[i] = true
+		if matches(x, y) {
+			queue = append(queue, i)
+		}
+	}
+
+	for x := region.Min.X; x < region.Max.X; x++ {
+		push(x, region.Min.Y)
+		push(x, region.Max.Y-1)
+	}
+	for y := region.Min.Y; y < region.Max.Y; y++ {
+		push(region.Min.X, y)
+		push(region.Max.X-1, y)
+	}
+
+	for len(queue) > 0 {
+		i := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+
+		x, y := i%w, i/w
+		m := mask.Pix[y*mask.Stride+x*4:]
+		m[0], m[1], m[2], m[3] = 255, 255, 255, 255
+
+		if x > region.Min.X {
+			push(x-1, y)
+		}
+		if x < region.Max.X-1 {
+			push(x+1, y)
+		}
+		if y > region.Min.Y {
+			push(x, y-1)
+		}
+		if y < region.Max.Y-1 {
+			push(x, y+1)
+		}
+	}
+
+	return mask
+}
@@ -0,0 +1,70 @@
+package replacebg_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/replacebg"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// product returns a red subject on a slightly noisy white backdrop. The
+// subject has a white highlight in the middle that must not be mistaken
+// for backdrop.
+func product() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 120, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 120; x++ {
+			v := uint8(250 - (x*7+y*13)%6)
+			c := color.NRGBA{R: v, G: v, B: v, A: 255}
+
+			if x >= 30 && x < 90 && y >= 20 && y < 80 {
+				c = color.NRGBA{R: 200, G: 20, B: 30, A: 255}
+			}
+			if x >= 50 && x < 70 && y >= 40 && y < 60 {
+				c = color.NRGBA{R: 250, G: 250, B: 250, A: 255}
+			}
+
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func at(img image.Image, x, y int) color.NRGBA {
+	return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+}
+
+func TestReplaceBgParams_ReplaceBgImage_Color(t *testing.T) {
+	params := replacebg.ReplaceBgParams{Background: "#0000ff"}
+
+	out, err := params.ReplaceBgImage(product(), nil)
+	require.NoError(t, err)
+
+	blue := color.NRGBA{B: 255, A: 255}
+	assert.Equal(t, blue, at(out, 2, 2), "backdrop is replaced")
+	assert.Equal(t, blue, at(out, 110, 50))
+	assert.Equal(t, blue, at(out, 60, 90))
+
+	assert.Equal(t, color.NRGBA{R: 200, G: 20, B: 30, A: 255}, at(out, 40, 50), "subject is kept")
+	assert.Equal(t, color.NRGBA{R: 250, G: 250, B: 250, A: 255}, at(out, 60, 50), "enclosed highlight is kept")
+}
+
+func TestReplaceBgParams_ReplaceBgImage_Image(t *testing.T) {
+	bg := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	for i := 0; i < len(bg.Pix); i += 4 {
+		copy(bg.Pix[i:], []uint8{0, 255, 0, 255})
+	}
+
+	params := replacebg.ReplaceBgParams{ImageName: "beach.png"}
+
+	out, err := params.ReplaceBgImage(product(), bg)
+	require.NoError(t, err)
+
+	assert.Equal(t, image.Rect(0, 0, 120, 100), out.Bounds())
+	assert.Equal(t, color.NRGBA{G: 255, A: 255}, at(out, 2, 2))
+	assert.Equal(t, color.NRGBA{R: 200, G: 20, B: 30, A: 255}, at(out, 40, 50))
+}
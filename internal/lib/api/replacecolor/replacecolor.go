@@ -0,0 +1,71 @@
+package replacecolor
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"online-photo-editor/internal/lib/colors"
+
+	"github.com/disintegration/imaging"
+)
+
+// ReplaceColorParams swaps pixels close to From for To. Tolerance is the
+// largest RGB distance, on a 0-255 scale, that still counts as a match.
+// Feather widens the match by that many steps, blending partially so the
+// edge of the replaced area stays soft.
+type ReplaceColorParams struct {
+	From      string `json:"from" validate:"required,max=20"`
+	To        string `json:"to" validate:"required,max=20"`
+	Tolerance int    `json:"tolerance" validate:"min=0,max=255"`
+	Feather   int    `json:"feather" validate:"min=0,max=255"`
+}
+
+func (params *ReplaceColorParams) ReplaceColorImage(img image.Image) (image.Image, error) {
+	const op = "api.replacecolor.ReplaceColorImage"
+
+	from, err := colors.Parse(params.From)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	to, err := colors.Parse(params.To)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	dst := imaging.Clone(img)
+	tolerance := float64(params.Tolerance)
+	feather := float64(params.Feather)
+
+	for i := 0; i < len(dst.Pix); i += 4 {
+		p := dst.Pix[i : i+4 : i+4]
+
+		dr := float64(p[0]) - float64(from.R)
+		dg := float64(p[1]) - float64(from.G)
+		db := float64(p[2]) - float64(from.B)
+		// Scaled so that black to white is 255.
+		dist := math.Sqrt((dr*dr + dg*dg + db*db) / 3)
+
+		var weight float64
+		switch {
+		case dist <= tolerance:
+			weight = 1
+		case dist < tolerance+feather:
+			weight = 1 - (dist-tolerance)/feather
+		default:
+			continue
+		}
+
+		p[0] = blend(p[0], to.R, weight)
+		p[1] = blend(p[1], to.G, weight)
+		p[2] = blend(p[2], to.B, weight)
+		p[3] = blend(p[3], to.A, weight)
+	}
+
+	return dst, nil
+}
+
+func blend(a, b uint8, weight float64) uint8 {
+	return uint8(math.Round(float64(a) + (float64(b)-float64(a))*weight))
+}
@@ -0,0 +1,31 @@
+package replacecolor_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/replacecolor"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplaceColorParams_ReplaceColorImage(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{R: 250, G: 252, B: 255, A: 255}) // near white
+	src.SetNRGBA(1, 0, color.NRGBA{R: 235, G: 235, B: 235, A: 255}) // in the feather band
+	src.SetNRGBA(2, 0, color.NRGBA{R: 200, G: 30, B: 30, A: 255})   // product
+
+	params := replacecolor.ReplaceColorParams{From: "white", To: "#cccccc", Tolerance: 10, Feather: 20}
+
+	out, err := params.ReplaceColorImage(src)
+	require.NoError(t, err)
+
+	at := func(x int) color.NRGBA { return color.NRGBAModel.Convert(out.At(x, 0)).(color.NRGBA) }
+
+	assert.Equal(t, color.NRGBA{R: 204, G: 204, B: 204, A: 255}, at(0))
+	assert.Greater(t, at(1).R, uint8(204), "feathered pixels are only partially replaced")
+	assert.Less(t, at(1).R, uint8(235))
+	assert.Equal(t, color.NRGBA{R: 200, G: 30, B: 30, A: 255}, at(2), "non-matching pixels stay untouched")
+}
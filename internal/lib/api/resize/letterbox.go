@@ -0,0 +1,50 @@
+package resize
+
+import (
+	"fmt"
+	"image"
+)
+
+// LetterboxParams scales an image, up or down, to fit within Width×Height
+// keeping its aspect ratio, and pads the rest with Background, black by
+// default or "transparent", so the output is always exactly Width×Height.
+type LetterboxParams struct {
+	Width      int    `json:"width" validate:"required,min=1,max=8000"`
+	Height     int    `json:"height" validate:"required,min=1,max=8000"`
+	Background string `json:"background,omitempty" validate:"max=20"`
+	Filter     string `json:"filter,omitempty" validate:"omitempty,oneof=nearest box linear catmullrom lanczos"`
+	// MemoryBudget is as for ResizeParams.
+	MemoryBudget int64 `json:"-"`
+}
+
+func (params *LetterboxParams) SetMemoryBudget(budget int64) {
+	params.MemoryBudget = budget
+}
+
+func (params *LetterboxParams) OutputSize(width, height int) (int, int, bool) {
+	return params.Width, params.Height, true
+}
+
+// LetterboxImage is a fit resize padded with Background, that may
+// upscale.
+func (params *LetterboxParams) LetterboxImage(img image.Image) (image.Image, error) {
+	const op = "api.resize.LetterboxImage"
+
+	fit := ResizeParams{
+		Width:        params.Width,
+		Height:       params.Height,
+		Mode:         ModeFit,
+		Pad:          true,
+		Background:   params.Background,
+		Filter:       params.Filter,
+		MemoryBudget: params.MemoryBudget,
+		enlarge:      true,
+	}
+
+	out, err := fit.ResizeImage(img)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return out, nil
+}
@@ -1,16 +1,433 @@
 package resize
 
 import (
+	"fmt"
 	"image"
+	"math"
+	"online-photo-editor/internal/lib/colors"
+	"online-photo-editor/internal/lib/tiling"
 
 	"github.com/disintegration/imaging"
 )
 
+const (
+	ModeExact = "exact"
+	ModeFit   = "fit"
+	ModeFill  = "fill"
+)
+
+const defaultBackground = "black"
+
+const (
+	UnitInch       = "in"
+	UnitCentimeter = "cm"
+)
+
+// What a resize that may not upscale does with a size larger than the
+// source, see ResizeParams.
+const (
+	UpscaleClamp  = "clamp"
+	UpscaleReject = "reject"
+)
+
+const (
+	maxSide   = 8000
+	cmPerInch = 2.54
+
+	minSharpenSigma = 0.4
+	maxSharpenSigma = 1.2
+)
+
+var filters = map[string]imaging.ResampleFilter{
+	"nearest":    imaging.NearestNeighbor,
+	"box":        imaging.Box,
+	"linear":     imaging.Linear,
+	"catmullrom": imaging.CatmullRom,
+	"lanczos":    imaging.Lanczos,
+}
+
+// ResizeParams needs at least one of Width and Height, in pixels, or of
+// PrintWidth and PrintHeight, a physical size in Unit printed at DPI. A
+// missing one is inferred from the source aspect ratio. Without DPI a
+// print size uses SourceDPI, the resolution recorded in the source image,
+// which callers fill in when NeedsSourceDPI says so. The DPI used is
+// written into the output metadata, see OutputDPI. CSSWidth and CSSHeight
+// size the image for a CSS box on a screen with device pixel ratio DPR,
+// 1 by default, so the result has CSSWidth*DPR by CSSHeight*DPR pixels.
+// With AllowUpscale false a size larger than the source, in exact or
+// fill mode, is shrunk to fit the source keeping its aspect ratio, or
+// rejected when OnUpscale is reject; fit never upscales anyway.
 type ResizeParams struct {
-	Width  int `json:"width" validate:"required,min=0,max=8000"`
-	Height int `json:"height" validate:"required,min=0,max=8000"`
+	Width       int     `json:"width,omitempty" validate:"required_without_all=Height PrintWidth PrintHeight CSSWidth CSSHeight,min=0,max=8000"`
+	Height      int     `json:"height,omitempty" validate:"required_without_all=Width PrintWidth PrintHeight CSSWidth CSSHeight,min=0,max=8000"`
+	PrintWidth  float64 `json:"print_width,omitempty" validate:"min=0,max=1000"`
+	PrintHeight float64 `json:"print_height,omitempty" validate:"min=0,max=1000"`
+	Unit        string  `json:"unit,omitempty" validate:"omitempty,oneof=in cm"`
+	DPI         int     `json:"dpi,omitempty" validate:"min=0,max=2400"`
+	SourceDPI   int     `json:"-"`
+	CSSWidth    int     `json:"css_width,omitempty" validate:"min=0,max=8000"`
+	CSSHeight   int     `json:"css_height,omitempty" validate:"min=0,max=8000"`
+	DPR         float64 `json:"dpr,omitempty" validate:"min=0,max=5"`
+	Mode        string  `json:"mode,omitempty" validate:"omitempty,oneof=exact fit fill"`
+	Pad         bool    `json:"pad,omitempty"`
+	Background  string  `json:"background,omitempty" validate:"max=20"`
+	Filter      string  `json:"filter,omitempty" validate:"omitempty,oneof=nearest box linear catmullrom lanczos"`
+	// Sharpen restores the crispness lost when downscaling, see
+	// sharpenDownscaled.
+	Sharpen bool `json:"sharpen,omitempty"`
+	// AllowUpscale defaults to true, see SetUpscaleDefaults.
+	AllowUpscale *bool  `json:"allow_upscale,omitempty"`
+	OnUpscale    string `json:"on_upscale,omitempty" validate:"omitempty,oneof=clamp reject"`
+	// MemoryBudget bounds the working memory in bytes, see SetMemoryBudget.
+	MemoryBudget int64 `json:"-"`
+	// enlarge makes fit mode scale smaller images up to the box too, as
+	// letterboxing does.
+	enlarge bool
+}
+
+// SetMemoryBudget makes resizing and sharpening take at most about budget
+// bytes of working memory, working on parts of the image at a time when
+// the whole would take more. Zero means no limit.
+func (params *ResizeParams) SetMemoryBudget(budget int64) {
+	params.MemoryBudget = budget
+}
+
+// SetUpscaleDefaults fills in AllowUpscale and OnUpscale when the request
+// left them out.
+func (params *ResizeParams) SetUpscaleDefaults(allow bool, onUpscale string) {
+	if params.AllowUpscale == nil {
+		params.AllowUpscale = &allow
+	}
+	if params.OnUpscale == "" {
+		params.OnUpscale = onUpscale
+	}
+}
+
+// limitsUpscale reports whether sizes larger than the source are clamped
+// or rejected, as OnUpscale says.
+func (params *ResizeParams) limitsUpscale() bool {
+	return params.AllowUpscale != nil && !*params.AllowUpscale && params.Mode != ModeFit
+}
+
+// filter returns the resampling filter, Lanczos unless Filter says otherwise.
+func (params *ResizeParams) filter() imaging.ResampleFilter {
+	return resampleFilter(params.Filter)
+}
+
+// resampleFilter returns the filter called name, Lanczos if there is none.
+func resampleFilter(name string) imaging.ResampleFilter {
+	if f, ok := filters[name]; ok {
+		return f
+	}
+	return imaging.Lanczos
+}
+
+func (params *ResizeParams) validate() error {
+	const op = "api.resize.validate"
+
+	if params.Width < 0 || params.Height < 0 || params.PrintWidth < 0 || params.PrintHeight < 0 ||
+		params.CSSWidth < 0 || params.CSSHeight < 0 || params.DPR < 0 {
+		return fmt.Errorf("%s dimensions must not be negative", op)
+	}
+
+	if params.Pad && params.Mode != ModeFit {
+		return fmt.Errorf("%s pad is only supported in fit mode", op)
+	}
+
+	if params.Background != "" && !params.Pad {
+		return fmt.Errorf("%s background requires pad", op)
+	}
+
+	printing := params.PrintWidth > 0 || params.PrintHeight > 0
+	if printing && (params.Width > 0 || params.Height > 0) {
+		return fmt.Errorf("%s width and height can't be combined with print_width and print_height", op)
+	}
+
+	if printing && params.dpi() == 0 {
+		return fmt.Errorf("%s dpi is required with print_width or print_height when the image records none", op)
+	}
+
+	if params.Unit != "" && !printing {
+		return fmt.Errorf("%s unit requires print_width or print_height", op)
+	}
+
+	css := params.CSSWidth > 0 || params.CSSHeight > 0
+	if css && (params.Width > 0 || params.Height > 0 || printing) {
+		return fmt.Errorf("%s css_width and css_height can't be combined with other sizes", op)
+	}
+
+	if params.DPR > 0 && !css {
+		return fmt.Errorf("%s dpr requires css_width or css_height", op)
+	}
+
+	if !printing && !css && params.Width == 0 && params.Height == 0 {
+		return fmt.Errorf("%s width or height is required", op)
+	}
+
+	if w, h := params.printPixels(params.PrintWidth), params.printPixels(params.PrintHeight); w > maxSide || h > maxSide {
+		return fmt.Errorf("%s print size at %d dpi exceeds %d pixels", op, params.dpi(), maxSide)
+	}
+
+	if w, h := params.cssPixels(params.CSSWidth), params.cssPixels(params.CSSHeight); w > maxSide || h > maxSide {
+		return fmt.Errorf("%s css size at dpr %g exceeds %d pixels", op, params.dpr(), maxSide)
+	}
+
+	return nil
 }
 
+// size returns the target dimensions for a width×height source, clamped
+// to it when upscaling isn't allowed.
+func (params *ResizeParams) size(width, height int) (int, int) {
+	w, h := params.target(width, height)
+	if !params.limitsUpscale() || params.OnUpscale == UpscaleReject || w <= width && h <= height {
+		return w, h
+	}
+
+	scale := math.Min(float64(width)/float64(w), float64(height)/float64(h))
+	return max(1, int(math.Round(float64(w)*scale))), max(1, int(math.Round(float64(h)*scale)))
+}
+
+// target returns the dimensions asked for a width×height source, filling
+// in a missing one so the aspect ratio is kept.
+func (params *ResizeParams) target(width, height int) (int, int) {
+	w, h := params.Width, params.Height
+	if params.PrintWidth > 0 || params.PrintHeight > 0 {
+		w, h = params.printPixels(params.PrintWidth), params.printPixels(params.PrintHeight)
+	}
+	if params.CSSWidth > 0 || params.CSSHeight > 0 {
+		w, h = params.cssPixels(params.CSSWidth), params.cssPixels(params.CSSHeight)
+	}
+
+	switch {
+	case w == 0 && h == 0:
+	case w == 0:
+		w = max(1, int(math.Round(float64(width)*float64(h)/float64(height))))
+	case h == 0:
+		h = max(1, int(math.Round(float64(height)*float64(w)/float64(width))))
+	}
+
+	return w, h
+}
+
+// CheckBounds reports whether the size ResizeImage targets for a
+// width×height source, with a missing dimension inferred from the aspect
+// ratio, stays within the size limit.
+func (params *ResizeParams) CheckBounds(width, height int) error {
+	const op = "api.resize.CheckBounds"
+
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	if w, h := params.size(width, height); w > maxSide || h > maxSide {
+		return fmt.Errorf("%s size %dx%d inferred from the aspect ratio exceeds %d pixels", op, w, h, maxSide)
+	}
+
+	if w, h := params.target(width, height); params.limitsUpscale() && params.OnUpscale == UpscaleReject && (w > width || h > height) {
+		return fmt.Errorf("%s size %dx%d would upscale the %dx%d image", op, w, h, width, height)
+	}
+
+	return nil
+}
+
+// printPixels returns the pixels length, in Unit, takes at DPI.
+func (params *ResizeParams) printPixels(length float64) int {
+	if length == 0 {
+		return 0
+	}
+
+	inches := length
+	if params.Unit == UnitCentimeter {
+		inches /= cmPerInch
+	}
+
+	return max(1, int(math.Round(inches*float64(params.dpi()))))
+}
+
+// cssPixels returns the device pixels length CSS pixels take at DPR.
+func (params *ResizeParams) cssPixels(length int) int {
+	if length == 0 {
+		return 0
+	}
+	return max(1, int(math.Round(float64(length)*params.dpr())))
+}
+
+func (params *ResizeParams) dpr() float64 {
+	if params.DPR == 0 {
+		return 1
+	}
+	return params.DPR
+}
+
+// dpi returns DPI, or SourceDPI when a print size is given without one.
+func (params *ResizeParams) dpi() int {
+	if params.NeedsSourceDPI() {
+		return params.SourceDPI
+	}
+	return params.DPI
+}
+
+// NeedsSourceDPI reports whether SourceDPI must be filled in before the
+// output size can be known.
+func (params *ResizeParams) NeedsSourceDPI() bool {
+	return params.DPI == 0 && (params.PrintWidth > 0 || params.PrintHeight > 0)
+}
+
+func (params *ResizeParams) SetSourceDPI(dpi int) {
+	params.SourceDPI = dpi
+}
+
+// OutputDPI returns the resolution to record in the saved image, zero if
+// none was asked for.
+func (params *ResizeParams) OutputDPI() int {
+	return params.dpi()
+}
+
+// ResizeImage resizes the image according to Mode. imaging weights every
+// sample by its alpha while interpolating, so semi-transparent edges do not
+// pick up the color of fully transparent neighbours.
 func (params *ResizeParams) ResizeImage(img image.Image) (image.Image, error) {
-	return imaging.Resize(img, params.Width, params.Height, imaging.Lanczos), nil
+	b := img.Bounds()
+	if err := params.CheckBounds(b.Dx(), b.Dy()); err != nil {
+		return nil, err
+	}
+
+	width, height := params.size(b.Dx(), b.Dy())
+
+	switch params.Mode {
+	case ModeFit:
+		return params.fit(img, width, height)
+	case ModeFill:
+		filled := resample(cropCenter(img, width, height), width, height, params.filter(), params.MemoryBudget)
+		return params.sharpenDownscaled(filled, b.Dx(), b.Dy()), nil
+	default:
+		return params.sharpenDownscaled(resample(img, width, height, params.filter(), params.MemoryBudget), b.Dx(), b.Dy()), nil
+	}
+}
+
+// sharpenDownscaled applies a light unsharp mask to img, the result of
+// shrinking a srcW×srcH image, when Sharpen is set. Its radius grows with
+// the reduction, from 0.4 pixels at barely any to 1.2 at 16 times
+// smaller and beyond. Upscaled and same-size results are left alone, as
+// they lost no detail to soften.
+func (params *ResizeParams) sharpenDownscaled(img image.Image, srcW, srcH int) image.Image {
+	if !params.Sharpen {
+		return img
+	}
+
+	b := img.Bounds()
+	scale := math.Max(float64(b.Dx())/float64(srcW), float64(b.Dy())/float64(srcH))
+	if scale >= 1 {
+		return img
+	}
+
+	sigma := min(maxSharpenSigma, minSharpenSigma+0.2*math.Log2(1/scale))
+
+	sharpened, _ := tiling.Apply(img, params.MemoryBudget, int(math.Ceil(3*sigma)), func(img image.Image) *image.NRGBA {
+		return imaging.Sharpen(img, sigma)
+	})
+	return sharpened
+}
+
+// OutputSize returns the dimensions ResizeImage produces for a
+// width×height image.
+func (params *ResizeParams) OutputSize(width, height int) (int, int, bool) {
+	boxW, boxH := params.size(width, height)
+
+	if params.Mode != ModeFit || params.Pad {
+		return boxW, boxH, true
+	}
+
+	w, h := fitSize(width, height, boxW, boxH, params.enlarge)
+	return w, h, true
+}
+
+// fitSize returns the size a width×height image is scaled to so it fits
+// within boxW×boxH, keeping its aspect ratio, rounded down as imaging.Fit
+// does. It is only upscaled with enlarge.
+func fitSize(width, height, boxW, boxH int, enlarge bool) (int, int) {
+	if !enlarge && width <= boxW && height <= boxH {
+		return width, height
+	}
+
+	aspect := float64(width) / float64(height)
+	if aspect > float64(boxW)/float64(boxH) {
+		return boxW, max(1, int(float64(boxW)/aspect))
+	}
+	return max(1, int(float64(boxH)*aspect)), boxH
+}
+
+// cropCenter returns the middle of img with the aspect ratio of a
+// width×height image, as imaging.Fill crops it. A sub-image is returned
+// when img has the method, so its type, and its fast path in resample,
+// are kept.
+func cropCenter(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+	cropW, cropH := b.Dx(), b.Dy()
+	if float64(b.Dx())/float64(b.Dy()) < float64(width)/float64(height) {
+		cropH = min(cropH, int(math.Max(1, float64(b.Dx())*float64(height)/float64(width))+0.5))
+	} else {
+		cropW = min(cropW, int(math.Max(1, float64(b.Dy())*float64(width)/float64(height))+0.5))
+	}
+
+	rect := image.Rect(0, 0, cropW, cropH).Add(b.Min).Add(image.Pt((b.Dx()-cropW)/2, (b.Dy()-cropH)/2))
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(rect)
+	}
+	return imaging.Crop(img, rect)
+}
+
+// fit scales the image to fit within width×height. With Pad the leftover
+// area is filled with Background, so the output is exactly width×height.
+func (params *ResizeParams) fit(img image.Image, width, height int) (image.Image, error) {
+	const op = "api.resize.fit"
+
+	b := img.Bounds()
+	fitW, fitH := fitSize(b.Dx(), b.Dy(), width, height, params.enlarge)
+	fitted := params.sharpenDownscaled(resample(img, fitW, fitH, params.filter(), params.MemoryBudget), b.Dx(), b.Dy())
+	if !params.Pad {
+		return fitted, nil
+	}
+
+	background := params.Background
+	if background == "" {
+		background = defaultBackground
+	}
+
+	bg, err := colors.Parse(background)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	canvas := imaging.New(width, height, bg)
+
+	return imaging.PasteCenter(canvas, fitted), nil
+}
+
+// DownscaledSize returns the size a width×height image is shrunk to by
+// Downscale and the scale applied, 1 when it already holds at most
+// megapixels million pixels.
+func DownscaledSize(width, height int, megapixels float64) (int, int, float64) {
+	pixels := float64(width) * float64(height)
+	if pixels <= megapixels*1e6 {
+		return width, height, 1
+	}
+
+	scale := math.Sqrt(megapixels * 1e6 / pixels)
+	return max(1, int(float64(width)*scale)), max(1, int(float64(height)*scale)), scale
+}
+
+// Downscale shrinks img, keeping its aspect ratio, to DownscaledSize,
+// resampling with the filter called filter in about budget bytes of
+// working memory, as a resize with that memory budget does. An image
+// already within the limit is returned as is.
+func Downscale(img image.Image, megapixels float64, filter string, budget int64) (image.Image, float64) {
+	w, h, scale := DownscaledSize(img.Bounds().Dx(), img.Bounds().Dy(), megapixels)
+	if scale == 1 {
+		return img, 1
+	}
+
+	return resample(img, w, h, resampleFilter(filter), budget), scale
 }
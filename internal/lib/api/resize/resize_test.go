@@ -0,0 +1,534 @@
+package resize_test
+
+import (
+	"image"
+	"image/color"
+	"math/rand/v2"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/resize"
+
+	"github.com/disintegration/imaging"
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResizeParams_ResizeImage_FitPad(t *testing.T) {
+	src := imaging.New(200, 100, color.NRGBA{R: 0, G: 0, B: 255, A: 255})
+
+	params := resize.ResizeParams{
+		Width:      100,
+		Height:     100,
+		Mode:       resize.ModeFit,
+		Pad:        true,
+		Background: "#ff0000",
+	}
+
+	out, err := params.ResizeImage(src)
+	require.NoError(t, err)
+
+	assert.Equal(t, image.Rect(0, 0, 100, 100), out.Bounds())
+
+	red := color.NRGBAModel.Convert(color.NRGBA{R: 255, A: 255})
+	blue := color.NRGBAModel.Convert(color.NRGBA{B: 255, A: 255})
+
+	// 200x100 fitted into 100x100 leaves 25px bars above and below.
+	assert.Equal(t, red, color.NRGBAModel.Convert(out.At(50, 5)))
+	assert.Equal(t, red, color.NRGBAModel.Convert(out.At(50, 94)))
+	assert.Equal(t, blue, color.NRGBAModel.Convert(out.At(50, 50)))
+}
+
+func TestResizeParams_ResizeImage_PadRequiresFit(t *testing.T) {
+	src := imaging.New(200, 100, color.White)
+
+	params := resize.ResizeParams{Width: 100, Height: 100, Mode: resize.ModeFill, Pad: true}
+
+	_, err := params.ResizeImage(src)
+	assert.Error(t, err)
+}
+
+func TestResizeParams_ResizeImage_NoDarkFringes(t *testing.T) {
+	// White disc with a semi-transparent rim on a fully transparent black
+	// background. Interpolating straight RGBA would pull the black into
+	// the rim and leave a dark halo.
+	src := image.NewNRGBA(image.Rect(0, 0, 200, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 200; x++ {
+			dx, dy := x-100, y-100
+			switch d := dx*dx + dy*dy; {
+			case d < 60*60:
+				src.SetNRGBA(x, y, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+			case d < 70*70:
+				src.SetNRGBA(x, y, color.NRGBA{R: 255, G: 255, B: 255, A: 128})
+			}
+		}
+	}
+
+	for _, mode := range []string{resize.ModeExact, resize.ModeFit, resize.ModeFill} {
+		t.Run(mode, func(t *testing.T) {
+			params := resize.ResizeParams{Width: 37, Height: 37, Mode: mode}
+
+			out, err := params.ResizeImage(src)
+			require.NoError(t, err)
+
+			b := out.Bounds()
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					c := color.NRGBAModel.Convert(out.At(x, y)).(color.NRGBA)
+					if c.A < 8 {
+						continue
+					}
+					require.GreaterOrEqualf(t, c.R, uint8(250), "dark fringe at %d,%d: %v", x, y, c)
+				}
+			}
+		})
+	}
+}
+
+func TestResizeParams_ResizeImage_OneDimension(t *testing.T) {
+	src := imaging.New(300, 200, color.White)
+
+	tests := []struct {
+		name   string
+		params resize.ResizeParams
+		want   image.Rectangle
+	}{
+		{name: "width only", params: resize.ResizeParams{Width: 150}, want: image.Rect(0, 0, 150, 100)},
+		{name: "height only", params: resize.ResizeParams{Height: 50}, want: image.Rect(0, 0, 75, 50)},
+		{name: "width only rounds", params: resize.ResizeParams{Width: 100}, want: image.Rect(0, 0, 100, 67)},
+		{name: "height only in fit mode", params: resize.ResizeParams{Height: 100, Mode: resize.ModeFit}, want: image.Rect(0, 0, 150, 100)},
+		{name: "fit rounds down", params: resize.ResizeParams{Width: 100, Height: 100, Mode: resize.ModeFit}, want: image.Rect(0, 0, 100, 66)},
+		{name: "width only in exact mode", params: resize.ResizeParams{Width: 60, Mode: resize.ModeExact}, want: image.Rect(0, 0, 60, 40)},
+		{name: "height only in fill mode", params: resize.ResizeParams{Height: 20, Mode: resize.ModeFill}, want: image.Rect(0, 0, 30, 20)},
+		// A sliver never infers a zero dimension.
+		{name: "width only at least one pixel", params: resize.ResizeParams{Width: 1}, want: image.Rect(0, 0, 1, 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := tt.params.ResizeImage(src)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out.Bounds())
+
+			w, h, ok := tt.params.OutputSize(300, 200)
+			assert.True(t, ok)
+			assert.Equal(t, tt.want.Dx(), w)
+			assert.Equal(t, tt.want.Dy(), h)
+		})
+	}
+}
+
+func TestResizeParams_RequiresOneDimension(t *testing.T) {
+	validate := validator.New()
+
+	assert.Error(t, validate.Struct(resize.ResizeParams{}))
+	assert.NoError(t, validate.Struct(resize.ResizeParams{Width: 10}))
+	assert.NoError(t, validate.Struct(resize.ResizeParams{Height: 10}))
+
+	params := resize.ResizeParams{}
+	_, err := params.ResizeImage(imaging.New(10, 10, color.White))
+	assert.Error(t, err)
+}
+
+func TestResizeParams_ResizeImage_InvalidDimensions(t *testing.T) {
+	src := imaging.New(10, 1000, color.White)
+
+	for _, params := range []resize.ResizeParams{
+		{Width: -10},
+		{Width: 100, Height: -1},
+		// Width 100 on a 10×1000 source infers a height of 10000.
+		{Width: 100},
+	} {
+		_, err := params.ResizeImage(src)
+		assert.Error(t, err, params)
+		assert.Error(t, params.CheckBounds(10, 1000), params)
+	}
+}
+
+func TestResizeParams_ResizeImage_PrintSize(t *testing.T) {
+	src := imaging.New(600, 900, color.White)
+
+	tests := []struct {
+		name   string
+		params resize.ResizeParams
+		want   image.Rectangle
+		dpi    int
+	}{
+		{name: "inches", params: resize.ResizeParams{PrintWidth: 4, PrintHeight: 6, DPI: 300}, want: image.Rect(0, 0, 1200, 1800), dpi: 300},
+		{name: "centimeters", params: resize.ResizeParams{PrintWidth: 10.16, PrintHeight: 15.24, Unit: resize.UnitCentimeter, DPI: 300}, want: image.Rect(0, 0, 1200, 1800), dpi: 300},
+		{name: "width only", params: resize.ResizeParams{PrintWidth: 2, DPI: 150}, want: image.Rect(0, 0, 300, 450), dpi: 150},
+		{name: "source dpi", params: resize.ResizeParams{PrintWidth: 4, PrintHeight: 6, SourceDPI: 300}, want: image.Rect(0, 0, 1200, 1800), dpi: 300},
+		{name: "dpi wins over source", params: resize.ResizeParams{PrintWidth: 4, PrintHeight: 6, DPI: 300, SourceDPI: 72}, want: image.Rect(0, 0, 1200, 1800), dpi: 300},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := tt.params.ResizeImage(src)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out.Bounds())
+			assert.Equal(t, tt.dpi, tt.params.OutputDPI())
+		})
+	}
+}
+
+func TestResizeParams_PrintSizeValidation(t *testing.T) {
+	validate := validator.New()
+	src := imaging.New(10, 10, color.White)
+
+	assert.NoError(t, validate.Struct(resize.ResizeParams{PrintWidth: 4, DPI: 300}))
+	assert.NoError(t, validate.Struct(resize.ResizeParams{PrintWidth: 4}))
+
+	for name, params := range map[string]resize.ResizeParams{
+		"no dpi recorded":       {PrintWidth: 4},
+		"pixels and print size": {Width: 100, PrintWidth: 4, DPI: 300},
+		"unit without size":     {Width: 100, Unit: resize.UnitInch},
+		"too many pixels":       {PrintWidth: 40, DPI: 300},
+	} {
+		_, err := params.ResizeImage(src)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestResizeParams_ResizeImage_CSSSize(t *testing.T) {
+	src := imaging.New(1200, 800, color.White)
+
+	tests := []struct {
+		name   string
+		params resize.ResizeParams
+		want   image.Rectangle
+	}{
+		{name: "dpr 2", params: resize.ResizeParams{CSSWidth: 300, CSSHeight: 200, DPR: 2}, want: image.Rect(0, 0, 600, 400)},
+		{name: "default dpr", params: resize.ResizeParams{CSSWidth: 300, CSSHeight: 200}, want: image.Rect(0, 0, 300, 200)},
+		{name: "fractional dpr", params: resize.ResizeParams{CSSWidth: 300, DPR: 1.5}, want: image.Rect(0, 0, 450, 300)},
+		{name: "fit the box", params: resize.ResizeParams{CSSWidth: 300, CSSHeight: 300, DPR: 2, Mode: resize.ModeFit}, want: image.Rect(0, 0, 600, 400)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := tt.params.ResizeImage(src)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out.Bounds())
+		})
+	}
+
+	validate := validator.New()
+	assert.NoError(t, validate.Struct(resize.ResizeParams{CSSWidth: 300, DPR: 2}))
+
+	for name, params := range map[string]resize.ResizeParams{
+		"dpr without css size":   {Width: 100, DPR: 2},
+		"pixels and css size":    {Width: 100, CSSWidth: 300},
+		"print and css size":     {PrintWidth: 4, DPI: 300, CSSWidth: 300},
+		"too many device pixels": {CSSWidth: 3000, DPR: 3},
+	} {
+		_, err := params.ResizeImage(src)
+		assert.Error(t, err, name)
+	}
+}
+
+// noise fills an image of every fast-path type with the same random
+// opaque pixels.
+func noise(width, height int) (*image.RGBA, *image.NRGBA, *image.Gray) {
+	rng := rand.New(rand.NewPCG(7, 11))
+
+	rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+	gray := image.NewGray(rgba.Rect)
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2], rgba.Pix[i+3] = uint8(rng.IntN(256)), uint8(rng.IntN(256)), uint8(rng.IntN(256)), 255
+		gray.Pix[i/4] = rgba.Pix[i]
+	}
+
+	return rgba, imaging.Clone(rgba), gray
+}
+
+func TestResizeParams_ResizeImage_FastPathParity(t *testing.T) {
+	rgba, nrgba, gray := noise(97, 61)
+
+	sources := map[string]image.Image{
+		"rgba":      rgba,
+		"nrgba":     nrgba,
+		"gray":      gray,
+		"sub-image": rgba.SubImage(image.Rect(13, 7, 80, 50)),
+	}
+	sizes := [][2]int{{40, 25}, {200, 130}, {97, 30}, {31, 61}, {1, 1}}
+
+	for name, src := range sources {
+		for _, filter := range []string{"lanczos", "linear", "box"} {
+			for _, size := range sizes {
+				params := resize.ResizeParams{Width: size[0], Height: size[1], Filter: filter}
+				out, err := params.ResizeImage(src)
+				require.NoError(t, err)
+
+				want := imaging.Resize(src, size[0], size[1], map[string]imaging.ResampleFilter{
+					"lanczos": imaging.Lanczos, "linear": imaging.Linear, "box": imaging.Box,
+				}[filter])
+				require.Equal(t, want.Bounds(), out.Bounds())
+
+				got := imaging.Clone(out)
+				for i := range want.Pix {
+					diff := int(want.Pix[i]) - int(got.Pix[i])
+					if diff < -1 || diff > 1 {
+						t.Fatalf("%s %s %v: byte %d is %d, want %d", name, filter, size, i, got.Pix[i], want.Pix[i])
+					}
+				}
+			}
+		}
+	}
+
+	for _, mode := range []string{resize.ModeExact, resize.ModeFit, resize.ModeFill} {
+		out, err := (&resize.ResizeParams{Width: 40, Height: 40, Mode: mode}).ResizeImage(gray)
+		require.NoError(t, err)
+		assert.IsType(t, &image.Gray{}, out, "gray images stay gray in %s mode", mode)
+	}
+}
+
+func TestResizeParams_ResizeImage_MemoryBudget(t *testing.T) {
+	rgba, _, gray := noise(97, 61)
+
+	for name, src := range map[string]image.Image{"rgba": rgba, "gray": gray} {
+		for _, size := range [][2]int{{40, 25}, {200, 130}} {
+			full, err := (&resize.ResizeParams{Width: size[0], Height: size[1], Sharpen: true}).ResizeImage(src)
+			require.NoError(t, err)
+
+			// A few rows of the horizontal pass at a time.
+			params := resize.ResizeParams{Width: size[0], Height: size[1], Sharpen: true}
+			params.SetMemoryBudget(int64(size[0]) * 4 * 8)
+			banded, err := params.ResizeImage(src)
+			require.NoError(t, err)
+
+			assert.Equal(t, imaging.Clone(full).Pix, imaging.Clone(banded).Pix, "%s %v", name, size)
+		}
+	}
+}
+
+// ycbcr returns a random image as the JPEG decoder makes them.
+func ycbcr(width, height int) *image.YCbCr {
+	rng := rand.New(rand.NewPCG(3, 13))
+
+	img := image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio420)
+	for _, plane := range [][]uint8{img.Y, img.Cb, img.Cr} {
+		for i := range plane {
+			plane[i] = uint8(rng.IntN(256))
+		}
+	}
+	return img
+}
+
+// assertClose fails unless a and b have the same bounds and channels at
+// most one level apart.
+func assertClose(t *testing.T, a, b image.Image, msg string) {
+	t.Helper()
+	require.Equal(t, a.Bounds(), b.Bounds(), msg)
+
+	pa, pb := imaging.Clone(a).Pix, imaging.Clone(b).Pix
+	for i := range pa {
+		if diff := int(pa[i]) - int(pb[i]); diff < -1 || diff > 1 {
+			t.Fatalf("%s: byte %d is %d, want %d", msg, i, pb[i], pa[i])
+		}
+	}
+}
+
+func TestResizeParams_ResizeImage_MemoryBudgetOtherTypes(t *testing.T) {
+	_, nrgba, _ := noise(97, 61)
+
+	// Opaque images without a path of their own are banded too.
+	for name, src := range map[string]image.Image{"ycbcr": ycbcr(97, 61), "nrgba": nrgba} {
+		for _, size := range [][2]int{{40, 25}, {200, 130}} {
+			params := resize.ResizeParams{Width: size[0], Height: size[1]}
+			params.SetMemoryBudget(int64(size[0]) * 4 * 8)
+			banded, err := params.ResizeImage(src)
+			require.NoError(t, err)
+
+			assertClose(t, imaging.Resize(src, size[0], size[1], imaging.Lanczos), banded, name)
+		}
+	}
+}
+
+func TestDownscale(t *testing.T) {
+	src := ycbcr(400, 300)
+
+	out, scale := resize.Downscale(src, 0.03, "linear", 0)
+	assert.Equal(t, image.Rect(0, 0, 200, 150), out.Bounds())
+	assert.InDelta(t, 0.5, scale, 1e-9)
+
+	// The memory budget bands the resize without changing it.
+	banded, _ := resize.Downscale(src, 0.03, "linear", 200*4*8)
+	assertClose(t, out, banded, "banded")
+
+	same, scale := resize.Downscale(src, 1, "linear", 0)
+	assert.Same(t, src, same)
+	assert.Equal(t, 1.0, scale)
+}
+
+func BenchmarkResizeParams_ResizeImage(b *testing.B) {
+	rgba, _, gray := noise(2000, 1500)
+
+	for _, bb := range []struct {
+		name string
+		src  image.Image
+	}{{"rgba", rgba}, {"gray", gray}} {
+		b.Run(bb.name+"/fast", func(b *testing.B) {
+			params := resize.ResizeParams{Width: 800, Height: 600}
+			for i := 0; i < b.N; i++ {
+				if _, err := params.ResizeImage(bb.src); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(bb.name+"/generic", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				imaging.Resize(bb.src, 800, 600, imaging.Lanczos)
+			}
+		})
+	}
+}
+
+func TestLetterboxParams_LetterboxImage(t *testing.T) {
+	blue := color.NRGBA{B: 255, A: 255}
+
+	cases := []struct {
+		name       string
+		src        image.Image
+		background string
+		want       color.NRGBA
+		// content is a pixel of the scaled image, border one of the padding.
+		content, border image.Point
+	}{
+		// 400×100 down to 200×50, with bars above and below.
+		{name: "wide", src: imaging.New(400, 100, blue), want: color.NRGBA{A: 255}, content: image.Pt(100, 100), border: image.Pt(100, 10)},
+		// 50×100 up to 100×200, with bars left and right.
+		{name: "tall upscaled", src: imaging.New(50, 100, blue), background: "white", want: color.NRGBA{R: 255, G: 255, B: 255, A: 255}, content: image.Pt(100, 100), border: image.Pt(10, 100)},
+		{name: "transparent", src: imaging.New(400, 100, blue), background: "transparent", want: color.NRGBA{}, content: image.Pt(100, 100), border: image.Pt(100, 190)},
+	}
+
+	for _, tc := range cases {
+		params := resize.LetterboxParams{Width: 200, Height: 200, Background: tc.background}
+
+		out, err := params.LetterboxImage(tc.src)
+		require.NoError(t, err, tc.name)
+		require.Equal(t, image.Rect(0, 0, 200, 200), out.Bounds(), tc.name)
+
+		got := imaging.Clone(out)
+		assert.Equal(t, blue, got.NRGBAAt(tc.content.X, tc.content.Y), tc.name)
+		assert.Equal(t, tc.want, got.NRGBAAt(tc.border.X, tc.border.Y), tc.name)
+	}
+}
+
+func TestLetterboxParams_InvalidBackground(t *testing.T) {
+	params := resize.LetterboxParams{Width: 10, Height: 10, Background: "nope"}
+
+	_, err := params.LetterboxImage(imaging.New(20, 10, color.White))
+	assert.Error(t, err)
+}
+
+// acutance is the mean difference between horizontally adjacent gray
+// levels, higher for crisper images.
+func acutance(img image.Image) float64 {
+	gray := imaging.Grayscale(img)
+	b := gray.Bounds()
+
+	var sum float64
+	for y := 0; y < b.Dy(); y++ {
+		for x := 1; x < b.Dx(); x++ {
+			d := int(gray.Pix[y*gray.Stride+x*4]) - int(gray.Pix[y*gray.Stride+(x-1)*4])
+			sum += float64(max(d, -d))
+		}
+	}
+	return sum / float64(b.Dy()*(b.Dx()-1))
+}
+
+func TestResizeParams_ResizeImage_Sharpen(t *testing.T) {
+	// Detail finer than the thumbnail: 5 pixel stripes.
+	src := image.NewNRGBA(image.Rect(0, 0, 600, 400))
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 600; x++ {
+			if x/5%2 == 0 {
+				src.SetNRGBA(x, y, color.NRGBA{R: 200, G: 200, B: 200, A: 255})
+			} else {
+				src.SetNRGBA(x, y, color.NRGBA{R: 60, G: 60, B: 60, A: 255})
+			}
+		}
+	}
+
+	for _, mode := range []string{resize.ModeExact, resize.ModeFit, resize.ModeFill} {
+		plain := resize.ResizeParams{Width: 150, Height: 100, Mode: mode}
+		before, err := plain.ResizeImage(src)
+		require.NoError(t, err, mode)
+
+		sharpened := plain
+		sharpened.Sharpen = true
+		after, err := sharpened.ResizeImage(src)
+		require.NoError(t, err, mode)
+
+		assert.Equal(t, before.Bounds(), after.Bounds(), mode)
+		assert.Greater(t, acutance(after), acutance(before)*1.05, mode)
+	}
+}
+
+func TestResizeParams_ResizeImage_SharpenSkipsUpscale(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 30, 20))
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(rng.IntN(256))
+	}
+
+	plain := resize.ResizeParams{Width: 90}
+	sharpened := resize.ResizeParams{Width: 90, Sharpen: true}
+
+	before, err := plain.ResizeImage(src)
+	require.NoError(t, err)
+	after, err := sharpened.ResizeImage(src)
+	require.NoError(t, err)
+
+	assert.Equal(t, imaging.Clone(before).Pix, imaging.Clone(after).Pix)
+}
+
+func TestResizeParams_ResizeImage_Upscale(t *testing.T) {
+	deny := false
+	src := image.NewNRGBA(image.Rect(0, 0, 300, 200))
+
+	tests := []struct {
+		name   string
+		params resize.ResizeParams
+		want   image.Rectangle
+	}{
+		{"allowed by default", resize.ResizeParams{Width: 600}, image.Rect(0, 0, 600, 400)},
+		{"clamp", resize.ResizeParams{Width: 600, AllowUpscale: &deny}, image.Rect(0, 0, 300, 200)},
+		{"clamp keeps the aspect ratio", resize.ResizeParams{Width: 800, Height: 400, AllowUpscale: &deny}, image.Rect(0, 0, 300, 150)},
+		{"clamp fill", resize.ResizeParams{Width: 100, Height: 400, Mode: resize.ModeFill, AllowUpscale: &deny}, image.Rect(0, 0, 50, 200)},
+		{"downscale untouched", resize.ResizeParams{Width: 150, AllowUpscale: &deny, OnUpscale: resize.UpscaleReject}, image.Rect(0, 0, 150, 100)},
+		{"fit never upscales", resize.ResizeParams{Width: 600, Height: 600, Mode: resize.ModeFit, AllowUpscale: &deny, OnUpscale: resize.UpscaleReject}, image.Rect(0, 0, 300, 200)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h, known := tt.params.OutputSize(300, 200)
+			require.True(t, known)
+			assert.Equal(t, tt.want, image.Rect(0, 0, w, h), "OutputSize")
+
+			out, err := tt.params.ResizeImage(src)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out.Bounds())
+		})
+	}
+}
+
+func TestResizeParams_CheckBounds_RejectUpscale(t *testing.T) {
+	deny := false
+	params := resize.ResizeParams{Width: 301, AllowUpscale: &deny, OnUpscale: resize.UpscaleReject}
+
+	err := params.CheckBounds(300, 200)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "would upscale the 300x200 image")
+
+	_, err = params.ResizeImage(image.NewNRGBA(image.Rect(0, 0, 300, 200)))
+	assert.Error(t, err)
+
+	params.SetUpscaleDefaults(true, resize.UpscaleClamp)
+	assert.Error(t, params.CheckBounds(300, 200), "the request wins over the defaults")
+
+	params = resize.ResizeParams{Width: 301}
+	params.SetUpscaleDefaults(false, resize.UpscaleReject)
+	assert.Error(t, params.CheckBounds(300, 200))
+	assert.NoError(t, params.CheckBounds(400, 300))
+}
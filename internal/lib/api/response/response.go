@@ -1,10 +1,14 @@
 package response
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"online-photo-editor/internal/lib/aspect"
 	"online-photo-editor/internal/lib/logger/sl"
+	"online-photo-editor/internal/lib/timefmt"
+	"online-photo-editor/internal/storage"
 	"strings"
 
 	"github.com/go-chi/render"
@@ -47,8 +51,16 @@ func ValidationError(errs validator.ValidationErrors) Response {
 			errMsgs = append(errMsgs, fmt.Sprintf("field %s is less than min value", err.Field()))
 		case "lowercase":
 			errMsgs = append(errMsgs, fmt.Sprintf("field %s is lis not lowercase", err.Field()))
+		case "len":
+			errMsgs = append(errMsgs, fmt.Sprintf("field %s must hold %s values", err.Field(), err.Param()))
 		case "oneof":
 			errMsgs = append(errMsgs, fmt.Sprintf("field %s must be one of the allowed values", err.Field()))
+		case "image_name":
+			errMsgs = append(errMsgs, fmt.Sprintf("field %s must be a plain file name", err.Field()))
+		case "aspect_ratio":
+			errMsgs = append(errMsgs, fmt.Sprintf("field %s must be an aspect ratio such as 16:9", err.Field()))
+		case "time_format":
+			errMsgs = append(errMsgs, fmt.Sprintf("field %s must be a time format such as %s", err.Field(), timefmt.Default))
 		default:
 			errMsgs = append(errMsgs, fmt.Sprintf("field %s is not valid", err.Field()))
 		}
@@ -60,8 +72,42 @@ func ValidationError(errs validator.ValidationErrors) Response {
 	}
 }
 
+var validate = newValidator()
+
+// newValidator returns a validator that also knows the image_name tag,
+// which only accepts plain file names, see storage.ValidateName, the
+// aspect_ratio tag for "width:height" strings and the time_format tag for
+// timefmt layouts. Empty values pass so that required decides.
+func newValidator() *validator.Validate {
+	v := validator.New()
+
+	v.RegisterValidation("image_name", func(fl validator.FieldLevel) bool {
+		name := fl.Field().String()
+		return name == "" || storage.ValidateName(name) == nil
+	})
+
+	v.RegisterValidation("aspect_ratio", func(fl validator.FieldLevel) bool {
+		if fl.Field().String() == "" {
+			return true
+		}
+		_, err := aspect.Parse(fl.Field().String())
+		return err == nil
+	})
+
+	v.RegisterValidation("time_format", func(fl validator.FieldLevel) bool {
+		return fl.Field().String() == "" || timefmt.Validate(fl.Field().String()) == nil
+	})
+
+	return v
+}
+
+// ValidateStruct validates s with the tags Validation understands.
+func ValidateStruct(s interface{}) error {
+	return validate.Struct(s)
+}
+
 func Validation(log *slog.Logger, w http.ResponseWriter, r *http.Request, s interface{}, errStatus int) bool {
-	if err := validator.New().Struct(s); err != nil {
+	if err := validate.Struct(s); err != nil {
 		validateErr := err.(validator.ValidationErrors)
 
 		log.Error("invalid request", sl.Err(err))
@@ -72,3 +118,52 @@ func Validation(log *slog.Logger, w http.ResponseWriter, r *http.Request, s inte
 	}
 	return true
 }
+
+// LoadError renders err from loading a stored image: 422 when the file
+// can't be decoded or is too large to, 404 otherwise.
+func LoadError(w http.ResponseWriter, r *http.Request, err error) {
+	if target := storage.ContentError(err); target != nil {
+		render.Status(r, http.StatusUnprocessableEntity)
+		render.JSON(w, r, Error(target.Error()))
+		return
+	}
+
+	render.Status(r, http.StatusNotFound)
+	render.JSON(w, r, Error("failed to load image"))
+}
+
+// UnsupportedFormatResponse is an error about an output format, listing
+// the ones that are supported.
+type UnsupportedFormatResponse struct {
+	Response
+	SupportedFormats []string `json:"supported_formats"`
+}
+
+// UnsupportedFormat renders 415 with msg for an output format outside
+// supported.
+func UnsupportedFormat(w http.ResponseWriter, r *http.Request, msg string, supported []string) {
+	render.Status(r, http.StatusUnsupportedMediaType)
+	render.JSON(w, r, UnsupportedFormatResponse{
+		Response:         Error(msg),
+		SupportedFormats: supported,
+	})
+}
+
+// SaveError renders err from saving an image: 507 when the storage, or
+// the namespace of the image, is over its quota, 415 with msg when the
+// image can't be encoded in its format and 500 with msg for any other
+// failure, such as a disk error.
+func SaveError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	if quotaErr := storage.QuotaError(err); quotaErr != nil {
+		render.Status(r, http.StatusInsufficientStorage)
+		render.JSON(w, r, Error(quotaErr.Error()))
+		return
+	}
+
+	if errors.Is(err, storage.ErrEncode) {
+		render.Status(r, http.StatusUnsupportedMediaType)
+	} else {
+		render.Status(r, http.StatusInternalServerError)
+	}
+	render.JSON(w, r, Error(msg))
+}
@@ -0,0 +1,91 @@
+package shadowshighlights
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// strength is how far the tone curve bends at amount 100.
+const strength = 1.5
+
+// ShadowsHighlightsParams lifts dark regions and pulls down bright ones.
+// Shadows and Highlights are amounts from 0 to 100. Radius is the blur
+// sigma, in pixels, of the mask that decides what counts as a shadow or
+// highlight; it defaults to 2% of the shorter image side.
+type ShadowsHighlightsParams struct {
+	Shadows    float64 `json:"shadows" validate:"min=0,max=100"`
+	Highlights float64 `json:"highlights" validate:"min=0,max=100"`
+	Radius     float64 `json:"radius,omitempty" validate:"omitempty,min=0.5,max=200"`
+}
+
+// Cost is the work per pixel of blurring the mask plus the passes that
+// build and apply it.
+func (params *ShadowsHighlightsParams) Cost(width, height int) float64 {
+	if params.Shadows == 0 && params.Highlights == 0 {
+		return 0
+	}
+	return 2*(2*math.Ceil(3*params.radius(width, height))+1) + 3
+}
+
+func (params *ShadowsHighlightsParams) radius(width, height int) float64 {
+	if params.Radius != 0 {
+		return params.Radius
+	}
+	return math.Max(1, 0.02*float64(min(width, height)))
+}
+
+// ShadowsHighlightsImage applies a local tone curve. The mask is a blurred
+// copy of the luminance, so whole dark or bright regions are adjusted
+// together and local contrast survives. Colors are scaled by the change in
+// luminance to keep their hue.
+func (params *ShadowsHighlightsParams) ShadowsHighlightsImage(img image.Image) (image.Image, error) {
+	if params.Shadows == 0 && params.Highlights == 0 {
+		return img, nil
+	}
+
+	dst := imaging.Clone(img)
+	b := dst.Bounds()
+
+	mask := imaging.Blur(imaging.Grayscale(dst), params.radius(b.Dx(), b.Dy()))
+
+	shadows := params.Shadows / 100
+	highlights := params.Highlights / 100
+
+	for i := 0; i < len(dst.Pix); i += 4 {
+		p := dst.Pix[i : i+4 : i+4]
+
+		l := (0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])) / 255
+		if l == 0 && shadows == 0 {
+			continue
+		}
+
+		m := float64(mask.Pix[i]) / 255
+
+		// Dark surroundings lift the pixel, bright surroundings pull it
+		// down. Black and white stay fixed points of both curves.
+		adjusted := math.Pow(l, 1/(1+strength*shadows*(1-m)*(1-m)))
+		adjusted = math.Pow(adjusted, 1+strength*highlights*m*m)
+
+		if l == 0 {
+			p[0], p[1], p[2] = gray(adjusted), gray(adjusted), gray(adjusted)
+			continue
+		}
+
+		ratio := adjusted / l
+		p[0] = scale(p[0], ratio)
+		p[1] = scale(p[1], ratio)
+		p[2] = scale(p[2], ratio)
+	}
+
+	return dst, nil
+}
+
+func scale(v uint8, ratio float64) uint8 {
+	return uint8(math.Min(255, math.Round(float64(v)*ratio)))
+}
+
+func gray(l float64) uint8 {
+	return uint8(math.Round(l * 255))
+}
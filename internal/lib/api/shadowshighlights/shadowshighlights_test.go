@@ -0,0 +1,54 @@
+package shadowshighlights_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/shadowshighlights"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// split returns an image whose left half is deep shadow with faint detail
+// and whose right half is near-blown highlight.
+func split() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 50))
+	for y := 0; y < 50; y++ {
+		for x := 0; x < 100; x++ {
+			v := uint8(20 + (x+y)%2*6)
+			if x >= 50 {
+				v = 240
+			}
+			img.SetNRGBA(x, y, color.NRGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	return img
+}
+
+func luma(img image.Image, x, y int) uint8 {
+	return color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+}
+
+func TestShadowsHighlightsParams_ShadowsHighlightsImage(t *testing.T) {
+	src := split()
+
+	params := shadowshighlights.ShadowsHighlightsParams{Shadows: 60, Highlights: 60}
+	out, err := params.ShadowsHighlightsImage(src)
+	require.NoError(t, err)
+
+	assert.Greater(t, luma(out, 10, 10), luma(src, 10, 10), "shadows are lifted")
+	assert.Less(t, luma(out, 90, 10), luma(src, 90, 10), "highlights are recovered")
+	assert.Greater(t, luma(out, 11, 10)-luma(out, 10, 10), luma(src, 11, 10)-luma(src, 10, 10),
+		"shadow detail is brought out, not flattened")
+}
+
+func TestShadowsHighlightsParams_ZeroAmountsAreNoOp(t *testing.T) {
+	src := split()
+
+	params := shadowshighlights.ShadowsHighlightsParams{}
+	out, err := params.ShadowsHighlightsImage(src)
+	require.NoError(t, err)
+	assert.Same(t, src, out)
+}
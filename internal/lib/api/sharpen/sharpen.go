@@ -2,14 +2,32 @@ package sharpen
 
 import (
 	"image"
+	"math"
+
+	"online-photo-editor/internal/lib/tiling"
 
 	"github.com/disintegration/imaging"
 )
 
 type SharpenParams struct {
 	Sigma float64 `json:"sigma" validate:"required,min=0.1,max=100.0"`
+	// MemoryBudget bounds the working memory in bytes, as for blur.
+	MemoryBudget int64 `json:"-"`
+}
+
+func (params *SharpenParams) SetMemoryBudget(budget int64) {
+	params.MemoryBudget = budget
+}
+
+// Cost is the work per pixel of the underlying blur plus the unsharp mask
+// pass.
+func (params *SharpenParams) Cost(width, height int) float64 {
+	return 2*(2*math.Ceil(3*params.Sigma)+1) + 1
 }
 
 func (params *SharpenParams) SharpenImage(img image.Image) (image.Image, error) {
-	return imaging.Sharpen(img, params.Sigma), nil
+	sharpened, _ := tiling.Apply(img, params.MemoryBudget, int(math.Ceil(3*params.Sigma)), func(img image.Image) *image.NRGBA {
+		return imaging.Sharpen(img, params.Sigma)
+	})
+	return sharpened, nil
 }
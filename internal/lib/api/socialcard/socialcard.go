@@ -0,0 +1,185 @@
+package socialcard
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"online-photo-editor/internal/lib/api/resize"
+	"online-photo-editor/internal/lib/colors"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// The open-graph image size recommended by most sites.
+const (
+	Width  = 1200
+	Height = 630
+)
+
+const (
+	margin      = 64
+	brandBar    = 12
+	maxLines    = 3
+	maxFontSize = 72
+	minFontSize = 40
+	// scrimHeight is the share of the card, from the bottom, the scrim
+	// darkens. It is opaque up to scrimAlpha at the bottom edge.
+	scrimHeight = 0.6
+	scrimAlpha  = 0.75
+)
+
+var loadFont = sync.OnceValues(func() (*opentype.Font, error) {
+	return opentype.Parse(gobold.TTF)
+})
+
+// SocialCardParams turns an image into a Width×Height open-graph card,
+// scaled and center-cropped to cover it. Title is set in white in the
+// bottom-left corner over a dark gradient scrim, which Scrim also draws
+// without a title. BrandColor adds a bar of that color along the bottom.
+type SocialCardParams struct {
+	Title      string `json:"title,omitempty" validate:"max=120"`
+	BrandColor string `json:"brand_color,omitempty" validate:"max=20"`
+	Scrim      bool   `json:"scrim,omitempty"`
+}
+
+// OutputSize returns the card size, whatever the input.
+func (params *SocialCardParams) OutputSize(width, height int) (int, int, bool) {
+	return Width, Height, true
+}
+
+func (params *SocialCardParams) SocialCardImage(img image.Image) (image.Image, error) {
+	const op = "api.socialcard.SocialCardImage"
+
+	var brand color.Color
+	if params.BrandColor != "" {
+		c, err := colors.Parse(params.BrandColor)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		brand = c
+	}
+
+	cover := resize.ResizeParams{Width: Width, Height: Height, Mode: resize.ModeFill}
+	covered, err := cover.ResizeImage(img)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	card := image.NewRGBA(image.Rect(0, 0, Width, Height))
+	draw.Draw(card, card.Bounds(), covered, covered.Bounds().Min, draw.Src)
+
+	bottom := Height
+	if brand != nil {
+		bottom -= brandBar
+		draw.Draw(card, image.Rect(0, bottom, Width, Height), image.NewUniform(brand), image.Point{}, draw.Src)
+	}
+
+	title := strings.Join(strings.Fields(params.Title), " ")
+	if params.Scrim || title != "" {
+		drawScrim(card, bottom)
+	}
+
+	if title != "" {
+		if err := drawTitle(card, title, bottom); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return card, nil
+}
+
+// drawScrim darkens dst above y with a black gradient that fades out
+// upwards.
+func drawScrim(dst draw.Image, y int) {
+	top := y - int(float64(Height)*scrimHeight)
+	for row := top; row < y; row++ {
+		alpha := scrimAlpha * float64(row-top) / float64(y-top)
+		shade := image.NewUniform(color.NRGBA{A: uint8(alpha * 255)})
+		draw.Draw(dst, image.Rect(0, row, Width, row+1), shade, image.Point{}, draw.Over)
+	}
+}
+
+// drawTitle sets title above y, shrinking the font until it wraps into
+// maxLines. Text that still doesn't fit at minFontSize is cut with an
+// ellipsis.
+func drawTitle(dst draw.Image, title string, y int) error {
+	f, err := loadFont()
+	if err != nil {
+		return err
+	}
+
+	var (
+		face  font.Face
+		lines []string
+	)
+	for size := maxFontSize; ; size -= 4 {
+		face, err = opentype.NewFace(f, &opentype.FaceOptions{Size: float64(size), DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return err
+		}
+
+		lines = wrap(face, title, Width-2*margin)
+		if len(lines) <= maxLines || size-4 < minFontSize {
+			break
+		}
+		face.Close()
+	}
+	defer face.Close()
+
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		lines[maxLines-1] = ellipsize(face, lines[maxLines-1], Width-2*margin)
+	}
+
+	lineHeight := face.Metrics().Height.Ceil()
+	drawer := font.Drawer{Dst: dst, Src: image.NewUniform(color.White), Face: face}
+	baseline := y - margin - (len(lines)-1)*lineHeight
+
+	for i, line := range lines {
+		drawer.Dot = fixed.P(margin, baseline+i*lineHeight)
+		drawer.DrawString(line)
+	}
+
+	return nil
+}
+
+// wrap breaks text into lines no wider than width. A word that is wider
+// on its own gets a line of its own.
+func wrap(face font.Face, text string, width int) []string {
+	var lines []string
+	line := ""
+
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+
+		if line != "" && font.MeasureString(face, candidate).Ceil() > width {
+			lines = append(lines, line)
+			candidate = word
+		}
+		line = candidate
+	}
+
+	return append(lines, line)
+}
+
+// ellipsize ends line with "…", dropping as many runes as it takes to keep
+// it within width.
+func ellipsize(face font.Face, line string, width int) string {
+	runes := []rune(line)
+	for ; len(runes) > 0; runes = runes[:len(runes)-1] {
+		candidate := strings.TrimRight(string(runes), " ") + "…"
+		if font.MeasureString(face, candidate).Ceil() <= width {
+			return candidate
+		}
+	}
+	return "…"
+}
@@ -0,0 +1,65 @@
+package socialcard_test
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/socialcard"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func nrgbaAt(img image.Image, x, y int) color.NRGBA {
+	return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+}
+
+func TestSocialCardImage_Size(t *testing.T) {
+	params := socialcard.SocialCardParams{}
+
+	out, err := params.SocialCardImage(imaging.New(300, 400, color.White))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, socialcard.Width, socialcard.Height), out.Bounds())
+
+	// Without a title or scrim the image is only resized.
+	assert.Equal(t, color.NRGBA{R: 255, G: 255, B: 255, A: 255}, nrgbaAt(out, 600, 620))
+}
+
+func TestSocialCardImage_TitleAndBrand(t *testing.T) {
+	params := socialcard.SocialCardParams{Title: "Hello world", BrandColor: "#ff0000"}
+
+	out, err := params.SocialCardImage(imaging.New(1200, 630, color.NRGBA{G: 255, A: 255}))
+	require.NoError(t, err)
+
+	assert.Equal(t, color.NRGBA{R: 255, A: 255}, nrgbaAt(out, 600, 625), "brand bar")
+
+	// The scrim darkens the bottom but leaves the top alone.
+	assert.Equal(t, uint8(255), nrgbaAt(out, 1100, 10).G)
+	assert.Less(t, nrgbaAt(out, 1100, 600).G, uint8(100))
+
+	// White title text sits in the bottom-left corner.
+	white := 0
+	for y := 400; y < 618; y++ {
+		for x := 0; x < 600; x++ {
+			if c := nrgbaAt(out, x, y); c.R > 200 && c.G > 200 && c.B > 200 {
+				white++
+			}
+		}
+	}
+	assert.Greater(t, white, 500)
+}
+
+func TestSocialCardImage_LongTitle(t *testing.T) {
+	params := socialcard.SocialCardParams{Title: strings.Repeat("word ", 24)}
+
+	out, err := params.SocialCardImage(imaging.New(1200, 630, color.Black))
+	require.NoError(t, err)
+
+	// The title wraps below the top of the card instead of running off it.
+	for x := 0; x < socialcard.Width; x++ {
+		assert.Equal(t, uint8(0), nrgbaAt(out, x, 5).R)
+	}
+}
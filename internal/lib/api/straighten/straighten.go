@@ -0,0 +1,235 @@
+package straighten
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	defaultMaxAngle = 10.0
+
+	// analysisSize bounds the image used for line detection.
+	analysisSize = 512
+	// angleStep is the resolution of the Hough accumulator, in degrees.
+	angleStep = 0.2
+	// gateMargin is how far past maxAngle from horizontal or vertical the
+	// gradient of an edge pixel may point for it to vote. Gradients of
+	// aliased edges are off by several degrees, so the margin is wide;
+	// the accumulator, not the gradient, decides the angle.
+	gateMargin = 20.0
+	// edgeThreshold is the fraction of the strongest gradient that counts
+	// as an edge.
+	edgeThreshold = 0.25
+	// minLineFraction is how much of the shorter image side the dominant
+	// line must cover to be trusted.
+	minLineFraction = 0.25
+	// minPeakContrast is how many times stronger the dominant line must be
+	// at its angle than at the angle it is weakest at. Texture votes about
+	// as much for every angle, a straight line for one.
+	minPeakContrast = 1.5
+)
+
+type StraightenParams struct {
+	MaxAngle float64  `json:"max_angle" validate:"omitempty,min=0.1,max=45"`
+	Angle    *float64 `json:"angle,omitempty" validate:"omitempty,min=-45,max=45"`
+}
+
+// StraightenImage levels the image by rotating it counter-clockwise by
+// Angle, or by the tilt of the dominant near-horizontal/near-vertical line
+// when no angle is given, and crops away the exposed corners. Images
+// without a clear dominant line are returned unchanged.
+func (params *StraightenParams) StraightenImage(img image.Image) (image.Image, error) {
+	var angle float64
+
+	if params.Angle != nil {
+		angle = *params.Angle
+	} else {
+		maxAngle := params.MaxAngle
+		if maxAngle == 0 {
+			maxAngle = defaultMaxAngle
+		}
+
+		detected, ok := detectTilt(img, maxAngle)
+		if !ok {
+			return img, nil
+		}
+		angle = detected
+	}
+
+	if math.Abs(angle) < angleStep/2 {
+		return img, nil
+	}
+
+	return rotateAndCrop(img, angle), nil
+}
+
+// detectTilt finds the tilt of the dominant line with a Hough transform
+// over Sobel edges. Every edge pixel whose gradient is near horizontal or
+// near vertical votes, weighted by its gradient magnitude, for every
+// angle of that family within maxAngle, so a long straight edge piles its
+// votes into one bin while short or curved ones spread theirs. The peak is
+// refined between angle steps from its neighbours.
+func detectTilt(img image.Image, maxAngle float64) (float64, bool) {
+	gray := imaging.Grayscale(imaging.Fit(img, analysisSize, analysisSize, imaging.Box))
+	w, h := gray.Bounds().Dx(), gray.Bounds().Dy()
+	if w < 3 || h < 3 {
+		return 0, false
+	}
+
+	lum := func(x, y int) float64 {
+		return float64(gray.Pix[y*gray.Stride+x*4])
+	}
+
+	gx := make([]float64, w*h)
+	gy := make([]float64, w*h)
+	maxMag := 0.0
+
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			dx := lum(x+1, y-1) + 2*lum(x+1, y) + lum(x+1, y+1) -
+				lum(x-1, y-1) - 2*lum(x-1, y) - lum(x-1, y+1)
+			dy := lum(x-1, y+1) + 2*lum(x, y+1) + lum(x+1, y+1) -
+				lum(x-1, y-1) - 2*lum(x, y-1) - lum(x+1, y-1)
+
+			gx[y*w+x], gy[y*w+x] = dx, dy
+			maxMag = math.Max(maxMag, math.Hypot(dx, dy))
+		}
+	}
+
+	if maxMag == 0 {
+		return 0, false
+	}
+
+	nAngles := int(math.Round(2*maxAngle/angleStep)) + 1
+	diag := math.Hypot(float64(w), float64(h))
+	nRho := int(2*diag) + 2
+
+	// Horizontal lines have their normal near 90°, vertical ones near 0°.
+	// Each family has an accumulator of its own, as their rho differ.
+	type family struct {
+		base     float64
+		cos, sin []float64
+		acc      []float64
+	}
+	families := []*family{{base: 90}, {base: 0}}
+	for _, f := range families {
+		f.cos, f.sin = make([]float64, nAngles), make([]float64, nAngles)
+		for i := range nAngles {
+			theta := (f.base + float64(i)*angleStep - maxAngle) * math.Pi / 180
+			f.cos[i], f.sin[i] = math.Cos(theta), math.Sin(theta)
+		}
+		f.acc = make([]float64, nAngles*nRho)
+	}
+
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			dx, dy := gx[y*w+x], gy[y*w+x]
+			mag := math.Hypot(dx, dy)
+			if mag < edgeThreshold*maxMag {
+				continue
+			}
+
+			normal := math.Atan2(dy, dx) * 180 / math.Pi
+			for _, f := range families {
+				if math.Abs(normalizeAngle(normal-f.base)) > maxAngle+gateMargin {
+					continue
+				}
+				for i := range nAngles {
+					rho := float64(x)*f.cos[i] + float64(y)*f.sin[i]
+					f.acc[i*nRho+int(rho+diag)] += mag / maxMag
+				}
+			}
+		}
+	}
+
+	// peaks[i] is the strongest line at angle i across both families.
+	peaks := make([]float64, nAngles)
+	for _, f := range families {
+		for i := range nAngles {
+			for _, votes := range f.acc[i*nRho : (i+1)*nRho] {
+				peaks[i] = math.Max(peaks[i], votes)
+			}
+		}
+	}
+
+	best, weakest := 0, 0
+	for i, votes := range peaks {
+		if votes > peaks[best] {
+			best = i
+		}
+		if votes < peaks[weakest] {
+			weakest = i
+		}
+	}
+
+	if peaks[best] < minLineFraction*float64(min(w, h)) || peaks[best] < minPeakContrast*peaks[weakest] {
+		return 0, false
+	}
+
+	return (float64(best)+refinePeak(peaks, best))*angleStep - maxAngle, true
+}
+
+// refinePeak returns the offset, within half a step, of the vertex of the
+// parabola through the peak at i and its neighbours.
+func refinePeak(peaks []float64, i int) float64 {
+	if i == 0 || i == len(peaks)-1 {
+		return 0
+	}
+
+	left, mid, right := peaks[i-1], peaks[i], peaks[i+1]
+	curvature := left - 2*mid + right
+	if curvature >= 0 {
+		return 0
+	}
+	return min(max(0.5*(left-right)/curvature, -0.5), 0.5)
+}
+
+// normalizeAngle maps an angle in degrees to (-90, 90], treating a line
+// normal and its opposite as the same direction.
+func normalizeAngle(a float64) float64 {
+	a = math.Mod(a, 180)
+	if a > 90 {
+		a -= 180
+	} else if a <= -90 {
+		a += 180
+	}
+	return a
+}
+
+// OutputSize returns the dimensions for a width×height image. They are
+// only known upfront when Angle is given.
+func (params *StraightenParams) OutputSize(width, height int) (int, int, bool) {
+	if params.Angle == nil {
+		return 0, 0, false
+	}
+	if math.Abs(*params.Angle) < angleStep/2 {
+		return width, height, true
+	}
+
+	cropW, cropH := cropSize(width, height, *params.Angle)
+
+	return cropW, cropH, true
+}
+
+// rotateAndCrop rotates the image and crops the largest centered rectangle
+// with the original aspect ratio that contains no exposed background.
+func rotateAndCrop(img image.Image, angle float64) image.Image {
+	cropW, cropH := cropSize(img.Bounds().Dx(), img.Bounds().Dy(), angle)
+
+	rotated := imaging.Rotate(img, angle, color.Transparent)
+
+	return imaging.CropCenter(rotated, cropW, cropH)
+}
+
+func cropSize(width, height int, angle float64) (int, int) {
+	w, h := float64(width), float64(height)
+	rad := math.Abs(angle) * math.Pi / 180
+	sin, cos := math.Sin(rad), math.Cos(rad)
+
+	scale := math.Min(w/(w*cos+h*sin), h/(w*sin+h*cos))
+
+	return max(1, int(w*scale)-2), max(1, int(h*scale)-2)
+}
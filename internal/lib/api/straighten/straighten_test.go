@@ -0,0 +1,168 @@
+package straighten_test
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/straighten"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tilted returns a 400×300 image of a light half beside a dark one, split
+// horizontally or vertically through the middle, tilted counter-clockwise
+// by angle. The edge is aliased, as in a photo sharpened or downscaled
+// without filtering, and a little noise is added on top.
+func tilted(angle float64, vertical bool) image.Image {
+	const w, h = 400, 300
+	rad := angle * math.Pi / 180
+	sin, cos := math.Sin(rad), math.Cos(rad)
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			dx, dy := float64(x)-w/2+0.5, float64(y)-h/2+0.5
+
+			// The signed distance from the edge, negative on the light side.
+			dist := dy*cos + dx*sin
+			if vertical {
+				dist = dx*cos - dy*sin
+			}
+
+			v := 40
+			if dist < 0 {
+				v = 220
+			}
+			v += rng.IntN(9) - 4
+
+			i := img.PixOffset(x, y)
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = uint8(v), uint8(v), uint8(v), 255
+		}
+	}
+	return img
+}
+
+// edgeAt returns where the light half gives way to the dark one along the
+// row or column at i, to a fraction of a pixel: the share of light pixels
+// before it, as seen from the light side.
+func edgeAt(img image.Image, i int, vertical bool) float64 {
+	b := img.Bounds()
+	n := b.Dy()
+	if vertical {
+		n = b.Dx()
+	}
+
+	var light float64
+	for j := range n {
+		x, y := b.Min.X+i, b.Min.Y+j
+		if vertical {
+			x, y = b.Min.X+j, b.Min.Y+i
+		}
+		r, _, _, _ := img.At(x, y).RGBA()
+		light += (float64(r>>8) - 40) / (220 - 40)
+	}
+	return light
+}
+
+// residualTilt returns the angle, in degrees counter-clockwise, between
+// the edge of a tilted image and the horizontal or vertical, measured
+// near its ends. Turning counter-clockwise lifts the right end of a
+// horizontal edge and moves the bottom of a vertical one right.
+func residualTilt(img image.Image, vertical bool) float64 {
+	side := img.Bounds().Dx()
+	if vertical {
+		side = img.Bounds().Dy()
+	}
+	lo, hi := side/8, side-1-side/8
+
+	shift := edgeAt(img, hi, vertical) - edgeAt(img, lo, vertical)
+	if !vertical {
+		shift = -shift
+	}
+	return math.Atan2(shift, float64(hi-lo)) * 180 / math.Pi
+}
+
+func TestStraightenParams_StraightenImage_Detects(t *testing.T) {
+	tests := []struct {
+		name     string
+		angle    float64
+		vertical bool
+	}{
+		{"horizon -7°", -7, false},
+		{"horizon -3°", -3, false},
+		{"horizon 2°", 2, false},
+		{"horizon 5°", 5, false},
+		{"horizon 8°", 8, false},
+		{"wall -4°", -4, true},
+		{"wall 6°", 6, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := tilted(tt.angle, tt.vertical)
+			require.InDelta(t, tt.angle, residualTilt(src, tt.vertical), 0.5, "the test image must be tilted as asked")
+
+			params := straighten.StraightenParams{}
+			out, err := params.StraightenImage(src)
+			require.NoError(t, err)
+
+			// The rotation crops the exposed corners, so the image
+			// changed size as for an angle of that magnitude.
+			assert.Less(t, out.Bounds().Dx(), src.Bounds().Dx())
+			assert.InDelta(t, 0, residualTilt(out, tt.vertical), 0.5, "the edge is level after straightening")
+		})
+	}
+}
+
+func TestStraightenParams_StraightenImage_MaxAngle(t *testing.T) {
+	src := tilted(8, false)
+
+	// A tilt past max_angle isn't looked for.
+	params := straighten.StraightenParams{MaxAngle: 3}
+	out, err := params.StraightenImage(src)
+	require.NoError(t, err)
+	assert.Greater(t, math.Abs(residualTilt(out, false)), 2.5)
+}
+
+func TestStraightenParams_StraightenImage_NoDominantLine(t *testing.T) {
+	noise := image.NewNRGBA(image.Rect(0, 0, 300, 200))
+	rng := rand.New(rand.NewPCG(3, 5))
+	for i := range noise.Pix {
+		noise.Pix[i] = uint8(rng.IntN(256))
+		if i%4 == 3 {
+			noise.Pix[i] = 255
+		}
+	}
+
+	for name, src := range map[string]image.Image{
+		"flat":  imaging.New(300, 200, color.White),
+		"noise": noise,
+	} {
+		t.Run(name, func(t *testing.T) {
+			params := straighten.StraightenParams{}
+			out, err := params.StraightenImage(src)
+			require.NoError(t, err)
+			assert.Same(t, src, out, "left unchanged")
+		})
+	}
+}
+
+func TestStraightenParams_StraightenImage_Angle(t *testing.T) {
+	src := tilted(4, false)
+
+	angle := -4.0
+	params := straighten.StraightenParams{Angle: &angle}
+	out, err := params.StraightenImage(src)
+	require.NoError(t, err)
+	assert.InDelta(t, 0, residualTilt(out, false), 0.5)
+
+	w, h, ok := params.OutputSize(src.Bounds().Dx(), src.Bounds().Dy())
+	assert.True(t, ok)
+	assert.Equal(t, image.Rect(0, 0, w, h), out.Bounds())
+}
@@ -0,0 +1,56 @@
+package swirl
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"online-photo-editor/internal/lib/warp"
+)
+
+// SwirlParams twists the disc of Radius pixels around CenterX, CenterY.
+// The center turns by Angle degrees, counter-clockwise, and the twist
+// eases off to nothing at the rim, so the image outside the disc is left
+// as it is.
+type SwirlParams struct {
+	CenterX int     `json:"center_x" validate:"min=0"`
+	CenterY int     `json:"center_y" validate:"min=0"`
+	Radius  int     `json:"radius" validate:"required,min=1,max=10000"`
+	Angle   float64 `json:"angle" validate:"required,min=-3600,max=3600"`
+}
+
+// CheckBounds reports whether the center lies in a width×height image.
+func (params *SwirlParams) CheckBounds(width, height int) error {
+	const op = "api.swirl.CheckBounds"
+
+	if params.CenterX >= width || params.CenterY >= height {
+		return fmt.Errorf("%s center is outside the image", op)
+	}
+
+	return nil
+}
+
+func (params *SwirlParams) SwirlImage(img image.Image) (image.Image, error) {
+	b := img.Bounds()
+	if err := params.CheckBounds(b.Dx(), b.Dy()); err != nil {
+		return nil, err
+	}
+
+	cx, cy := float64(params.CenterX)+0.5, float64(params.CenterY)+0.5
+	radius := float64(params.Radius)
+	angle := params.Angle * math.Pi / 180
+
+	return warp.Remap(img, func(x, y float64) (float64, float64, bool) {
+		dx, dy := x-cx, y-cy
+		d := math.Hypot(dx, dy)
+		if d >= radius {
+			return 0, 0, false
+		}
+
+		// Content turns counter-clockwise on screen, so every pixel is
+		// sampled from the same distance, turned clockwise; y points down.
+		falloff := 1 - d/radius
+		sin, cos := math.Sincos(angle * falloff * falloff)
+		return cx + dx*cos - dy*sin, cy + dx*sin + dy*cos, true
+	}), nil
+}
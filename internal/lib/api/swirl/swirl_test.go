@@ -0,0 +1,71 @@
+package swirl_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/swirl"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	red    = color.NRGBA{R: 255, A: 255}
+	green  = color.NRGBA{G: 255, A: 255}
+	blue   = color.NRGBA{B: 255, A: 255}
+	yellow = color.NRGBA{R: 255, G: 255, A: 255}
+)
+
+// quadrants is red top-left, green top-right, blue bottom-left and
+// yellow bottom-right.
+func quadrants(size int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			c := [2][2]color.NRGBA{{red, green}, {blue, yellow}}[y*2/size][x*2/size]
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestSwirlImage(t *testing.T) {
+	img := quadrants(200)
+	params := swirl.SwirlParams{CenterX: 100, CenterY: 100, Radius: 60, Angle: 90}
+
+	out, err := params.SwirlImage(img)
+	require.NoError(t, err)
+	got := out.(*image.NRGBA)
+
+	// Near the center the quadrants turned by close to 90° counter-
+	// clockwise: each spot shows the quadrant that was below or to the
+	// right of it.
+	assert.Equal(t, yellow, got.NRGBAAt(104, 95), "top right shows bottom right")
+	assert.Equal(t, green, got.NRGBAAt(95, 95), "top left shows top right")
+	assert.Equal(t, red, got.NRGBAAt(95, 104), "bottom left shows top left")
+	assert.Equal(t, blue, got.NRGBAAt(104, 104), "bottom right shows bottom left")
+
+	// Outside the radius nothing moved.
+	for _, p := range []image.Point{{0, 0}, {199, 0}, {30, 180}, {100, 35}, {165, 100}} {
+		assert.Equal(t, img.NRGBAAt(p.X, p.Y), got.NRGBAAt(p.X, p.Y), p)
+	}
+}
+
+func TestSwirlImage_Clockwise(t *testing.T) {
+	params := swirl.SwirlParams{CenterX: 100, CenterY: 100, Radius: 60, Angle: -90}
+
+	out, err := params.SwirlImage(quadrants(200))
+	require.NoError(t, err)
+
+	assert.Equal(t, red, out.(*image.NRGBA).NRGBAAt(104, 95), "top right shows top left")
+}
+
+func TestSwirlImage_CenterOutside(t *testing.T) {
+	params := swirl.SwirlParams{CenterX: 50, CenterY: 10, Radius: 5, Angle: 45}
+
+	assert.Error(t, params.CheckBounds(50, 50))
+	_, err := params.SwirlImage(quadrants(50))
+	assert.Error(t, err)
+}
@@ -0,0 +1,88 @@
+package tiles
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"online-photo-editor/internal/lib/colors"
+
+	"github.com/disintegration/imaging"
+)
+
+const defaultTileSize = 256
+
+// MaxTiles bounds the number of tiles a single split may produce.
+const MaxTiles = 4096
+
+// TilesParams slices an image into a grid of TileSize×TileSize tiles,
+// left to right and top to bottom. Tiles on the right and bottom edges are
+// smaller unless Pad is set, in which case they are padded to the full
+// size with Background (transparent by default).
+type TilesParams struct {
+	TileSize   int    `json:"tile_size,omitempty" validate:"omitempty,min=16,max=2048"`
+	Pad        bool   `json:"pad,omitempty"`
+	Background string `json:"background,omitempty" validate:"excluded_without=Pad,max=20"`
+}
+
+// Tile is one cell of the grid. X and Y are its offset in the source.
+type Tile struct {
+	Column int
+	Row    int
+	X      int
+	Y      int
+	Image  image.Image
+}
+
+// Size returns the tile size used for the split.
+func (params *TilesParams) Size() int {
+	if params.TileSize == 0 {
+		return defaultTileSize
+	}
+	return params.TileSize
+}
+
+// Grid returns the number of columns and rows a width×height image is
+// split into.
+func (params *TilesParams) Grid(width, height int) (columns, rows int) {
+	size := params.Size()
+	return (width + size - 1) / size, (height + size - 1) / size
+}
+
+// SplitImage returns the tiles of img in row order.
+func (params *TilesParams) SplitImage(img image.Image) ([]Tile, error) {
+	const op = "api.tiles.SplitImage"
+
+	bounds := img.Bounds()
+	columns, rows := params.Grid(bounds.Dx(), bounds.Dy())
+	if columns*rows > MaxTiles {
+		return nil, fmt.Errorf("%s image would split into %d tiles, more than %d", op, columns*rows, MaxTiles)
+	}
+
+	var background color.Color = color.Transparent
+	if params.Background != "" {
+		c, err := colors.Parse(params.Background)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		background = c
+	}
+
+	size := params.Size()
+	tiles := make([]Tile, 0, columns*rows)
+
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			x, y := col*size, row*size
+			rect := image.Rect(x, y, x+size, y+size).Add(bounds.Min).Intersect(bounds)
+
+			var tile image.Image = imaging.Crop(img, rect)
+			if params.Pad && (rect.Dx() < size || rect.Dy() < size) {
+				tile = imaging.Paste(imaging.New(size, size, background), tile, image.Point{})
+			}
+
+			tiles = append(tiles, Tile{Column: col, Row: row, X: x, Y: y, Image: tile})
+		}
+	}
+
+	return tiles, nil
+}
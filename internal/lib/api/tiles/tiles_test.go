@@ -0,0 +1,60 @@
+package tiles_test
+
+import (
+	"image/color"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/tiles"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitImage(t *testing.T) {
+	img := imaging.New(600, 600, color.White)
+
+	params := tiles.TilesParams{TileSize: 256}
+
+	out, err := params.SplitImage(img)
+	require.NoError(t, err)
+	require.Len(t, out, 9)
+
+	sizes := []int{256, 256, 88}
+	for i, tile := range out {
+		assert.Equal(t, i%3, tile.Column)
+		assert.Equal(t, i/3, tile.Row)
+		assert.Equal(t, tile.Column*256, tile.X)
+		assert.Equal(t, tile.Row*256, tile.Y)
+		assert.Equal(t, sizes[tile.Column], tile.Image.Bounds().Dx(), "tile %d width", i)
+		assert.Equal(t, sizes[tile.Row], tile.Image.Bounds().Dy(), "tile %d height", i)
+	}
+}
+
+func TestSplitImage_Pad(t *testing.T) {
+	img := imaging.New(600, 600, color.White)
+
+	params := tiles.TilesParams{TileSize: 256, Pad: true, Background: "#000000"}
+
+	out, err := params.SplitImage(img)
+	require.NoError(t, err)
+	require.Len(t, out, 9)
+
+	for i, tile := range out {
+		assert.Equal(t, 256, tile.Image.Bounds().Dx(), "tile %d width", i)
+		assert.Equal(t, 256, tile.Image.Bounds().Dy(), "tile %d height", i)
+	}
+
+	corner := out[8].Image
+	assert.Equal(t, color.NRGBA{R: 255, G: 255, B: 255, A: 255}, color.NRGBAModel.Convert(corner.At(87, 87)))
+	assert.Equal(t, color.NRGBA{A: 255}, color.NRGBAModel.Convert(corner.At(88, 88)))
+}
+
+func TestSplitImage_TooManyTiles(t *testing.T) {
+	img := imaging.New(2000, 2000, color.White)
+
+	params := tiles.TilesParams{TileSize: 16}
+
+	_, err := params.SplitImage(img)
+	assert.Error(t, err)
+}
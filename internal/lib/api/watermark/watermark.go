@@ -0,0 +1,130 @@
+package watermark
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	PositionTopLeft     = "top-left"
+	PositionTopRight    = "top-right"
+	PositionBottomLeft  = "bottom-left"
+	PositionBottomRight = "bottom-right"
+	PositionCenter      = "center"
+)
+
+const defaultOpacity = 0.5
+
+// WatermarkParams overlays the stored image ImageName. By default a single
+// mark sits at Position, Margin pixels from the edges. With Tile the mark
+// repeats across the whole image in staggered rows, Spacing pixels apart,
+// and every row is offset by half a tile so the pattern runs diagonally.
+// Angle rotates the mark counter-clockwise, in degrees. Opacity, from 0 to
+// 1, applies to every mark on its own.
+type WatermarkParams struct {
+	ImageName string  `json:"image_name" validate:"required,max=100,image_name"`
+	Opacity   float64 `json:"opacity,omitempty" validate:"min=0,max=1"`
+	Position  string  `json:"position,omitempty" validate:"omitempty,oneof=top-left top-right bottom-left bottom-right center"`
+	Margin    int     `json:"margin,omitempty" validate:"min=0,max=1000"`
+	Tile      bool    `json:"tile,omitempty"`
+	Spacing   int     `json:"spacing,omitempty" validate:"min=0,max=2000"`
+	Angle     float64 `json:"angle,omitempty" validate:"min=-180,max=180"`
+}
+
+func (params *WatermarkParams) validate() error {
+	const op = "api.watermark.validate"
+
+	if params.Tile && params.Position != "" {
+		return fmt.Errorf("%s position is not supported with tile", op)
+	}
+
+	if !params.Tile && params.Spacing != 0 {
+		return fmt.Errorf("%s spacing requires tile", op)
+	}
+
+	return nil
+}
+
+// WatermarkImage overlays mark, the loaded ImageName, on img.
+func (params *WatermarkParams) WatermarkImage(img image.Image, mark image.Image) (image.Image, error) {
+	const op = "api.watermark.WatermarkImage"
+
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+
+	if mark == nil {
+		return nil, fmt.Errorf("%s watermark image is missing", op)
+	}
+
+	opacity := params.Opacity
+	if opacity == 0 {
+		opacity = defaultOpacity
+	}
+
+	var src *image.NRGBA
+	if params.Angle != 0 {
+		src = imaging.Rotate(mark, params.Angle, color.Transparent)
+	} else {
+		src = imaging.Clone(mark)
+	}
+
+	// All marks are drawn into one canvas: imaging.Overlay copies the
+	// whole background on every call.
+	sb := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, sb.Dx(), sb.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, sb.Min, draw.Src)
+
+	b := dst.Bounds()
+	mw, mh := src.Bounds().Dx(), src.Bounds().Dy()
+	alpha := image.NewUniform(color.Alpha{A: uint8(opacity*255 + 0.5)})
+
+	overlay := func(pt image.Point) {
+		draw.DrawMask(dst, image.Rectangle{Min: pt, Max: pt.Add(image.Pt(mw, mh))}, src, image.Point{}, alpha, image.Point{}, draw.Over)
+	}
+
+	if !params.Tile {
+		overlay(params.position(b.Dx(), b.Dy(), mw, mh))
+		return dst, nil
+	}
+
+	stepX, stepY := mw+params.Spacing, mh+params.Spacing
+
+	for row, y := 0, 0; y < b.Dy(); row, y = row+1, y+stepY {
+		// Start odd rows half a tile to the left so they still reach the
+		// left edge.
+		x := 0
+		if row%2 == 1 {
+			x = -stepX / 2
+		}
+
+		for ; x < b.Dx(); x += stepX {
+			overlay(image.Pt(x, y))
+		}
+	}
+
+	return dst, nil
+}
+
+// position returns where a mw×mh mark goes on a width×height image.
+func (params *WatermarkParams) position(width, height, mw, mh int) image.Point {
+	left, top := params.Margin, params.Margin
+	right, bottom := width-mw-params.Margin, height-mh-params.Margin
+
+	switch params.Position {
+	case PositionTopLeft:
+		return image.Pt(left, top)
+	case PositionTopRight:
+		return image.Pt(right, top)
+	case PositionBottomLeft:
+		return image.Pt(left, bottom)
+	case PositionCenter:
+		return image.Pt((width-mw)/2, (height-mh)/2)
+	default:
+		return image.Pt(right, bottom)
+	}
+}
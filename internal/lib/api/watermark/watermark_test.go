@@ -0,0 +1,58 @@
+package watermark_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"online-photo-editor/internal/lib/api/watermark"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func redAt(img image.Image, x, y int) uint8 {
+	return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA).R
+}
+
+func TestWatermarkImage_Tile(t *testing.T) {
+	img := imaging.New(100, 100, color.Black)
+	mark := imaging.New(10, 10, color.White)
+
+	params := watermark.WatermarkParams{ImageName: "mark.png", Tile: true, Spacing: 10, Opacity: 0.5}
+
+	out, err := params.WatermarkImage(img, mark)
+	require.NoError(t, err)
+	assert.Equal(t, img.Bounds(), out.Bounds())
+
+	// Marks repeat in even rows and, shifted half a tile, in odd ones.
+	for _, pt := range []image.Point{{5, 5}, {25, 5}, {85, 5}, {15, 25}, {95, 25}} {
+		assert.InDelta(t, 128, redAt(out, pt.X, pt.Y), 2, "mark at %v", pt)
+	}
+	// Gaps between marks keep the image.
+	for _, pt := range []image.Point{{15, 5}, {5, 15}, {25, 25}} {
+		assert.Equal(t, uint8(0), redAt(out, pt.X, pt.Y), "gap at %v", pt)
+	}
+}
+
+func TestWatermarkImage_SingleDefaultsToBottomRight(t *testing.T) {
+	img := imaging.New(100, 100, color.Black)
+	mark := imaging.New(10, 10, color.White)
+
+	params := watermark.WatermarkParams{ImageName: "mark.png", Margin: 5, Opacity: 1}
+
+	out, err := params.WatermarkImage(img, mark)
+	require.NoError(t, err)
+
+	assert.Equal(t, uint8(255), redAt(out, 90, 90))
+	assert.Equal(t, uint8(0), redAt(out, 96, 96))
+	assert.Equal(t, uint8(0), redAt(out, 5, 5))
+}
+
+func TestWatermarkImage_PositionWithTile(t *testing.T) {
+	params := watermark.WatermarkParams{ImageName: "mark.png", Tile: true, Position: watermark.PositionCenter}
+
+	_, err := params.WatermarkImage(imaging.New(10, 10, color.Black), imaging.New(2, 2, color.White))
+	assert.Error(t, err)
+}
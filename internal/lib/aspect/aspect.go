@@ -0,0 +1,45 @@
+package aspect
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxTerm bounds both terms of a ratio, which is plenty for "21:9" or
+// "1.91:1" and keeps the arithmetic on them exact.
+const maxTerm = 10000
+
+// Parse accepts an aspect ratio as "width:height", e.g. "16:9" or
+// "1.91:1", and returns width/height.
+func Parse(s string) (float64, error) {
+	const op = "lib.aspect.Parse"
+
+	w, h, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		return 0, fmt.Errorf("%s: %q is not in the width:height form", op, s)
+	}
+
+	width, err := parseTerm(w)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %q: %w", op, s, err)
+	}
+	height, err := parseTerm(h)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %q: %w", op, s, err)
+	}
+
+	return width / height, nil
+}
+
+func parseTerm(s string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, err
+	}
+	// NaN fails both comparisons.
+	if !(v > 0 && v <= maxTerm) {
+		return 0, fmt.Errorf("terms must be between 0 and %d", maxTerm)
+	}
+	return v, nil
+}
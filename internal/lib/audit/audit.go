@@ -0,0 +1,143 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const (
+	ActionDelete    = "delete"
+	ActionOverwrite = "overwrite"
+	// ActionEvict is an image removed to keep the storage within its
+	// quota.
+	ActionEvict = "evict"
+)
+
+const (
+	SinkStdout = "stdout"
+	SinkFile   = "file"
+)
+
+// APIKeyHeader is the header a client's API key is read from.
+const APIKeyHeader = "X-API-Key"
+
+// Entry records one destructive operation.
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Image  string    `json:"image"`
+	Origin
+}
+
+// Origin is the request an operation comes from, empty for those that
+// don't come from one.
+type Origin struct {
+	// Actor identifies the API key the request was made with.
+	Actor      string `json:"actor,omitempty"`
+	RequestID  string `json:"request_id,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+}
+
+type Sink interface {
+	Record(entry Entry) error
+}
+
+// FromRequest returns an entry for action on image, attributed to the
+// client of r.
+func FromRequest(r *http.Request, action, image string) Entry {
+	return OriginOf(r).Entry(action, image)
+}
+
+// OriginOf returns the Origin of r, for the operations the storage does
+// on its behalf.
+func OriginOf(r *http.Request) Origin {
+	return Origin{
+		Actor:      Actor(r),
+		RequestID:  middleware.GetReqID(r.Context()),
+		RemoteAddr: r.RemoteAddr,
+	}
+}
+
+// Entry returns an entry for action on image, now, attributed to o.
+func (o Origin) Entry(action, image string) Entry {
+	return Entry{Time: time.Now().UTC(), Action: action, Image: image, Origin: o}
+}
+
+// Actor returns "key:" and a fingerprint of the request's API key, so the
+// log identifies the key without containing it, or "anonymous".
+func Actor(r *http.Request) string {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		return "anonymous"
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:8])
+}
+
+// WriterSink writes entries as JSON lines.
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+func (s *WriterSink) Record(entry Entry) error {
+	const op = "audit.WriterSink.Record"
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Nop discards entries.
+type Nop struct{}
+
+func (Nop) Record(Entry) error { return nil }
+
+// New returns the sink named kind: SinkStdout, SinkFile appending to path,
+// or Nop for an empty kind. close releases the file, if any.
+func New(kind, path string) (sink Sink, close func() error, err error) {
+	const op = "audit.New"
+
+	switch kind {
+	case "":
+		return Nop{}, func() error { return nil }, nil
+	case SinkStdout:
+		return NewWriterSink(os.Stdout), func() error { return nil }, nil
+	case SinkFile:
+		if path == "" {
+			return nil, nil, fmt.Errorf("%s: file sink needs a path", op)
+		}
+
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", op, err)
+		}
+		return NewWriterSink(file), file.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("%s: unknown audit sink: %s", op, kind)
+	}
+}
@@ -0,0 +1,48 @@
+package colors
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/colornames"
+)
+
+// Parse accepts SVG color names ("white", "tomato"), "transparent" and hex
+// notation in the #rgb, #rrggbb and #rrggbbaa forms.
+func Parse(s string) (color.NRGBA, error) {
+	const op = "lib.colors.Parse"
+
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	if s == "transparent" {
+		return color.NRGBA{}, nil
+	}
+
+	if c, ok := colornames.Map[s]; ok {
+		return color.NRGBA{R: c.R, G: c.G, B: c.B, A: c.A}, nil
+	}
+
+	hex, ok := strings.CutPrefix(s, "#")
+	if !ok {
+		return color.NRGBA{}, fmt.Errorf("%s: unknown color %q", op, s)
+	}
+
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return color.NRGBA{}, fmt.Errorf("%s: invalid hex color %q", op, s)
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("%s: invalid hex color %q", op, s)
+	}
+
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
@@ -0,0 +1,19 @@
+package colors
+
+import "math"
+
+// ToLinear converts an sRGB channel value in [0, 1] to linear light.
+func ToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// ToSRGB converts a linear-light channel value in [0, 1] back to sRGB.
+func ToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
@@ -0,0 +1,122 @@
+package encoding
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"math"
+)
+
+const metersPerInch = 0.0254
+
+// withJFIFDensity inserts a JFIF APP0 segment recording dpi right after the
+// SOI marker of an encoded JPEG. image/jpeg writes no APP0 of its own.
+func withJFIFDensity(data []byte, dpi int) []byte {
+	if len(data) < 2 {
+		return data
+	}
+
+	density := uint16(min(dpi, math.MaxUint16))
+
+	app0 := []byte{0xff, 0xe0, 0x00, 0x10, 'J', 'F', 'I', 'F', 0x00, 0x01, 0x02}
+	// Units 1 is dots per inch.
+	app0 = append(app0, 1)
+	app0 = binary.BigEndian.AppendUint16(app0, density)
+	app0 = binary.BigEndian.AppendUint16(app0, density)
+	// No thumbnail.
+	app0 = append(app0, 0, 0)
+
+	out := make([]byte, 0, len(data)+len(app0))
+	out = append(out, data[:2]...)
+	out = append(out, app0...)
+	return append(out, data[2:]...)
+}
+
+// withPNGDensity inserts a pHYs chunk recording dpi right after the IHDR
+// chunk of an encoded PNG, where the spec wants it: before any IDAT.
+func withPNGDensity(data []byte, dpi int) []byte {
+	// Signature, then IHDR: length, type, 13 bytes of data and the CRC.
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	if len(data) < ihdrEnd {
+		return data
+	}
+
+	ppm := uint32(math.Round(float64(dpi) / metersPerInch))
+
+	chunk := make([]byte, 0, 4+4+9+4)
+	chunk = binary.BigEndian.AppendUint32(chunk, 9)
+	chunk = append(chunk, "pHYs"...)
+	chunk = binary.BigEndian.AppendUint32(chunk, ppm)
+	chunk = binary.BigEndian.AppendUint32(chunk, ppm)
+	// Unit 1 is the meter.
+	chunk = append(chunk, 1)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	out := make([]byte, 0, len(data)+len(chunk))
+	out = append(out, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...)
+}
+
+// ReadDPI returns the resolution recorded by a JFIF APP0 segment or a PNG
+// pHYs chunk in data, zero if there is none or it has no physical unit.
+func ReadDPI(data []byte) int {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		return jfifDPI(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return pngDPI(data)
+	default:
+		return 0
+	}
+}
+
+func jfifDPI(data []byte) int {
+	pos := 2
+
+	// Markers before the scan, each with a two-byte length.
+	for pos+4 <= len(data) && data[pos] == 0xff && data[pos+1] != 0xda {
+		marker := data[pos+1]
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		payload := data[pos+4 : min(len(data), pos+2+length)]
+
+		if marker == 0xe0 && len(payload) >= 12 && string(payload[:5]) == "JFIF\x00" {
+			density := float64(binary.BigEndian.Uint16(payload[8:]))
+			switch payload[7] {
+			case 1:
+				return int(density)
+			case 2: // Dots per centimeter.
+				return int(math.Round(density * 2.54))
+			}
+			return 0
+		}
+
+		pos += 2 + length
+	}
+
+	return 0
+}
+
+func pngDPI(data []byte) int {
+	pos := 8
+
+	for pos+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		kind := string(data[pos+4 : pos+8])
+
+		if kind == "IDAT" {
+			return 0
+		}
+		if kind == "pHYs" && pos+8+9 <= len(data) && data[pos+16] == 1 {
+			ppm := float64(binary.BigEndian.Uint32(data[pos+8:]))
+			return int(math.Round(ppm * metersPerInch))
+		}
+
+		if length < 0 || length > len(data) {
+			return 0
+		}
+		pos += 8 + length + 4
+	}
+
+	return 0
+}
@@ -0,0 +1,79 @@
+package encoding
+
+import (
+	"image"
+	"math"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// ReducesDepth reports whether BitDepth applies to images saved as
+// fileExt. Lossy formats re-quantize the pixels in their own way, so a
+// reduced value set wouldn't survive the encoder and is left to lossless
+// formats.
+func ReducesDepth(fileExt string) bool {
+	switch strings.ToLower(fileExt) {
+	case ".png", ".gif", ".bmp", ".tif", ".tiff", ".ico":
+		return true
+	default:
+		return false
+	}
+}
+
+// ReduceDepth returns img with every color channel limited to BitDepth
+// bits, scaled back to the full 0-255 range so it displays as before,
+// for images saved as fileExt. With Dither the rounding error is
+// diffused to the neighbouring pixels (Floyd-Steinberg) instead of
+// banding smooth gradients. Alpha is left unchanged. img is returned as
+// it is when there is nothing to reduce.
+func (opts Options) ReduceDepth(img image.Image, fileExt string) image.Image {
+	if opts.BitDepth <= 0 || opts.BitDepth >= 8 || !ReducesDepth(fileExt) {
+		return img
+	}
+
+	dst := imaging.Clone(img)
+	w, h := dst.Rect.Dx(), dst.Rect.Dy()
+	levels := float64(int(1)<<opts.BitDepth - 1)
+
+	quantize := func(v float64) uint8 {
+		v = min(max(v, 0), 255)
+		return uint8(math.Round(math.Round(v*levels/255) * 255 / levels))
+	}
+
+	if !opts.Dither {
+		for i := 0; i < len(dst.Pix); i += 4 {
+			for c := range 3 {
+				dst.Pix[i+c] = quantize(float64(dst.Pix[i+c]))
+			}
+		}
+		return dst
+	}
+
+	// Errors carried to the current and the next row, with a pixel of
+	// margin on both sides.
+	cur := make([]float64, (w+2)*3)
+	next := make([]float64, (w+2)*3)
+
+	for y := 0; y < h; y++ {
+		row := dst.Pix[y*dst.Stride:]
+		for x := 0; x < w; x++ {
+			for c := range 3 {
+				i := (x+1)*3 + c
+				v := float64(row[x*4+c]) + cur[i]
+				q := quantize(v)
+				row[x*4+c] = q
+
+				e := v - float64(q)
+				cur[i+3] += e * 7 / 16
+				next[i-3] += e * 3 / 16
+				next[i] += e * 5 / 16
+				next[i+3] += e * 1 / 16
+			}
+		}
+		cur, next = next, cur
+		clear(next)
+	}
+
+	return dst
+}
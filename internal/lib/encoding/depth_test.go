@@ -0,0 +1,71 @@
+package encoding_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"online-photo-editor/internal/lib/encoding"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gradient is a horizontal ramp through every level of every channel.
+func gradient() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 256, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 256; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(255 - x), B: uint8(x / 2), A: 200})
+		}
+	}
+	return img
+}
+
+func TestOptions_ReduceDepth(t *testing.T) {
+	// The 32 levels of 5 bits, scaled to 0-255.
+	allowed := map[uint8]bool{}
+	for k := 0; k < 32; k++ {
+		allowed[uint8((k*255+15)/31)] = true
+	}
+
+	for _, dither := range []bool{false, true} {
+		opts := encoding.Options{BitDepth: 5, Dither: dither}
+
+		// Round trip through PNG, as it is saved.
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, opts.ReduceDepth(gradient(), ".png")))
+		decoded, err := png.Decode(&buf)
+		require.NoError(t, err)
+		out := decoded.(*image.NRGBA)
+
+		levels := map[uint8]bool{}
+		for i := 0; i < len(out.Pix); i += 4 {
+			for c := range 3 {
+				assert.True(t, allowed[out.Pix[i+c]], "dither %v: %d is not a 5 bit level", dither, out.Pix[i+c])
+			}
+			levels[out.Pix[i]] = true
+			assert.EqualValues(t, 200, out.Pix[i+3], "alpha is kept")
+		}
+		assert.Len(t, levels, 32, "dither %v: the ramp still spans every level", dither)
+	}
+}
+
+func TestOptions_ReduceDepth_Unchanged(t *testing.T) {
+	img := gradient()
+
+	for _, tc := range []struct {
+		opts encoding.Options
+		ext  string
+	}{
+		{opts: encoding.Options{}, ext: ".png"},
+		{opts: encoding.Options{BitDepth: 8}, ext: ".png"},
+		// Lossy formats don't keep a reduced value set.
+		{opts: encoding.Options{BitDepth: 4}, ext: ".jpg"},
+		{opts: encoding.Options{BitDepth: 4}, ext: ".webp"},
+	} {
+		assert.Same(t, img, tc.opts.ReduceDepth(img, tc.ext), tc)
+	}
+}
@@ -0,0 +1,138 @@
+package encoding
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"online-photo-editor/internal/lib/ico"
+	"strings"
+
+	"github.com/gen2brain/webp"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// ErrUnsupportedFormat is returned by Encode for a file extension it has
+// no encoder for.
+var ErrUnsupportedFormat = errors.New("unsupported file format")
+
+// Encodes reports whether Encode can write images as fileExt.
+func Encodes(fileExt string) bool {
+	switch strings.ToLower(fileExt) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".bmp", ".webp", ".tif", ".tiff", ".ico":
+		return true
+	default:
+		return false
+	}
+}
+
+// Encode writes img to w in the format of fileExt, as the storages save
+// it, so that results kept in memory, such as data URIs, come out the
+// same as saved ones.
+func Encode(w io.Writer, img image.Image, fileExt string, opts Options) error {
+	fileExt = strings.ToLower(fileExt)
+	if !Encodes(fileExt) {
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, fileExt)
+	}
+
+	img = opts.ReduceDepth(img, fileExt)
+
+	switch fileExt {
+	case ".jpg", ".jpeg":
+		return encodeJPEG(w, img, opts)
+	case ".png":
+		return encodePNG(w, img, opts)
+	case ".gif":
+		return gif.Encode(w, img, nil)
+	case ".bmp":
+		return bmp.Encode(w, img)
+	case ".webp":
+		return encodeWEBP(w, img, opts)
+	case ".tif", ".tiff":
+		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+	default:
+		return ico.Encode(w, img, ico.DefaultSizes)
+	}
+}
+
+func encodeJPEG(w io.Writer, img image.Image, opts Options) error {
+	quality := jpeg.DefaultQuality
+	if opts.Quality > 0 {
+		quality = opts.Quality
+	}
+
+	if opts.DPI <= 0 && !opts.EXIFThumbnail && !opts.OptimizeJPEG {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return err
+	}
+
+	data := buf.Bytes()
+	if opts.OptimizeJPEG {
+		optimized, err := OptimizeJPEG(data)
+		if err != nil {
+			return err
+		}
+		data = optimized
+	}
+	if opts.EXIFThumbnail {
+		withThumb, err := withEXIFThumbnail(data, img)
+		if err != nil {
+			return err
+		}
+		data = withThumb
+	}
+	// JFIF wants its APP0 first, so it goes in last.
+	if opts.DPI > 0 {
+		data = withJFIFDensity(data, opts.DPI)
+	}
+
+	_, err := w.Write(data)
+	return err
+}
+
+func encodePNG(w io.Writer, img image.Image, opts Options) error {
+	encoder := png.Encoder{CompressionLevel: opts.PNGCompression}
+
+	var buf bytes.Buffer
+	if err := encoder.Encode(&buf, img); err != nil {
+		return err
+	}
+
+	data := WithSRGB(buf.Bytes())
+	if opts.DPI > 0 {
+		data = withPNGDensity(data, opts.DPI)
+	}
+
+	_, err := w.Write(data)
+	return err
+}
+
+func encodeWEBP(w io.Writer, img image.Image, opts Options) error {
+	// The encoder reads *image.RGBA pixels as if they were not premultiplied,
+	// so translucent pixels would come out darkened. Hand it NRGBA instead.
+	if rgba, ok := img.(*image.RGBA); ok && !rgba.Opaque() {
+		nrgba := image.NewNRGBA(rgba.Bounds())
+		draw.Draw(nrgba, nrgba.Bounds(), rgba, rgba.Bounds().Min, draw.Src)
+		img = nrgba
+	}
+
+	webpOpts := webp.Options{Quality: webp.DefaultQuality, Method: webp.DefaultMethod}
+	if opts.Quality > 0 {
+		webpOpts.Quality = opts.Quality
+	}
+	if opts.Effort > 0 {
+		webpOpts.Method = opts.Effort
+	}
+
+	return webp.Encode(w, img, webpOpts)
+}
@@ -0,0 +1,38 @@
+package encoding
+
+import (
+	"image/png"
+	"online-photo-editor/internal/lib/audit"
+)
+
+// Options tunes how an image is written. Zero values leave the choice to
+// the storage defaults.
+type Options struct {
+	// Quality is the JPEG and lossy WebP quality, 1 to 100.
+	Quality int
+	// PNGCompression is the zlib level used for PNG.
+	PNGCompression png.CompressionLevel
+	// Effort is the WebP encoder method, 1 (fastest) to 6 (smallest
+	// output).
+	Effort int
+	// DPI is the print resolution recorded in JPEG and PNG metadata.
+	DPI int
+	// EXIFThumbnail embeds a small thumbnail of the image in JPEG EXIF
+	// metadata, for the tools that show it instead of decoding the image.
+	EXIFThumbnail bool
+	// OptimizeJPEG rewrites JPEGs with Huffman tables fitted to the
+	// image, see OptimizeJPEG. Smaller files for some more CPU.
+	OptimizeJPEG bool
+	// BitDepth limits lossless formats to that many bits per color
+	// channel, 1 to 7; zero keeps the full 8. See ReduceDepth.
+	BitDepth int
+	// Dither diffuses the error of a reduced BitDepth.
+	Dither bool
+	// Origin is the request the image is written for, recorded in the
+	// audit log when writing it replaces or evicts another image.
+	Origin audit.Origin
+	// Temp keeps the image uncommitted, with the uploads waiting in the
+	// temp area, so it is removed unless committed. Its name must come
+	// from GenerateTempName.
+	Temp bool
+}
@@ -0,0 +1,109 @@
+package encoding
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	exifThumbnailWidth   = 160
+	exifThumbnailHeight  = 120
+	exifThumbnailQuality = 75
+)
+
+// The TIFF tags and field types written by withEXIFThumbnail.
+const (
+	tagCompression                 = 259
+	tagOrientation                 = 274
+	tagJPEGInterchangeFormat       = 513
+	tagJPEGInterchangeFormatLength = 514
+
+	typeShort = 3
+	typeLong  = 4
+
+	compressionJPEGThumbnail = 6
+)
+
+// withEXIFThumbnail inserts an EXIF APP1 segment right after the SOI
+// marker of an encoded JPEG, embedding a thumbnail of img that fits
+// 160×120. image/jpeg writes no metadata, so this is the only EXIF.
+func withEXIFThumbnail(data []byte, img image.Image) ([]byte, error) {
+	if len(data) < 2 {
+		return data, nil
+	}
+
+	var thumb bytes.Buffer
+	small := imaging.Fit(img, exifThumbnailWidth, exifThumbnailHeight, imaging.Linear)
+	if err := jpeg.Encode(&thumb, small, &jpeg.Options{Quality: exifThumbnailQuality}); err != nil {
+		return nil, err
+	}
+
+	tiff := exifTIFF(thumb.Bytes())
+
+	// The segment length counts itself and the "Exif\0\0" header.
+	length := 2 + 6 + len(tiff)
+	if length > math.MaxUint16 {
+		return data, nil
+	}
+
+	app1 := []byte{0xff, 0xe1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(length))
+	app1 = append(app1, "Exif\x00\x00"...)
+	app1 = append(app1, tiff...)
+
+	out := make([]byte, 0, len(data)+len(app1))
+	out = append(out, data[:2]...)
+	out = append(out, app1...)
+	return append(out, data[2:]...), nil
+}
+
+// exifTIFF lays out the big-endian TIFF structure of an EXIF segment: the
+// header, IFD0 with the orientation of the image and IFD1 describing the
+// JPEG thumbnail that follows it. Offsets count from the header.
+func exifTIFF(thumb []byte) []byte {
+	const (
+		headerSize = 8
+		entrySize  = 12
+		ifd0Size   = 2 + 1*entrySize + 4
+		ifd1Size   = 2 + 3*entrySize + 4
+		ifd1Offset = headerSize + ifd0Size
+		thumbStart = ifd1Offset + ifd1Size
+	)
+
+	out := make([]byte, 0, thumbStart+len(thumb))
+	out = append(out, 'M', 'M', 0x00, 0x2a)
+	out = binary.BigEndian.AppendUint32(out, headerSize)
+
+	out = binary.BigEndian.AppendUint16(out, 1)
+	// Orientation 1 is upright: the output is already rotated.
+	out = appendIFDEntry(out, tagOrientation, typeShort, 1)
+	out = binary.BigEndian.AppendUint32(out, ifd1Offset)
+
+	out = binary.BigEndian.AppendUint16(out, 3)
+	out = appendIFDEntry(out, tagCompression, typeShort, compressionJPEGThumbnail)
+	out = appendIFDEntry(out, tagJPEGInterchangeFormat, typeLong, thumbStart)
+	out = appendIFDEntry(out, tagJPEGInterchangeFormatLength, typeLong, uint32(len(thumb)))
+	// No next IFD.
+	out = binary.BigEndian.AppendUint32(out, 0)
+
+	return append(out, thumb...)
+}
+
+// appendIFDEntry appends an entry holding a single SHORT or LONG value.
+func appendIFDEntry(out []byte, tag, kind uint16, value uint32) []byte {
+	out = binary.BigEndian.AppendUint16(out, tag)
+	out = binary.BigEndian.AppendUint16(out, kind)
+	out = binary.BigEndian.AppendUint32(out, 1)
+
+	if kind == typeShort {
+		// A SHORT is left-justified in the four value bytes.
+		out = binary.BigEndian.AppendUint16(out, uint16(value))
+		return binary.BigEndian.AppendUint16(out, 0)
+	}
+	return binary.BigEndian.AppendUint32(out, value)
+}
@@ -0,0 +1,106 @@
+package encoding
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"math"
+
+	"online-photo-editor/internal/lib/colors"
+
+	"github.com/disintegration/imaging"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// srgbGamma is the gAMA value the PNG spec pairs with an sRGB chunk,
+// 1/2.2 times 100000.
+const srgbGamma = 45455
+
+// PNGGamma returns the file gamma a PNG records in its gAMA chunk, e.g.
+// 0.45455 for the usual 1/2.2, or zero when data isn't a PNG, has no gAMA
+// or also has an sRGB or iCCP chunk, which take precedence over it.
+func PNGGamma(data []byte) float64 {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return 0
+	}
+
+	gamma := 0.0
+	pos := len(pngSignature)
+
+	for pos+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		kind := string(data[pos+4 : pos+8])
+
+		switch kind {
+		case "IDAT":
+			return gamma
+		case "sRGB", "iCCP":
+			return 0
+		case "gAMA":
+			if length == 4 && pos+12 <= len(data) {
+				gamma = float64(binary.BigEndian.Uint32(data[pos+8:])) / 100000
+			}
+		}
+
+		if length < 0 || length > len(data) {
+			return 0
+		}
+		pos += 8 + length + 4
+	}
+
+	return gamma
+}
+
+// ToSRGB re-encodes img, decoded from a PNG with file gamma, in sRGB, so
+// it looks the same to the rest of the pipeline, which takes every image
+// for sRGB. img is returned as it is for a zero gamma or one close enough
+// to sRGB's to make no visible difference. Alpha is left unchanged.
+func ToSRGB(img image.Image, gamma float64) image.Image {
+	if gamma <= 0 || math.Abs(gamma*100000-srgbGamma) < 500 {
+		return img
+	}
+
+	var lut [256]uint8
+	for i := range lut {
+		linear := math.Pow(float64(i)/255, 1/gamma)
+		lut[i] = uint8(math.Round(colors.ToSRGB(linear) * 255))
+	}
+
+	dst := imaging.Clone(img)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		for c := range 3 {
+			dst.Pix[i+c] = lut[dst.Pix[i+c]]
+		}
+	}
+	return dst
+}
+
+// WithSRGB inserts an sRGB chunk and the gAMA chunk the spec wants with
+// it right after the IHDR chunk of an encoded PNG, so viewers that honor
+// gamma show the sRGB pixels as they are.
+func WithSRGB(data []byte) []byte {
+	// Signature, then IHDR: length, type, 13 bytes of data and the CRC.
+	ihdrEnd := len(pngSignature) + 4 + 4 + 13 + 4
+	if len(data) < ihdrEnd || !bytes.HasPrefix(data, pngSignature) {
+		return data
+	}
+
+	// Rendering intent 0 is perceptual.
+	chunks := appendPNGChunk(nil, "sRGB", []byte{0})
+	chunks = appendPNGChunk(chunks, "gAMA", binary.BigEndian.AppendUint32(nil, srgbGamma))
+
+	out := make([]byte, 0, len(data)+len(chunks))
+	out = append(out, data[:ihdrEnd]...)
+	out = append(out, chunks...)
+	return append(out, data[ihdrEnd:]...)
+}
+
+func appendPNGChunk(dst []byte, kind string, payload []byte) []byte {
+	start := len(dst)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(payload)))
+	dst = append(dst, kind...)
+	dst = append(dst, payload...)
+	return binary.BigEndian.AppendUint32(dst, crc32.ChecksumIEEE(dst[start+4:]))
+}
@@ -0,0 +1,58 @@
+package encoding_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"online-photo-editor/internal/lib/encoding"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withGamma inserts a gAMA chunk recording gamma right after the IHDR
+// chunk of an encoded PNG.
+func withGamma(data []byte, gamma float64) []byte {
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+
+	chunk := binary.BigEndian.AppendUint32(nil, 4)
+	chunk = append(chunk, "gAMA"...)
+	chunk = binary.BigEndian.AppendUint32(chunk, uint32(gamma*100000))
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	return append(append(append([]byte(nil), data[:ihdrEnd]...), chunk...), data[ihdrEnd:]...)
+}
+
+func TestPNGGamma(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))))
+
+	assert.Zero(t, encoding.PNGGamma(buf.Bytes()))
+	assert.InDelta(t, 1.0, encoding.PNGGamma(withGamma(buf.Bytes(), 1)), 1e-9)
+	assert.Zero(t, encoding.PNGGamma(encoding.WithSRGB(withGamma(buf.Bytes(), 1))), "sRGB takes precedence")
+	assert.Zero(t, encoding.PNGGamma([]byte("not a png")))
+
+	// The tagged file still decodes.
+	_, err := png.Decode(bytes.NewReader(encoding.WithSRGB(buf.Bytes())))
+	assert.NoError(t, err)
+}
+
+func TestToSRGB(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 128, G: 0, B: 255, A: 255})
+	img.SetNRGBA(1, 0, color.NRGBA{R: 128, G: 128, B: 128, A: 100})
+
+	assert.Same(t, image.Image(img), encoding.ToSRGB(img, 0))
+	assert.Same(t, image.Image(img), encoding.ToSRGB(img, 0.45455))
+
+	// Linear mid-gray is much lighter in sRGB; black, white and alpha stay.
+	out := imaging.Clone(encoding.ToSRGB(img, 1))
+	assert.Equal(t, color.NRGBA{R: 188, G: 0, B: 255, A: 255}, out.NRGBAAt(0, 0))
+	assert.Equal(t, uint8(100), out.NRGBAAt(1, 0).A)
+}
@@ -0,0 +1,519 @@
+package encoding
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+)
+
+// JPEG markers read by OptimizeJPEG.
+const (
+	markerSOF0 = 0xc0
+	markerSOF1 = 0xc1
+	markerDHT  = 0xc4
+	markerSOI  = 0xd8
+	markerEOI  = 0xd9
+	markerSOS  = 0xda
+	markerDRI  = 0xdd
+)
+
+var errNotOptimizable = errors.New("not a single-scan baseline jpeg")
+
+// OptimizeJPEG rewrites the baseline JPEG data with Huffman tables built
+// from the symbol frequencies of the image instead of the generic ones of
+// the standard, like jpegtran -optimize. The coefficients are untouched,
+// so the pixels decode exactly the same while the file is usually 5-15%
+// smaller. Images it can't rewrite, e.g. progressive ones or ones with
+// restart intervals, are returned as they are.
+func OptimizeJPEG(data []byte) ([]byte, error) {
+	out, err := optimizeJPEG(data)
+	if errors.Is(err, errNotOptimizable) {
+		return data, nil
+	}
+	return out, err
+}
+
+type jpegComponent struct {
+	id     byte
+	h, v   int
+	dc, ac int
+}
+
+// huffTable decodes canonical Huffman codes as described by a DHT table.
+type huffTable struct {
+	symbols []byte
+	maxCode [17]int
+	valPtr  [17]int
+	minCode [17]int
+}
+
+func newHuffTable(counts [17]int, symbols []byte) *huffTable {
+	t := &huffTable{symbols: symbols}
+	code, k := 0, 0
+	for l := 1; l <= 16; l++ {
+		t.valPtr[l] = k
+		t.minCode[l] = code
+		code += counts[l]
+		k += counts[l]
+		t.maxCode[l] = code - 1
+		if counts[l] == 0 {
+			t.maxCode[l] = -1
+		}
+		code <<= 1
+	}
+	return t
+}
+
+// token is one Huffman coded symbol of the scan with the raw bits that
+// follow it.
+type token struct {
+	table  uint8 // 0-3 DC, 4-7 AC
+	symbol uint8
+	nbits  uint8
+	bits   uint16
+}
+
+type bitReader struct {
+	data []byte
+	pos  int
+	acc  uint32
+	n    int
+}
+
+func (r *bitReader) bit() (int, error) {
+	if r.n == 0 {
+		if r.pos >= len(r.data) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		b := r.data[r.pos]
+		r.pos++
+		if b == 0xff {
+			if r.pos >= len(r.data) || r.data[r.pos] != 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			r.pos++
+		}
+		r.acc, r.n = uint32(b), 8
+	}
+	r.n--
+	return int(r.acc>>r.n) & 1, nil
+}
+
+func (r *bitReader) bits(n int) (uint16, error) {
+	var v uint16
+	for range n {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | uint16(b)
+	}
+	return v, nil
+}
+
+func (r *bitReader) decode(t *huffTable) (byte, error) {
+	code := 0
+	for l := 1; l <= 16; l++ {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		code = code<<1 | b
+		if code <= t.maxCode[l] {
+			return t.symbols[t.valPtr[l]+code-t.minCode[l]], nil
+		}
+	}
+	return 0, errors.New("invalid huffman code")
+}
+
+type bitWriter struct {
+	buf bytes.Buffer
+	acc uint32
+	n   int
+}
+
+func (w *bitWriter) write(code uint32, n int) {
+	for n > 0 {
+		take := min(n, 8-w.n)
+		n -= take
+		w.acc = w.acc<<take | (code>>n)&(1<<take-1)
+		w.n += take
+		if w.n == 8 {
+			b := byte(w.acc)
+			w.buf.WriteByte(b)
+			if b == 0xff {
+				w.buf.WriteByte(0)
+			}
+			w.acc, w.n = 0, 0
+		}
+	}
+}
+
+// flush pads the last byte with ones.
+func (w *bitWriter) flush() {
+	if w.n > 0 {
+		w.write(1<<(8-w.n)-1, 8-w.n)
+	}
+}
+
+func optimizeJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != markerSOI {
+		return nil, errors.New("not a jpeg")
+	}
+
+	var (
+		head       bytes.Buffer // every segment before the scan but the DHTs
+		tables     [8]*huffTable
+		components []jpegComponent
+		width      int
+		height     int
+		scan       []jpegComponent
+		scanHeader []byte
+		rest       []byte
+	)
+	head.Write(data[:2])
+
+	pos := 2
+	for scan == nil {
+		if pos+4 > len(data) || data[pos] != 0xff {
+			return nil, errors.New("malformed jpeg segment")
+		}
+		marker := data[pos+1]
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil, errors.New("malformed jpeg segment")
+		}
+		segment := data[pos : pos+2+length]
+		body := segment[4:]
+		pos += 2 + length
+
+		switch {
+		case marker == markerSOF0 || marker == markerSOF1:
+			if len(body) < 6 || int(body[5])*3+6 > len(body) {
+				return nil, errors.New("malformed jpeg frame header")
+			}
+			height = int(binary.BigEndian.Uint16(body[1:]))
+			width = int(binary.BigEndian.Uint16(body[3:]))
+			for i := range int(body[5]) {
+				c := body[6+i*3:]
+				components = append(components, jpegComponent{id: c[0], h: int(c[1] >> 4), v: int(c[1] & 0x0f)})
+			}
+		case marker >= 0xc2 && marker <= 0xcf && marker != markerDHT && marker != 0xc8 && marker != 0xcc:
+			return nil, errNotOptimizable
+		case marker == markerDRI:
+			if len(body) >= 2 && binary.BigEndian.Uint16(body) != 0 {
+				return nil, errNotOptimizable
+			}
+		case marker == markerDHT:
+			for len(body) > 0 {
+				if len(body) < 17 {
+					return nil, errors.New("malformed jpeg huffman table")
+				}
+				class, id := int(body[0]>>4), int(body[0]&0x0f)
+				if class > 1 || id > 3 {
+					return nil, errors.New("malformed jpeg huffman table")
+				}
+				var counts [17]int
+				total := 0
+				for l := 1; l <= 16; l++ {
+					counts[l] = int(body[l])
+					total += counts[l]
+				}
+				if 17+total > len(body) {
+					return nil, errors.New("malformed jpeg huffman table")
+				}
+				tables[class*4+id] = newHuffTable(counts, body[17:17+total])
+				body = body[17+total:]
+			}
+			continue
+		case marker == markerSOS:
+			if len(body) < 1 || int(body[0])*2+4 > len(body) || components == nil {
+				return nil, errors.New("malformed jpeg scan header")
+			}
+			scanHeader = segment
+			for i := range int(body[0]) {
+				sel, spec := body[1+i*2], body[2+i*2]
+				found := false
+				for _, c := range components {
+					if c.id == sel {
+						c.dc, c.ac = int(spec>>4), int(spec&0x0f)
+						scan = append(scan, c)
+						found = true
+					}
+				}
+				if !found || tables[int(spec>>4)&3] == nil || tables[4+int(spec&3)] == nil {
+					return nil, errors.New("malformed jpeg scan header")
+				}
+			}
+			if tail := body[1+int(body[0])*2:]; len(tail) < 3 || tail[0] != 0 || tail[1] != 63 || tail[2] != 0 {
+				return nil, errNotOptimizable
+			}
+			rest = data[pos:]
+			continue
+		}
+		head.Write(segment)
+	}
+
+	tokens, end, err := readScan(rest, tables, components, scan, width, height)
+	if err != nil {
+		return nil, err
+	}
+	// Only one scan and nothing but EOI after it can be rewritten.
+	if end+2 > len(rest) || rest[end] != 0xff || rest[end+1] != markerEOI {
+		return nil, errNotOptimizable
+	}
+
+	var freqs [8][256]int
+	var used [8]bool
+	for _, t := range tokens {
+		freqs[t.table][t.symbol]++
+		used[t.table] = true
+	}
+
+	var codes [8]*[256]struct {
+		code uint32
+		size int
+	}
+	var dht bytes.Buffer
+	// Tables the scan doesn't use are dropped.
+	for i := range tables {
+		if !used[i] {
+			continue
+		}
+		counts, symbols := optimalTable(freqs[i])
+		codes[i] = canonicalCodes(counts, symbols)
+
+		dht.WriteByte(byte(i/4<<4 | i%4))
+		for l := 1; l <= 16; l++ {
+			dht.WriteByte(byte(counts[l]))
+		}
+		dht.Write(symbols)
+	}
+
+	var w bitWriter
+	for _, t := range tokens {
+		c := codes[t.table][t.symbol]
+		w.write(c.code, c.size)
+		w.write(uint32(t.bits), int(t.nbits))
+	}
+	w.flush()
+
+	var out bytes.Buffer
+	out.Grow(len(data))
+	out.Write(head.Bytes())
+	out.Write([]byte{0xff, markerDHT, byte((dht.Len() + 2) >> 8), byte(dht.Len() + 2)})
+	out.Write(dht.Bytes())
+	out.Write(scanHeader)
+	out.Write(w.buf.Bytes())
+	out.Write([]byte{0xff, markerEOI})
+
+	return out.Bytes(), nil
+}
+
+// readScan decodes the entropy coded data of a baseline scan into tokens
+// and returns them with the offset of the marker that ends the scan.
+func readScan(data []byte, tables [8]*huffTable, components, scan []jpegComponent, width, height int) ([]token, int, error) {
+	hMax, vMax := 1, 1
+	for _, c := range components {
+		hMax, vMax = max(hMax, c.h), max(vMax, c.v)
+	}
+	if width == 0 || height == 0 {
+		return nil, 0, errNotOptimizable
+	}
+
+	r := &bitReader{data: data}
+	tokens := make([]token, 0, width*height/4)
+
+	readBlock := func(c jpegComponent) error {
+		dc := tables[c.dc]
+		ac := tables[4+c.ac]
+
+		s, err := r.decode(dc)
+		if err != nil {
+			return err
+		}
+		if s > 15 {
+			return errors.New("invalid dc coefficient")
+		}
+		bits, err := r.bits(int(s))
+		if err != nil {
+			return err
+		}
+		tokens = append(tokens, token{table: uint8(c.dc), symbol: s, nbits: s, bits: bits})
+
+		for k := 1; k < 64; {
+			rs, err := r.decode(ac)
+			if err != nil {
+				return err
+			}
+			size := rs & 0x0f
+			bits, err := r.bits(int(size))
+			if err != nil {
+				return err
+			}
+			tokens = append(tokens, token{table: uint8(4 + c.ac), symbol: rs, nbits: size, bits: bits})
+
+			if size == 0 {
+				if rs>>4 != 15 {
+					break
+				}
+				k += 16
+				continue
+			}
+			k += int(rs>>4) + 1
+		}
+		return nil
+	}
+
+	if len(scan) == 1 {
+		// A single component scan isn't interleaved: its blocks cover just
+		// the component, not whole MCUs.
+		c := scan[0]
+		cw := (width*c.h + hMax - 1) / hMax
+		ch := (height*c.v + vMax - 1) / vMax
+		for range ((cw + 7) / 8) * ((ch + 7) / 8) {
+			if err := readBlock(c); err != nil {
+				return nil, 0, err
+			}
+		}
+	} else {
+		mcus := ((width + 8*hMax - 1) / (8 * hMax)) * ((height + 8*vMax - 1) / (8 * vMax))
+		for range mcus {
+			for _, c := range scan {
+				for range c.h * c.v {
+					if err := readBlock(c); err != nil {
+						return nil, 0, err
+					}
+				}
+			}
+		}
+	}
+
+	// Skip the padding bits to the marker after the scan.
+	end := r.pos
+	for end < len(data) && !(data[end] == 0xff && end+1 < len(data) && data[end+1] != 0) {
+		end++
+	}
+	return tokens, end, nil
+}
+
+// optimalTable returns the code length counts and symbols, in code order,
+// of a Huffman table for freqs with no code longer than 16 bits and none
+// of all ones, following Annex K.2 of the JPEG standard.
+func optimalTable(freqs [256]int) ([17]int, []byte) {
+	var freq [257]int
+	copy(freq[:], freqs[:])
+	// A reserved symbol with the lowest frequency gets the all ones code,
+	// which is then dropped.
+	freq[256] = 1
+
+	var codeSize [257]int
+	var others [257]int
+	for i := range others {
+		others[i] = -1
+	}
+
+	for {
+		c1, c2 := -1, -1
+		for i := range freq {
+			if freq[i] == 0 {
+				continue
+			}
+			switch {
+			case c1 < 0 || freq[i] <= freq[c1]:
+				c2, c1 = c1, i
+			case c2 < 0 || freq[i] <= freq[c2]:
+				c2 = i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+
+		freq[c1] += freq[c2]
+		freq[c2] = 0
+
+		codeSize[c1]++
+		for others[c1] >= 0 {
+			c1 = others[c1]
+			codeSize[c1]++
+		}
+		others[c1] = c2
+
+		codeSize[c2]++
+		for others[c2] >= 0 {
+			c2 = others[c2]
+			codeSize[c2]++
+		}
+	}
+
+	var bits [33]int
+	for _, size := range codeSize {
+		if size > 0 {
+			bits[size]++
+		}
+	}
+
+	for i := 32; i > 16; i-- {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
+			}
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
+		}
+	}
+	// Drop the reserved symbol from the longest codes.
+	i := 16
+	for bits[i] == 0 {
+		i--
+	}
+	bits[i]--
+
+	var counts [17]int
+	copy(counts[1:], bits[1:17])
+
+	symbols := make([]int, 0, 256)
+	for s := range 256 {
+		if codeSize[s] > 0 {
+			symbols = append(symbols, s)
+		}
+	}
+	// Shorter codes go to the more frequent symbols: sort by code size,
+	// which the length limiting above doesn't change the order of.
+	sort.SliceStable(symbols, func(a, b int) bool {
+		return codeSize[symbols[a]] < codeSize[symbols[b]]
+	})
+
+	out := make([]byte, len(symbols))
+	for k, s := range symbols {
+		out[k] = byte(s)
+	}
+	return counts, out
+}
+
+// canonicalCodes assigns the codes of a DHT table to its symbols.
+func canonicalCodes(counts [17]int, symbols []byte) *[256]struct {
+	code uint32
+	size int
+} {
+	var codes [256]struct {
+		code uint32
+		size int
+	}
+	code, k := uint32(0), 0
+	for l := 1; l <= 16; l++ {
+		for range counts[l] {
+			codes[symbols[k]].code, codes[symbols[k]].size = code, l
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return &codes
+}
@@ -0,0 +1,65 @@
+package encoding_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"testing"
+
+	"online-photo-editor/internal/lib/encoding"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// photo is a smooth scene with some noise and an odd size, so MCUs are
+// padded at the edges.
+func photo() *image.NRGBA {
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, 301, 203))
+	for y := 0; y < 203; y++ {
+		for x := 0; x < 301; x++ {
+			n := rng.Intn(24)
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x*200/301 + n), G: uint8(y + n), B: uint8((x+y)/3 + n), A: 255})
+		}
+	}
+	return img
+}
+
+func TestOptimizeJPEG(t *testing.T) {
+	for name, img := range map[string]image.Image{
+		"color": photo(),
+		"gray":  image.NewGray(image.Rect(0, 0, 50, 30)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}))
+
+			optimized, err := encoding.OptimizeJPEG(buf.Bytes())
+			require.NoError(t, err)
+			assert.Less(t, len(optimized), buf.Len())
+
+			want, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+			require.NoError(t, err)
+			got, err := jpeg.Decode(bytes.NewReader(optimized))
+			require.NoError(t, err)
+
+			// Only the entropy coding changes: the pixels are the same.
+			require.Equal(t, want.Bounds(), got.Bounds())
+			for y := want.Bounds().Min.Y; y < want.Bounds().Max.Y; y++ {
+				for x := want.Bounds().Min.X; x < want.Bounds().Max.X; x++ {
+					if want.At(x, y) != got.At(x, y) {
+						t.Fatalf("pixel %d,%d differs: %v, want %v", x, y, got.At(x, y), want.At(x, y))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestOptimizeJPEG_NotJPEG(t *testing.T) {
+	_, err := encoding.OptimizeJPEG([]byte("not a jpeg"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,75 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+
+	"github.com/disintegration/imaging"
+)
+
+// MaxSize is the largest side an ICO entry may have.
+const MaxSize = 256
+
+// DefaultSizes are the entries of a favicon.ico, the sizes browsers and
+// the Windows shell pick from.
+var DefaultSizes = []int{16, 32, 48}
+
+const (
+	headerLen = 6
+	entryLen  = 16
+)
+
+// Encode writes img as an ICO file with one entry per size, img resized
+// to a size×size square for each. Entries are PNG compressed, which
+// every browser and Windows since Vista reads.
+func Encode(w io.Writer, img image.Image, sizes []int) error {
+	const op = "ico.Encode"
+
+	if len(sizes) == 0 {
+		return fmt.Errorf("%s: no sizes", op)
+	}
+
+	entries := make([][]byte, len(sizes))
+	for i, size := range sizes {
+		if size < 1 || size > MaxSize {
+			return fmt.Errorf("%s: size %d is outside 1 to %d", op, size, MaxSize)
+		}
+
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, imaging.Resize(img, size, size, imaging.Lanczos)); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		entries[i] = buf.Bytes()
+	}
+
+	dir := make([]byte, headerLen+entryLen*len(sizes))
+	binary.LittleEndian.PutUint16(dir[2:], 1) // type: icon
+	binary.LittleEndian.PutUint16(dir[4:], uint16(len(sizes)))
+
+	offset := len(dir)
+	for i, size := range sizes {
+		entry := dir[headerLen+entryLen*i:]
+		// A side of 256 is written as 0.
+		entry[0], entry[1] = byte(size), byte(size)
+		binary.LittleEndian.PutUint16(entry[4:], 1)  // color planes
+		binary.LittleEndian.PutUint16(entry[6:], 32) // bits per pixel
+		binary.LittleEndian.PutUint32(entry[8:], uint32(len(entries[i])))
+		binary.LittleEndian.PutUint32(entry[12:], uint32(offset))
+		offset += len(entries[i])
+	}
+
+	if _, err := w.Write(dir); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	for _, data := range entries {
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package ico_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"online-photo-editor/internal/lib/ico"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, ico.Encode(&buf, imaging.New(300, 300, color.NRGBA{R: 255, A: 255}), []int{16, 48, 256}))
+	data := buf.Bytes()
+
+	assert.Equal(t, []byte{0, 0, 1, 0, 3, 0}, data[:6])
+
+	for i, size := range []int{16, 48, 256} {
+		entry := data[6+16*i:]
+		assert.Equal(t, byte(size), entry[0], "width byte, 0 for 256")
+		assert.Equal(t, byte(size), entry[1], "height byte, 0 for 256")
+
+		length := binary.LittleEndian.Uint32(entry[8:])
+		offset := binary.LittleEndian.Uint32(entry[12:])
+		require.LessOrEqual(t, int(offset+length), len(data))
+
+		img, err := png.Decode(bytes.NewReader(data[offset : offset+length]))
+		require.NoError(t, err, size)
+		assert.Equal(t, image.Rect(0, 0, size, size), img.Bounds())
+		assert.Equal(t, color.NRGBA{R: 255, A: 255}, color.NRGBAModel.Convert(img.At(size/2, size/2)))
+	}
+}
+
+func TestEncode_InvalidSize(t *testing.T) {
+	img := imaging.New(10, 10, color.White)
+
+	for _, sizes := range [][]int{nil, {0}, {512}} {
+		assert.Error(t, ico.Encode(&bytes.Buffer{}, img, sizes), sizes)
+	}
+}
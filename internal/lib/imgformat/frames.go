@@ -0,0 +1,122 @@
+package imgformat
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// FrameStats counts the frames of an animated GIF or WebP and their total
+// pixels by walking the container, without decoding any frame. Other
+// formats, and still WebPs, report zero frames. A file cut short reports
+// what was read before the cut; the decoder reports the corruption itself.
+func FrameStats(data []byte) (frames int, pixels int64) {
+	switch {
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return gifFrameStats(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return webpFrameStats(data)
+	default:
+		return 0, 0
+	}
+}
+
+// CanvasSize reads the canvas size of a GIF from its logical screen
+// descriptor, or of an extended WebP, the kind animations are, from its
+// VP8X chunk: the first 30 bytes of data are enough. It is false for
+// other data, which needs a DecodeConfig.
+func CanvasSize(data []byte) (int, int, bool) {
+	switch {
+	case len(data) >= 10 && (bytes.HasPrefix(data, []byte("GIF87a")) || bytes.HasPrefix(data, []byte("GIF89a"))):
+		return int(binary.LittleEndian.Uint16(data[6:])), int(binary.LittleEndian.Uint16(data[8:])), true
+	case len(data) >= 30 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP" && string(data[12:16]) == "VP8X":
+		// Flags and reserved bytes, then 3 bytes each of width-1 and
+		// height-1.
+		return uint24(data[24:]) + 1, uint24(data[27:]) + 1, true
+	default:
+		return 0, 0, false
+	}
+}
+
+func gifFrameStats(data []byte) (frames int, pixels int64) {
+	// Header and logical screen descriptor.
+	const screenEnd = 13
+	if len(data) < screenEnd {
+		return 0, 0
+	}
+
+	pos := screenEnd
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << ((flags & 0x07) + 1)
+	}
+
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // Extension: label, then sub-blocks.
+			pos = skipSubBlocks(data, pos+2)
+		case 0x2c: // Image descriptor.
+			if pos+10 > len(data) {
+				return frames, pixels
+			}
+			width := binary.LittleEndian.Uint16(data[pos+5:])
+			height := binary.LittleEndian.Uint16(data[pos+7:])
+			flags := data[pos+9]
+
+			frames++
+			pixels += int64(width) * int64(height)
+
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << ((flags & 0x07) + 1)
+			}
+			// LZW minimum code size, then the image data sub-blocks.
+			pos = skipSubBlocks(data, pos+1)
+		default: // Trailer or garbage.
+			return frames, pixels
+		}
+	}
+
+	return frames, pixels
+}
+
+// skipSubBlocks returns the position after the sub-block chain at pos.
+func skipSubBlocks(data []byte, pos int) int {
+	for pos < len(data) {
+		size := int(data[pos])
+		pos++
+		if size == 0 {
+			break
+		}
+		pos += size
+	}
+	return pos
+}
+
+func webpFrameStats(data []byte) (frames int, pixels int64) {
+	pos := 12
+
+	for pos+8 <= len(data) {
+		fourCC := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		payload := pos + 8
+
+		// ANMF: 3 bytes each of X, Y, width-1 and height-1.
+		if fourCC == "ANMF" && payload+12 <= len(data) {
+			width := uint24(data[payload+6:]) + 1
+			height := uint24(data[payload+9:]) + 1
+
+			frames++
+			pixels += int64(width) * int64(height)
+		}
+
+		if size < 0 || size > len(data)-payload {
+			break
+		}
+		pos = payload + size + size&1
+	}
+
+	return frames, pixels
+}
+
+func uint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
@@ -0,0 +1,50 @@
+package imgformat_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+
+	"online-photo-editor/internal/lib/imgformat"
+
+	"github.com/disintegration/imaging"
+	"github.com/gen2brain/webp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanvasSize_Animations(t *testing.T) {
+	anim := &gif.GIF{Config: image.Config{Width: 40, Height: 30}}
+	webpAnim := &webp.WEBP{}
+	for i := 0; i < 3; i++ {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 40, 30), color.Palette{color.Black, color.White}))
+		anim.Delay = append(anim.Delay, 10)
+		webpAnim.Image = append(webpAnim.Image, imaging.New(40, 30, color.NRGBA{R: uint8(i * 80), A: 255}))
+		webpAnim.Delay = append(webpAnim.Delay, 10)
+	}
+
+	var gifBuf, webpBuf bytes.Buffer
+	require.NoError(t, gif.EncodeAll(&gifBuf, anim))
+	require.NoError(t, webp.EncodeAll(&webpBuf, webpAnim))
+
+	for name, data := range map[string][]byte{"gif": gifBuf.Bytes(), "webp": webpBuf.Bytes()} {
+		width, height, ok := imgformat.CanvasSize(data[:30])
+		assert.True(t, ok, name)
+		assert.Equal(t, 40, width, name)
+		assert.Equal(t, 30, height, name)
+
+		frames, pixels := imgformat.FrameStats(data)
+		assert.Equal(t, 3, frames, name)
+		assert.Equal(t, int64(3*40*30), pixels, name)
+	}
+
+	var pngBuf bytes.Buffer
+	require.NoError(t, png.Encode(&pngBuf, image.NewGray(image.Rect(0, 0, 4, 4))))
+	_, _, ok := imgformat.CanvasSize(pngBuf.Bytes())
+	assert.False(t, ok)
+	frames, _ := imgformat.FrameStats(pngBuf.Bytes())
+	assert.Zero(t, frames)
+}
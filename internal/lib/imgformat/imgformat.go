@@ -0,0 +1,89 @@
+// Package imgformat maps image formats to their media types, and tells
+// the format of encoded image bytes, so a Content-Type always matches
+// what is sent rather than the name it is stored under.
+package imgformat
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+)
+
+// contentTypes maps the formats, as normalized by Normalize, to their
+// media types.
+var contentTypes = map[string]string{
+	"jpg":  "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"bmp":  "image/bmp",
+	"webp": "image/webp",
+	"tiff": "image/tiff",
+	"ico":  "image/x-icon",
+	"avif": "image/avif",
+}
+
+// alphaFormats are the formats, as normalized by Normalize, that keep
+// the alpha channel.
+var alphaFormats = map[string]bool{
+	"png":  true,
+	"webp": true,
+	"tiff": true,
+	"ico":  true,
+	"avif": true,
+}
+
+// Normalize returns format, or a file extension, lowercased without the
+// dot and with the aliases jpeg and tif as jpg and tiff.
+func Normalize(format string) string {
+	format = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(format)), ".")
+
+	switch format {
+	case "jpeg":
+		return "jpg"
+	case "tif":
+		return "tiff"
+	default:
+		return format
+	}
+}
+
+// ContentType returns the media type of format, or "" for an unknown one.
+func ContentType(format string) string {
+	return contentTypes[Normalize(format)]
+}
+
+// HasAlpha reports whether images saved in format keep their
+// transparency.
+func HasAlpha(format string) bool {
+	return alphaFormats[Normalize(format)]
+}
+
+// Format returns the format of the media type contentType, parameters
+// allowed, or "" if it isn't an image type listed here.
+func Format(contentType string) string {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	if mediaType == "image/vnd.microsoft.icon" {
+		return "ico"
+	}
+	for format, t := range contentTypes {
+		if t == mediaType {
+			return format
+		}
+	}
+	return ""
+}
+
+// Detect returns the format of the image encoded in data, of which the
+// first 512 bytes are enough, or "" if it isn't one listed here.
+func Detect(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		return "tiff"
+	case len(data) >= 12 && string(data[4:8]) == "ftyp" && (string(data[8:12]) == "avif" || string(data[8:12]) == "avis"):
+		return "avif"
+	}
+
+	return Format(http.DetectContentType(data))
+}
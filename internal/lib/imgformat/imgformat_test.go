@@ -0,0 +1,58 @@
+package imgformat_test
+
+import (
+	"bytes"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"online-photo-editor/internal/lib/imgformat"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentType(t *testing.T) {
+	for format, want := range map[string]string{
+		"jpg":   "image/jpeg",
+		".JPEG": "image/jpeg",
+		"tif":   "image/tiff",
+		"webp":  "image/webp",
+		"avif":  "image/avif",
+		"svg":   "",
+	} {
+		assert.Equal(t, want, imgformat.ContentType(format), format)
+	}
+
+	assert.Equal(t, "png", imgformat.Format("image/png; charset=binary"))
+	assert.Equal(t, "", imgformat.Format("text/plain"))
+}
+
+func TestHasAlpha(t *testing.T) {
+	for format, want := range map[string]bool{
+		"png":   true,
+		".WEBP": true,
+		"tif":   true,
+		"jpeg":  false,
+		"bmp":   false,
+		"gif":   false,
+	} {
+		assert.Equal(t, want, imgformat.HasAlpha(format), format)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	img := imaging.New(4, 4, color.White)
+
+	var jpg, pngBuf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&jpg, img, nil))
+	require.NoError(t, png.Encode(&pngBuf, img))
+
+	assert.Equal(t, "jpg", imgformat.Detect(jpg.Bytes()))
+	assert.Equal(t, "png", imgformat.Detect(pngBuf.Bytes()))
+	assert.Equal(t, "tiff", imgformat.Detect([]byte("II*\x00rest")))
+	assert.Equal(t, "avif", imgformat.Detect([]byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00")))
+	assert.Equal(t, "", imgformat.Detect([]byte("not an image")))
+}
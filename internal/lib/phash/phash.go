@@ -0,0 +1,162 @@
+package phash
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	AlgorithmAverage    = "ahash"
+	AlgorithmDifference = "dhash"
+	AlgorithmPerceptual = "phash"
+
+	hashSize = 8
+	// dctSize is the side of the image the DCT is computed on; only the
+	// lowest hashSize x hashSize frequencies end up in the hash.
+	dctSize = 32
+)
+
+// Hash computes a 64-bit hash of img with the given algorithm.
+func Hash(img image.Image, algorithm string) (uint64, error) {
+	const op = "lib.phash.Hash"
+
+	switch algorithm {
+	case AlgorithmAverage:
+		return AverageHash(img), nil
+	case AlgorithmDifference:
+		return DifferenceHash(img), nil
+	case AlgorithmPerceptual, "":
+		return PerceptualHash(img), nil
+	default:
+		return 0, fmt.Errorf("%s: unknown algorithm %q", op, algorithm)
+	}
+}
+
+// AverageHash sets a bit for every pixel of an 8x8 thumbnail that is
+// brighter than the thumbnail's mean.
+func AverageHash(img image.Image) uint64 {
+	lum := luminance(img, hashSize, hashSize)
+
+	mean := 0.0
+	for _, v := range lum {
+		mean += v
+	}
+	mean /= float64(len(lum))
+
+	var hash uint64
+	for i, v := range lum {
+		if v > mean {
+			hash |= 1 << uint(i)
+		}
+	}
+
+	return hash
+}
+
+// DifferenceHash sets a bit for every pixel of a 9x8 thumbnail that is
+// brighter than its right neighbour.
+func DifferenceHash(img image.Image) uint64 {
+	lum := luminance(img, hashSize+1, hashSize)
+
+	var hash uint64
+	for y := 0; y < hashSize; y++ {
+		for x := 0; x < hashSize; x++ {
+			row := y * (hashSize + 1)
+			if lum[row+x] > lum[row+x+1] {
+				hash |= 1 << uint(y*hashSize+x)
+			}
+		}
+	}
+
+	return hash
+}
+
+// PerceptualHash sets a bit for every low-frequency DCT coefficient of a
+// 32x32 thumbnail that is above the median. It is the most robust of the
+// three against re-encoding and small colour changes.
+func PerceptualHash(img image.Image) uint64 {
+	lum := luminance(img, dctSize, dctSize)
+	coeffs := dct2D(lum, dctSize)
+
+	low := make([]float64, 0, hashSize*hashSize)
+	for y := 0; y < hashSize; y++ {
+		for x := 0; x < hashSize; x++ {
+			low = append(low, coeffs[y*dctSize+x])
+		}
+	}
+
+	// The DC term only carries the average brightness, so it is left out
+	// of the median.
+	sorted := append([]float64(nil), low[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2] + sorted[(len(sorted)-1)/2]) / 2
+
+	var hash uint64
+	for i, v := range low {
+		if v > median {
+			hash |= 1 << uint(i)
+		}
+	}
+
+	return hash
+}
+
+// Distance returns the Hamming distance between two hashes.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Hex formats a hash as a 16-digit hex string.
+func Hex(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+func luminance(img image.Image, width, height int) []float64 {
+	gray := imaging.Grayscale(imaging.Resize(img, width, height, imaging.Box))
+
+	lum := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			lum[y*width+x] = float64(gray.Pix[y*gray.Stride+x*4])
+		}
+	}
+
+	return lum
+}
+
+// dct2D computes the type-II DCT of an n x n block, rows first, then columns.
+func dct2D(block []float64, n int) []float64 {
+	cos := make([]float64, n*n)
+	for k := 0; k < n; k++ {
+		for i := 0; i < n; i++ {
+			cos[k*n+i] = math.Cos(math.Pi / float64(n) * (float64(i) + 0.5) * float64(k))
+		}
+	}
+
+	transform := func(src []float64, stride, offset int, dst []float64) {
+		for k := 0; k < n; k++ {
+			sum := 0.0
+			for i := 0; i < n; i++ {
+				sum += src[offset+i*stride] * cos[k*n+i]
+			}
+			dst[offset+k*stride] = sum
+		}
+	}
+
+	rows := make([]float64, n*n)
+	for y := 0; y < n; y++ {
+		transform(block, 1, y*n, rows)
+	}
+
+	out := make([]float64, n*n)
+	for x := 0; x < n; x++ {
+		transform(rows, n, x, out)
+	}
+
+	return out
+}
@@ -0,0 +1,76 @@
+package phash_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+
+	"online-photo-editor/internal/lib/phash"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scene draws a gradient with a bright disk, enough structure for the
+// hashes to pick up.
+func scene(w, h int, reversed bool, cx, cy float64) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			t := float64(x) / float64(w)
+			if reversed {
+				t = 1 - t
+			}
+			v := uint8(40 + 150*t)
+
+			if math.Hypot(float64(x)-cx*float64(w), float64(y)-cy*float64(h)) < float64(min(w, h))/5 {
+				v = 250
+			}
+
+			img.SetNRGBA(x, y, color.NRGBA{R: v, G: v / 2, B: 255 - v, A: 255})
+		}
+	}
+
+	return img
+}
+
+func reencode(t *testing.T, img image.Image, quality int) image.Image {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}))
+
+	out, err := jpeg.Decode(&buf)
+	require.NoError(t, err)
+
+	return out
+}
+
+func TestHash_ReencodedImagesAreClose(t *testing.T) {
+	original := scene(640, 480, false, 0.3, 0.4)
+	reencoded := reencode(t, original, 60)
+	different := scene(640, 480, true, 0.75, 0.7)
+
+	for _, algorithm := range []string{phash.AlgorithmAverage, phash.AlgorithmDifference, phash.AlgorithmPerceptual} {
+		t.Run(algorithm, func(t *testing.T) {
+			a, err := phash.Hash(original, algorithm)
+			require.NoError(t, err)
+			b, err := phash.Hash(reencoded, algorithm)
+			require.NoError(t, err)
+			c, err := phash.Hash(different, algorithm)
+			require.NoError(t, err)
+
+			assert.LessOrEqual(t, phash.Distance(a, b), 5, "re-encoded image must hash close to the original")
+			assert.GreaterOrEqual(t, phash.Distance(a, c), 16, "different images must hash far apart")
+		})
+	}
+}
+
+func TestHash_UnknownAlgorithm(t *testing.T) {
+	_, err := phash.Hash(scene(16, 16, false, 0.5, 0.5), "md5")
+	assert.Error(t, err)
+}
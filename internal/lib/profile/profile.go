@@ -0,0 +1,45 @@
+package profile
+
+import (
+	"image/png"
+
+	"online-photo-editor/internal/lib/encoding"
+)
+
+const (
+	Fast     = "fast"
+	Balanced = "balanced"
+	Quality  = "quality"
+)
+
+// Settings are the defaults a profile picks across the pipeline. Explicit
+// action params take precedence over them.
+type Settings struct {
+	// ResizeFilter is the resize "filter" param used when none is given.
+	ResizeFilter string
+	Encoding     encoding.Options
+}
+
+var profiles = map[string]Settings{
+	Fast: {
+		ResizeFilter: "linear",
+		Encoding:     encoding.Options{Quality: 75, PNGCompression: png.BestSpeed, Effort: 1},
+	},
+	Balanced: {
+		ResizeFilter: "catmullrom",
+		Encoding:     encoding.Options{Quality: 85, PNGCompression: png.DefaultCompression, Effort: 4},
+	},
+	Quality: {
+		ResizeFilter: "lanczos",
+		Encoding:     encoding.Options{Quality: 92, PNGCompression: png.BestCompression, Effort: 6},
+	},
+}
+
+// Get returns the settings for name, falling back to Balanced for an
+// empty or unknown name.
+func Get(name string) Settings {
+	if settings, ok := profiles[name]; ok {
+		return settings
+	}
+	return profiles[Balanced]
+}
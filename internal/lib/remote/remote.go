@@ -0,0 +1,385 @@
+// Package remote fetches source images from URLs into storage, caching
+// them by URL so repeated edits of one remote image download it once.
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"online-photo-editor/internal/lib/imgformat"
+	"online-photo-editor/internal/storage"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// DefaultMaxBytes matches the upload size limit.
+	DefaultMaxBytes   = 10 << 20
+	defaultMaxEntries = 100
+	defaultTTL        = time.Hour
+)
+
+var (
+	ErrInvalidURL = errors.New("invalid source url")
+	ErrHostDenied = errors.New("source host is not allowed")
+	// ErrAddressDenied is a source resolving to a loopback, private or
+	// link-local address while no AllowedHosts are set.
+	ErrAddressDenied = errors.New("source address is not public")
+	ErrTooLarge      = errors.New("source image exceeds the size limit")
+	ErrUnsupported   = errors.New("source is not a supported image")
+)
+
+// StatusError is a remote answering with a status other than 200 or 304.
+type StatusError struct {
+	Status int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("remote answered %d %s", e.Status, http.StatusText(e.Status))
+}
+
+// Store is where fetched images are kept, the image storage.
+type Store interface {
+	FindImage(imgName string) (string, error)
+	// UploadImage returns the name the file is stored under.
+	UploadImage(file io.Reader, fileName string) (string, error)
+	DeleteImage(imgName string) error
+}
+
+type Options struct {
+	// AllowedHosts are the hosts images may be fetched from, redirects
+	// included; empty allows any public one, see ErrAddressDenied.
+	AllowedHosts []string
+	// MaxEntries is how many URLs are cached; the least recently used are
+	// dropped, and their images deleted, past it. Zero means 100.
+	MaxEntries int
+	// TTL is how long a fetched image is used without asking the remote
+	// again, shortened by its Cache-Control max-age. Zero means an hour.
+	TTL time.Duration
+	// MaxBytes caps a download. Zero means DefaultMaxBytes.
+	MaxBytes int64
+	// Timeout bounds a request of the client the cache makes.
+	Timeout time.Duration
+	// Client does the requests instead of the client the cache makes,
+	// which without AllowedHosts refuses to connect to addresses that
+	// aren't public. Its redirects are still checked against
+	// AllowedHosts.
+	Client *http.Client
+}
+
+type entry struct {
+	imgName string
+	etag    string
+	expires time.Time
+	// used is the clock of the last fetch, for MaxEntries.
+	used uint64
+}
+
+// Cache fetches source images, keeping the stored image of each URL for
+// later fetches while the remote says it is fresh, and revalidating it
+// with If-None-Match once it isn't.
+type Cache struct {
+	store Store
+	opts  Options
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	clock   uint64
+
+	group singleflight.Group
+}
+
+func New(store Store, opts Options) *Cache {
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = defaultMaxEntries
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = defaultTTL
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultMaxBytes
+	}
+
+	c := &Cache{store: store, opts: opts, entries: make(map[string]*entry)}
+
+	var client http.Client
+	if opts.Client != nil {
+		client = *opts.Client
+	} else {
+		client = http.Client{Transport: transport(len(opts.AllowedHosts) == 0), Timeout: opts.Timeout}
+	}
+	client.CheckRedirect = c.checkRedirect
+	c.opts.Client = &client
+
+	return c
+}
+
+// transport returns the transport of the client the cache makes, refusing
+// addresses that aren't public when publicOnly is set.
+func transport(publicOnly bool) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if publicOnly {
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: checkAddress}
+		t.DialContext = dialer.DialContext
+	}
+	return t
+}
+
+// checkAddress fails connections to loopback, private, link-local and
+// other addresses that aren't reachable from the internet, so a source
+// URL can't be used to reach the network the server runs in, cloud
+// metadata endpoints included. It runs on the resolved address, so names
+// resolving to one are refused too.
+func checkAddress(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || sharedAddressSpace.Contains(ip) {
+		return fmt.Errorf("%w: %s", ErrAddressDenied, ip)
+	}
+	return nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, private in all but
+// name.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// checkRedirect follows a redirect only to a URL a fetch could have asked
+// for itself.
+func (c *Cache) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	_, err := c.parse(req.URL.String())
+	return err
+}
+
+// Fetch returns the name of the stored image fetched from rawURL into
+// namespace, downloading it only if it isn't cached or the remote
+// changed it. Every namespace keeps copies of its own, counted against
+// its quota. Concurrent fetches of one URL into a namespace share a
+// download.
+func (c *Cache) Fetch(ctx context.Context, namespace, rawURL string) (string, error) {
+	const op = "remote.Fetch"
+
+	u, err := c.parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	key := storage.InNamespace(namespace, u.String())
+
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		return c.fetch(context.WithoutCancel(ctx), namespace, u)
+	})
+
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return "", fmt.Errorf("%s: %w", op, res.Err)
+		}
+		return res.Val.(string), nil
+	case <-ctx.Done():
+		return "", fmt.Errorf("%s: %w", op, ctx.Err())
+	}
+}
+
+func (c *Cache) parse(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidURL
+	}
+	u.Fragment = ""
+
+	if len(c.opts.AllowedHosts) == 0 {
+		return u, nil
+	}
+	for _, host := range c.opts.AllowedHosts {
+		if strings.EqualFold(host, u.Hostname()) {
+			return u, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrHostDenied, u.Hostname())
+}
+
+func (c *Cache) fetch(ctx context.Context, namespace string, u *url.URL) (string, error) {
+	key := storage.InNamespace(namespace, u.String())
+	cached := c.lookup(key)
+	if cached != nil && time.Now().Before(cached.expires) {
+		return cached.imgName, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if cached != nil && cached.etag != "" {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := c.opts.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	ttl, store := c.freshness(resp.Header)
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		c.keep(key, entry{imgName: cached.imgName, etag: cached.etag, expires: time.Now().Add(ttl)})
+		return cached.imgName, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &StatusError{Status: resp.StatusCode}
+	}
+
+	if resp.ContentLength > c.opts.MaxBytes {
+		return "", ErrTooLarge
+	}
+
+	ext := fileExt(u, resp.Header.Get("Content-Type"))
+	if ext == "" {
+		return "", ErrUnsupported
+	}
+
+	imgName, err := c.store.UploadImage(&limitedReader{r: resp.Body, limit: c.opts.MaxBytes}, storage.InNamespace(namespace, "remote")+ext)
+	if err != nil {
+		return "", err
+	}
+
+	if cached != nil {
+		c.drop(key)
+	}
+	if store {
+		c.keep(key, entry{imgName: imgName, etag: resp.Header.Get("ETag"), expires: time.Now().Add(ttl)})
+	}
+
+	return imgName, nil
+}
+
+// lookup returns a copy of the entry of key, nil if there is none or its
+// image is gone from storage.
+func (c *Cache) lookup(key string) *entry {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if ok {
+		c.clock++
+		e.used = c.clock
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	if _, err := c.store.FindImage(e.imgName); err != nil {
+		c.mu.Lock()
+		delete(c.entries, key)
+		c.mu.Unlock()
+		return nil
+	}
+
+	cp := *e
+	return &cp
+}
+
+// keep records e as the entry of key, then drops the least recently used
+// entries past MaxEntries.
+func (c *Cache) keep(key string, e entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.clock++
+	e.used = c.clock
+	c.entries[key] = &e
+
+	for len(c.entries) > c.opts.MaxEntries {
+		oldest := ""
+		for k, e := range c.entries {
+			if k != key && (oldest == "" || e.used < c.entries[oldest].used) {
+				oldest = k
+			}
+		}
+		c.store.DeleteImage(c.entries[oldest].imgName)
+		delete(c.entries, oldest)
+	}
+}
+
+// drop forgets key and deletes its stored image.
+func (c *Cache) drop(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.store.DeleteImage(e.imgName)
+		delete(c.entries, key)
+	}
+}
+
+// freshness returns how long a response with header stays fresh, and
+// whether it may be cached at all.
+func (c *Cache) freshness(header http.Header) (time.Duration, bool) {
+	ttl := c.opts.TTL
+
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(strings.ToLower(directive)), "=")
+		switch name {
+		case "no-store":
+			return 0, false
+		case "no-cache":
+			ttl = 0
+		case "max-age":
+			if seconds, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil {
+				ttl = min(ttl, time.Duration(max(seconds, 0))*time.Second)
+			}
+		}
+	}
+
+	return ttl, true
+}
+
+// fileExt returns the extension to store the image of u under: the one of
+// its path, or the one of contentType without it.
+func fileExt(u *url.URL, contentType string) string {
+	if ext := strings.ToLower(path.Ext(u.Path)); ext != "" {
+		return ext
+	}
+
+	if format := imgformat.Format(contentType); format != "" {
+		return "." + format
+	}
+	return ""
+}
+
+// limitedReader fails with ErrTooLarge as soon as more than limit bytes
+// have been read, so an oversized download is aborted mid-stream.
+type limitedReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.limit {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
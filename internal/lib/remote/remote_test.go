@@ -0,0 +1,221 @@
+package remote_test
+
+import (
+	"bytes"
+	"context"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"online-photo-editor/internal/lib/remote"
+	"online-photo-editor/internal/lib/signedurl"
+	"online-photo-editor/internal/storage"
+	"online-photo-editor/internal/storage/filesystem"
+	"online-photo-editor/internal/storage/memory"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pngBytes(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, imaging.New(4, 3, color.White)))
+	return buf.Bytes()
+}
+
+// remoteServer serves a PNG at every path with cacheControl and etag,
+// counting the requests and the full downloads.
+func remoteServer(t *testing.T, cacheControl, etag string) (*httptest.Server, *atomic.Int32, *atomic.Int32) {
+	t.Helper()
+
+	body := pngBytes(t)
+	var hits, downloads atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		downloads.Add(1)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &hits, &downloads
+}
+
+// local allows the test servers, which without AllowedHosts are refused
+// as they aren't public.
+var local = []string{"127.0.0.1"}
+
+func TestCache_Fetch_Cached(t *testing.T) {
+	srv, hits, _ := remoteServer(t, "max-age=600", "")
+	store := memory.New()
+	cache := remote.New(store, remote.Options{AllowedHosts: local})
+
+	first, err := cache.Fetch(context.Background(), "", srv.URL+"/photo.png")
+	require.NoError(t, err)
+	_, err = store.FindImage(first)
+	require.NoError(t, err)
+
+	second, err := cache.Fetch(context.Background(), "", srv.URL+"/photo.png")
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+	assert.EqualValues(t, 1, hits.Load())
+
+	// Another URL is another download.
+	_, err = cache.Fetch(context.Background(), "", srv.URL+"/other.png")
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, hits.Load())
+}
+
+func TestCache_Fetch_SignedURLs(t *testing.T) {
+	srv, _, _ := remoteServer(t, "max-age=600", "")
+	signer, err := signedurl.New([]byte("0123456789abcdef"), time.Hour)
+	require.NoError(t, err)
+	store, err := filesystem.New(t.TempDir(), filesystem.Options{URLSigner: signer})
+	require.NoError(t, err)
+	cache := remote.New(store, remote.Options{AllowedHosts: local})
+
+	imgName, err := cache.Fetch(context.Background(), "", srv.URL+"/photo.png")
+	require.NoError(t, err)
+	assert.NotContains(t, imgName, "?")
+	_, err = store.FindImage(imgName)
+	require.NoError(t, err)
+}
+
+func TestCache_Fetch_Revalidate(t *testing.T) {
+	srv, hits, downloads := remoteServer(t, "no-cache", `"v1"`)
+	cache := remote.New(memory.New(), remote.Options{AllowedHosts: local})
+
+	first, err := cache.Fetch(context.Background(), "", srv.URL+"/photo")
+	require.NoError(t, err)
+	assert.Equal(t, ".png", first[len(first)-4:], "extension from the content type")
+
+	second, err := cache.Fetch(context.Background(), "", srv.URL+"/photo")
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+	assert.EqualValues(t, 2, hits.Load(), "asked the remote again")
+	assert.EqualValues(t, 1, downloads.Load(), "but got 304")
+}
+
+func TestCache_Fetch_NoStore(t *testing.T) {
+	srv, hits, _ := remoteServer(t, "no-store", `"v1"`)
+	cache := remote.New(memory.New(), remote.Options{AllowedHosts: local})
+
+	for range 2 {
+		_, err := cache.Fetch(context.Background(), "", srv.URL+"/photo.png")
+		require.NoError(t, err)
+	}
+	assert.EqualValues(t, 2, hits.Load())
+}
+
+func TestCache_Fetch_Evicts(t *testing.T) {
+	srv, hits, _ := remoteServer(t, "", "")
+	store := memory.New()
+	cache := remote.New(store, remote.Options{AllowedHosts: local, MaxEntries: 1})
+
+	first, err := cache.Fetch(context.Background(), "", srv.URL+"/a.png")
+	require.NoError(t, err)
+	_, err = cache.Fetch(context.Background(), "", srv.URL+"/b.png")
+	require.NoError(t, err)
+
+	// The image of the dropped URL is deleted from storage.
+	_, err = store.FindImage(first)
+	assert.Error(t, err)
+
+	_, err = cache.Fetch(context.Background(), "", srv.URL+"/a.png")
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, hits.Load())
+}
+
+func TestCache_Fetch_Errors(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(srv.Close)
+
+	cache := remote.New(memory.New(), remote.Options{AllowedHosts: local, MaxBytes: 10})
+
+	_, err := cache.Fetch(context.Background(), "", "ftp://example.com/a.png")
+	assert.ErrorIs(t, err, remote.ErrInvalidURL)
+
+	_, err = cache.Fetch(context.Background(), "", "http://example.com/a.png")
+	assert.ErrorIs(t, err, remote.ErrHostDenied)
+
+	_, err = cache.Fetch(context.Background(), "", srv.URL+"/a.png")
+	var statusErr *remote.StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusNotFound, statusErr.Status)
+
+	big, _, _ := remoteServer(t, "", "")
+	_, err = cache.Fetch(context.Background(), "", big.URL+"/a.png")
+	assert.ErrorIs(t, err, remote.ErrTooLarge)
+}
+
+func TestCache_Fetch_PrivateAddresses(t *testing.T) {
+	srv, hits, _ := remoteServer(t, "", "")
+	cache := remote.New(memory.New(), remote.Options{})
+
+	_, err := cache.Fetch(context.Background(), "", srv.URL+"/photo.png")
+	assert.ErrorIs(t, err, remote.ErrAddressDenied)
+	assert.Zero(t, hits.Load())
+}
+
+func TestCache_Fetch_Redirects(t *testing.T) {
+	target, hits, _ := remoteServer(t, "", "")
+
+	// The same server under a name that isn't allowed.
+	denied := strings.Replace(target.URL, "127.0.0.1", "localhost", 1)
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		to := target.URL
+		if r.URL.Path == "/denied.png" {
+			to = denied
+		}
+		http.Redirect(w, r, to+"/photo.png", http.StatusFound)
+	}))
+	t.Cleanup(redirect.Close)
+
+	cache := remote.New(memory.New(), remote.Options{AllowedHosts: local})
+
+	_, err := cache.Fetch(context.Background(), "", redirect.URL+"/allowed.png")
+	require.NoError(t, err)
+
+	_, err = cache.Fetch(context.Background(), "", redirect.URL+"/denied.png")
+	assert.ErrorIs(t, err, remote.ErrHostDenied)
+	assert.EqualValues(t, 1, hits.Load())
+}
+
+func TestCache_Fetch_Namespaces(t *testing.T) {
+	srv, hits, _ := remoteServer(t, "max-age=600", "")
+	cache := remote.New(memory.New(), remote.Options{AllowedHosts: local})
+
+	shared, err := cache.Fetch(context.Background(), "", srv.URL+"/photo.png")
+	require.NoError(t, err)
+	acme, err := cache.Fetch(context.Background(), "acme", srv.URL+"/photo.png")
+	require.NoError(t, err)
+
+	// Every namespace stores, and pays for, a copy of its own.
+	assert.Empty(t, storage.Namespace(shared))
+	assert.Equal(t, "acme", storage.Namespace(acme))
+	assert.EqualValues(t, 2, hits.Load())
+
+	again, err := cache.Fetch(context.Background(), "acme", srv.URL+"/photo.png")
+	require.NoError(t, err)
+	assert.Equal(t, acme, again)
+	assert.EqualValues(t, 2, hits.Load())
+}
@@ -0,0 +1,64 @@
+package signedurl_test
+
+import (
+	"net/url"
+	"online-photo-editor/internal/lib/signedurl"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var key = []byte("0123456789abcdef")
+
+func TestSigner_Verify(t *testing.T) {
+	signer, err := signedurl.New(key, time.Hour)
+	require.NoError(t, err)
+
+	parse := func(raw string) url.Values {
+		query, err := url.ParseQuery(raw)
+		require.NoError(t, err)
+		return query
+	}
+
+	valid := signer.Sign("img.png", time.Now().Add(time.Minute))
+	assert.NoError(t, signer.Verify("img.png", parse(valid)))
+
+	expired := signer.Sign("img.png", time.Now().Add(-time.Minute))
+	assert.ErrorIs(t, signer.Verify("img.png", parse(expired)), signedurl.ErrExpired)
+
+	// Another name, or a later expiry, doesn't match the signature.
+	assert.ErrorIs(t, signer.Verify("other.png", parse(valid)), signedurl.ErrInvalid)
+	extended := parse(expired)
+	extended.Set(signedurl.ExpiresParam, "99999999999")
+	assert.ErrorIs(t, signer.Verify("img.png", extended), signedurl.ErrInvalid)
+
+	other, err := signedurl.New([]byte("another key, 16+"), time.Hour)
+	require.NoError(t, err)
+	assert.ErrorIs(t, other.Verify("img.png", parse(valid)), signedurl.ErrInvalid)
+
+	assert.ErrorIs(t, signer.Verify("img.png", url.Values{}), signedurl.ErrMissing)
+}
+
+func TestSigner_SignURL(t *testing.T) {
+	signer, err := signedurl.New(key, time.Hour)
+	require.NoError(t, err)
+
+	signed := signer.SignURL("https://cdn.example.com/images/img.png", "img.png")
+	u, err := url.Parse(signed)
+	require.NoError(t, err)
+	assert.Equal(t, "/images/img.png", u.Path)
+	assert.NoError(t, signer.Verify("img.png", u.Query()))
+
+	assert.True(t, strings.HasPrefix(signer.SignURL("/images/a.png?w=100", "a.png"), "/images/a.png?w=100&expires="))
+}
+
+func TestNew_Invalid(t *testing.T) {
+	_, err := signedurl.New([]byte("short"), time.Hour)
+	assert.Error(t, err)
+
+	_, err = signedurl.New(key, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,62 @@
+// Package tiling bounds the working memory of neighbourhood operations,
+// such as blurs, on huge images by running them on overlapping tiles
+// instead of the whole image at once.
+package tiling
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// bytesPerPixel is the working memory Apply assumes fn needs per pixel
+	// it runs on: an NRGBA copy of its input, one intermediate pass and
+	// its output.
+	bytesPerPixel = 12
+	// minTile is the smallest tile side, so a tiny budget doesn't turn
+	// into millions of tiles.
+	minTile = 64
+)
+
+// Fits reports whether width×height pixels of bytesPerPixel each stay
+// within budget bytes. A budget of zero or less means no limit.
+func Fits(width, height, bytesPerPixel int, budget int64) bool {
+	return budget <= 0 || int64(width)*int64(height)*int64(bytesPerPixel) <= budget
+}
+
+// Apply runs fn on img whole when its working memory fits in budget, and
+// otherwise on tiles, each with overlap pixels of context on every side,
+// stitched back together. fn must keep the size of its input and compute
+// every pixel from pixels no farther than overlap away, like a blur of
+// that radius, for the result to be the same either way. tiled reports
+// which way it was run.
+func Apply(img image.Image, budget int64, overlap int, fn func(image.Image) *image.NRGBA) (out *image.NRGBA, tiled bool) {
+	b := img.Bounds()
+	if Fits(b.Dx(), b.Dy(), bytesPerPixel, budget) {
+		return fn(img), false
+	}
+
+	side := int(math.Sqrt(float64(budget)/bytesPerPixel)) - 2*overlap
+	side = max(side, minTile)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y += side {
+		for x := 0; x < b.Dx(); x += side {
+			inner := image.Rect(x, y, min(x+side, b.Dx()), min(y+side, b.Dy()))
+			outer := inner.Inset(-overlap).Intersect(dst.Rect)
+
+			tile := fn(imaging.Crop(img, outer.Add(b.Min)))
+
+			offset := inner.Min.Sub(outer.Min)
+			rowLen := inner.Dx() * 4
+			for row := 0; row < inner.Dy(); row++ {
+				src := tile.Pix[(offset.Y+row)*tile.Stride+offset.X*4:]
+				copy(dst.Pix[(inner.Min.Y+row)*dst.Stride+inner.Min.X*4:], src[:rowLen])
+			}
+		}
+	}
+
+	return dst, true
+}
@@ -0,0 +1,54 @@
+package tiling_test
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+
+	"online-photo-editor/internal/lib/tiling"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func noise(w, h int) *image.NRGBA {
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rng.Intn(256))
+	}
+	return img
+}
+
+func TestApply(t *testing.T) {
+	img := noise(300, 220)
+	blur := func(img image.Image) *image.NRGBA {
+		return imaging.Blur(img, 2.5)
+	}
+	want := imaging.Blur(img, 2.5)
+
+	out, tiled := tiling.Apply(img, 0, 8, blur)
+	assert.False(t, tiled, "no budget")
+	assert.Equal(t, want.Pix, out.Pix)
+
+	// 100×100 pixels of working memory: 300×220 needs a dozen tiles.
+	out, tiled = tiling.Apply(img, 100*100*12, 8, blur)
+	assert.True(t, tiled)
+	assert.Equal(t, want.Rect, out.Rect)
+	assert.Equal(t, want.Pix, out.Pix, "tiles stitch back to the full-buffer result")
+
+	out, tiled = tiling.Apply(img, 400*400*12, 8, blur)
+	assert.False(t, tiled, "within budget")
+	assert.Equal(t, want.Pix, out.Pix)
+}
+
+func TestApply_OffsetBounds(t *testing.T) {
+	img := noise(200, 150).SubImage(image.Rect(30, 20, 190, 140))
+	sharpen := func(img image.Image) *image.NRGBA {
+		return imaging.Sharpen(img, 1)
+	}
+
+	out, tiled := tiling.Apply(img, 1, 3, sharpen)
+	assert.True(t, tiled)
+	assert.Equal(t, sharpen(img).Pix, out.Pix)
+}
@@ -0,0 +1,93 @@
+// Package timefmt formats times with strftime-style directives such as
+// "%Y-%m-%d %H:%M:%S", which read better in a JSON request than Go
+// reference-time layouts.
+package timefmt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Default is the layout used when none is given.
+const Default = "%Y-%m-%d %H:%M:%S"
+
+var errNoDirective = errors.New("the format has no time directive")
+
+// directives maps each directive letter to the Go layout it stands for.
+var directives = map[byte]string{
+	'Y': "2006",
+	'y': "06",
+	'm': "01",
+	'd': "02",
+	'e': "_2",
+	'H': "15",
+	'I': "03",
+	'M': "04",
+	'S': "05",
+	'p': "PM",
+	'b': "Jan",
+	'B': "January",
+	'a': "Mon",
+	'A': "Monday",
+	'j': "002",
+	'Z': "MST",
+	'z': "-0700",
+}
+
+// Validate checks that layout only uses known directives, "%%" for a
+// literal percent sign, and at least one directive.
+func Validate(layout string) error {
+	const op = "lib.timefmt.Validate"
+
+	found := false
+	for i := 0; i < len(layout); i++ {
+		if layout[i] != '%' {
+			continue
+		}
+		if i+1 == len(layout) {
+			return fmt.Errorf("%s: %q ends with a lone %%", op, layout)
+		}
+		i++
+		if layout[i] == '%' {
+			continue
+		}
+		if _, ok := directives[layout[i]]; !ok {
+			return fmt.Errorf("%s: %q: unknown directive %%%c", op, layout, layout[i])
+		}
+		found = true
+	}
+
+	if !found {
+		return fmt.Errorf("%s: %q: %w", op, layout, errNoDirective)
+	}
+	return nil
+}
+
+// Format formats t with layout, which must pass Validate.
+func Format(t time.Time, layout string) (string, error) {
+	const op = "lib.timefmt.Format"
+
+	if err := Validate(layout); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(layout); i++ {
+		if layout[i] != '%' {
+			out.WriteByte(layout[i])
+			continue
+		}
+		i++
+		if layout[i] == '%' {
+			out.WriteByte('%')
+			continue
+		}
+		// Directives are formatted one by one so the text between them
+		// is never read as a Go layout.
+		out.WriteString(t.Format(directives[layout[i]]))
+	}
+
+	return out.String(), nil
+}
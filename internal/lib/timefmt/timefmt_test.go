@@ -0,0 +1,35 @@
+package timefmt_test
+
+import (
+	"online-photo-editor/internal/lib/timefmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat(t *testing.T) {
+	at := time.Date(2024, 3, 7, 14, 5, 9, 0, time.FixedZone("CET", 3600))
+
+	cases := map[string]string{
+		timefmt.Default:             "2024-03-07 14:05:09",
+		"%d.%m.%y %I:%M %p":         "07.03.24 02:05 PM",
+		"%a %b %e, day %j":          "Thu Mar  7, day 067",
+		"CAM 1 %H:%M:%S %z (100%%)": "CAM 1 14:05:09 +0100 (100%)",
+		"%A %d %B %Y %Z":            "Thursday 07 March 2024 CET",
+	}
+
+	for layout, want := range cases {
+		got, err := timefmt.Format(at, layout)
+		require.NoError(t, err, layout)
+		assert.Equal(t, want, got, layout)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, layout := range []string{"", "no directives", "100%%", "%Y-%q", "%H:%M %"} {
+		assert.Error(t, timefmt.Validate(layout), layout)
+	}
+	assert.NoError(t, timefmt.Validate("%H"))
+}
@@ -0,0 +1,112 @@
+// Package warp moves pixels around by inverse mapping: every output pixel
+// is sampled from where a mapping says it comes from, so the result has
+// no holes, whatever the distortion.
+package warp
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// Mapping returns the source position of the output pixel centered at x,
+// y, both in pixel units with pixel centers at half-integers. ok false
+// keeps the pixel as it is.
+type Mapping func(x, y float64) (sx, sy float64, ok bool)
+
+// Remap returns img with every pixel sampled from its source position,
+// interpolating bilinearly and repeating the edge pixels beyond the
+// image. Alpha is interpolated premultiplied.
+func Remap(img image.Image, mapping Mapping) *image.NRGBA {
+	return remap(img, mapping, false)
+}
+
+// RemapClipped is Remap leaving the pixels sampled from beyond the image
+// transparent instead.
+func RemapClipped(img image.Image, mapping Mapping) *image.NRGBA {
+	return remap(img, mapping, true)
+}
+
+// RemapTo returns a new width×height image sampled from img like
+// RemapClipped. The pixels mapping isn't ok for are transparent.
+func RemapTo(img image.Image, width, height int, mapping Mapping) *image.NRGBA {
+	src := imaging.Clone(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sx, sy, ok := mapping(float64(x)+0.5, float64(y)+0.5)
+			if !ok || sx < 0 || sy < 0 || sx > float64(w) || sy > float64(h) {
+				continue
+			}
+			sample(src, sx-0.5, sy-0.5, dst.Pix[y*dst.Stride+x*4:])
+		}
+	}
+
+	return dst
+}
+
+func remap(img image.Image, mapping Mapping, clip bool) *image.NRGBA {
+	src := imaging.Clone(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewNRGBA(src.Rect)
+	copy(dst.Pix, src.Pix)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sx, sy, ok := mapping(float64(x)+0.5, float64(y)+0.5)
+			if !ok {
+				continue
+			}
+			out := dst.Pix[y*dst.Stride+x*4:]
+			if clip && (sx < 0 || sy < 0 || sx > float64(w) || sy > float64(h)) {
+				out[0], out[1], out[2], out[3] = 0, 0, 0, 0
+				continue
+			}
+			sample(src, sx-0.5, sy-0.5, out)
+		}
+	}
+
+	return dst
+}
+
+// sample writes the NRGBA color of img at x, y, in pixel indices, to out.
+func sample(img *image.NRGBA, x, y float64, out []uint8) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	x = min(max(x, 0), float64(w-1))
+	y = min(max(y, 0), float64(h-1))
+
+	x0, y0 := int(x), int(y)
+	x1, y1 := min(x0+1, w-1), min(y0+1, h-1)
+	fx, fy := x-float64(x0), y-float64(y0)
+
+	var sum [4]float64
+	for _, c := range [4]struct {
+		x, y   int
+		weight float64
+	}{
+		{x0, y0, (1 - fx) * (1 - fy)},
+		{x1, y0, fx * (1 - fy)},
+		{x0, y1, (1 - fx) * fy},
+		{x1, y1, fx * fy},
+	} {
+		if c.weight == 0 {
+			continue
+		}
+		p := img.Pix[c.y*img.Stride+c.x*4:]
+		a := float64(p[3]) * c.weight
+		sum[0] += float64(p[0]) * a
+		sum[1] += float64(p[1]) * a
+		sum[2] += float64(p[2]) * a
+		sum[3] += a
+	}
+
+	out[0], out[1], out[2], out[3] = 0, 0, 0, uint8(math.Round(sum[3]))
+	if sum[3] > 0 {
+		out[0] = uint8(math.Round(min(sum[0]/sum[3], 255)))
+		out[1] = uint8(math.Round(min(sum[1]/sum[3], 255)))
+		out[2] = uint8(math.Round(min(sum[2]/sum[3], 255)))
+	}
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"time"
+)
+
+// EXIF tags CaptureTime reads.
+const (
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagDateTimeOriginal = 0x9003
+)
+
+// exifTimeLayout is how EXIF records dates, without a time zone.
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// CaptureTime returns when a JPEG or TIFF was taken, from the EXIF
+// DateTimeOriginal tag or, without it, DateTime. EXIF has no time zone,
+// so the time is the camera's clock read as UTC. It is zero when the
+// file records none, or data isn't a JPEG or TIFF.
+func CaptureTime(data []byte) time.Time {
+	tiff := exifTIFF(data)
+	if len(tiff) < 8 {
+		return time.Time{}
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return time.Time{}
+	}
+
+	ifd0 := readIFD(tiff, order, order.Uint32(tiff[4:]))
+
+	if offset, ok := ifd0[tagExifIFD]; ok {
+		exif := readIFD(tiff, order, order.Uint32(offset))
+		if t, ok := exifTime(tiff, order, exif[tagDateTimeOriginal]); ok {
+			return t
+		}
+	}
+	t, _ := exifTime(tiff, order, ifd0[tagDateTime])
+	return t
+}
+
+// exifTIFF returns the TIFF structure holding the EXIF tags of data: the
+// payload of the APP1 Exif segment in a JPEG, or a TIFF file itself.
+func exifTIFF(data []byte) []byte {
+	if !bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+		return data
+	}
+
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xff && data[pos+1] != 0xda {
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil
+		}
+
+		payload := data[pos+4 : pos+2+length]
+		if data[pos+1] == 0xe1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			return payload[6:]
+		}
+
+		pos += 2 + length
+	}
+
+	return nil
+}
+
+// readIFD returns the four value bytes of each entry of the IFD at
+// offset, keyed by tag.
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32) map[uint16][]byte {
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return nil
+	}
+
+	count := int(order.Uint16(tiff[offset:]))
+	entries := make(map[uint16][]byte, count)
+
+	for i := 0; i < count; i++ {
+		start := int(offset) + 2 + 12*i
+		if start+12 > len(tiff) {
+			break
+		}
+		entries[order.Uint16(tiff[start:])] = tiff[start+8 : start+12]
+	}
+
+	return entries
+}
+
+// exifTime parses the date an ASCII entry points at. value is the entry's
+// value bytes: a date doesn't fit in four, so they hold its offset.
+func exifTime(tiff []byte, order binary.ByteOrder, value []byte) (time.Time, bool) {
+	if len(value) != 4 {
+		return time.Time{}, false
+	}
+
+	start := uint64(order.Uint32(value))
+	end := start + uint64(len(exifTimeLayout))
+	if end > uint64(len(tiff)) {
+		return time.Time{}, false
+	}
+
+	raw := strings.TrimRight(string(tiff[start:end]), "\x00 ")
+	t, err := time.Parse(exifTimeLayout, raw)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,64 @@
+package filesystem
+
+import (
+	"bytes"
+	"fmt"
+	"image/gif"
+	"io"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/storage"
+	"path/filepath"
+	"strings"
+)
+
+// LoadGIF decodes every frame of a stored GIF, with the limits of
+// LoadImage and those on frames.
+func (img *ImageStorage) LoadGIF(imgName string) (*gif.GIF, error) {
+	const op = "storage.img.LoadGIF"
+
+	filePath := img.resolvePath(imgName)
+
+	if err := checkFile(filePath); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	data, err := img.readFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := img.checkLimits(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := img.checkFrames(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	anim, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %w", op, storage.ErrCorruptImage, err)
+	}
+
+	return anim, nil
+}
+
+// SaveGIF writes all frames of anim, keeping their delays, disposal and
+// loop count. imgName must have a .gif extension.
+func (img *ImageStorage) SaveGIF(anim *gif.GIF, imgName string, origin audit.Origin) (string, error) {
+	const op = "storage.img.SaveGIF"
+
+	if fileExt := strings.ToLower(filepath.Ext(imgName)); fileExt != ".gif" {
+		return "", fmt.Errorf("%s: %w: unsupported file format: %s", op, storage.ErrEncode, fileExt)
+	}
+
+	filePath := filepath.Join(img.Path, imgName)
+
+	err := img.saveFile(filePath, encodeWith(func(w io.Writer) error {
+		return gif.EncodeAll(w, anim)
+	}), origin)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return img.ImageURL(imgName), nil
+}
@@ -0,0 +1,367 @@
+package filesystem
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"online-photo-editor/internal/storage"
+	"os"
+)
+
+// magic starts every encrypted file, followed by a random salt and the
+// content, sealed in chunks. No image format starts with a NUL byte, so
+// files stored before encryption was enabled are told apart and still
+// read.
+const magic = "\x00OPEenc1"
+
+var encryptedMagic = []byte(magic)
+
+const (
+	saltSize   = 32
+	headerSize = int64(len(magic) + saltSize)
+
+	// chunkSize is how much content is sealed at a time: files are
+	// written and read a chunk at a time, never whole.
+	chunkSize = 64 << 10
+	// tagSize is the AES-GCM overhead of every chunk.
+	tagSize         = 16
+	sealedChunkSize = chunkSize + tagSize
+)
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func (img *ImageStorage) encrypts() bool {
+	return len(img.encryptionKey) > 0
+}
+
+// fileAEAD returns the AEAD sealing the file with salt. Every file gets a
+// key of its own, derived from the encryption key and its salt, so the
+// chunk counters used as nonces never repeat under a key.
+func (img *ImageStorage) fileAEAD(salt []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, img.encryptionKey)
+	mac.Write(encryptedMagic)
+	mac.Write(salt)
+	return newAEAD(mac.Sum(nil)[:len(img.encryptionKey)])
+}
+
+// chunkNonce returns the nonce of the chunk at index. The last chunk is
+// flagged in it, so a file cut at a chunk boundary fails to decrypt
+// instead of reading as a shorter one.
+func chunkNonce(nonce []byte, index int64, last bool) []byte {
+	clear(nonce)
+	binary.BigEndian.PutUint32(nonce[len(nonce)-5:], uint32(index))
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// plainSize returns the size of the content of an encrypted file of
+// fileSize bytes.
+func plainSize(fileSize int64) (int64, error) {
+	body := fileSize - headerSize
+	chunks := (body + sealedChunkSize - 1) / sealedChunkSize
+	if body < tagSize || body-(chunks-1)*sealedChunkSize < tagSize {
+		return 0, fmt.Errorf("%w: encrypted file is truncated", storage.ErrCorruptImage)
+	}
+	return body - chunks*tagSize, nil
+}
+
+// chunkWriter seals what is written to it a chunk at a time. Close seals
+// the last chunk, which may be short or, for empty content, empty.
+type chunkWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	nonce  []byte
+	index  int64
+	chunk  []byte
+	sealed []byte
+}
+
+func (img *ImageStorage) newChunkWriter(w io.Writer) (*chunkWriter, error) {
+	header := make([]byte, headerSize)
+	copy(header, encryptedMagic)
+	if _, err := rand.Read(header[len(encryptedMagic):]); err != nil {
+		return nil, err
+	}
+
+	aead, err := img.fileAEAD(header[len(encryptedMagic):])
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &chunkWriter{
+		w:      w,
+		aead:   aead,
+		nonce:  make([]byte, aead.NonceSize()),
+		chunk:  make([]byte, 0, chunkSize),
+		sealed: make([]byte, 0, sealedChunkSize),
+	}, nil
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		// A full chunk is only sealed once more content comes, as
+		// until then it may be the last one.
+		if len(cw.chunk) == chunkSize {
+			if err := cw.seal(false); err != nil {
+				return written - len(p), err
+			}
+		}
+
+		n := copy(cw.chunk[len(cw.chunk):chunkSize], p)
+		cw.chunk = cw.chunk[:len(cw.chunk)+n]
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (cw *chunkWriter) seal(last bool) error {
+	if cw.index == math.MaxUint32 {
+		return errors.New("file is too large to encrypt")
+	}
+
+	cw.sealed = cw.aead.Seal(cw.sealed[:0], chunkNonce(cw.nonce, cw.index, last), cw.chunk, encryptedMagic)
+	if _, err := cw.w.Write(cw.sealed); err != nil {
+		return err
+	}
+
+	cw.index++
+	cw.chunk = cw.chunk[:0]
+	return nil
+}
+
+func (cw *chunkWriter) Close() error {
+	return cw.seal(true)
+}
+
+// chunkReader decrypts an encrypted file a chunk at a time, as it is
+// read, keeping only the current chunk in memory.
+type chunkReader struct {
+	file     *os.File
+	aead     cipher.AEAD
+	nonce    []byte
+	fileSize int64
+	size     int64
+	chunks   int64
+	pos      int64
+
+	// index is that of the chunk in chunk, -1 for none.
+	index  int64
+	chunk  []byte
+	sealed []byte
+}
+
+func (img *ImageStorage) newChunkReader(file *os.File, salt []byte) (*chunkReader, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := plainSize(info.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := img.fileAEAD(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &chunkReader{
+		file:     file,
+		aead:     aead,
+		nonce:    make([]byte, aead.NonceSize()),
+		fileSize: info.Size(),
+		size:     size,
+		chunks:   (info.Size() - headerSize + sealedChunkSize - 1) / sealedChunkSize,
+		index:    -1,
+		chunk:    make([]byte, 0, chunkSize),
+		sealed:   make([]byte, sealedChunkSize),
+	}
+
+	// The last chunk is opened first, so a wrong key or a truncated file
+	// fails here rather than partway through a download.
+	if err := r.load(r.chunks - 1); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *chunkReader) load(index int64) error {
+	offset := headerSize + index*sealedChunkSize
+	sealed := r.sealed[:min(sealedChunkSize, r.fileSize-offset)]
+	if _, err := r.file.ReadAt(sealed, offset); err != nil {
+		return err
+	}
+
+	chunk, err := r.aead.Open(r.chunk[:0], chunkNonce(r.nonce, index, index == r.chunks-1), sealed, encryptedMagic)
+	if err != nil {
+		r.index = -1
+		return fmt.Errorf("%w: failed to decrypt: %w", storage.ErrCorruptImage, err)
+	}
+
+	r.index = index
+	r.chunk = chunk
+	return nil
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+
+	index := r.pos / chunkSize
+	if index != r.index {
+		if err := r.load(index); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.chunk[r.pos-index*chunkSize:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+func (r *chunkReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *chunkReader) Close() error {
+	return r.file.Close()
+}
+
+// readFile reads a stored file, decrypting it if it is encrypted. Without
+// a key, an encrypted file fails with storage.ErrCorruptImage.
+func (img *ImageStorage) readFile(filePath string) ([]byte, error) {
+	if !img.encrypts() {
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(data, encryptedMagic) {
+			return nil, fmt.Errorf("%w: file is encrypted and no key is set", storage.ErrCorruptImage)
+		}
+		return data, nil
+	}
+
+	file, err := img.openFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
+// openFile opens a stored file for reading. Files without the magic are
+// read as they are; encrypted ones are decrypted as they are read. With
+// the wrong key, an encrypted file fails with storage.ErrCorruptImage.
+func (img *ImageStorage) openFile(filePath string) (io.ReadSeekCloser, error) {
+	file, err := os.Open(filePath)
+	if err != nil || !img.encrypts() {
+		return file, err
+	}
+
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(file, header)
+	if !bytes.HasPrefix(header[:n], encryptedMagic) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return file, nil
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%w: encrypted file is truncated", storage.ErrCorruptImage)
+	}
+
+	r, err := img.newChunkReader(file, header[len(encryptedMagic):])
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// writeFile creates filePath with what write writes. Without a key the
+// output goes straight to disk; with one it is sealed a chunk at a time
+// on its way there.
+func (img *ImageStorage) writeFile(filePath string, write func(w io.Writer) error) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+
+	if !img.encrypts() {
+		if err := write(file); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}
+
+	w, err := img.newChunkWriter(file)
+	if err == nil {
+		err = write(w)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// isEncrypted reports whether the file at filePath starts with the magic.
+func isEncrypted(filePath string) bool {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	header := make([]byte, len(encryptedMagic))
+	if _, err := io.ReadFull(file, header); err != nil {
+		return false
+	}
+	return bytes.Equal(header, encryptedMagic)
+}
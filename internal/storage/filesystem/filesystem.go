@@ -1,80 +1,263 @@
 package filesystem
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"image"
-	"image/gif"
-	"image/jpeg"
-	"image/png"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
 	"io"
-	"mime/multipart"
 	"net/http"
+	"net/url"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/imgformat"
+	"online-photo-editor/internal/lib/signedurl"
+	"online-photo-editor/internal/storage"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
-	"golang.org/x/image/bmp"
+	_ "github.com/gen2brain/webp"
+	_ "golang.org/x/image/bmp"
+)
+
+const etagExt = ".etag"
+
+// savingExt ends the temp files saveFile writes, which aren't images
+// until they are renamed.
+const savingExt = ".saving"
+
+// defaultMaxPixels is the largest image LoadImage decodes unless Options
+// say otherwise, 100 megapixels.
+const defaultMaxPixels = 100_000_000
+
+// defaultExt is the extension GenerateName falls back to unless Options
+// say otherwise.
+const defaultExt = ".png"
+
+// Defaults for the limits on animated images.
+const (
+	defaultMaxFrames          = 1000
+	defaultMaxAnimationPixels = 1_000_000_000
 )
 
 type ImageStorage struct {
 	Path string
+	// TempPath is where uploads wait until they are committed. Empty
+	// means uploads go straight to Path.
+	TempPath string
+	// PublicBaseURL prefixes the returned image URLs, e.g. with a CDN
+	// origin. Empty means URLs are relative to this server.
+	PublicBaseURL string
+	// OnCollision is what GenerateName does when a name is taken, one of
+	// CollisionFail, CollisionOverwrite or CollisionSuffix.
+	OnCollision string
+	// DefaultExt is the extension GenerateName uses when it is given
+	// none, e.g. for the result of processing an extensionless source.
+	DefaultExt string
+	// Audit records images replaced by SaveImage.
+	Audit audit.Sink
+	// URLSigner, when set, signs the returned image URLs so they can be
+	// checked on download.
+	URLSigner *signedurl.Signer
+	// MaxPixels is the largest width×height LoadImage decodes. Bigger
+	// images are rejected from their header, before memory is allocated
+	// for the pixels.
+	MaxPixels int64
+	// MaxFrames is the most frames an animated GIF or WebP may have, and
+	// MaxAnimationPixels the most pixels all of them may have together.
+	// Both are checked from the container before LoadGIF decodes them.
+	MaxFrames          int
+	MaxAnimationPixels int64
+	// MaxImages and MaxBytes cap the number and total size of stored
+	// images, permanent and temp together; zero means no limit. A save
+	// that would exceed them fails with storage.ErrQuotaExceeded, unless
+	// EvictOldest makes room by removing the least recently modified
+	// images.
+	MaxImages   int
+	MaxBytes    int64
+	EvictOldest bool
+	// NamespaceMaxImages and NamespaceMaxBytes cap every namespace, see
+	// storage.Namespace, on its own; zero means no limit.
+	NamespaceMaxImages int
+	NamespaceMaxBytes  int64
+	// MaxNamespaces caps the namespaces other than the default one that
+	// hold images, so that clients naming namespaces of their own can't
+	// multiply their share; zero means no limit.
+	MaxNamespaces int
+
+	quotaMu sync.Mutex
+	// encryptionKey encrypts stored files when set.
+	encryptionKey []byte
 }
 
-func New(internalStoragePath string) (*ImageStorage, error) {
+type Options struct {
+	TempPath      string
+	PublicBaseURL string
+	// OnCollision defaults to CollisionSuffix.
+	OnCollision string
+	// DefaultExt defaults to ".png".
+	DefaultExt string
+	// Audit defaults to discarding entries.
+	Audit audit.Sink
+	// URLSigner defaults to unsigned URLs.
+	URLSigner *signedurl.Signer
+	// MaxPixels defaults to 100 megapixels.
+	MaxPixels int64
+	// MaxFrames defaults to 1000 and MaxAnimationPixels to 1000
+	// megapixels.
+	MaxFrames          int
+	MaxAnimationPixels int64
+	// MaxImages and MaxBytes default to no limit, as do
+	// NamespaceMaxImages, NamespaceMaxBytes and MaxNamespaces.
+	MaxImages          int
+	MaxBytes           int64
+	EvictOldest        bool
+	NamespaceMaxImages int
+	NamespaceMaxBytes  int64
+	MaxNamespaces      int
+	// EncryptionKey, 16, 24 or 32 bytes, encrypts stored images with
+	// AES-GCM, in chunks. Without one they are stored as they are.
+	EncryptionKey []byte
+}
+
+func New(internalStoragePath string, opts Options) (*ImageStorage, error) {
 	const op = "storage.img.New"
 
 	if err := checkFile(internalStoragePath); err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return &ImageStorage{Path: internalStoragePath}, nil
+	if opts.TempPath != "" {
+		if err := os.MkdirAll(opts.TempPath, 0o755); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if _, err := url.Parse(opts.PublicBaseURL); err != nil {
+		return nil, fmt.Errorf("%s: invalid public base url: %w", op, err)
+	}
+
+	if opts.Audit == nil {
+		opts.Audit = audit.Nop{}
+	}
+
+	if opts.MaxPixels <= 0 {
+		opts.MaxPixels = defaultMaxPixels
+	}
+	if opts.MaxFrames <= 0 {
+		opts.MaxFrames = defaultMaxFrames
+	}
+	if opts.MaxAnimationPixels <= 0 {
+		opts.MaxAnimationPixels = defaultMaxAnimationPixels
+	}
+
+	if opts.OnCollision == "" {
+		opts.OnCollision = CollisionSuffix
+	}
+	if !validCollision(opts.OnCollision) {
+		return nil, fmt.Errorf("%s: unknown name collision strategy: %s", op, opts.OnCollision)
+	}
+
+	defaultExt, err := storage.NormalizeExt(opts.DefaultExt, defaultExt)
+	if err != nil {
+		return nil, fmt.Errorf("%s: default extension %q: %w", op, opts.DefaultExt, err)
+	}
+	if !isImageExt(defaultExt) {
+		return nil, fmt.Errorf("%s: default extension %q is not an image format", op, opts.DefaultExt)
+	}
+
+	if len(opts.EncryptionKey) > 0 {
+		if _, err := newAEAD(opts.EncryptionKey); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return &ImageStorage{
+		Path:               internalStoragePath,
+		TempPath:           opts.TempPath,
+		PublicBaseURL:      strings.TrimSuffix(opts.PublicBaseURL, "/"),
+		OnCollision:        opts.OnCollision,
+		DefaultExt:         defaultExt,
+		Audit:              opts.Audit,
+		URLSigner:          opts.URLSigner,
+		MaxPixels:          opts.MaxPixels,
+		MaxFrames:          opts.MaxFrames,
+		MaxAnimationPixels: opts.MaxAnimationPixels,
+		MaxImages:          opts.MaxImages,
+		MaxBytes:           opts.MaxBytes,
+		EvictOldest:        opts.EvictOldest,
+		NamespaceMaxImages: opts.NamespaceMaxImages,
+		NamespaceMaxBytes:  opts.NamespaceMaxBytes,
+		MaxNamespaces:      opts.MaxNamespaces,
+		encryptionKey:      opts.EncryptionKey,
+	}, nil
 }
 
-func (img *ImageStorage) UploadImage(file multipart.File, handler *multipart.FileHeader) (string, error) {
+// UploadImage streams file into storage. Only the first 512 bytes are
+// buffered, to detect the content type; an encrypted storage seals the
+// rest a chunk at a time on its way to disk. Nothing is kept if reading
+// fails. The image is stored in the namespace of fileName, and its name
+// returned; ImageURL has the URL to download it from.
+func (img *ImageStorage) UploadImage(file io.Reader, fileName string) (string, error) {
 	const op = "storage.img.UploadImage"
 
-	buffer := make([]byte, 512)
-	if _, err := file.Read(buffer); err != nil {
+	reader := bufio.NewReaderSize(file, 512)
+
+	buffer, err := reader.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return "", fmt.Errorf("%s: %w", op, err)
+	if len(buffer) == 0 {
+		return "", fmt.Errorf("%s: empty file", op)
 	}
 
 	mimeType := http.DetectContentType(buffer)
-	if !isImage(mimeType) {
+	if isTIFF(buffer) {
+		mimeType = "image/tiff"
+	}
+	if !isImage(mimeType) && !isLUT(filepath.Ext(fileName), mimeType) {
 		return "", fmt.Errorf("%s: unsupported file type: %s", op, mimeType)
 	}
 
-	fileName, err := img.GenerateName("img", filepath.Ext(handler.Filename))
+	uploadPath := img.Path
+	if img.TempPath != "" {
+		uploadPath = img.TempPath
+	}
+
+	imgName, err := img.generateName(uploadPath, storage.InNamespaceOf(fileName, "img"), filepath.Ext(fileName))
 	if err != nil {
 		return "", err
 	}
 
-	filePath := filepath.Join(img.Path, fileName)
+	filePath := filepath.Join(uploadPath, imgName)
 
-	dst, err := os.Create(filePath)
+	// An upload gets a name of its own, so it replaces nothing; only the
+	// images it evicts are audited, without an origin.
+	err = img.saveFile(filePath, func(w io.Writer) error {
+		_, err := io.Copy(w, reader)
+		return err
+	}, audit.Origin{})
 	if err != nil {
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
-	defer dst.Close()
 
-	if _, err = io.Copy(dst, file); err != nil {
-		return "", fmt.Errorf("%s: %w", op, err)
-	}
-
-	imageURL := fmt.Sprintf("/images/%s", fileName)
-
-	return imageURL, nil
+	return imgName, nil
 }
 
 func (img *ImageStorage) FindImage(imgName string) (string, error) {
 	const op = "storage.img.FindImage"
 
-	filePath := filepath.Join(img.Path, imgName)
+	filePath := img.resolvePath(imgName)
 
-	if err := checkFile(img.Path); err != nil {
+	if err := checkFile(filePath); err != nil {
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
 
@@ -84,7 +267,7 @@ func (img *ImageStorage) FindImage(imgName string) (string, error) {
 func (img *ImageStorage) DeleteImage(imgName string) error {
 	const op = "storage.img.DeleteImage"
 
-	filepath := filepath.Join(img.Path, imgName)
+	filepath := img.resolvePath(imgName)
 
 	if err := checkFile(filepath); err != nil {
 		return fmt.Errorf("%s: %w", op, err)
@@ -94,118 +277,406 @@ func (img *ImageStorage) DeleteImage(imgName string) error {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
+	os.Remove(filepath + etagExt)
+
 	return nil
 }
 
+func (img *ImageStorage) OpenImage(imgName string) (io.ReadSeekCloser, error) {
+	const op = "storage.img.OpenImage"
+
+	// LUTs are opened too, for the lut action to read.
+	if ext := filepath.Ext(imgName); !isImageExt(ext) && !isLUTExt(ext) {
+		return nil, fmt.Errorf("%s: %w", op, os.ErrNotExist)
+	}
+
+	file, err := img.openFile(img.resolvePath(imgName))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return file, nil
+}
+
+// ImageETag returns a strong ETag derived from the image content. It is
+// computed on first use and kept next to the image in a ".etag" file.
+func (img *ImageStorage) ImageETag(imgName string) (string, error) {
+	const op = "storage.img.ImageETag"
+
+	filePath := img.resolvePath(imgName)
+
+	if etag, err := os.ReadFile(filePath + etagExt); err == nil {
+		return string(etag), nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	etag := fmt.Sprintf(`"%x"`, hash.Sum(nil)[:16])
+
+	if err := os.WriteFile(filePath+etagExt, []byte(etag), 0o644); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return etag, nil
+}
+
 func (img *ImageStorage) LoadImage(imgName string) (image.Image, error) {
 	const op = "storage.img.LoadImage"
 
-	filepath := filepath.Join(img.Path, imgName)
+	filepath := img.resolvePath(imgName)
 
 	if err := checkFile(filepath); err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	file, err := os.Open(filepath)
+	data, err := img.readFile(filepath)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
-	defer file.Close()
 
-	loadImg, _, err := image.Decode(file)
-	if err != nil {
+	if err := img.checkLimits(data); err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
+	loadImg, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %w", op, storage.ErrCorruptImage, err)
+	}
+
 	return loadImg, nil
 }
 
-func (img *ImageStorage) SaveImage(inputImg image.Image, imgName string) (string, error) {
-	const op = "storage.img.SaveImage"
+// checkLimits rejects data whose size exceeds the storage limits, reading
+// only the header.
+func (img *ImageStorage) checkLimits(data []byte) error {
+	width, height, err := dataSize(data)
+	if err != nil {
+		return fmt.Errorf("%w: %w", storage.ErrCorruptImage, err)
+	}
+	if int64(width)*int64(height) > img.MaxPixels {
+		return fmt.Errorf("%dx%d: %w", width, height, storage.ErrImageTooLarge)
+	}
 
-	filePath := filepath.Join(img.Path, imgName)
+	return nil
+}
 
-	fileExt := strings.ToLower(filepath.Ext(imgName))
-	var err error
-
-	switch fileExt {
-	case ".jpg", ".jpeg":
-		err = saveJPEG(inputImg, filePath)
-	case ".png":
-		err = savePNG(inputImg, filePath)
-	case ".gif":
-		err = saveGIF(inputImg, filePath)
-	case ".bmp":
-		err = saveBMP(inputImg, filePath)
-	default:
-		return "", fmt.Errorf("%s: unsupported file format: %s", op, fileExt)
+// checkFrames rejects an animation whose frames exceed the storage limits,
+// walking the container without decoding any. Only decoding every frame
+// needs it: LoadImage stops after the first.
+func (img *ImageStorage) checkFrames(data []byte) error {
+	frames, pixels := imgformat.FrameStats(data)
+	if frames > img.MaxFrames {
+		return fmt.Errorf("%d frames: %w", frames, storage.ErrTooManyFrames)
+	}
+	if pixels > img.MaxAnimationPixels {
+		return fmt.Errorf("%d pixels across %d frames: %w", pixels, frames, storage.ErrImageTooLarge)
+	}
+
+	return nil
+}
+
+// dataSize reads the dimensions from the header of an image file.
+func dataSize(data []byte) (int, int, error) {
+	if isTIFF(data) {
+		return tiffSize(bytes.NewReader(data))
+	}
+	if width, height, ok := imgformat.CanvasSize(data); ok {
+		return width, height, nil
 	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
 	if err != nil {
-		return "", fmt.Errorf("%s: %w", op, err)
+		return 0, 0, err
 	}
 
-	imageURL := fmt.Sprintf("/images/%s", imgName)
+	return config.Width, config.Height, nil
+}
+
+// decode decodes an image file, in sRGB whatever the gamma a PNG records.
+// A decoder that panics on malformed data is reported as an error.
+func decode(data []byte) (img image.Image, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			img, err = nil, fmt.Errorf("decoder panic: %v", r)
+		}
+	}()
+
+	if isTIFF(data) {
+		return decodeTIFF(data)
+	}
 
-	return imageURL, nil
+	img, _, err = image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return encoding.ToSRGB(img, encoding.PNGGamma(data)), nil
 }
 
-func (img *ImageStorage) GenerateName(prefix string, fileExt string) (string, error) {
-	const op = "storage.img.GenerateName"
+// ImageSize returns the image dimensions from its header, without
+// decoding the pixels.
+func (img *ImageStorage) ImageSize(imgName string) (int, int, error) {
+	const op = "storage.img.ImageSize"
 
-	if prefix == "" || fileExt == "" {
-		return "", fmt.Errorf("%s: the file prefix or extension must not be empty", op)
+	file, err := img.openFile(img.resolvePath(imgName))
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	header, _ := reader.Peek(30)
+	if width, height, ok := imgformat.CanvasSize(header); ok {
+		return width, height, nil
+	}
+	if isTIFF(header) {
+		width, height, err := tiffSize(reader)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s: %w: %w", op, storage.ErrCorruptImage, err)
+		}
+		return width, height, nil
 	}
 
-	if !strings.HasPrefix(fileExt, ".") {
-		fileExt = "." + fileExt
+	config, _, err := image.DecodeConfig(reader)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %w: %w", op, storage.ErrCorruptImage, err)
 	}
 
-	return fmt.Sprintf("%s_%s%s", prefix, time.Now().Format("20060102150405"), fileExt), nil
+	return config.Width, config.Height, nil
 }
 
-func saveJPEG(img image.Image, filePath string) error {
-	file, err := os.Create(filePath)
+// ImageFrames returns the number of frames of an animated GIF or WebP,
+// walking the file without decoding any, and 1 for other images.
+func (img *ImageStorage) ImageFrames(imgName string) (int, error) {
+	const op = "storage.img.ImageFrames"
+
+	filepath := img.resolvePath(imgName)
+
+	if err := checkFile(filepath); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	data, err := img.readFile(filepath)
 	if err != nil {
-		return err
+		return 0, fmt.Errorf("%s: %w", op, err)
 	}
-	defer file.Close()
 
-	return jpeg.Encode(file, img, nil)
+	frames, _ := imgformat.FrameStats(data)
+	return max(frames, 1), nil
 }
 
-func savePNG(img image.Image, filePath string) error {
-	file, err := os.Create(filePath)
+// ImageDPI returns the print resolution recorded in a JPEG or PNG, from
+// the first 64 KB of the file. It is zero when none is recorded.
+func (img *ImageStorage) ImageDPI(imgName string) (int, error) {
+	const op = "storage.img.ImageDPI"
+
+	file, err := img.openFile(img.resolvePath(imgName))
 	if err != nil {
-		return err
+		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 	defer file.Close()
 
-	return png.Encode(file, img)
+	header, err := io.ReadAll(io.LimitReader(file, 64<<10))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return encoding.ReadDPI(header), nil
 }
 
-func saveGIF(img image.Image, filePath string) error {
-	file, err := os.Create(filePath)
+// ImageCaptureTime returns when a JPEG or TIFF was taken, from the EXIF
+// in the first 128 KB of the file. It is zero when none is recorded.
+func (img *ImageStorage) ImageCaptureTime(imgName string) (time.Time, error) {
+	const op = "storage.img.ImageCaptureTime"
+
+	file, err := img.openFile(img.resolvePath(imgName))
 	if err != nil {
-		return err
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
 	}
 	defer file.Close()
 
-	return gif.Encode(file, img, nil)
+	header, err := io.ReadAll(io.LimitReader(file, 128<<10))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return storage.CaptureTime(header), nil
+}
+
+// ImageModTime returns when the image file was last written.
+func (img *ImageStorage) ImageModTime(imgName string) (time.Time, error) {
+	const op = "storage.img.ImageModTime"
+
+	info, err := os.Stat(img.resolvePath(imgName))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return info.ModTime(), nil
 }
 
-func saveBMP(img image.Image, filePath string) error {
-	file, err := os.Create(filePath)
+// FileSize returns the size of the stored image in bytes, as served:
+// for an encrypted file, without the encryption overhead.
+func (img *ImageStorage) FileSize(imgName string) (int64, error) {
+	const op = "storage.img.FileSize"
+
+	filePath := img.resolvePath(imgName)
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if img.encrypts() && isEncrypted(filePath) {
+		size, err := plainSize(info.Size())
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+		return size, nil
+	}
+
+	return info.Size(), nil
+}
+
+func (img *ImageStorage) SaveImage(inputImg image.Image, imgName string, opts encoding.Options) (string, error) {
+	const op = "storage.img.SaveImage"
+
+	dir := img.Path
+	if opts.Temp && img.TempPath != "" {
+		dir = img.TempPath
+	}
+	filePath := filepath.Join(dir, imgName)
+
+	fileExt := strings.ToLower(filepath.Ext(imgName))
+
+	if !encoding.Encodes(fileExt) {
+		return "", fmt.Errorf("%s: %w: %w: %s", op, storage.ErrEncode, encoding.ErrUnsupportedFormat, fileExt)
+	}
+
+	encode := func(w io.Writer) error { return encoding.Encode(w, inputImg, fileExt, opts) }
+	if err := img.saveFile(filePath, encodeWith(encode), opts.Origin); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return img.ImageURL(imgName), nil
+}
+
+// saveFile writes filePath with what write writes, to a temp file next to
+// it that is renamed into place once it is complete and fits the quota.
+// An image being replaced keeps its content until then, and if the save
+// fails it is left as it was; a name only reserved by GenerateName is
+// given up. Replacing an image is recorded in the audit log, attributed
+// to origin, before it happens: if it can't be, the save fails. The
+// cached ETag goes with the old content.
+func (img *ImageStorage) saveFile(filePath string, write func(w io.Writer) error, origin audit.Origin) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*"+savingExt)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	tmpPath := tmp.Name()
+	// CreateTemp makes the file private, but it becomes an image.
+	err = tmp.Chmod(0o644)
+	tmp.Close()
+
+	replace := func() error {
+		// Names handed out by GenerateName are reserved with an empty
+		// file, so only a non-empty one is an image being replaced.
+		if info, err := os.Stat(filePath); err == nil && info.Size() > 0 {
+			if err := img.Audit.Record(origin.Entry(audit.ActionOverwrite, filepath.Base(filePath))); err != nil {
+				return fmt.Errorf("failed to audit overwrite: %w", err)
+			}
+		}
+		return os.Rename(tmpPath, filePath)
+	}
+
+	if err == nil {
+		err = img.writeFile(tmpPath, write)
+	}
+	if err == nil {
+		err = img.enforceQuota(tmpPath, filePath, origin, replace)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		if info, statErr := os.Stat(filePath); statErr == nil && info.Size() == 0 {
+			os.Remove(filePath)
+		}
+		return err
+	}
+
+	os.Remove(filePath + etagExt)
+	return nil
+}
+
+// ImageURL returns the URL clients download imgName from, signed when
+// there is a URLSigner.
+func (img *ImageStorage) ImageURL(imgName string) string {
+	imgURL := fmt.Sprintf("%s/images/%s", img.PublicBaseURL, url.PathEscape(imgName))
+	if img.URLSigner != nil {
+		return img.URLSigner.SignURL(imgURL, imgName)
+	}
+	return imgURL
+}
+
+// recordingWriter keeps the first error of writing to w.
+type recordingWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	n, err := rw.w.Write(p)
+	if err != nil && rw.err == nil {
+		rw.err = err
+	}
+	return n, err
+}
+
+// encodeWith wraps encode so that its failures wrap storage.ErrEncode,
+// but for those writing the encoded bytes out, which are left as they are.
+func encodeWith(encode func(w io.Writer) error) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		rw := &recordingWriter{w: w}
+		if err := encode(rw); err != nil {
+			if rw.err != nil {
+				return rw.err
+			}
+			return fmt.Errorf("%w: %w", storage.ErrEncode, err)
+		}
+		return nil
+	}
+}
+
+func isImageExt(fileExt string) bool {
+	switch strings.ToLower(fileExt) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".bmp", ".webp", ".tif", ".tiff":
+		return true
+	default:
+		return false
+	}
+}
+
+// isLUT reports whether an upload is a .cube color lookup table, a text
+// file the lut action reads.
+func isLUT(fileExt, mimeType string) bool {
+	return isLUTExt(fileExt) && strings.HasPrefix(mimeType, "text/plain")
+}
 
-	return bmp.Encode(file, img)
+func isLUTExt(fileExt string) bool {
+	return strings.EqualFold(fileExt, ".cube")
 }
 
 func isImage(mimeType string) bool {
 	switch mimeType {
-	case "image/jpeg", "image/png", "image/bmp", "image/gif":
+	case "image/jpeg", "image/png", "image/bmp", "image/gif", "image/webp", "image/tiff":
 		return true
 	default:
 		return false
@@ -0,0 +1,1221 @@
+package filesystem_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/signedurl"
+	"online-photo-editor/internal/storage"
+	"online-photo-editor/internal/storage/filesystem"
+
+	"github.com/disintegration/imaging"
+	"github.com/gen2brain/webp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/tiff"
+)
+
+func TestImageStorage_SaveImage_WebPKeepsAlpha(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := filesystem.New(dir, filesystem.Options{})
+	require.NoError(t, err)
+
+	src := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: 200, G: 40, B: 40, A: 128})
+		}
+	}
+
+	file, err := os.Create(filepath.Join(dir, "transparent.png"))
+	require.NoError(t, err)
+	require.NoError(t, png.Encode(file, src))
+	require.NoError(t, file.Close())
+
+	inputImg, err := storage.LoadImage("transparent.png")
+	require.NoError(t, err)
+
+	imgUrl, err := storage.SaveImage(inputImg, "converted.webp", encoding.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, "/images/converted.webp", imgUrl)
+
+	outputImg, err := storage.LoadImage("converted.webp")
+	require.NoError(t, err)
+
+	c := color.NRGBAModel.Convert(outputImg.At(16, 16)).(color.NRGBA)
+	assert.InDelta(t, 128, c.A, 4, "alpha channel must survive the WebP encode")
+	assert.InDelta(t, 200, c.R, 16)
+}
+
+func TestImageStorage_LoadImage_CMYKTIFF(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := filesystem.New(dir, filesystem.Options{})
+	require.NoError(t, err)
+
+	// Pure cyan, pure magenta and 100% black.
+	pixels := []byte{
+		255, 0, 0, 0,
+		0, 255, 0, 0,
+		0, 0, 0, 255,
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "print.tif"), cmykTIFF(3, 1, pixels), 0o644))
+
+	img, err := storage.LoadImage("print.tif")
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 3, 1), img.Bounds())
+
+	assert.Equal(t, color.RGBA{R: 0, G: 255, B: 255, A: 255}, color.RGBAModel.Convert(img.At(0, 0)))
+	assert.Equal(t, color.RGBA{R: 255, G: 0, B: 255, A: 255}, color.RGBAModel.Convert(img.At(1, 0)))
+	assert.Equal(t, color.RGBA{R: 0, G: 0, B: 0, A: 255}, color.RGBAModel.Convert(img.At(2, 0)))
+}
+
+func TestImageStorage_LoadImage_GrayTIFF(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := filesystem.New(dir, filesystem.Options{})
+	require.NoError(t, err)
+
+	src := image.NewGray(image.Rect(0, 0, 2, 1))
+	src.SetGray(0, 0, color.Gray{Y: 30})
+	src.SetGray(1, 0, color.Gray{Y: 220})
+
+	var buf bytes.Buffer
+	require.NoError(t, tiff.Encode(&buf, src, nil))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "gray.tiff"), buf.Bytes(), 0o644))
+
+	img, err := storage.LoadImage("gray.tiff")
+	require.NoError(t, err)
+
+	gray, ok := img.(*image.Gray)
+	require.True(t, ok, "grayscale TIFF must decode to *image.Gray, got %T", img)
+	assert.Equal(t, uint8(30), gray.GrayAt(0, 0).Y)
+	assert.Equal(t, uint8(220), gray.GrayAt(1, 0).Y)
+}
+
+// cmykTIFF builds a minimal little-endian, uncompressed, single-strip CMYK TIFF.
+func cmykTIFF(width, height int, pixels []byte) []byte {
+	type entry struct {
+		tag, typ    uint16
+		count, data uint32
+	}
+
+	const headerSize, entrySize = 8, 12
+	bitsOffset := uint32(headerSize + 2 + 10*entrySize + 4)
+	pixelsOffset := bitsOffset + 8
+
+	entries := []entry{
+		{256, 4, 1, uint32(width)},
+		{257, 4, 1, uint32(height)},
+		{258, 3, 4, bitsOffset},
+		{259, 3, 1, 1},
+		{262, 3, 1, 5},
+		{273, 4, 1, pixelsOffset},
+		{277, 3, 1, 4},
+		{278, 4, 1, uint32(height)},
+		{279, 4, 1, uint32(len(pixels))},
+		{284, 3, 1, 1},
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("II*\x00")
+	binary.Write(&buf, binary.LittleEndian, uint32(headerSize))
+	binary.Write(&buf, binary.LittleEndian, uint16(len(entries)))
+	for _, e := range entries {
+		binary.Write(&buf, binary.LittleEndian, e)
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(0))
+	binary.Write(&buf, binary.LittleEndian, [4]uint16{8, 8, 8, 8})
+	buf.Write(pixels)
+
+	return buf.Bytes()
+}
+
+func TestImageStorage_ImageETag_StoredAlongsideFile(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := filesystem.New(dir, filesystem.Options{})
+	require.NoError(t, err)
+
+	_, err = storage.SaveImage(image.NewNRGBA(image.Rect(0, 0, 4, 4)), "img.png", encoding.Options{})
+	require.NoError(t, err)
+
+	etag, err := storage.ImageETag("img.png")
+	require.NoError(t, err)
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+
+	stored, err := os.ReadFile(filepath.Join(dir, "img.png.etag"))
+	require.NoError(t, err)
+	assert.Equal(t, etag, string(stored))
+
+	again, err := storage.ImageETag("img.png")
+	require.NoError(t, err)
+	assert.Equal(t, etag, again)
+
+	_, err = storage.SaveImage(image.NewNRGBA(image.Rect(0, 0, 8, 8)), "img.png", encoding.Options{})
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(dir, "img.png.etag"), "overwriting an image must drop its stale ETag")
+}
+
+func TestImageStorage_CommitImage_MovesOutOfTemp(t *testing.T) {
+	dir := t.TempDir()
+	tempDir := filepath.Join(dir, "tmp")
+
+	storage, err := filesystem.New(dir, filesystem.Options{TempPath: tempDir})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 4, 4))))
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "kept.png"), buf.Bytes(), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "abandoned.png"), buf.Bytes(), 0o644))
+
+	_, err = storage.LoadImage("kept.png")
+	require.NoError(t, err, "uncommitted uploads must still be loadable")
+
+	imgUrl, err := storage.CommitImage("kept.png")
+	require.NoError(t, err)
+	assert.Equal(t, "/images/kept.png", imgUrl)
+	assert.FileExists(t, filepath.Join(dir, "kept.png"))
+	assert.NoFileExists(t, filepath.Join(tempDir, "kept.png"))
+
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(tempDir, "abandoned.png"), old, old))
+
+	removed, err := storage.CleanupTemp(time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.NoFileExists(t, filepath.Join(tempDir, "abandoned.png"))
+	assert.FileExists(t, filepath.Join(dir, "kept.png"))
+}
+
+func TestImageStorage_SaveImage_PublicBaseURL(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := filesystem.New(dir, filesystem.Options{PublicBaseURL: "https://cdn.example.com/"})
+	require.NoError(t, err)
+
+	imgUrl, err := storage.SaveImage(image.NewNRGBA(image.Rect(0, 0, 4, 4)), "img.png", encoding.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, "https://cdn.example.com/images/img.png", imgUrl)
+	assert.FileExists(t, filepath.Join(dir, "img.png"), "the internal path must not change")
+}
+
+func TestImageStorage_SaveImage_BitDepth(t *testing.T) {
+	storage, err := filesystem.New(t.TempDir(), filesystem.Options{})
+	require.NoError(t, err)
+
+	src := image.NewNRGBA(image.Rect(0, 0, 256, 1))
+	for x := 0; x < 256; x++ {
+		src.SetNRGBA(x, 0, color.NRGBA{R: uint8(x), G: uint8(x), B: uint8(x), A: 255})
+	}
+
+	_, err = storage.SaveImage(src, "retro.png", encoding.Options{BitDepth: 2})
+	require.NoError(t, err)
+
+	out, err := storage.LoadImage("retro.png")
+	require.NoError(t, err)
+
+	levels := map[uint32]bool{}
+	for x := 0; x < 256; x++ {
+		r, _, _, _ := out.At(x, 0).RGBA()
+		levels[r>>8] = true
+	}
+	assert.Equal(t, map[uint32]bool{0: true, 85: true, 170: true, 255: true}, levels)
+}
+
+// withCaptureTime inserts a little-endian EXIF segment recording taken as
+// DateTimeOriginal right after the SOI marker of a JPEG.
+func withCaptureTime(jpg []byte, taken string) []byte {
+	le := binary.LittleEndian
+
+	tiff := []byte("II*\x00")
+	tiff = le.AppendUint32(tiff, 8)
+	// IFD0: only the pointer to the Exif IFD, which follows it at 26.
+	tiff = le.AppendUint16(tiff, 1)
+	tiff = le.AppendUint16(tiff, 0x8769)
+	tiff = le.AppendUint16(tiff, 4)
+	tiff = le.AppendUint32(tiff, 1)
+	tiff = le.AppendUint32(tiff, 26)
+	tiff = le.AppendUint32(tiff, 0)
+	// Exif IFD: DateTimeOriginal, stored right after it at 44.
+	tiff = le.AppendUint16(tiff, 1)
+	tiff = le.AppendUint16(tiff, 0x9003)
+	tiff = le.AppendUint16(tiff, 2)
+	tiff = le.AppendUint32(tiff, 20)
+	tiff = le.AppendUint32(tiff, 44)
+	tiff = le.AppendUint32(tiff, 0)
+	tiff = append(tiff, taken+"\x00"...)
+
+	app1 := []byte{0xff, 0xe1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(2+6+len(tiff)))
+	app1 = append(app1, "Exif\x00\x00"...)
+	app1 = append(app1, tiff...)
+
+	out := append([]byte{}, jpg[:2]...)
+	out = append(out, app1...)
+	return append(out, jpg[2:]...)
+}
+
+func TestImageStorage_ImageCaptureTime(t *testing.T) {
+	dir := t.TempDir()
+	imgStorage, err := filesystem.New(dir, filesystem.Options{})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "taken.jpg"), withCaptureTime(buf.Bytes(), "2019:08:01 09:30:15"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "plain.jpg"), buf.Bytes(), 0o644))
+
+	taken, err := imgStorage.ImageCaptureTime("taken.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2019, 8, 1, 9, 30, 15, 0, time.UTC), taken)
+
+	taken, err = imgStorage.ImageCaptureTime("plain.jpg")
+	require.NoError(t, err)
+	assert.True(t, taken.IsZero())
+
+	_, err = imgStorage.ImageCaptureTime("missing.jpg")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestImageStorage_SaveImage_SignedURL(t *testing.T) {
+	signer, err := signedurl.New([]byte("0123456789abcdef"), time.Hour)
+	require.NoError(t, err)
+
+	imgStorage, err := filesystem.New(t.TempDir(), filesystem.Options{
+		PublicBaseURL: "https://cdn.example.com",
+		URLSigner:     signer,
+	})
+	require.NoError(t, err)
+
+	imgURL, err := imgStorage.SaveImage(image.NewNRGBA(image.Rect(0, 0, 4, 4)), "img.png", encoding.Options{})
+	require.NoError(t, err)
+
+	u, err := url.Parse(imgURL)
+	require.NoError(t, err)
+	assert.Equal(t, "https://cdn.example.com/images/img.png", u.Scheme+"://"+u.Host+u.Path)
+	assert.NoError(t, signer.Verify("img.png", u.Query()))
+}
+
+func TestImageStorage_SaveImage_DPI(t *testing.T) {
+	dir := t.TempDir()
+	imgStorage, err := filesystem.New(dir, filesystem.Options{})
+	require.NoError(t, err)
+
+	src := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+
+	for _, name := range []string{"print.jpg", "print.png"} {
+		_, err := imgStorage.SaveImage(src, name, encoding.Options{DPI: 300})
+		require.NoError(t, err)
+
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+
+		switch filepath.Ext(name) {
+		case ".jpg":
+			// JFIF APP0 right after SOI, units in dots per inch.
+			require.Greater(t, len(data), 20)
+			assert.Equal(t, []byte{0xff, 0xd8, 0xff, 0xe0}, data[:4])
+			assert.Equal(t, "JFIF\x00", string(data[6:11]))
+			assert.Equal(t, byte(1), data[13])
+			assert.Equal(t, uint16(300), binary.BigEndian.Uint16(data[14:]))
+			assert.Equal(t, uint16(300), binary.BigEndian.Uint16(data[16:]))
+		case ".png":
+			i := bytes.Index(data, []byte("pHYs"))
+			require.Positive(t, i)
+			assert.Less(t, i, bytes.Index(data, []byte("IDAT")))
+			// 300 dpi is 11811 pixels per meter.
+			assert.Equal(t, uint32(11811), binary.BigEndian.Uint32(data[i+4:]))
+			assert.Equal(t, uint32(11811), binary.BigEndian.Uint32(data[i+8:]))
+			assert.Equal(t, byte(1), data[i+12])
+		}
+
+		_, err = imgStorage.LoadImage(name)
+		assert.NoError(t, err, "%s must still decode", name)
+
+		dpi, err := imgStorage.ImageDPI(name)
+		require.NoError(t, err)
+		assert.Equal(t, 300, dpi, "%s dpi must read back", name)
+	}
+
+	_, err = imgStorage.SaveImage(src, "screen.png", encoding.Options{})
+	require.NoError(t, err)
+
+	dpi, err := imgStorage.ImageDPI("screen.png")
+	require.NoError(t, err)
+	assert.Zero(t, dpi)
+}
+
+// exifThumbnail returns the thumbnail embedded in the EXIF APP1 segment
+// of a JPEG, nil if there is none.
+func exifThumbnail(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xff && data[pos+1] != 0xda {
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		payload := data[pos+4 : pos+2+length]
+
+		if data[pos+1] == 0xe1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			tiff := payload[6:]
+			require.Equal(t, "MM\x00*", string(tiff[:4]))
+
+			ifd0 := binary.BigEndian.Uint32(tiff[4:])
+			entries := binary.BigEndian.Uint16(tiff[ifd0:])
+			ifd1 := binary.BigEndian.Uint32(tiff[ifd0+2+12*uint32(entries):])
+
+			var offset, size uint32
+			entries = binary.BigEndian.Uint16(tiff[ifd1:])
+			for i := uint32(0); i < uint32(entries); i++ {
+				entry := tiff[ifd1+2+12*i:]
+				switch binary.BigEndian.Uint16(entry) {
+				case 0x0201:
+					offset = binary.BigEndian.Uint32(entry[8:])
+				case 0x0202:
+					size = binary.BigEndian.Uint32(entry[8:])
+				}
+			}
+			require.NotZero(t, size)
+			return tiff[offset : offset+size]
+		}
+
+		pos += 2 + length
+	}
+
+	return nil
+}
+
+func TestImageStorage_SaveImage_EXIFThumbnail(t *testing.T) {
+	dir := t.TempDir()
+	imgStorage, err := filesystem.New(dir, filesystem.Options{})
+	require.NoError(t, err)
+
+	src := imaging.New(1600, 900, color.NRGBA{R: 200, A: 255})
+
+	_, err = imgStorage.SaveImage(src, "thumb.jpg", encoding.Options{EXIFThumbnail: true, DPI: 300})
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(filepath.Join(dir, "thumb.jpg"))
+	require.NoError(t, err)
+
+	thumb := exifThumbnail(t, data)
+	require.NotNil(t, thumb)
+	config, err := jpeg.DecodeConfig(bytes.NewReader(thumb))
+	require.NoError(t, err)
+	assert.Equal(t, [2]int{160, 90}, [2]int{config.Width, config.Height})
+
+	// The APP0 still comes first, with its density.
+	assert.Equal(t, []byte{0xff, 0xd8, 0xff, 0xe0}, data[:4])
+	dpi, err := imgStorage.ImageDPI("thumb.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, 300, dpi)
+
+	loaded, err := imgStorage.LoadImage("thumb.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 1600, 900), loaded.Bounds())
+
+	_, err = imgStorage.SaveImage(src, "plain.jpg", encoding.Options{})
+	require.NoError(t, err)
+
+	data, err = os.ReadFile(filepath.Join(dir, "plain.jpg"))
+	require.NoError(t, err)
+	assert.Nil(t, exifThumbnail(t, data))
+}
+
+// takeNames creates images for the names GenerateName can produce in the
+// next second, so the next call is guaranteed to collide.
+func takeNames(t *testing.T, dir, prefix string) {
+	t.Helper()
+
+	now := time.Now()
+	for _, ts := range []time.Time{now, now.Add(time.Second)} {
+		name := prefix + "_" + ts.Format("20060102150405") + ".png"
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("taken"), 0o644))
+	}
+}
+
+func TestImageStorage_GenerateName_Collision(t *testing.T) {
+	t.Run("fail", func(t *testing.T) {
+		dir := t.TempDir()
+		storage, err := filesystem.New(dir, filesystem.Options{OnCollision: filesystem.CollisionFail})
+		require.NoError(t, err)
+
+		takeNames(t, dir, "proc")
+
+		_, err = storage.GenerateName("proc", ".png")
+		assert.ErrorIs(t, err, filesystem.ErrNameTaken)
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		dir := t.TempDir()
+		storage, err := filesystem.New(dir, filesystem.Options{OnCollision: filesystem.CollisionOverwrite})
+		require.NoError(t, err)
+
+		takeNames(t, dir, "proc")
+
+		name, err := storage.GenerateName("proc", ".png")
+		require.NoError(t, err)
+
+		_, err = storage.SaveImage(image.NewNRGBA(image.Rect(0, 0, 4, 4)), name, encoding.Options{})
+		require.NoError(t, err)
+
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		assert.NotEqual(t, []byte("taken"), data)
+	})
+
+	t.Run("suffix", func(t *testing.T) {
+		dir := t.TempDir()
+		storage, err := filesystem.New(dir, filesystem.Options{OnCollision: filesystem.CollisionSuffix})
+		require.NoError(t, err)
+
+		takeNames(t, dir, "proc")
+
+		name, err := storage.GenerateName("proc", ".png")
+		require.NoError(t, err)
+		assert.Regexp(t, `^proc_\d{14}_1\.png$`, name)
+
+		info, err := os.Stat(filepath.Join(dir, name))
+		require.NoError(t, err)
+		assert.Zero(t, info.Size(), "name should be reserved with an empty file")
+	})
+
+	t.Run("suffix is the default and never repeats", func(t *testing.T) {
+		storage, err := filesystem.New(t.TempDir(), filesystem.Options{})
+		require.NoError(t, err)
+
+		const n = 20
+
+		names := make(chan string, n)
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				name, err := storage.GenerateName("proc", ".png")
+				assert.NoError(t, err)
+				names <- name
+			}()
+		}
+		wg.Wait()
+		close(names)
+
+		seen := make(map[string]bool)
+		for name := range names {
+			assert.False(t, seen[name], "duplicate name %s", name)
+			seen[name] = true
+		}
+	})
+}
+
+func TestNew_UnknownCollisionStrategy(t *testing.T) {
+	_, err := filesystem.New(t.TempDir(), filesystem.Options{OnCollision: "rename"})
+	assert.Error(t, err)
+}
+
+func TestImageStorage_GenerateName_Extension(t *testing.T) {
+	images, err := filesystem.New(t.TempDir(), filesystem.Options{DefaultExt: "jpg"})
+	require.NoError(t, err)
+
+	cases := []struct {
+		ext  string
+		want string
+	}{
+		{ext: ".png", want: `\.png$`},
+		{ext: "png", want: `\.png$`},
+		{ext: "..PNG", want: `\.png$`},
+		{ext: " .png ", want: `\.png$`},
+		{ext: "", want: `\.jpg$`},
+		{ext: ".", want: `\.jpg$`},
+	}
+
+	for _, tc := range cases {
+		name, err := images.GenerateName("proc", tc.ext)
+		require.NoError(t, err, tc.ext)
+		assert.Regexp(t, `^proc_\d{14}(_\d+)?`+tc.want, name, tc.ext)
+		assert.NoError(t, storage.ValidateName(name))
+	}
+
+	for _, ext := range []string{".p/ng", ".tar.gz", ".waytoolongext"} {
+		_, err := images.GenerateName("proc", ext)
+		assert.ErrorIs(t, err, storage.ErrInvalidExt, ext)
+	}
+}
+
+func TestImageStorage_GenerateName_ExtensionlessSource(t *testing.T) {
+	dir := t.TempDir()
+	images, err := filesystem.New(dir, filesystem.Options{})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 6)), nil))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "photo"), buf.Bytes(), 0o644))
+
+	src, err := images.LoadImage("photo")
+	require.NoError(t, err)
+
+	name, err := images.GenerateName("proc", filepath.Ext("photo"))
+	require.NoError(t, err)
+	assert.Equal(t, ".png", filepath.Ext(name))
+
+	_, err = images.SaveImage(src, name, encoding.Options{})
+	require.NoError(t, err)
+
+	file, err := images.OpenImage(name)
+	require.NoError(t, err)
+	defer file.Close()
+
+	out, format, err := image.Decode(file)
+	require.NoError(t, err)
+	assert.Equal(t, "png", format)
+	assert.Equal(t, image.Rect(0, 0, 8, 6), out.Bounds())
+}
+
+func TestNew_DefaultExtNotAnImage(t *testing.T) {
+	_, err := filesystem.New(t.TempDir(), filesystem.Options{DefaultExt: ".txt"})
+	assert.Error(t, err)
+}
+
+func TestImageStorage_UploadImage_ReservesInTemp(t *testing.T) {
+	dir := t.TempDir()
+	tempDir := filepath.Join(dir, "tmp")
+
+	storage, err := filesystem.New(dir, filesystem.Options{TempPath: tempDir})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 4, 4))))
+
+	name, err := storage.UploadImage(&buf, "photo.png")
+	require.NoError(t, err)
+
+	assert.FileExists(t, filepath.Join(tempDir, name))
+	assert.NoFileExists(t, filepath.Join(dir, name), "the name must not be reserved in permanent storage")
+
+	_, err = storage.LoadImage(name)
+	assert.NoError(t, err)
+}
+
+func TestImageStorage_UploadImage_LUT(t *testing.T) {
+	storage, err := filesystem.New(t.TempDir(), filesystem.Options{})
+	require.NoError(t, err)
+
+	cube := "LUT_3D_SIZE 2\n0 0 0\n1 0 0\n0 1 0\n1 1 0\n0 0 1\n1 0 1\n0 1 1\n1 1 1\n"
+
+	name, err := storage.UploadImage(strings.NewReader(cube), "look.cube")
+	require.NoError(t, err)
+	assert.Equal(t, ".cube", filepath.Ext(name))
+
+	// LUTs uploaded in the same second get names of their own.
+	other, err := storage.UploadImage(strings.NewReader(cube), "look.cube")
+	require.NoError(t, err)
+	assert.NotEqual(t, name, other)
+
+	// Other text files are still rejected.
+	_, err = storage.UploadImage(strings.NewReader(cube), "look.txt")
+	assert.Error(t, err)
+}
+
+func TestImageStorage_SaveImage_AuditsOverwrite(t *testing.T) {
+	dir := t.TempDir()
+
+	var log bytes.Buffer
+	storage, err := filesystem.New(dir, filesystem.Options{
+		OnCollision: filesystem.CollisionOverwrite,
+		Audit:       audit.NewWriterSink(&log),
+	})
+	require.NoError(t, err)
+
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+
+	_, err = storage.SaveImage(img, "result.png", encoding.Options{})
+	require.NoError(t, err)
+	assert.Empty(t, log.String(), "a new image is not an overwrite")
+
+	origin := audit.Origin{Actor: "key:0123456789abcdef", RequestID: "host/req-1", RemoteAddr: "192.0.2.1:1234"}
+	_, err = storage.SaveImage(img, "result.png", encoding.Options{Origin: origin})
+	require.NoError(t, err)
+
+	var entry audit.Entry
+	require.NoError(t, json.Unmarshal(log.Bytes(), &entry))
+	assert.Equal(t, audit.ActionOverwrite, entry.Action)
+	assert.Equal(t, "result.png", entry.Image)
+	assert.Equal(t, origin, entry.Origin)
+}
+
+type failingSink struct{}
+
+func (failingSink) Record(audit.Entry) error { return errors.New("disk full") }
+
+func TestImageStorage_SaveImage_AuditFailureKeepsImage(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := filesystem.New(dir, filesystem.Options{
+		OnCollision: filesystem.CollisionOverwrite,
+		Audit:       failingSink{},
+	})
+	require.NoError(t, err)
+
+	_, err = storage.SaveImage(image.NewNRGBA(image.Rect(0, 0, 4, 4)), "result.png", encoding.Options{})
+	require.NoError(t, err, "a new image needs no audit entry")
+
+	// The overwrite can't be recorded, so it doesn't happen.
+	_, err = storage.SaveImage(image.NewNRGBA(image.Rect(0, 0, 8, 8)), "result.png", encoding.Options{})
+	require.Error(t, err)
+
+	w, h, err := storage.ImageSize("result.png")
+	require.NoError(t, err)
+	assert.Equal(t, []int{4, 4}, []int{w, h})
+
+	leftovers, err := filepath.Glob(filepath.Join(dir, "*.saving"))
+	require.NoError(t, err)
+	assert.Empty(t, leftovers)
+}
+
+func TestImageStorage_LoadImage_Truncated(t *testing.T) {
+	dir := t.TempDir()
+	imgStorage, err := filesystem.New(dir, filesystem.Options{})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 64, 64))))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cut.png"), buf.Bytes()[:buf.Len()/2], 0o644))
+
+	_, err = imgStorage.LoadImage("cut.png")
+	assert.ErrorIs(t, err, storage.ErrCorruptImage)
+
+	_, err = imgStorage.LoadImage("missing.png")
+	assert.NotErrorIs(t, err, storage.ErrCorruptImage)
+}
+
+func TestImageStorage_LoadImage_PixelLimit(t *testing.T) {
+	dir := t.TempDir()
+	imgStorage, err := filesystem.New(dir, filesystem.Options{MaxPixels: 100 * 100})
+	require.NoError(t, err)
+
+	for _, size := range []int{100, 101} {
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, size, size))))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "img.png"), buf.Bytes(), 0o644))
+
+		_, err = imgStorage.LoadImage("img.png")
+		if size == 100 {
+			assert.NoError(t, err)
+		} else {
+			assert.ErrorIs(t, err, storage.ErrImageTooLarge)
+		}
+	}
+}
+
+func TestImageStorage_LoadGIF_FrameLimits(t *testing.T) {
+	dir := t.TempDir()
+	imgStorage, err := filesystem.New(dir, filesystem.Options{MaxFrames: 10, MaxAnimationPixels: 10 * 20 * 20})
+	require.NoError(t, err)
+
+	gifFrames := func(n, size int) []byte {
+		anim := &gif.GIF{}
+		for i := 0; i < n; i++ {
+			anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.Black, color.White}))
+			anim.Delay = append(anim.Delay, 10)
+		}
+
+		var buf bytes.Buffer
+		require.NoError(t, gif.EncodeAll(&buf, anim))
+		return buf.Bytes()
+	}
+
+	webpFrames := func(n, size int) []byte {
+		anim := &webp.WEBP{}
+		for i := 0; i < n; i++ {
+			anim.Image = append(anim.Image, imaging.New(size, size, color.NRGBA{R: uint8(i * 20), A: 255}))
+			anim.Delay = append(anim.Delay, 10)
+		}
+
+		var buf bytes.Buffer
+		require.NoError(t, webp.EncodeAll(&buf, anim))
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"gif within limits", gifFrames(10, 20), nil},
+		{"gif too many frames", gifFrames(11, 2), storage.ErrTooManyFrames},
+		{"gif too many pixels", gifFrames(5, 30), storage.ErrImageTooLarge},
+		{"webp within limits", webpFrames(10, 20), nil},
+		{"webp too many frames", webpFrames(11, 2), storage.ErrTooManyFrames},
+		{"webp too many pixels", webpFrames(5, 30), storage.ErrImageTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ext := ".gif"
+			if bytes.HasPrefix(tt.data, []byte("RIFF")) {
+				ext = ".webp"
+			}
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "anim"+ext), tt.data, 0o644))
+
+			_, err := imgStorage.LoadImage("anim" + ext)
+			assert.NoError(t, err, "only the first frame is decoded")
+
+			if ext != ".gif" {
+				return
+			}
+			_, err = imgStorage.LoadGIF("anim" + ext)
+			if tt.want == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestImageStorage_SaveGIF_KeepsFrames(t *testing.T) {
+	imgStorage, err := filesystem.New(t.TempDir(), filesystem.Options{MaxFrames: 2})
+	require.NoError(t, err)
+
+	anim := &gif.GIF{LoopCount: 3}
+	for i := 0; i < 3; i++ {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White}))
+		anim.Delay = append(anim.Delay, 10*(i+1))
+	}
+
+	_, err = imgStorage.SaveGIF(anim, "anim.gif", audit.Origin{})
+	require.NoError(t, err)
+
+	_, err = imgStorage.LoadGIF("anim.gif")
+	assert.ErrorIs(t, err, storage.ErrTooManyFrames)
+
+	imgStorage.MaxFrames = 3
+	loaded, err := imgStorage.LoadGIF("anim.gif")
+	require.NoError(t, err)
+	assert.Len(t, loaded.Image, 3)
+	assert.Equal(t, []int{10, 20, 30}, loaded.Delay)
+	assert.Equal(t, 3, loaded.LoopCount)
+
+	_, err = imgStorage.SaveGIF(anim, "anim.png", audit.Origin{})
+	assert.Error(t, err)
+}
+
+func TestImageStorage_SaveImage_CountQuota(t *testing.T) {
+	dir := t.TempDir()
+	imgStorage, err := filesystem.New(dir, filesystem.Options{MaxImages: 2})
+	require.NoError(t, err)
+
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+
+	for _, name := range []string{"a.png", "b.png"} {
+		_, err := imgStorage.SaveImage(img, name, encoding.Options{})
+		require.NoError(t, err)
+	}
+
+	_, err = imgStorage.SaveImage(img, "c.png", encoding.Options{})
+	assert.ErrorIs(t, err, storage.ErrQuotaExceeded)
+	assert.NoFileExists(t, filepath.Join(dir, "c.png"))
+
+	_, err = imgStorage.SaveImage(img, "a.png", encoding.Options{})
+	assert.NoError(t, err, "replacing an image doesn't add one")
+}
+
+func TestImageStorage_SaveImage_EncodeError(t *testing.T) {
+	dir := t.TempDir()
+	imgStorage, err := filesystem.New(dir, filesystem.Options{})
+	require.NoError(t, err)
+
+	_, err = imgStorage.SaveImage(image.NewNRGBA(image.Rect(0, 0, 0, 0)), "empty.png", encoding.Options{})
+	assert.ErrorIs(t, err, storage.ErrEncode, "png can't encode an empty image")
+
+	_, err = imgStorage.SaveImage(image.NewNRGBA(image.Rect(0, 0, 4, 4)), "img.psd", encoding.Options{})
+	assert.ErrorIs(t, err, storage.ErrEncode)
+
+	_, err = imgStorage.SaveImage(image.NewNRGBA(image.Rect(0, 0, 4, 4)), "missing/img.png", encoding.Options{})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.NotErrorIs(t, err, storage.ErrEncode, "failing to create the file isn't an encode error")
+}
+
+func TestImageStorage_SaveImage_ByteQuota(t *testing.T) {
+	dir := t.TempDir()
+
+	var log bytes.Buffer
+	imgStorage, err := filesystem.New(dir, filesystem.Options{Audit: audit.NewWriterSink(&log)})
+	require.NoError(t, err)
+
+	img := imaging.New(16, 16, color.NRGBA{R: 200, A: 255})
+	_, err = imgStorage.SaveImage(img, "old.png", encoding.Options{})
+	require.NoError(t, err)
+	info, err := os.Stat(filepath.Join(dir, "old.png"))
+	require.NoError(t, err)
+
+	// Room for one image of this size, not two.
+	imgStorage.MaxBytes = info.Size()*2 - 1
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "old.png"), time.Now(), time.Now().Add(-time.Hour)))
+
+	_, err = imgStorage.SaveImage(img, "new.png", encoding.Options{})
+	assert.ErrorIs(t, err, storage.ErrQuotaExceeded)
+	assert.NoFileExists(t, filepath.Join(dir, "new.png"))
+	assert.FileExists(t, filepath.Join(dir, "old.png"))
+
+	imgStorage.EvictOldest = true
+	_, err = imgStorage.SaveImage(img, "new.png", encoding.Options{})
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "new.png"))
+	assert.NoFileExists(t, filepath.Join(dir, "old.png"))
+
+	var entry audit.Entry
+	require.NoError(t, json.Unmarshal(log.Bytes(), &entry))
+	assert.Equal(t, audit.ActionEvict, entry.Action)
+	assert.Equal(t, "old.png", entry.Image)
+
+	imgStorage.MaxBytes = info.Size() - 1
+	_, err = imgStorage.SaveImage(img, "big.png", encoding.Options{})
+	assert.ErrorIs(t, err, storage.ErrQuotaExceeded, "eviction can't make room for an image over the quota on its own")
+	assert.FileExists(t, filepath.Join(dir, "new.png"))
+}
+
+func TestImageStorage_SaveImage_QuotaOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	imgStorage, err := filesystem.New(dir, filesystem.Options{MaxImages: 1})
+	require.NoError(t, err)
+
+	small := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	_, err = imgStorage.SaveImage(small, "a.png", encoding.Options{})
+	require.NoError(t, err)
+
+	// The image replaced doesn't count against the quota.
+	_, err = imgStorage.SaveImage(small, "a.png", encoding.Options{})
+	require.NoError(t, err)
+
+	info, err := os.Stat(filepath.Join(dir, "a.png"))
+	require.NoError(t, err)
+	imgStorage.MaxBytes = info.Size()
+
+	large := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for i := range large.Pix {
+		large.Pix[i] = uint8(i * 31)
+	}
+	_, err = imgStorage.SaveImage(large, "a.png", encoding.Options{})
+	assert.ErrorIs(t, err, storage.ErrQuotaExceeded)
+
+	// The save failing leaves the old content in place, and nothing else.
+	loaded, err := imgStorage.LoadImage("a.png")
+	require.NoError(t, err)
+	assert.Equal(t, small.Bounds(), loaded.Bounds())
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "a.png", entries[0].Name())
+}
+
+func TestImageStorage_SaveImage_NamespaceQuota(t *testing.T) {
+	dir := t.TempDir()
+	imgStorage, err := filesystem.New(dir, filesystem.Options{NamespaceMaxImages: 2})
+	require.NoError(t, err)
+
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+
+	for _, name := range []string{"acme--a.png", "acme--b.png", "other--a.png", "a.png"} {
+		_, err := imgStorage.SaveImage(img, name, encoding.Options{})
+		require.NoError(t, err, name)
+	}
+
+	_, err = imgStorage.SaveImage(img, "acme--c.png", encoding.Options{})
+	assert.ErrorIs(t, err, storage.ErrNamespaceQuotaExceeded)
+	assert.ErrorIs(t, err, storage.ErrQuotaExceeded)
+	assert.NoFileExists(t, filepath.Join(dir, "acme--c.png"))
+
+	// The other namespaces, the default one too, have room of their own.
+	for _, name := range []string{"other--b.png", "b.png"} {
+		_, err := imgStorage.SaveImage(img, name, encoding.Options{})
+		assert.NoError(t, err, name)
+	}
+
+	// Uploads are stored in the namespace of their file name.
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	_, err = imgStorage.UploadImage(bytes.NewReader(buf.Bytes()), "acme--upload.png")
+	assert.ErrorIs(t, err, storage.ErrNamespaceQuotaExceeded)
+
+	name, err := imgStorage.UploadImage(bytes.NewReader(buf.Bytes()), "new--upload.png")
+	require.NoError(t, err)
+	assert.Equal(t, "new", storage.Namespace(name))
+}
+
+func TestImageStorage_SaveImage_MaxNamespaces(t *testing.T) {
+	dir := t.TempDir()
+	imgStorage, err := filesystem.New(dir, filesystem.Options{MaxNamespaces: 2})
+	require.NoError(t, err)
+
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+
+	for _, name := range []string{"acme--a.png", "other--a.png", "a.png"} {
+		_, err := imgStorage.SaveImage(img, name, encoding.Options{})
+		require.NoError(t, err, name)
+	}
+
+	_, err = imgStorage.SaveImage(img, "third--a.png", encoding.Options{})
+	assert.ErrorIs(t, err, storage.ErrNamespaceQuotaExceeded)
+	assert.NoFileExists(t, filepath.Join(dir, "third--a.png"))
+
+	// The namespaces there are, and the default one, still take images.
+	for _, name := range []string{"acme--b.png", "b.png"} {
+		_, err := imgStorage.SaveImage(img, name, encoding.Options{})
+		assert.NoError(t, err, name)
+	}
+}
+
+func TestImageStorage_Encryption(t *testing.T) {
+	dir := t.TempDir()
+	key := bytes.Repeat([]byte{7}, 32)
+
+	images, err := filesystem.New(dir, filesystem.Options{EncryptionKey: key})
+	require.NoError(t, err)
+
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 8))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i)
+	}
+
+	_, err = images.SaveImage(src, "secret.png", encoding.Options{})
+	require.NoError(t, err)
+
+	var plain bytes.Buffer
+	require.NoError(t, png.Encode(&plain, src))
+
+	onDisk, err := os.ReadFile(filepath.Join(dir, "secret.png"))
+	require.NoError(t, err)
+	assert.NotEqual(t, plain.Bytes(), onDisk)
+	assert.NotContains(t, string(onDisk), "PNG")
+	_, _, err = image.Decode(bytes.NewReader(onDisk))
+	assert.Error(t, err, "stored bytes must not decode without the key")
+
+	out, err := images.LoadImage("secret.png")
+	require.NoError(t, err)
+	assert.Equal(t, src.Pix, imaging.Clone(out).Pix)
+
+	width, height, err := images.ImageSize("secret.png")
+	require.NoError(t, err)
+	assert.Equal(t, 16, width)
+	assert.Equal(t, 8, height)
+
+	// Downloads get the decrypted image, and its size.
+	file, err := images.OpenImage("secret.png")
+	require.NoError(t, err)
+	defer file.Close()
+	served, _, err := image.Decode(file)
+	require.NoError(t, err)
+	assert.Equal(t, src.Bounds(), served.Bounds())
+
+	size, err := images.FileSize("secret.png")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(encoding.WithSRGB(plain.Bytes()))), size)
+
+	// Another key, or none, can't read it.
+	for _, opts := range []filesystem.Options{{EncryptionKey: bytes.Repeat([]byte{8}, 32)}, {}} {
+		other, err := filesystem.New(dir, opts)
+		require.NoError(t, err)
+
+		_, err = other.LoadImage("secret.png")
+		assert.ErrorIs(t, err, storage.ErrCorruptImage)
+	}
+}
+
+func TestImageStorage_Encryption_ReadsPlainFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 3, 2))))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "old.png"), buf.Bytes(), 0o644))
+
+	images, err := filesystem.New(dir, filesystem.Options{EncryptionKey: bytes.Repeat([]byte{7}, 16)})
+	require.NoError(t, err)
+
+	out, err := images.LoadImage("old.png")
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 3, 2), out.Bounds())
+
+	size, err := images.FileSize("old.png")
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), size)
+}
+
+func TestImageStorage_Encryption_Upload(t *testing.T) {
+	dir := t.TempDir()
+
+	images, err := filesystem.New(dir, filesystem.Options{EncryptionKey: bytes.Repeat([]byte{7}, 32)})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 5, 4))))
+
+	name, err := images.UploadImage(bytes.NewReader(buf.Bytes()), "photo.png")
+	require.NoError(t, err)
+
+	onDisk, err := os.ReadFile(filepath.Join(dir, name))
+	require.NoError(t, err)
+	assert.NotEqual(t, buf.Bytes(), onDisk)
+
+	out, err := images.LoadImage(name)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 5, 4), out.Bounds())
+}
+
+func TestImageStorage_Encryption_Chunks(t *testing.T) {
+	dir := t.TempDir()
+
+	images, err := filesystem.New(dir, filesystem.Options{EncryptionKey: bytes.Repeat([]byte{7}, 32)})
+	require.NoError(t, err)
+
+	// Noise doesn't compress, so the file spans several chunks.
+	src := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+	state := uint32(1)
+	for i := range src.Pix {
+		state = state*1664525 + 1013904223
+		src.Pix[i] = uint8(state >> 24)
+	}
+
+	_, err = images.SaveImage(src, "noise.png", encoding.Options{})
+	require.NoError(t, err)
+
+	file, err := images.OpenImage("noise.png")
+	require.NoError(t, err)
+	defer file.Close()
+
+	plain, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.Greater(t, len(plain), 3*64<<10)
+
+	size, err := images.FileSize("noise.png")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(plain)), size)
+
+	// Ranges are read across chunk boundaries.
+	offset := int64(64<<10 - 10)
+	_, err = file.Seek(offset, io.SeekStart)
+	require.NoError(t, err)
+	part := make([]byte, 64<<10+20)
+	_, err = io.ReadFull(file, part)
+	require.NoError(t, err)
+	assert.Equal(t, plain[offset:offset+int64(len(part))], part)
+
+	// A file cut at a chunk boundary doesn't read as a shorter image.
+	path := filepath.Join(dir, "noise.png")
+	onDisk, err := os.ReadFile(path)
+	require.NoError(t, err)
+	header := 8 + 32 // magic and salt
+	require.NoError(t, os.WriteFile(path, onDisk[:header+2*(64<<10+16)], 0o644))
+
+	_, err = images.OpenImage("noise.png")
+	assert.ErrorIs(t, err, storage.ErrCorruptImage)
+	_, err = images.LoadImage("noise.png")
+	assert.ErrorIs(t, err, storage.ErrCorruptImage)
+}
+
+func TestNew_InvalidEncryptionKey(t *testing.T) {
+	_, err := filesystem.New(t.TempDir(), filesystem.Options{EncryptionKey: []byte("short")})
+	assert.Error(t, err)
+}
+
+// FuzzImageStorage_LoadImage feeds truncated and mutated image files to
+// LoadImage and ImageSize, which must fail cleanly instead of panicking.
+func FuzzImageStorage_LoadImage(f *testing.F) {
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 7)
+	}
+
+	seeds := make([][]byte, 0, 6)
+	for _, encode := range []func(*bytes.Buffer) error{
+		func(buf *bytes.Buffer) error { return png.Encode(buf, src) },
+		func(buf *bytes.Buffer) error { return jpeg.Encode(buf, src, nil) },
+		func(buf *bytes.Buffer) error { return gif.Encode(buf, src, nil) },
+		func(buf *bytes.Buffer) error { return tiff.Encode(buf, src, nil) },
+		func(buf *bytes.Buffer) error { return webp.Encode(buf, src) },
+	} {
+		var buf bytes.Buffer
+		require.NoError(f, encode(&buf))
+		seeds = append(seeds, buf.Bytes())
+	}
+	seeds = append(seeds, cmykTIFF(2, 2, make([]byte, 16)))
+
+	for _, seed := range seeds {
+		for _, cut := range []int{0, 8, len(seed) / 3, len(seed) / 2, len(seed) - 1} {
+			f.Add(seed[:cut])
+		}
+	}
+
+	dir := f.TempDir()
+	// A mutated header can claim any size; keep allocations small.
+	imgStorage, err := filesystem.New(dir, filesystem.Options{MaxPixels: 1 << 16})
+	require.NoError(f, err)
+
+	filePath := filepath.Join(dir, "fuzz.png")
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		require.NoError(t, os.WriteFile(filePath, data, 0o644))
+
+		if _, err := imgStorage.LoadImage("fuzz.png"); err != nil && !errors.Is(err, storage.ErrImageTooLarge) {
+			assert.ErrorIs(t, err, storage.ErrCorruptImage)
+		}
+		if _, _, err := imgStorage.ImageSize("fuzz.png"); err != nil {
+			assert.ErrorIs(t, err, storage.ErrCorruptImage)
+		}
+	})
+}
+
+func TestImageStorage_LoadImage_PNGGamma(t *testing.T) {
+	dir := t.TempDir()
+	imgStorage, err := filesystem.New(dir, filesystem.Options{})
+	require.NoError(t, err)
+
+	src := image.NewGray(image.Rect(0, 0, 4, 4))
+	for i := range src.Pix {
+		src.Pix[i] = 128
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+
+	// A gAMA of 1.0 marks linear samples, which display much lighter than
+	// the same values in sRGB.
+	data := buf.Bytes()
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	chunk := binary.BigEndian.AppendUint32(nil, 4)
+	chunk = append(chunk, "gAMA"...)
+	chunk = binary.BigEndian.AppendUint32(chunk, 100000)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	tagged := append(append(append([]byte(nil), data[:ihdrEnd]...), chunk...), data[ihdrEnd:]...)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "linear.png"), tagged, 0o644))
+
+	loaded, err := imgStorage.LoadImage("linear.png")
+	require.NoError(t, err)
+	r, _, _, _ := loaded.At(1, 1).RGBA()
+	assert.Equal(t, uint32(188), r>>8)
+
+	// Saved tagged as sRGB, it loads back as bright as it was.
+	_, err = imgStorage.SaveImage(loaded, "saved.png", encoding.Options{})
+	require.NoError(t, err)
+	saved, err := os.ReadFile(filepath.Join(dir, "saved.png"))
+	require.NoError(t, err)
+	assert.Contains(t, string(saved), "sRGB")
+
+	reloaded, err := imgStorage.LoadImage("saved.png")
+	require.NoError(t, err)
+	assert.Equal(t, imaging.Clone(loaded).Pix, imaging.Clone(reloaded).Pix)
+}
@@ -0,0 +1,123 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"online-photo-editor/internal/storage"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Strategies for a generated name that is already taken.
+const (
+	// CollisionFail makes GenerateName return ErrNameTaken.
+	CollisionFail = "fail"
+	// CollisionOverwrite hands out the name anyway and the save replaces
+	// the existing image. Only safe when equal names mean equal content,
+	// as with content-hash names.
+	CollisionOverwrite = "overwrite"
+	// CollisionSuffix retries with "_1", "_2", ... appended to the name.
+	CollisionSuffix = "suffix"
+)
+
+// maxSuffix bounds the retries of CollisionSuffix.
+const maxSuffix = 100
+
+var ErrNameTaken = errors.New("image name is already taken")
+
+func validCollision(strategy string) bool {
+	switch strategy {
+	case CollisionFail, CollisionOverwrite, CollisionSuffix:
+		return true
+	default:
+		return false
+	}
+}
+
+// GenerateName returns a name for a new image. fileExt is normalized by
+// storage.NormalizeExt, an empty one becoming DefaultExt. Unless the
+// collision strategy is overwrite, the name is reserved by creating an
+// empty file, so concurrent callers never get the same one; SaveImage
+// then fills it.
+func (img *ImageStorage) GenerateName(prefix string, fileExt string) (string, error) {
+	return img.generateName(img.Path, prefix, fileExt)
+}
+
+// GenerateTempName is GenerateName for an image saved with
+// encoding.Options.Temp: the name is reserved in the temp area. Without
+// one it is GenerateName.
+func (img *ImageStorage) GenerateTempName(prefix string, fileExt string) (string, error) {
+	if img.TempPath == "" {
+		return img.GenerateName(prefix, fileExt)
+	}
+	return img.generateName(img.TempPath, prefix, fileExt)
+}
+
+// generateName is GenerateName for an image that will be written to dir.
+func (img *ImageStorage) generateName(dir, prefix, fileExt string) (string, error) {
+	const op = "storage.img.GenerateName"
+
+	if prefix == "" {
+		return "", fmt.Errorf("%s: the file prefix must not be empty", op)
+	}
+
+	fallback := img.DefaultExt
+	if fallback == "" {
+		fallback = defaultExt
+	}
+
+	ext, err := storage.NormalizeExt(fileExt, fallback)
+	if err != nil {
+		return "", fmt.Errorf("%s: %q: %w", op, fileExt, err)
+	}
+	fileExt = ext
+
+	base := fmt.Sprintf("%s_%s", prefix, time.Now().Format("20060102150405"))
+
+	// Nothing but images and LUTs can be saved, so other extensions have
+	// nothing to reserve.
+	if img.OnCollision == CollisionOverwrite || !isImageExt(fileExt) && !isLUTExt(fileExt) {
+		return base + fileExt, nil
+	}
+
+	name := base + fileExt
+	for i := 1; ; i++ {
+		err := img.reserve(dir, name)
+		if err == nil {
+			return name, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+		if img.OnCollision == CollisionFail {
+			return "", fmt.Errorf("%s: %s: %w", op, name, ErrNameTaken)
+		}
+		if i > maxSuffix {
+			return "", fmt.Errorf("%s: no free name after %d attempts: %w", op, maxSuffix, ErrNameTaken)
+		}
+
+		name = fmt.Sprintf("%s_%d%s", base, i, fileExt)
+	}
+}
+
+// reserve atomically creates an empty file for imgName in dir, failing
+// with os.ErrExist if the name is taken. A name is also taken when the
+// other area, permanent or temp, has it, as committing would clash.
+func (img *ImageStorage) reserve(dir, imgName string) error {
+	other := img.Path
+	if dir == img.Path {
+		other = img.TempPath
+	}
+	if other != "" {
+		if _, err := os.Stat(filepath.Join(other, imgName)); err == nil {
+			return os.ErrExist
+		}
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, imgName), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
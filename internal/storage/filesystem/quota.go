@@ -0,0 +1,172 @@
+package filesystem
+
+import (
+	"fmt"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/storage"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// storedFile is an image counted against the quota.
+type storedFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// quotaEnabled reports whether any quota is set.
+func (img *ImageStorage) quotaEnabled() bool {
+	return img.MaxImages > 0 || img.MaxBytes > 0 || img.NamespaceMaxImages > 0 || img.NamespaceMaxBytes > 0 || img.MaxNamespaces > 0
+}
+
+// enforceQuota checks the stored images, permanent and temp, with the one
+// written to tmpPath, and calls replace to move it to filePath if it
+// fits. An image it replaces there no longer counts. Over the quota, the
+// oldest other images are evicted for origin when EvictOldest is set; if
+// that isn't enough, or eviction is off, ErrQuotaExceeded is returned and
+// filePath is left as it was. An image taking its namespace over the
+// namespace quota fails with ErrNamespaceQuotaExceeded, nothing is
+// evicted for it. Saves run the check and replace one at a time, so
+// concurrent ones can't both squeeze in.
+func (img *ImageStorage) enforceQuota(tmpPath, filePath string, origin audit.Origin, replace func() error) error {
+	if !img.quotaEnabled() {
+		return replace()
+	}
+
+	img.quotaMu.Lock()
+	defer img.quotaMu.Unlock()
+
+	newInfo, err := os.Stat(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	files, err := img.storedFiles(filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := img.checkNamespaceQuota(filePath, newInfo.Size(), files); err != nil {
+		return err
+	}
+
+	count, bytes := len(files)+1, newInfo.Size()
+	for _, f := range files {
+		bytes += f.size
+	}
+
+	over := func() bool {
+		return (img.MaxImages > 0 && count > img.MaxImages) || (img.MaxBytes > 0 && bytes > img.MaxBytes)
+	}
+
+	// No eviction helps an image that is over the byte quota on its own.
+	fits := img.MaxBytes <= 0 || newInfo.Size() <= img.MaxBytes
+
+	if over() && img.EvictOldest && fits {
+		sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+		for _, f := range files {
+			if !over() {
+				break
+			}
+			if err := img.evict(f.path, origin); err != nil {
+				return err
+			}
+			count--
+			bytes -= f.size
+		}
+	}
+
+	if over() {
+		return fmt.Errorf("%d images, %d bytes: %w", count, bytes, storage.ErrQuotaExceeded)
+	}
+
+	return replace()
+}
+
+// checkNamespaceQuota checks the images of the namespace of filePath,
+// among files, with the size bytes one about to be written to it. An image that
+// would start one namespace more than MaxNamespaces fails the same way.
+func (img *ImageStorage) checkNamespaceQuota(filePath string, size int64, files []storedFile) error {
+	if img.NamespaceMaxImages <= 0 && img.NamespaceMaxBytes <= 0 && img.MaxNamespaces <= 0 {
+		return nil
+	}
+
+	namespace := storage.Namespace(filepath.Base(filePath))
+
+	count, bytes := 1, size
+	others := make(map[string]bool)
+	for _, f := range files {
+		switch ns := storage.Namespace(filepath.Base(f.path)); ns {
+		case namespace:
+			count++
+			bytes += f.size
+		case "":
+		default:
+			others[ns] = true
+		}
+	}
+
+	if namespace != "" && count == 1 && img.MaxNamespaces > 0 && len(others) >= img.MaxNamespaces {
+		return fmt.Errorf("namespace %q: %d namespaces already: %w", namespace, len(others), storage.ErrNamespaceQuotaExceeded)
+	}
+
+	if (img.NamespaceMaxImages > 0 && count > img.NamespaceMaxImages) || (img.NamespaceMaxBytes > 0 && bytes > img.NamespaceMaxBytes) {
+		return fmt.Errorf("namespace %q: %d images, %d bytes: %w", namespace, count, bytes, storage.ErrNamespaceQuotaExceeded)
+	}
+
+	return nil
+}
+
+// storedFiles lists the saved images in Path and TempPath other than
+// skip. Files reserved by GenerateName but not saved yet are empty and
+// don't count, nor do saves in progress, which aren't named as images.
+func (img *ImageStorage) storedFiles(skip string) ([]storedFile, error) {
+	var files []storedFile
+
+	for _, dir := range []string{img.Path, img.TempPath} {
+		if dir == "" {
+			continue
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if entry.IsDir() || !isImageExt(filepath.Ext(entry.Name())) || path == skip {
+				continue
+			}
+
+			info, err := entry.Info()
+			if err != nil || info.Size() == 0 {
+				continue
+			}
+
+			files = append(files, storedFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		}
+	}
+
+	return files, nil
+}
+
+// evict removes a stored image to make room for a save of origin. Like an
+// overwrite, the eviction is recorded in the audit log first and doesn't
+// happen if it can't be.
+func (img *ImageStorage) evict(path string, origin audit.Origin) error {
+	if err := img.Audit.Record(origin.Entry(audit.ActionEvict, filepath.Base(path))); err != nil {
+		return fmt.Errorf("failed to audit eviction: %w", err)
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	os.Remove(path + etagExt)
+
+	return nil
+}
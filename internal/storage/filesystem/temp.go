@@ -0,0 +1,163 @@
+package filesystem
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"online-photo-editor/internal/lib/logger/sl"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// resolvePath returns the location of imgName, looking in permanent
+// storage first and then in the temp area.
+func (img *ImageStorage) resolvePath(imgName string) string {
+	filePath := filepath.Join(img.Path, imgName)
+	if img.TempPath == "" {
+		return filePath
+	}
+
+	if _, err := os.Stat(filePath); err == nil {
+		return filePath
+	}
+
+	tempPath := filepath.Join(img.TempPath, imgName)
+	if _, err := os.Stat(tempPath); err == nil {
+		return tempPath
+	}
+
+	return filePath
+}
+
+// Committed reports whether imgName is in permanent storage rather than
+// waiting in the temp area for CommitImage.
+func (img *ImageStorage) Committed(imgName string) bool {
+	return checkFile(filepath.Join(img.Path, imgName)) == nil
+}
+
+// CommitImage moves an upload from the temp area to permanent storage.
+// Images that are already permanent are left as they are.
+func (img *ImageStorage) CommitImage(imgName string) (string, error) {
+	const op = "storage.img.CommitImage"
+
+	imageURL := img.ImageURL(imgName)
+	filePath := filepath.Join(img.Path, imgName)
+
+	if _, err := os.Stat(filePath); err == nil {
+		return imageURL, nil
+	}
+
+	if img.TempPath == "" {
+		return "", fmt.Errorf("%s: %w", op, os.ErrNotExist)
+	}
+
+	tempPath := filepath.Join(img.TempPath, imgName)
+	if err := checkFile(tempPath); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := moveFile(tempPath, filePath); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	os.Remove(tempPath + etagExt)
+
+	return imageURL, nil
+}
+
+// CleanupTemp removes temp files last modified more than ttl ago and
+// returns how many were removed.
+func (img *ImageStorage) CleanupTemp(ttl time.Duration) (int, error) {
+	const op = "storage.img.CleanupTemp"
+
+	if img.TempPath == "" {
+		return 0, nil
+	}
+
+	entries, err := os.ReadDir(img.TempPath)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	cutoff := time.Now().Add(-ttl)
+	removed := 0
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(img.TempPath, entry.Name())); err == nil {
+			removed++
+		}
+	}
+
+	return removed, nil
+}
+
+// StartJanitor runs CleanupTemp every interval until ctx is done.
+func (img *ImageStorage) StartJanitor(ctx context.Context, log *slog.Logger, interval, ttl time.Duration) {
+	const op = "storage.img.StartJanitor"
+
+	log = log.With(slog.String("op", op))
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				removed, err := img.CleanupTemp(ttl)
+				if err != nil {
+					log.Error("failed to clean up temp uploads", sl.Err(err))
+					continue
+				}
+				if removed > 0 {
+					log.Info("abandoned temp uploads removed", slog.Int("count", removed))
+				}
+			}
+		}
+	}()
+}
+
+// moveFile renames src to dst, falling back to copy and remove when the
+// two paths are on different filesystems.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	return os.Remove(src)
+}
@@ -0,0 +1,301 @@
+package filesystem
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/tiff/lzw"
+)
+
+// TIFF tags, field types and values used by the CMYK decoder.
+const (
+	tagImageWidth                = 256
+	tagImageLength               = 257
+	tagBitsPerSample             = 258
+	tagCompression               = 259
+	tagPhotometricInterpretation = 262
+	tagStripOffsets              = 273
+	tagSamplesPerPixel           = 277
+	tagRowsPerStrip              = 278
+	tagStripByteCounts           = 279
+	tagPlanarConfiguration       = 284
+	tagPredictor                 = 317
+
+	typeShort = 3
+	typeLong  = 4
+
+	compressionNone     = 1
+	compressionLZW      = 5
+	compressionDeflate  = 8
+	compressionPackBits = 32773
+	compressionDeflate2 = 32946
+
+	photometricCMYK = 5
+
+	predictorHorizontal = 2
+)
+
+func isTIFF(header []byte) bool {
+	return bytes.HasPrefix(header, []byte("II*\x00")) || bytes.HasPrefix(header, []byte("MM\x00*"))
+}
+
+// decodeTIFF decodes a TIFF file. Grayscale and RGB files are handled by
+// x/image/tiff; CMYK files, which it rejects, are decoded here and converted
+// to RGBA so the rest of the pipeline never sees an *image.CMYK.
+func decodeTIFF(data []byte) (image.Image, error) {
+	img, err := tiff.Decode(bytes.NewReader(data))
+	if err == nil {
+		return img, nil
+	}
+
+	var unsupported tiff.UnsupportedError
+	if !errors.As(err, &unsupported) {
+		return nil, err
+	}
+
+	cmyk, cmykErr := decodeCMYK(data)
+	if cmykErr != nil {
+		if errors.Is(cmykErr, errNotCMYK) {
+			return nil, err
+		}
+		return nil, cmykErr
+	}
+
+	rgba := image.NewRGBA(cmyk.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), cmyk, cmyk.Bounds().Min, draw.Src)
+
+	return rgba, nil
+}
+
+// tiffSize reads the dimensions from the first IFD. Unlike
+// tiff.DecodeConfig it also works for CMYK files.
+func tiffSize(r io.Reader) (int, int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	d, err := readIFD(data)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	width, height := int(d.first(tagImageWidth, 0)), int(d.first(tagImageLength, 0))
+	if width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("tiff: invalid dimensions %dx%d", width, height)
+	}
+
+	return width, height, nil
+}
+
+var errNotCMYK = errors.New("tiff: not a CMYK image")
+
+type ifd struct {
+	order  binary.ByteOrder
+	fields map[uint16][]uint
+}
+
+func (d *ifd) first(tag uint16, def uint) uint {
+	if v := d.fields[tag]; len(v) > 0 {
+		return v[0]
+	}
+	return def
+}
+
+func decodeCMYK(data []byte) (*image.CMYK, error) {
+	d, err := readIFD(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.first(tagPhotometricInterpretation, 0) != photometricCMYK {
+		return nil, errNotCMYK
+	}
+
+	width := int(d.first(tagImageWidth, 0))
+	height := int(d.first(tagImageLength, 0))
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("tiff: invalid dimensions %dx%d", width, height)
+	}
+
+	samples := int(d.first(tagSamplesPerPixel, 1))
+	if samples < 4 {
+		return nil, fmt.Errorf("tiff: CMYK needs 4 samples per pixel, got %d", samples)
+	}
+	for _, bits := range d.fields[tagBitsPerSample] {
+		if bits != 8 {
+			return nil, fmt.Errorf("tiff: unsupported CMYK bit depth %d", bits)
+		}
+	}
+	if d.first(tagPlanarConfiguration, 1) != 1 {
+		return nil, errors.New("tiff: planar CMYK is not supported")
+	}
+
+	offsets := d.fields[tagStripOffsets]
+	counts := d.fields[tagStripByteCounts]
+	if len(offsets) == 0 || len(offsets) != len(counts) {
+		return nil, errors.New("tiff: CMYK strips are missing or inconsistent")
+	}
+
+	rowsPerStrip := int(d.first(tagRowsPerStrip, uint(height)))
+	if rowsPerStrip <= 0 || rowsPerStrip > height {
+		rowsPerStrip = height
+	}
+
+	compression := d.first(tagCompression, compressionNone)
+	predictor := d.first(tagPredictor, 1)
+	rowSize := width * samples
+
+	img := image.NewCMYK(image.Rect(0, 0, width, height))
+
+	for i := range offsets {
+		start, end := offsets[i], offsets[i]+counts[i]
+		if end < start || end > uint(len(data)) {
+			return nil, errors.New("tiff: strip exceeds file size")
+		}
+
+		strip, err := decompress(data[start:end], compression)
+		if err != nil {
+			return nil, err
+		}
+
+		y0 := i * rowsPerStrip
+		for row := 0; row < rowsPerStrip && y0+row < height; row++ {
+			if (row+1)*rowSize > len(strip) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			line := strip[row*rowSize : (row+1)*rowSize]
+
+			if predictor == predictorHorizontal {
+				for x := samples; x < len(line); x++ {
+					line[x] += line[x-samples]
+				}
+			}
+
+			for x := 0; x < width; x++ {
+				p := line[x*samples:]
+				img.SetCMYK(x, y0+row, color.CMYK{C: p[0], M: p[1], Y: p[2], K: p[3]})
+			}
+		}
+	}
+
+	return img, nil
+}
+
+func readIFD(data []byte) (*ifd, error) {
+	if len(data) < 8 || !isTIFF(data) {
+		return nil, errors.New("tiff: invalid header")
+	}
+
+	d := &ifd{fields: make(map[uint16][]uint)}
+	if data[0] == 'I' {
+		d.order = binary.LittleEndian
+	} else {
+		d.order = binary.BigEndian
+	}
+
+	offset := uint(d.order.Uint32(data[4:8]))
+	if offset+2 > uint(len(data)) {
+		return nil, errors.New("tiff: invalid IFD offset")
+	}
+
+	n := uint(d.order.Uint16(data[offset:]))
+	entries := offset + 2
+	if entries+n*12 > uint(len(data)) {
+		return nil, errors.New("tiff: truncated IFD")
+	}
+
+	for i := uint(0); i < n; i++ {
+		entry := data[entries+i*12 : entries+(i+1)*12]
+		tag := d.order.Uint16(entry[0:2])
+		typ := d.order.Uint16(entry[2:4])
+		count := uint(d.order.Uint32(entry[4:8]))
+
+		var size uint
+		switch typ {
+		case typeShort:
+			size = 2
+		case typeLong:
+			size = 4
+		default:
+			continue
+		}
+
+		raw := entry[8:12]
+		if count*size > 4 {
+			start := uint(d.order.Uint32(entry[8:12]))
+			if count > uint(len(data)) || start+count*size > uint(len(data)) {
+				return nil, errors.New("tiff: field exceeds file size")
+			}
+			raw = data[start : start+count*size]
+		}
+
+		values := make([]uint, count)
+		for j := range values {
+			if size == 2 {
+				values[j] = uint(d.order.Uint16(raw[j*2:]))
+			} else {
+				values[j] = uint(d.order.Uint32(raw[j*4:]))
+			}
+		}
+		d.fields[tag] = values
+	}
+
+	return d, nil
+}
+
+func decompress(src []byte, compression uint) ([]byte, error) {
+	switch compression {
+	case compressionNone:
+		return append([]byte(nil), src...), nil
+	case compressionLZW:
+		r := lzw.NewReader(bytes.NewReader(src), lzw.MSB, 8)
+		defer r.Close()
+		return io.ReadAll(r)
+	case compressionDeflate, compressionDeflate2:
+		r, err := zlib.NewReader(bytes.NewReader(src))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case compressionPackBits:
+		return unpackBits(src)
+	default:
+		return nil, fmt.Errorf("tiff: unsupported CMYK compression %d", compression)
+	}
+}
+
+func unpackBits(src []byte) ([]byte, error) {
+	var dst []byte
+
+	for i := 0; i < len(src); {
+		n := int(int8(src[i]))
+		i++
+
+		switch {
+		case n >= 0:
+			if i+n+1 > len(src) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			dst = append(dst, src[i:i+n+1]...)
+			i += n + 1
+		case n != -128:
+			if i >= len(src) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			dst = append(dst, bytes.Repeat(src[i:i+1], 1-n)...)
+			i++
+		}
+	}
+
+	return dst, nil
+}
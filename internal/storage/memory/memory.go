@@ -0,0 +1,409 @@
+// Package memory is an image storage kept in memory, for tests, local
+// development and small deployments that keep nothing on disk. It behaves
+// like the filesystem storage where handlers can tell: missing images wrap
+// os.ErrNotExist, undecodable ones storage.ErrCorruptImage, and unknown
+// extensions fail to save.
+package memory
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"online-photo-editor/internal/lib/audit"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/lib/imgformat"
+	"online-photo-editor/internal/storage"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/gen2brain/webp"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+)
+
+type entry struct {
+	data []byte
+	dpi  int
+	// modTime is when the image was stored.
+	modTime time.Time
+	// temp marks an upload that isn't committed yet.
+	temp bool
+	// used is the clock of the last store or read, for MaxImages.
+	used uint64
+}
+
+// MemStorage is safe for concurrent use.
+type MemStorage struct {
+	// DefaultExt is the extension GenerateName uses when it is given none.
+	DefaultExt string
+	// MaxImages caps the number of stored images: storing one more evicts
+	// the least recently stored or read one. Zero means no limit.
+	MaxImages int
+
+	mu     sync.RWMutex
+	images map[string]entry
+	// names counts the names handed out, so every one is unique.
+	names int
+	// clock orders stores and reads for eviction.
+	clock uint64
+}
+
+func New() *MemStorage {
+	return &MemStorage{images: make(map[string]entry)}
+}
+
+// get returns the entry of imgName, marking it used.
+func (m *MemStorage) get(op, imgName string) (entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.images[imgName]
+	if !ok {
+		return entry{}, fmt.Errorf("%s: %s: %w", op, imgName, os.ErrNotExist)
+	}
+
+	m.clock++
+	e.used = m.clock
+	m.images[imgName] = e
+
+	return e, nil
+}
+
+// put stores e as imgName, then evicts the least recently used images
+// while there are more than MaxImages.
+func (m *MemStorage) put(imgName string, e entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.clock++
+	e.used = m.clock
+	m.images[imgName] = e
+
+	for m.MaxImages > 0 && len(m.images) > m.MaxImages {
+		oldest, oldestUsed := "", uint64(0)
+		for name, e := range m.images {
+			if name != imgName && (oldest == "" || e.used < oldestUsed) {
+				oldest, oldestUsed = name, e.used
+			}
+		}
+		delete(m.images, oldest)
+	}
+}
+
+// List returns the names of the stored images, committed or not, sorted.
+func (m *MemStorage) List() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	names := make([]string, 0, len(m.images))
+	for name := range m.images {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+func (m *MemStorage) FindImage(imgName string) (string, error) {
+	const op = "storage.memory.FindImage"
+
+	if _, err := m.get(op, imgName); err != nil {
+		return "", err
+	}
+	return imgName, nil
+}
+
+func (m *MemStorage) LoadImage(imgName string) (image.Image, error) {
+	const op = "storage.memory.LoadImage"
+
+	e, err := m.get(op, imgName)
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(e.data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %w", op, storage.ErrCorruptImage, err)
+	}
+	return encoding.ToSRGB(img, encoding.PNGGamma(e.data)), nil
+}
+
+// SaveImage encodes inputImg in the format of imgName's extension.
+func (m *MemStorage) SaveImage(inputImg image.Image, imgName string, opts encoding.Options) (string, error) {
+	const op = "storage.memory.SaveImage"
+
+	var buf bytes.Buffer
+	if err := encoding.Encode(&buf, inputImg, filepath.Ext(imgName), opts); err != nil {
+		return "", fmt.Errorf("%s: %w: %w", op, storage.ErrEncode, err)
+	}
+
+	m.put(imgName, entry{data: buf.Bytes(), dpi: opts.DPI, temp: opts.Temp, modTime: time.Now()})
+
+	return imageURL(imgName), nil
+}
+
+// UploadImage stores file as an uncommitted image in the namespace of
+// fileName and returns its name.
+func (m *MemStorage) UploadImage(file io.Reader, fileName string) (string, error) {
+	const op = "storage.memory.UploadImage"
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	if len(data) == 0 {
+		return "", fmt.Errorf("%s: empty file", op)
+	}
+
+	mimeType := http.DetectContentType(data)
+	if bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")) {
+		mimeType = "image/tiff"
+	}
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/bmp", "image/gif", "image/webp", "image/tiff":
+	default:
+		// .cube color lookup tables are text, read by the lut action.
+		if !strings.EqualFold(filepath.Ext(fileName), ".cube") || !strings.HasPrefix(mimeType, "text/plain") {
+			return "", fmt.Errorf("%s: unsupported file type: %s", op, mimeType)
+		}
+	}
+
+	imgName, err := m.GenerateName(storage.InNamespaceOf(fileName, "img"), filepath.Ext(fileName))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.put(imgName, entry{data: data, temp: true, modTime: time.Now()})
+
+	return imgName, nil
+}
+
+func (m *MemStorage) DeleteImage(imgName string) error {
+	const op = "storage.memory.DeleteImage"
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.images[imgName]; !ok {
+		return fmt.Errorf("%s: %s: %w", op, imgName, os.ErrNotExist)
+	}
+	delete(m.images, imgName)
+
+	return nil
+}
+
+// GenerateTempName is GenerateName: temp images share its names.
+func (m *MemStorage) GenerateTempName(prefix string, fileExt string) (string, error) {
+	return m.GenerateName(prefix, fileExt)
+}
+
+// GenerateName returns prefix, a sequence number and fileExt, e.g.
+// "proc_3.png". An empty fileExt becomes DefaultExt, or ".png" without
+// one. Names are never reused.
+func (m *MemStorage) GenerateName(prefix string, fileExt string) (string, error) {
+	const op = "storage.memory.GenerateName"
+
+	if prefix == "" {
+		return "", fmt.Errorf("%s: the file prefix must not be empty", op)
+	}
+
+	fallback := m.DefaultExt
+	if fallback == "" {
+		fallback = ".png"
+	}
+
+	ext, err := storage.NormalizeExt(fileExt, fallback)
+	if err != nil {
+		return "", fmt.Errorf("%s: %q: %w", op, fileExt, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.names++
+
+	return fmt.Sprintf("%s_%d%s", prefix, m.names, ext), nil
+}
+
+type readSeekNopCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekNopCloser) Close() error { return nil }
+
+func (m *MemStorage) OpenImage(imgName string) (io.ReadSeekCloser, error) {
+	const op = "storage.memory.OpenImage"
+
+	e, err := m.get(op, imgName)
+	if err != nil {
+		return nil, err
+	}
+	return readSeekNopCloser{bytes.NewReader(e.data)}, nil
+}
+
+// ImageETag returns a strong ETag derived from the image content.
+func (m *MemStorage) ImageETag(imgName string) (string, error) {
+	const op = "storage.memory.ImageETag"
+
+	e, err := m.get(op, imgName)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(e.data)
+	return fmt.Sprintf(`"%x"`, sum[:16]), nil
+}
+
+// ImageCaptureTime returns when a JPEG or TIFF was taken, from its EXIF.
+func (m *MemStorage) ImageCaptureTime(imgName string) (time.Time, error) {
+	const op = "storage.memory.ImageCaptureTime"
+
+	e, err := m.get(op, imgName)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return storage.CaptureTime(e.data), nil
+}
+
+// ImageModTime returns when the image was stored.
+func (m *MemStorage) ImageModTime(imgName string) (time.Time, error) {
+	const op = "storage.memory.ImageModTime"
+
+	e, err := m.get(op, imgName)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return e.modTime, nil
+}
+
+func (m *MemStorage) CommitImage(imgName string) (string, error) {
+	const op = "storage.memory.CommitImage"
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.images[imgName]
+	if !ok {
+		return "", fmt.Errorf("%s: %s: %w", op, imgName, os.ErrNotExist)
+	}
+	e.temp = false
+	m.images[imgName] = e
+
+	return imageURL(imgName), nil
+}
+
+// Committed reports whether imgName is stored and not waiting for
+// CommitImage.
+func (m *MemStorage) Committed(imgName string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	e, ok := m.images[imgName]
+	return ok && !e.temp
+}
+
+func (m *MemStorage) ImageSize(imgName string) (int, int, error) {
+	const op = "storage.memory.ImageSize"
+
+	e, err := m.get(op, imgName)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if width, height, ok := imgformat.CanvasSize(e.data); ok {
+		return width, height, nil
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(e.data))
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %w: %w", op, storage.ErrCorruptImage, err)
+	}
+	return config.Width, config.Height, nil
+}
+
+// ImageFrames returns the number of frames of an animated GIF or WebP,
+// and 1 for other images.
+func (m *MemStorage) ImageFrames(imgName string) (int, error) {
+	const op = "storage.memory.ImageFrames"
+
+	e, err := m.get(op, imgName)
+	if err != nil {
+		return 0, err
+	}
+
+	frames, _ := imgformat.FrameStats(e.data)
+	return max(frames, 1), nil
+}
+
+func (m *MemStorage) FileSize(imgName string) (int64, error) {
+	const op = "storage.memory.FileSize"
+
+	e, err := m.get(op, imgName)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(e.data)), nil
+}
+
+// ImageDPI returns the DPI the image was saved with.
+func (m *MemStorage) ImageDPI(imgName string) (int, error) {
+	const op = "storage.memory.ImageDPI"
+
+	e, err := m.get(op, imgName)
+	if err != nil {
+		return 0, err
+	}
+	return e.dpi, nil
+}
+
+func (m *MemStorage) LoadGIF(imgName string) (*gif.GIF, error) {
+	const op = "storage.memory.LoadGIF"
+
+	e, err := m.get(op, imgName)
+	if err != nil {
+		return nil, err
+	}
+
+	anim, err := gif.DecodeAll(bytes.NewReader(e.data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %w", op, storage.ErrCorruptImage, err)
+	}
+	return anim, nil
+}
+
+func (m *MemStorage) SaveGIF(anim *gif.GIF, imgName string, _ audit.Origin) (string, error) {
+	const op = "storage.memory.SaveGIF"
+
+	if fileExt := strings.ToLower(filepath.Ext(imgName)); fileExt != ".gif" {
+		return "", fmt.Errorf("%s: %w: unsupported file format: %s", op, storage.ErrEncode, fileExt)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		return "", fmt.Errorf("%s: %w: %w", op, storage.ErrEncode, err)
+	}
+
+	m.put(imgName, entry{data: buf.Bytes(), modTime: time.Now()})
+
+	return imageURL(imgName), nil
+}
+
+// ImageURL returns the URL clients download imgName from.
+func (m *MemStorage) ImageURL(imgName string) string {
+	return imageURL(imgName)
+}
+
+func imageURL(imgName string) string {
+	return "/images/" + url.PathEscape(imgName)
+}
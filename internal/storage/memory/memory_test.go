@@ -0,0 +1,163 @@
+package memory_test
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"sync"
+	"testing"
+
+	"online-photo-editor/internal/http-server/handlers/image/processor"
+	"online-photo-editor/internal/lib/encoding"
+	"online-photo-editor/internal/storage"
+	"online-photo-editor/internal/storage/memory"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _ processor.ImageProcessor = (*memory.MemStorage)(nil)
+
+func TestMemStorage_SaveLoad(t *testing.T) {
+	mem := memory.New()
+
+	for _, name := range []string{"a.png", "a.jpg", "a.gif", "a.bmp", "a.webp", "a.tiff"} {
+		imgUrl, err := mem.SaveImage(imaging.New(30, 20, color.White), name, encoding.Options{})
+		require.NoError(t, err, name)
+		assert.Equal(t, "/images/"+name, imgUrl)
+
+		img, err := mem.LoadImage(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, image.Rect(0, 0, 30, 20), img.Bounds(), name)
+
+		width, height, err := mem.ImageSize(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, [2]int{30, 20}, [2]int{width, height}, name)
+	}
+}
+
+func TestMemStorage_Errors(t *testing.T) {
+	mem := memory.New()
+
+	_, err := mem.LoadImage("missing.png")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = mem.FindImage("missing.png")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.ErrorIs(t, mem.DeleteImage("missing.png"), os.ErrNotExist)
+
+	_, err = mem.SaveImage(imaging.New(1, 1, color.White), "img.avif", encoding.Options{})
+	assert.ErrorContains(t, err, "unsupported file format")
+
+	_, err = mem.UploadImage(bytes.NewBufferString("not an image"), "notes.txt")
+	assert.ErrorContains(t, err, "unsupported file type")
+
+	_, err = mem.UploadImage(bytes.NewBufferString("\x89PNG\r\n\x1a\ntruncated"), "img.png")
+	require.NoError(t, err)
+	_, err = mem.LoadImage("img_1.png")
+	assert.ErrorIs(t, err, storage.ErrCorruptImage)
+}
+
+func TestMemStorage_UploadCommit(t *testing.T) {
+	mem := memory.New()
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, imaging.New(4, 4, color.Black)))
+	data := buf.Bytes()
+
+	// A bare name, like the filesystem storage: ImageURL has the URL.
+	imgName, err := mem.UploadImage(bytes.NewReader(data), "photo.png")
+	require.NoError(t, err)
+	assert.Equal(t, "img_1.png", imgName)
+	assert.Equal(t, "/images/img_1.png", mem.ImageURL(imgName))
+	assert.False(t, mem.Committed(imgName))
+
+	imgUrl, err := mem.CommitImage(imgName)
+	require.NoError(t, err)
+	assert.Equal(t, "/images/"+imgName, imgUrl)
+	assert.True(t, mem.Committed(imgName))
+
+	file, err := mem.OpenImage(imgName)
+	require.NoError(t, err)
+	got, err := io.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	size, err := mem.FileSize(imgName)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), size)
+
+	etag, err := mem.ImageETag(imgName)
+	require.NoError(t, err)
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+}
+
+func TestMemStorage_MaxImages(t *testing.T) {
+	mem := memory.New()
+	mem.MaxImages = 3
+	img := imaging.New(2, 2, color.White)
+
+	for _, name := range []string{"a.png", "b.png", "c.png"} {
+		_, err := mem.SaveImage(img, name, encoding.Options{})
+		require.NoError(t, err)
+	}
+
+	// Reading a keeps it; b is now the least recently used.
+	_, err := mem.LoadImage("a.png")
+	require.NoError(t, err)
+
+	_, err = mem.SaveImage(img, "d.png", encoding.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.png", "c.png", "d.png"}, mem.List())
+
+	// Replacing an image doesn't evict anything.
+	_, err = mem.SaveImage(img, "c.png", encoding.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.png", "c.png", "d.png"}, mem.List())
+
+	_, err = mem.SaveImage(img, "e.png", encoding.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c.png", "d.png", "e.png"}, mem.List())
+}
+
+func TestMemStorage_Concurrent(t *testing.T) {
+	mem := memory.New()
+	img := imaging.New(2, 2, color.White)
+
+	const workers = 20
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		names = make(map[string]bool)
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			name, err := mem.GenerateName("proc", "png")
+			assert.NoError(t, err)
+
+			_, err = mem.SaveImage(img, name, encoding.Options{})
+			assert.NoError(t, err)
+			_, err = mem.LoadImage(name)
+			assert.NoError(t, err)
+
+			mu.Lock()
+			names[name] = true
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, names, workers, "every name must be unique")
+	for i := 1; i <= workers; i++ {
+		_, err := mem.FindImage(fmt.Sprintf("proc_%d.png", i))
+		assert.NoError(t, err)
+	}
+}
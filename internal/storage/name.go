@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidName is returned for an image name that isn't a plain file
+// name.
+var ErrInvalidName = errors.New("invalid image name")
+
+// ValidateName rejects image names that could address a file outside the
+// storage directory: names with path separators, "..", null bytes or
+// absolute paths. Names are checked before they reach any storage.
+func ValidateName(name string) error {
+	switch {
+	case name == "",
+		strings.ContainsAny(name, `/\`),
+		strings.Contains(name, ".."),
+		strings.ContainsRune(name, 0),
+		filepath.IsAbs(name),
+		filepath.VolumeName(name) != "":
+		return ErrInvalidName
+	default:
+		return nil
+	}
+}
+
+// ErrInvalidExt is returned for a file extension that isn't a short run
+// of letters and digits.
+var ErrInvalidExt = errors.New("invalid file extension")
+
+// maxExtLen is the longest extension NormalizeExt accepts, without the dot.
+const maxExtLen = 10
+
+// NormalizeExt returns fileExt lowercased with exactly one leading dot,
+// e.g. ".png" for "PNG" or "..png". An empty fileExt, as for a source
+// without an extension, is replaced with fallback.
+func NormalizeExt(fileExt, fallback string) (string, error) {
+	ext := strings.ToLower(strings.TrimLeft(strings.TrimSpace(fileExt), "."))
+	if ext == "" {
+		ext = strings.ToLower(strings.TrimLeft(strings.TrimSpace(fallback), "."))
+	}
+
+	if ext == "" || len(ext) > maxExtLen {
+		return "", ErrInvalidExt
+	}
+	for _, r := range ext {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return "", ErrInvalidExt
+		}
+	}
+
+	return "." + ext, nil
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+)
+
+// NamespaceSep separates the namespace of an image, e.g. the tenant it
+// belongs to, from the rest of its name, as in "acme--img_20240101.png".
+// Names without one are in the default namespace, "".
+const NamespaceSep = "--"
+
+// maxNamespaceLen is the longest namespace ValidateNamespace accepts.
+const maxNamespaceLen = 32
+
+// ErrInvalidNamespace is returned for a namespace that isn't a short run
+// of lowercase letters, digits and single hyphens.
+var ErrInvalidNamespace = errors.New("invalid namespace")
+
+// ValidateNamespace checks a namespace given by a client. The empty one,
+// the default namespace, is valid.
+func ValidateNamespace(namespace string) error {
+	if len(namespace) > maxNamespaceLen || strings.Contains(namespace, NamespaceSep) ||
+		strings.HasPrefix(namespace, "-") || strings.HasSuffix(namespace, "-") {
+		return ErrInvalidNamespace
+	}
+	for _, r := range namespace {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return ErrInvalidNamespace
+		}
+	}
+	return nil
+}
+
+// Namespace returns the namespace of the image name.
+func Namespace(name string) string {
+	namespace, _, found := strings.Cut(name, NamespaceSep)
+	if !found || ValidateNamespace(namespace) != nil {
+		return ""
+	}
+	return namespace
+}
+
+// InNamespace returns name, e.g. a GenerateName prefix, moved into
+// namespace.
+func InNamespace(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + NamespaceSep + name
+}
+
+// InNamespaceOf returns prefix moved into the namespace of the image name,
+// for the names of the images made from it.
+func InNamespaceOf(name, prefix string) string {
+	return InNamespace(Namespace(name), prefix)
+}
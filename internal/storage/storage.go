@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrCorruptImage is returned when a stored file can't be decoded,
+	// e.g. because an upload was cut short.
+	ErrCorruptImage = errors.New("corrupt or truncated image")
+	// ErrImageTooLarge is returned instead of decoding an image with more
+	// pixels than the storage allows.
+	ErrImageTooLarge = errors.New("image exceeds the pixel limit")
+	// ErrTooManyFrames is returned instead of decoding an animation with
+	// more frames than the storage allows.
+	ErrTooManyFrames = errors.New("animation exceeds the frame limit")
+	// ErrQuotaExceeded is returned instead of keeping an image that would
+	// take the storage over its quota.
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+	// ErrNamespaceQuotaExceeded is ErrQuotaExceeded for the quota of the
+	// namespace of the image rather than the whole storage.
+	ErrNamespaceQuotaExceeded = fmt.Errorf("namespace %w", ErrQuotaExceeded)
+	// ErrEncode is returned when an image can't be written in the format
+	// of its name, e.g. one the storage has no encoder for, as opposed to
+	// a failure to store the encoded file.
+	ErrEncode = errors.New("failed to encode image")
+)
+
+// ContentError returns the sentinel error of the above that err wraps, if
+// any: err is about what a stored image contains, not whether it exists.
+func ContentError(err error) error {
+	for _, target := range []error{ErrCorruptImage, ErrImageTooLarge, ErrTooManyFrames} {
+		if errors.Is(err, target) {
+			return target
+		}
+	}
+	return nil
+}
+
+// QuotaError returns the quota error of the above that err wraps, if any,
+// the namespace one first.
+func QuotaError(err error) error {
+	for _, target := range []error{ErrNamespaceQuotaExceeded, ErrQuotaExceeded} {
+		if errors.Is(err, target) {
+			return target
+		}
+	}
+	return nil
+}